@@ -0,0 +1,161 @@
+// Package auth authorizes the requests to the Google APIs with OAuth 2.0.
+// The OAuth client of the application is read from its client secret file,
+// and the token from a file caching it, which is asked for in the browser
+// the first time.
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// Config names the files of an authorization.
+type Config struct {
+	// ClientSecretFile holds the OAuth client of the application,
+	// client_secret.json if empty.
+	ClientSecretFile string
+	// TokenFile caches the token authorizing it,
+	// ~/.credentials/drive-go-quickstart.json if empty.
+	TokenFile string
+	// Scopes are those asked for. If modifying them, delete the token
+	// file so that the next run asks for them.
+	Scopes []string
+	// Prompt returns the authorization code the user got at authURL. If
+	// nil, the URL is printed and the code read from the standard input.
+	Prompt func(authURL string) (string, error)
+}
+
+// Error is an error reading the client secret or getting a token.
+type Error struct {
+	Err error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+func authError(format string, v ...interface{}) error {
+	return &Error{Err: fmt.Errorf(format, v...)}
+}
+
+// Client returns an HTTP client authorized with the cached token, or with
+// one asked for if there is none. Its requests go through the client of
+// ctx's oauth2.HTTPClient, if any.
+func (c *Config) Client(ctx context.Context) (*http.Client, error) {
+	secretFile := c.ClientSecretFile
+	if secretFile == "" {
+		secretFile = "client_secret.json"
+	}
+	b, err := ioutil.ReadFile(expandHome(secretFile))
+	if err != nil {
+		return nil, authError("Unable to read client secret file: %v", err)
+	}
+	config, err := google.ConfigFromJSON(b, c.Scopes...)
+	if err != nil {
+		return nil, authError("Unable to parse client secret file to config: %v", err)
+	}
+	file, err := c.TokenPath()
+	if err != nil {
+		return nil, authError("Unable to get path to cached credential file. %v", err)
+	}
+	tok, err := tokenFromFile(file)
+	if err != nil {
+		if tok, err = c.tokenFromWeb(ctx, config); err != nil {
+			return nil, err
+		}
+		if err := saveToken(file, tok); err != nil {
+			return nil, fmt.Errorf("Unable to cache oauth token: %v", err)
+		}
+	}
+	return config.Client(ctx, tok), nil
+}
+
+// TokenPath returns the path of the token file.
+func (c *Config) TokenPath() (string, error) {
+	if c.TokenFile != "" {
+		return expandHome(c.TokenFile), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(home, ".credentials")
+	os.MkdirAll(dir, 0700)
+	return filepath.Join(dir, "drive-go-quickstart.json"), nil
+}
+
+// tokenFromWeb asks for a token with the code the user gets at the
+// authorization URL.
+func (c *Config) tokenFromWeb(ctx context.Context, config *oauth2.Config) (*oauth2.Token, error) {
+	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
+	prompt := c.Prompt
+	if prompt == nil {
+		prompt = promptStdin
+	}
+	code, err := prompt(authURL)
+	if err != nil {
+		return nil, authError("Unable to read authorization code %v", err)
+	}
+	tok, err := config.Exchange(ctx, code)
+	if err != nil {
+		return nil, authError("Unable to retrieve token from web %v", err)
+	}
+	return tok, nil
+}
+
+func promptStdin(authURL string) (string, error) {
+	fmt.Printf("Go to the following link in your browser then type the "+
+		"authorization code: \n%v\n", authURL)
+	var code string
+	_, err := fmt.Scan(&code)
+	return code, err
+}
+
+// tokenFromFile retrieves a Token from a given file path.
+// It returns the retrieved Token and any read error encountered.
+func tokenFromFile(file string) (*oauth2.Token, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t := &oauth2.Token{}
+	err = json.NewDecoder(f).Decode(t)
+	return t, err
+}
+
+// saveToken uses a file path to create a file and store the
+// token in it.
+func saveToken(file string, token *oauth2.Token) error {
+	fmt.Printf("Saving credential file to: %s\n", file)
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(token); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// expandHome replaces a leading "~/" with the home directory.
+func expandHome(p string) string {
+	if !strings.HasPrefix(p, "~/") {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+	return filepath.Join(home, p[2:])
+}
@@ -0,0 +1,49 @@
+// Package checksum computes the checksums files are compared by, of the
+// hashes Drive reports for its files.
+package checksum
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+)
+
+// Hashes Drive reports checksums of.
+const (
+	MD5    = "md5"
+	SHA256 = "sha256"
+)
+
+// bufferSize is the size of the buffer files are hashed through, which
+// bounds the memory hashing takes regardless of the file size.
+const bufferSize = 1 << 20
+
+// Valid reports whether name is one of the hashes.
+func Valid(name string) bool {
+	return name == MD5 || name == SHA256
+}
+
+// New returns a hash of name, MD5 unless it is SHA256.
+func New(name string) hash.Hash {
+	if name == SHA256 {
+		return sha256.New()
+	}
+	return md5.New()
+}
+
+// File returns the hex checksum of the file at path, reading it in chunks.
+func File(path, name string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := New(name)
+	if _, err := io.CopyBuffer(h, f, make([]byte, bufferSize)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,9 @@
+// Command drive syncs local directories with Google Drive. The sync engine
+// is the syncer package.
+package main
+
+import "github.com/hiroshi/googledriveclient/syncer"
+
+func main() {
+	syncer.Main()
+}
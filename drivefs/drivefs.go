@@ -0,0 +1,458 @@
+// Package drivefs reads and changes the remote tree below a Drive folder
+// like a file system, for the gateways serving it and the commands working
+// on remote paths.
+package drivefs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	folderMimeType   = "application/vnd.google-apps.folder"
+	googleAppsPrefix = "application/vnd.google-apps."
+	shortcutMimeType = googleAppsPrefix + "shortcut"
+)
+
+// TTL is how long the listing of a folder is kept.
+const TTL = 30 * time.Second
+
+// FS is the remote tree below a folder. Folders are listed when they are
+// visited, and their listings kept for TTL. Names are made unique like in
+// a sync.
+type FS struct {
+	srv    *drive.Service
+	rootId string
+	retry  func(what string, call func() error) error
+	// Natives keeps the native documents and shortcuts, which have no
+	// content to read, in the listings.
+	Natives bool
+	// Fields are those of the files listed, DefaultFields if empty.
+	Fields string
+	mu     sync.Mutex
+	dirs   map[string]*remoteDir // by folder id
+}
+
+// DefaultFields are the fields of the files listed by default.
+const DefaultFields = "id, name, mimeType, parents, size, modifiedTime, md5Checksum"
+
+// remoteDir is the listing of a folder.
+type remoteDir struct {
+	listed time.Time
+	names  []string // sorted
+	files  map[string]drive.File
+}
+
+// New returns the tree below the folder rootId, "root" for My Drive. Its
+// requests are made through retry, which may try them again when they
+// fail.
+func New(srv *drive.Service, rootId string, retry func(what string, call func() error) error) *FS {
+	return &FS{srv: srv, rootId: rootId, retry: retry, dirs: make(map[string]*remoteDir)}
+}
+
+// RootId returns the id of the folder at the root of the tree.
+func (fs *FS) RootId() string {
+	return fs.rootId
+}
+
+// Service returns the Drive service of the tree.
+func (fs *FS) Service() *drive.Service {
+	return fs.srv
+}
+
+// list returns the listing of the folder id, listing it again if the one
+// kept is too old.
+func (fs *FS) list(ctx context.Context, id string) (*remoteDir, error) {
+	fs.mu.Lock()
+	d := fs.dirs[id]
+	fs.mu.Unlock()
+	if d != nil && time.Since(d.listed) < TTL {
+		return d, nil
+	}
+	var files []drive.File
+	pageToken := ""
+	for {
+		call := fs.srv.Files.List().
+			Q(QueryQuote(id) + " in parents and trashed = false").
+			PageSize(1000).
+			Fields(googleapi.Field("nextPageToken, files(" + fs.fields() + ")")).
+			Context(ctx)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		var r *drive.FileList
+		err := fs.retry("Listing folder "+id, func() (err error) {
+			r, err = call.Do()
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range r.Files {
+			if f.MimeType == folderMimeType || !strings.HasPrefix(f.MimeType, googleAppsPrefix) || (fs.Natives && f.MimeType != shortcutMimeType) {
+				files = append(files, *f)
+			}
+		}
+		if pageToken = r.NextPageToken; pageToken == "" {
+			break
+		}
+	}
+	// Files with the same name, in id order, keep it unless another one
+	// has it already, like in a sync.
+	sort.Slice(files, func(i, j int) bool { return files[i].Id < files[j].Id })
+	d = &remoteDir{listed: time.Now(), files: make(map[string]drive.File)}
+	for _, f := range files {
+		name := SanitizeName(f.Name, false)
+		if _, taken := d.files[name]; taken {
+			name = IdName(name, f.Id)
+		}
+		d.files[name] = f
+		d.names = append(d.names, name)
+	}
+	sort.Strings(d.names)
+	fs.mu.Lock()
+	fs.dirs[id] = d
+	fs.mu.Unlock()
+	return d, nil
+}
+
+// Stat returns the file at the slash separated path name, below the root,
+// or an error satisfying os.IsNotExist.
+func (fs *FS) Stat(ctx context.Context, name string) (FileInfo, error) {
+	info := FileInfo{name: "/", File: drive.File{Id: fs.rootId, MimeType: folderMimeType}}
+	for _, part := range strings.Split(strings.Trim(path.Clean("/"+name), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		if !info.IsDir() {
+			return FileInfo{}, os.ErrNotExist
+		}
+		d, err := fs.list(ctx, info.File.Id)
+		if err != nil {
+			return FileInfo{}, err
+		}
+		f, ok := d.files[part]
+		if !ok {
+			return FileInfo{}, os.ErrNotExist
+		}
+		info = FileInfo{name: part, File: f}
+	}
+	return info, nil
+}
+
+// ReadDir returns the files in the folder dir, by name.
+func (fs *FS) ReadDir(ctx context.Context, dir FileInfo) ([]FileInfo, error) {
+	d, err := fs.list(ctx, dir.File.Id)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]FileInfo, len(d.names))
+	for i, name := range d.names {
+		infos[i] = FileInfo{name: name, File: d.files[name]}
+	}
+	return infos, nil
+}
+
+// Open returns the content of the file from offset on.
+func (fs *FS) Open(ctx context.Context, file drive.File, offset int64) (io.ReadCloser, error) {
+	var body io.ReadCloser
+	err := fs.retry("Download of "+file.Name, func() error {
+		call := fs.srv.Files.Get(file.Id).Context(ctx)
+		if offset > 0 {
+			call.Header().Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+		resp, err := call.Download()
+		if err != nil {
+			return err
+		}
+		body = resp.Body
+		return nil
+	})
+	return body, err
+}
+
+// FileInfo is a remote file as an os.FileInfo.
+type FileInfo struct {
+	name string
+	File drive.File
+}
+
+func (i FileInfo) Name() string { return i.name }
+func (i FileInfo) Size() int64  { return i.File.Size }
+func (i FileInfo) IsDir() bool  { return i.File.MimeType == folderMimeType }
+func (i FileInfo) Sys() interface{} {
+	return nil
+}
+
+func (i FileInfo) Mode() os.FileMode {
+	if i.IsDir() {
+		return os.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (i FileInfo) ModTime() time.Time {
+	t, _ := time.Parse(time.RFC3339, i.File.ModifiedTime)
+	return t
+}
+
+// Reader reads a remote file at any offset, downloading from the offset
+// when it moves.
+type Reader struct {
+	fs      *FS
+	ctx     context.Context
+	info    FileInfo
+	offset  int64
+	body    io.ReadCloser
+	bodyOff int64 // offset the body is at
+}
+
+// NewReader returns a Reader of the file info, whose requests are made
+// with ctx.
+func (fs *FS) NewReader(ctx context.Context, info FileInfo) *Reader {
+	return &Reader{fs: fs, ctx: ctx, info: info}
+}
+
+func (r *Reader) Read(b []byte) (int, error) {
+	if r.offset >= r.info.Size() {
+		return 0, io.EOF
+	}
+	if r.body != nil && r.bodyOff != r.offset {
+		r.body.Close()
+		r.body = nil
+	}
+	if r.body == nil {
+		body, err := r.fs.Open(r.ctx, r.info.File, r.offset)
+		if err != nil {
+			return 0, err
+		}
+		r.body, r.bodyOff = body, r.offset
+	}
+	n, err := r.body.Read(b)
+	r.offset += int64(n)
+	r.bodyOff += int64(n)
+	if err == io.EOF && r.offset < r.info.Size() {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.info.Size()
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("seek before the start of %s", r.info.name)
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// Info returns the file read.
+func (r *Reader) Info() FileInfo {
+	return r.info
+}
+
+// Offset returns the offset the next Read reads at.
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+// Drop closes the download, for the next Read to download again from the
+// offset, after the connection broke.
+func (r *Reader) Drop() {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+}
+
+func (r *Reader) Close() error {
+	if r.body != nil {
+		return r.body.Close()
+	}
+	return nil
+}
+
+// Forget drops the listing kept of the folder id, after a change in it.
+func (fs *FS) Forget(id string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	delete(fs.dirs, id)
+}
+
+// Parent returns the folder above the slash separated path name, which
+// must exist, and the base name.
+func (fs *FS) Parent(ctx context.Context, name string) (FileInfo, string, error) {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return FileInfo{}, "", os.ErrPermission
+	}
+	dir, err := fs.Stat(ctx, path.Dir(name))
+	if err != nil {
+		return FileInfo{}, "", err
+	}
+	if !dir.IsDir() {
+		return FileInfo{}, "", os.ErrNotExist
+	}
+	return dir, path.Base(name), nil
+}
+
+// Mkdir creates the folder name.
+func (fs *FS) Mkdir(ctx context.Context, name string) error {
+	dir, base, err := fs.Parent(ctx, name)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.Stat(ctx, name); err == nil {
+		return os.ErrExist
+	}
+	defer fs.Forget(dir.File.Id)
+	return fs.retry("Creating folder "+name, func() error {
+		_, err := fs.srv.Files.Create(&drive.File{Name: base, MimeType: folderMimeType, Parents: []string{dir.File.Id}}).
+			Fields("id").Context(ctx).Do()
+		return err
+	})
+}
+
+// MkdirAll creates the folder name and those above it which are missing.
+func (fs *FS) MkdirAll(ctx context.Context, name string) error {
+	dir := "/"
+	for _, part := range strings.Split(strings.Trim(path.Clean("/"+name), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		dir = path.Join(dir, part)
+		info, err := fs.Stat(ctx, dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a folder", dir)
+			}
+			continue
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if err := fs.Mkdir(ctx, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Update changes the metadata of the file name.
+func (fs *FS) Update(ctx context.Context, name string, change *drive.File) error {
+	dir, _, err := fs.Parent(ctx, name)
+	if err != nil {
+		return err
+	}
+	info, err := fs.Stat(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer fs.Forget(dir.File.Id)
+	return fs.retry("Updating "+name, func() error {
+		_, err := fs.srv.Files.Update(info.File.Id, change).Fields("id").Context(ctx).Do()
+		return err
+	})
+}
+
+// Remove moves the file or empty folder name to the Drive trash.
+func (fs *FS) Remove(ctx context.Context, name string, folder bool) error {
+	info, err := fs.Stat(ctx, name)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() != folder {
+		return fmt.Errorf("%s: wrong type", name)
+	}
+	if folder {
+		children, err := fs.ReadDir(ctx, info)
+		if err != nil {
+			return err
+		}
+		if len(children) > 0 {
+			return fmt.Errorf("%s: folder not empty", name)
+		}
+	}
+	return fs.Update(ctx, name, &drive.File{Trashed: true})
+}
+
+// Rename moves the file oldName to newName, which must not exist.
+func (fs *FS) Rename(ctx context.Context, oldName, newName string) error {
+	oldDir, _, err := fs.Parent(ctx, oldName)
+	if err != nil {
+		return err
+	}
+	info, err := fs.Stat(ctx, oldName)
+	if err != nil {
+		return err
+	}
+	newDir, base, err := fs.Parent(ctx, newName)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.Stat(ctx, newName); err == nil {
+		return os.ErrExist
+	}
+	defer fs.Forget(oldDir.File.Id)
+	defer fs.Forget(newDir.File.Id)
+	return fs.retry("Renaming "+oldName, func() error {
+		call := fs.srv.Files.Update(info.File.Id, &drive.File{Name: base}).Fields("id").Context(ctx)
+		if newDir.File.Id != oldDir.File.Id {
+			call = call.AddParents(newDir.File.Id).RemoveParents(oldDir.File.Id)
+		}
+		_, err := call.Do()
+		return err
+	})
+}
+
+// Upload uploads content as the file name, replacing its content if it
+// exists, with the modification time modTime unless it is zero.
+func (fs *FS) Upload(ctx context.Context, name string, content io.ReadSeeker, modTime time.Time) error {
+	dir, base, err := fs.Parent(ctx, name)
+	if err != nil {
+		return err
+	}
+	existing, err := fs.Stat(ctx, name)
+	if err == nil && existing.IsDir() {
+		return fmt.Errorf("%s is a folder", name)
+	}
+	meta := &drive.File{}
+	if !modTime.IsZero() {
+		meta.ModifiedTime = modTime.UTC().Format(time.RFC3339)
+	}
+	defer fs.Forget(dir.File.Id)
+	return fs.retry("Upload of "+name, func() (err error) {
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if existing.File.Id != "" {
+			_, err = fs.srv.Files.Update(existing.File.Id, meta).Media(content).Fields("id").Context(ctx).Do()
+		} else {
+			meta.Name, meta.Parents = base, []string{dir.File.Id}
+			_, err = fs.srv.Files.Create(meta).Media(content).Fields("id").Context(ctx).Do()
+		}
+		return err
+	})
+}
+
+func (fs *FS) fields() string {
+	if fs.Fields == "" {
+		return DefaultFields
+	}
+	return fs.Fields
+}
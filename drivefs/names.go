@@ -0,0 +1,77 @@
+package drivefs
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+var queryEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// QueryQuote quotes s as a string in a Files.List query.
+func QueryQuote(s string) string {
+	return "'" + queryEscaper.Replace(s) + "'"
+}
+
+// IdName returns a name with the id inserted before the extension, as in
+// "report (id-1a2b3c4d).pdf".
+func IdName(name string, id string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	return fmt.Sprintf("%s (id-%s)%s", base, id, ext)
+}
+
+// windowsReplacer replaces the characters Windows doesn't allow in names by
+// their full width forms, which keeps them readable and reversible.
+var windowsReplacer = strings.NewReplacer(
+	":", "：", "*", "＊", "?", "？", `"`, "＂", "<", "＜", ">", "＞", "|", "｜", `\`, "＼")
+
+// windowsReserved are the device names Windows doesn't allow as file names,
+// with or without an extension.
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeName turns a remote name into one usable as a local file name.
+// Slashes are always replaced; with windows also the characters, trailing
+// dots and spaces, and device names Windows rejects.
+func SanitizeName(name string, windows bool) string {
+	name = strings.Replace(name, "/", "／", -1)
+	if name == "" || name == "." || name == ".." {
+		return strings.Replace(name, ".", "．", -1) + "_"
+	}
+	if !windows {
+		return name
+	}
+	name = windowsReplacer.Replace(name)
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 {
+			return 0x2400 + r // control picture
+		}
+		return r
+	}, name)
+	for end := len(name); end > 0; end = len(name) {
+		if name[end-1] == '.' {
+			name = name[:end-1] + "．"
+		} else if name[end-1] == ' ' {
+			name = name[:end-1] + "␠"
+		} else {
+			break
+		}
+	}
+	base := name
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		base = name[:i]
+	}
+	if windowsReserved[strings.ToUpper(base)] {
+		name = base + "_" + name[len(base):]
+	}
+	return name
+}
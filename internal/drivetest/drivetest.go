@@ -0,0 +1,1117 @@
+// Package drivetest serves an in-memory stand-in for the Drive API, which
+// the end-to-end tests sync against.
+package drivetest
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// RootId is the id of My Drive.
+const RootId = "fakeroot"
+
+const (
+	fakeDrivePrefix  = "/drive/v3/"
+	fakeUploadPrefix = "/upload/drive/v3/"
+	fakeSessionPath  = "/upload/session/"
+	fakeSheetsPrefix = "/v4/spreadsheets/"
+	fakeLabelsPath   = "/v2/labels"
+	// fakeUser is the email address of the account.
+	fakeUser = "me@example.com"
+)
+
+// The MIME types of Drive the fake tells apart.
+const (
+	googleAppsPrefix    = "application/vnd.google-apps."
+	folderMimeType      = googleAppsPrefix + "folder"
+	spreadsheetMimeType = googleAppsPrefix + "spreadsheet"
+	colabMimeType       = "application/vnd.google.colaboratory"
+	markdownMimeType    = "text/markdown"
+)
+
+// Drive is an in-memory Drive API serving the Files calls the client
+// makes: listing with queries and pages, metadata, downloads, exports and
+// multipart and resumable uploads, labels and permissions, and the Sheets calls of -sheets-csv. It fails requests on purpose to exercise
+// the retries: a share of ErrorRate of them, those beyond RateLimit a
+// second, and those queued with FailNext.
+type Drive struct {
+	mu       sync.Mutex
+	files    map[string]*fakeFile
+	sessions map[string]*fakeSession
+	nextId   int
+
+	// PageSize, ErrorRate, RateLimit and Quota are set before serving.
+	PageSize  int     // files per page at most, 0 for the page size asked
+	ErrorRate float64 // share of requests failing with 503 or cut short
+	RateLimit int     // requests a second, 0 for no limit
+	Quota     int64   // storage limit in bytes, 0 for unlimited
+
+	window   time.Time
+	inWindow int
+	failures []int // statuses of the next requests
+
+	requests int // served, failures included
+}
+
+type fakeFile struct {
+	file drive.File
+	data []byte
+}
+
+// fakeSession is a resumable upload in progress.
+type fakeSession struct {
+	fileId string // the file updated, "" to create one
+	meta   map[string]json.RawMessage
+	data   []byte
+}
+
+// New returns an empty Drive.
+func New() *Drive {
+	d := &Drive{files: make(map[string]*fakeFile), sessions: make(map[string]*fakeSession)}
+	d.files[RootId] = &fakeFile{file: drive.File{
+		Id:        RootId,
+		Name:      "My Drive",
+		MimeType:  folderMimeType,
+		OwnedByMe: true,
+	}}
+	return d
+}
+
+// FailNext makes the next requests fail with the statuses, in order.
+func (d *Drive) FailNext(statuses ...int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failures = append(d.failures, statuses...)
+}
+
+// add adds a file below the folder parent and returns its id.
+func (d *Drive) Add(parent, name, mimeType string, data []byte, modTime time.Time) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f := &fakeFile{file: drive.File{
+		Name:         name,
+		MimeType:     mimeType,
+		Parents:      []string{parent},
+		ModifiedTime: modTime.UTC().Format(time.RFC3339),
+	}}
+	d.store(f, data)
+	return f.file.Id
+}
+
+// seed adds the files below the local directory dir to the root.
+func (d *Drive) Seed(dir string) error {
+	ids := map[string]string{".": RootId}
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		if rel == "." {
+			return nil
+		}
+		parent := ids[filepath.Dir(rel)]
+		if info.IsDir() {
+			ids[rel] = d.Add(parent, info.Name(), folderMimeType, nil, info.ModTime())
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		mimeType := mime.TypeByExtension(filepath.Ext(p))
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+		d.Add(parent, info.Name(), mimeType, data, info.ModTime())
+		return nil
+	})
+}
+
+// Files returns the files but My Drive, by id.
+func (d *Drive) Files() map[string]drive.File {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	files := make(map[string]drive.File, len(d.files))
+	for id, f := range d.files {
+		if id != RootId {
+			files[id] = f.file
+		}
+	}
+	return files
+}
+
+// Content returns the content of the file id.
+func (d *Drive) Content(id string) []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if f, ok := d.files[id]; ok {
+		return f.data
+	}
+	return nil
+}
+
+// Requests returns the number of requests served, failures included.
+func (d *Drive) Requests() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.requests
+}
+
+// store gives f an id if it has none, sets its content and the fields
+// derived from it, and keeps it.
+func (d *Drive) store(f *fakeFile, data []byte) {
+	if f.file.Id == "" {
+		d.nextId++
+		f.file.Id = fmt.Sprintf("fake%06d", d.nextId)
+	}
+	if f.file.ModifiedTime == "" {
+		f.file.ModifiedTime = time.Now().UTC().Format(time.RFC3339)
+	}
+	if len(f.file.Parents) == 0 {
+		f.file.Parents = []string{RootId}
+	}
+	f.file.OwnedByMe = true
+	f.file.Owners = []*drive.User{{EmailAddress: fakeUser}}
+	f.file.Capabilities = &drive.FileCapabilities{CanEdit: true}
+	f.file.WebViewLink = "https://drive.google.com/open?id=" + f.file.Id
+	if f.file.MimeType != folderMimeType && !strings.HasPrefix(f.file.MimeType, googleAppsPrefix) {
+		sum := md5.Sum(data)
+		f.data = data
+		f.file.Size = int64(len(data))
+		// Like on Drive, Colab notebooks have no checksum.
+		if f.file.MimeType != colabMimeType {
+			f.file.Md5Checksum = hex.EncodeToString(sum[:])
+			sha := sha256.Sum256(data)
+			f.file.Sha256Checksum = hex.EncodeToString(sha[:])
+		}
+	}
+	d.files[f.file.Id] = f
+}
+
+// merge sets the fields of meta on f, as a metadata update does.
+func mergeFakeFile(f *drive.File, meta map[string]json.RawMessage) error {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	for key, value := range meta {
+		if key != "id" {
+			fields[key] = value
+		}
+	}
+	if b, err = json.Marshal(fields); err != nil {
+		return err
+	}
+	*f = drive.File{}
+	return json.Unmarshal(b, f)
+}
+
+// fakeDriveError writes an error the way the Drive API does.
+func fakeDriveError(w http.ResponseWriter, code int, reason, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+			"errors":  []map[string]string{{"domain": "global", "reason": reason, "message": message}},
+		},
+	})
+}
+
+func fakeDriveJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// fail reports whether the request fails on purpose, writing the error.
+func (d *Drive) fail(w http.ResponseWriter) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.requests++
+	if len(d.failures) > 0 {
+		code := d.failures[0]
+		d.failures = d.failures[1:]
+		reason := "backendError"
+		if code == http.StatusForbidden {
+			reason = "userRateLimitExceeded"
+		}
+		fakeDriveError(w, code, reason, "Failure asked for")
+		return true
+	}
+	if d.RateLimit > 0 {
+		now := time.Now()
+		if now.Sub(d.window) >= time.Second {
+			d.window, d.inWindow = now, 0
+		}
+		if d.inWindow++; d.inWindow > d.RateLimit {
+			fakeDriveError(w, http.StatusForbidden, "userRateLimitExceeded", "User Rate Limit Exceeded")
+			return true
+		}
+	}
+	if d.ErrorRate > 0 && rand.Float64() < d.ErrorRate/2 {
+		fakeDriveError(w, http.StatusServiceUnavailable, "backendError", "Backend Error")
+		return true
+	}
+	return false
+}
+
+// cut reports whether a download is cut short on purpose.
+func (d *Drive) cut() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.ErrorRate > 0 && rand.Float64() < d.ErrorRate/2
+}
+
+func (d *Drive) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if d.fail(w) {
+		return
+	}
+	switch p := r.URL.Path; {
+	case p == fakeDrivePrefix+"about" && r.Method == http.MethodGet:
+		d.about(w)
+	case p == fakeDrivePrefix+"files" && r.Method == http.MethodGet:
+		d.list(w, r)
+	case p == fakeDrivePrefix+"files" && r.Method == http.MethodPost:
+		d.create(w, r)
+	case strings.HasPrefix(p, fakeDrivePrefix+"files/") && strings.HasSuffix(p, "/export"):
+		d.export(w, r, strings.TrimSuffix(strings.TrimPrefix(p, fakeDrivePrefix+"files/"), "/export"))
+	case strings.HasPrefix(p, fakeDrivePrefix+"files/") && strings.HasSuffix(p, "/modifyLabels") && r.Method == http.MethodPost:
+		d.modifyLabels(w, r, strings.TrimSuffix(strings.TrimPrefix(p, fakeDrivePrefix+"files/"), "/modifyLabels"))
+	case strings.HasPrefix(p, fakeDrivePrefix+"files/") && strings.HasSuffix(p, "/listLabels") && r.Method == http.MethodGet:
+		d.listLabels(w, strings.TrimSuffix(strings.TrimPrefix(p, fakeDrivePrefix+"files/"), "/listLabels"))
+	case strings.HasPrefix(p, fakeDrivePrefix+"files/") && strings.Contains(p, "/permissions"):
+		id, perm, _ := strings.Cut(strings.TrimPrefix(p, fakeDrivePrefix+"files/"), "/permissions")
+		d.permissions(w, r, id, strings.TrimPrefix(perm, "/"))
+	case strings.HasPrefix(p, fakeDrivePrefix+"files/"):
+		d.file(w, r, strings.TrimPrefix(p, fakeDrivePrefix+"files/"))
+	case p == fakeUploadPrefix+"files" && r.Method == http.MethodPost:
+		d.upload(w, r, "")
+	case strings.HasPrefix(p, fakeUploadPrefix+"files/") && r.Method == http.MethodPatch:
+		d.upload(w, r, strings.TrimPrefix(p, fakeUploadPrefix+"files/"))
+	case strings.HasPrefix(p, fakeSessionPath):
+		d.chunk(w, r, strings.TrimPrefix(p, fakeSessionPath))
+	case strings.HasPrefix(p, fakeSheetsPrefix) && r.Method == http.MethodGet:
+		d.spreadsheet(w, r, strings.TrimPrefix(p, fakeSheetsPrefix))
+	case p == fakeLabelsPath && r.Method == http.MethodGet:
+		fakeDriveJSON(w, map[string]interface{}{"labels": fakeLabels})
+	default:
+		fakeDriveError(w, http.StatusNotFound, "notFound", "No such endpoint "+r.Method+" "+p)
+	}
+}
+
+// about answers with the storage quota, the files counting towards it.
+func (d *Drive) about(w http.ResponseWriter) {
+	d.mu.Lock()
+	var usage, trash int64
+	for _, f := range d.files {
+		usage += int64(len(f.data))
+		if f.file.Trashed {
+			trash += int64(len(f.data))
+		}
+	}
+	q := &drive.AboutStorageQuota{Usage: usage, UsageInDrive: usage, UsageInDriveTrash: trash, Limit: d.Quota}
+	d.mu.Unlock()
+	fakeDriveJSON(w, &drive.About{StorageQuota: q, User: &drive.User{EmailAddress: fakeUser}})
+}
+
+func (d *Drive) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	match, err := parseFakeQuery(q)
+	if err != nil {
+		fakeDriveError(w, http.StatusBadRequest, "invalid", "Invalid Value: "+err.Error())
+		return
+	}
+	size := 100
+	if s := r.URL.Query().Get("pageSize"); s != "" {
+		if size, err = strconv.Atoi(s); err != nil || size < 1 || size > 1000 {
+			fakeDriveError(w, http.StatusBadRequest, "invalid", "Invalid pageSize")
+			return
+		}
+	}
+	start := 0
+	if token := r.URL.Query().Get("pageToken"); token != "" {
+		if start, err = strconv.Atoi(strings.TrimPrefix(token, "page-")); err != nil || start < 0 {
+			fakeDriveError(w, http.StatusBadRequest, "invalid", "Invalid pageToken")
+			return
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.PageSize > 0 && d.PageSize < size {
+		size = d.PageSize
+	}
+	// Like on Drive, trashed files are listed unless the query says
+	// trashed = false.
+	var matches []*drive.File
+	for _, f := range d.files {
+		if f.file.Id == RootId || !match(&f.file) {
+			continue
+		}
+		file := f.file
+		matches = append(matches, &file)
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Id < matches[j].Id })
+	list := &drive.FileList{Files: []*drive.File{}}
+	if start < len(matches) {
+		end := start + size
+		if end < len(matches) {
+			list.NextPageToken = fmt.Sprintf("page-%d", end)
+		} else {
+			end = len(matches)
+		}
+		list.Files = matches[start:end]
+	}
+	fakeDriveJSON(w, list)
+}
+
+func (d *Drive) lookup(w http.ResponseWriter, id string) *fakeFile {
+	if id == "root" {
+		id = RootId
+	}
+	f := d.files[id]
+	if f == nil {
+		fakeDriveError(w, http.StatusNotFound, "notFound", "File not found: "+id)
+	}
+	return f
+}
+
+// file serves files.get, files.update and files.delete.
+func (d *Drive) file(w http.ResponseWriter, r *http.Request, id string) {
+	d.mu.Lock()
+	f := d.lookup(w, id)
+	if f == nil {
+		d.mu.Unlock()
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		file, data := f.file, f.data
+		d.mu.Unlock()
+		if r.URL.Query().Get("alt") != "media" {
+			fakeDriveJSON(w, &file)
+			return
+		}
+		if file.MimeType == folderMimeType || strings.HasPrefix(file.MimeType, googleAppsPrefix) {
+			fakeDriveError(w, http.StatusForbidden, "fileNotDownloadable", "Only files with binary content can be downloaded")
+			return
+		}
+		if rng := r.Header.Get("Range"); strings.HasPrefix(rng, "bytes=") && strings.HasSuffix(rng, "-") {
+			start, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+			if err != nil || start >= len(data) {
+				fakeDriveError(w, http.StatusRequestedRangeNotSatisfiable, "invalid", "Invalid Range")
+				return
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(data)-1, len(data)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[start:])
+			return
+		}
+		d.serveContent(w, file.MimeType, data)
+	case http.MethodPatch:
+		defer d.mu.Unlock()
+		var meta map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&meta); err != nil {
+			fakeDriveError(w, http.StatusBadRequest, "parseError", err.Error())
+			return
+		}
+		if err := d.update(f, r, meta); err != nil {
+			fakeDriveError(w, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+		fakeDriveJSON(w, &f.file)
+	case http.MethodDelete:
+		delete(d.files, f.file.Id)
+		d.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		d.mu.Unlock()
+		fakeDriveError(w, http.StatusMethodNotAllowed, "badRequest", "Method not allowed")
+	}
+}
+
+// update sets meta and the parents added and removed by the query of r on
+// f.
+func (d *Drive) update(f *fakeFile, r *http.Request, meta map[string]json.RawMessage) error {
+	if err := mergeFakeFile(&f.file, meta); err != nil {
+		return err
+	}
+	query := r.URL.Query()
+	if remove := query.Get("removeParents"); remove != "" {
+		remove = strings.Replace(remove, "root", RootId, -1)
+		var parents []string
+		for _, p := range f.file.Parents {
+			if !strings.Contains(","+remove+",", ","+p+",") {
+				parents = append(parents, p)
+			}
+		}
+		f.file.Parents = parents
+	}
+	if add := query.Get("addParents"); add != "" {
+		for _, p := range strings.Split(add, ",") {
+			if p == "root" {
+				p = RootId
+			}
+			f.file.Parents = append(f.file.Parents, p)
+		}
+	}
+	return nil
+}
+
+// serveContent writes data, cutting it short now and then with
+// ErrorRate.
+func (d *Drive) serveContent(w http.ResponseWriter, mimeType string, data []byte) {
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if len(data) > 1 && d.cut() {
+		// Returning before the whole length is written drops the
+		// connection.
+		w.Write(data[:len(data)/2])
+		return
+	}
+	w.Write(data)
+}
+
+func (d *Drive) export(w http.ResponseWriter, r *http.Request, id string) {
+	d.mu.Lock()
+	f := d.lookup(w, id)
+	if f == nil {
+		d.mu.Unlock()
+		return
+	}
+	file := f.file
+	d.mu.Unlock()
+	if !strings.HasPrefix(file.MimeType, googleAppsPrefix) || file.MimeType == folderMimeType {
+		fakeDriveError(w, http.StatusForbidden, "fileNotExportable", "Export only supports Docs Editors files")
+		return
+	}
+	// The export is made up: the name and type of the document, and in
+	// Markdown an image the way Drive embeds them.
+	content := file.Name + "\n" + file.MimeType + "\n"
+	if r.URL.Query().Get("mimeType") == markdownMimeType {
+		content += "\n![][image1]\n\n[image1]: <data:image/png;base64," + fakeImage + ">\n"
+	}
+	d.serveContent(w, r.URL.Query().Get("mimeType"), []byte(content))
+}
+
+// fakeImage is a PNG of one pixel, in base64.
+const fakeImage = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAQAAAC1HAwCAAAAC0lEQVR42mNkYAAAAAYAAjCB0C8AAAAASUVORK5CYII="
+
+// fakeLabels are the labels of the Drive Labels API.
+var fakeLabels = []map[string]interface{}{
+	{"id": "records", "properties": map[string]string{"title": "Records"}, "fields": []interface{}{
+		map[string]interface{}{"id": "class", "properties": map[string]string{"displayName": "Classification"},
+			"selectionOptions": map[string]interface{}{"choices": []interface{}{
+				map[string]interface{}{"id": "public", "properties": map[string]string{"displayName": "Public"}},
+				map[string]interface{}{"id": "internal", "properties": map[string]string{"displayName": "Internal"}},
+			}}},
+		map[string]interface{}{"id": "retain", "properties": map[string]string{"displayName": "Retain until"}, "dateOptions": map[string]string{}},
+	}},
+	{"id": "reviewed", "properties": map[string]string{"title": "Reviewed"}},
+}
+
+// fakeSheetTabs are the tabs of every spreadsheet.
+var fakeSheetTabs = []string{"Sheet1", "It's 50/50"}
+
+// spreadsheet serves spreadsheets.get and spreadsheets.values.batchGet, on
+// tabs and values as made up as exports.
+func (d *Drive) spreadsheet(w http.ResponseWriter, r *http.Request, p string) {
+	id := strings.TrimSuffix(p, "/values:batchGet")
+	d.mu.Lock()
+	f := d.lookup(w, id)
+	if f == nil {
+		d.mu.Unlock()
+		return
+	}
+	file := f.file
+	d.mu.Unlock()
+	if file.MimeType != spreadsheetMimeType {
+		fakeDriveError(w, http.StatusBadRequest, "failedPrecondition", "This operation is not supported for this document")
+		return
+	}
+	if id == p {
+		var tabs []interface{}
+		for _, title := range fakeSheetTabs {
+			tabs = append(tabs, map[string]interface{}{"properties": map[string]string{"title": title}})
+		}
+		fakeDriveJSON(w, map[string]interface{}{"spreadsheetId": id, "sheets": tabs})
+		return
+	}
+	var ranges []interface{}
+	for _, rng := range r.URL.Query()["ranges"] {
+		ranges = append(ranges, map[string]interface{}{
+			"range":  rng + "!A1:B2",
+			"values": [][]string{{file.Name, file.ModifiedTime}, {rng, "1,5"}},
+		})
+	}
+	fakeDriveJSON(w, map[string]interface{}{"spreadsheetId": id, "valueRanges": ranges})
+}
+
+// modifyLabels applies labels to the file id or removes them. The fields
+// set replace the values of the fields before.
+func (d *Drive) modifyLabels(w http.ResponseWriter, r *http.Request, id string) {
+	var req drive.ModifyLabelsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		fakeDriveError(w, http.StatusBadRequest, "parseError", "Parse Error")
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f := d.lookup(w, id)
+	if f == nil {
+		return
+	}
+	if f.file.LabelInfo == nil {
+		f.file.LabelInfo = &drive.FileLabelInfo{}
+	}
+	var modified []*drive.Label
+	for _, m := range req.LabelModifications {
+		var label *drive.Label
+		var kept []*drive.Label
+		for _, l := range f.file.LabelInfo.Labels {
+			if l.Id == m.LabelId {
+				label = l
+			} else {
+				kept = append(kept, l)
+			}
+		}
+		if m.RemoveLabel {
+			f.file.LabelInfo.Labels = kept
+			continue
+		}
+		if label == nil {
+			label = &drive.Label{Id: m.LabelId, Kind: "drive#label"}
+			f.file.LabelInfo.Labels = append(f.file.LabelInfo.Labels, label)
+		}
+		if label.Fields == nil {
+			label.Fields = make(map[string]drive.LabelField)
+		}
+		for _, fm := range m.FieldModifications {
+			field := drive.LabelField{Id: fm.FieldId, Kind: "drive#labelField",
+				Text: fm.SetTextValues, Selection: fm.SetSelectionValues, DateString: fm.SetDateValues, Integer: fm.SetIntegerValues}
+			if fm.UnsetValues {
+				delete(label.Fields, fm.FieldId)
+			} else {
+				label.Fields[fm.FieldId] = field
+			}
+		}
+		modified = append(modified, label)
+	}
+	fakeDriveJSON(w, &drive.ModifyLabelsResponse{ModifiedLabels: modified})
+}
+
+// permissions lists, creates, updates and deletes the permissions of the
+// file id, those other than the owner's. perm is the id of the permission
+// updated or deleted.
+func (d *Drive) permissions(w http.ResponseWriter, r *http.Request, id, perm string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f := d.lookup(w, id)
+	if f == nil {
+		return
+	}
+	if perm == "" {
+		switch r.Method {
+		case http.MethodGet:
+			fakeDriveJSON(w, &drive.PermissionList{Permissions: f.file.Permissions})
+		case http.MethodPost:
+			var p drive.Permission
+			if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+				fakeDriveError(w, http.StatusBadRequest, "parseError", "Parse Error")
+				return
+			}
+			if !d.transfer(w, r, f, &p) {
+				return
+			}
+			d.nextId++
+			p.Id = fmt.Sprintf("perm%06d", d.nextId)
+			f.file.Permissions = append(f.file.Permissions, &p)
+			fakeDriveJSON(w, &p)
+		default:
+			fakeDriveError(w, http.StatusMethodNotAllowed, "badRequest", "Method not allowed")
+		}
+		return
+	}
+	for i, p := range f.file.Permissions {
+		if p.Id != perm {
+			continue
+		}
+		switch r.Method {
+		case http.MethodPatch:
+			var change drive.Permission
+			if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+				fakeDriveError(w, http.StatusBadRequest, "parseError", "Parse Error")
+				return
+			}
+			change.EmailAddress = p.EmailAddress
+			if !d.transfer(w, r, f, &change) {
+				return
+			}
+			p.Role, p.PendingOwner = change.Role, change.PendingOwner
+			fakeDriveJSON(w, p)
+		case http.MethodDelete:
+			f.file.Permissions = append(f.file.Permissions[:i], f.file.Permissions[i+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			fakeDriveError(w, http.StatusMethodNotAllowed, "badRequest", "Method not allowed")
+		}
+		return
+	}
+	fakeDriveError(w, http.StatusNotFound, "notFound", "Permission not found: "+perm)
+}
+
+// transfer checks the permission p given on f and makes its user the owner
+// of f if p has the role owner, writing an error if it can't. Users at
+// gmail.com are taken to be outside the domain, to accept the ownership.
+func (d *Drive) transfer(w http.ResponseWriter, r *http.Request, f *fakeFile, p *drive.Permission) bool {
+	if p.PendingOwner && p.Role != "writer" {
+		fakeDriveError(w, http.StatusBadRequest, "pendingOwnerWriterRequired", "A pending owner has to be a writer")
+		return false
+	}
+	if p.Role != "owner" {
+		return true
+	}
+	switch {
+	case r.URL.Query().Get("transferOwnership") != "true":
+		fakeDriveError(w, http.StatusForbidden, "forbidden", "The transferOwnership parameter must be enabled when the permission role is 'owner'")
+		return false
+	case !f.file.OwnedByMe:
+		fakeDriveError(w, http.StatusForbidden, "insufficientFilePermissions", "The user does not own the file")
+		return false
+	case strings.HasSuffix(p.EmailAddress, "@gmail.com"):
+		fakeDriveError(w, http.StatusForbidden, "consentRequiredForOwnershipTransfer", "Consent is required to transfer ownership of a file to another user")
+		return false
+	}
+	f.file.OwnedByMe = false
+	f.file.Owners = []*drive.User{{EmailAddress: p.EmailAddress}}
+	p.PendingOwner = false
+	return true
+}
+
+// listLabels answers with the labels of the file id.
+func (d *Drive) listLabels(w http.ResponseWriter, id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f := d.lookup(w, id)
+	if f == nil {
+		return
+	}
+	list := &drive.LabelList{}
+	if f.file.LabelInfo != nil {
+		list.Labels = f.file.LabelInfo.Labels
+	}
+	fakeDriveJSON(w, list)
+}
+
+// create serves files.create without content, e.g. of a folder.
+func (d *Drive) create(w http.ResponseWriter, r *http.Request) {
+	var meta map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&meta); err != nil {
+		fakeDriveError(w, http.StatusBadRequest, "parseError", err.Error())
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f, err := d.save("", meta, nil)
+	if err != nil {
+		fakeDriveError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+	fakeDriveJSON(w, &f.file)
+}
+
+// save creates a file with meta and data, or updates the file id. d.mu
+// must be held.
+func (d *Drive) save(id string, meta map[string]json.RawMessage, data []byte) (*fakeFile, error) {
+	f := &fakeFile{}
+	if id != "" {
+		if f = d.files[id]; f == nil {
+			return nil, fmt.Errorf("file not found: %s", id)
+		}
+	}
+	if err := mergeFakeFile(&f.file, meta); err != nil {
+		return nil, err
+	}
+	if _, ok := meta["modifiedTime"]; !ok {
+		f.file.ModifiedTime = ""
+	}
+	for i, parent := range f.file.Parents {
+		if parent == "root" {
+			f.file.Parents[i], parent = RootId, RootId
+		}
+		if p := d.files[parent]; p == nil || p.file.MimeType != folderMimeType {
+			return nil, fmt.Errorf("no folder %s", parent)
+		}
+	}
+	d.store(f, data)
+	return f, nil
+}
+
+// upload serves files.create and files.update with content, of
+// uploadType multipart or resumable.
+func (d *Drive) upload(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.URL.Query().Get("uploadType") {
+	case "multipart":
+		meta, data, err := readMultipart(r)
+		if err != nil {
+			fakeDriveError(w, http.StatusBadRequest, "parseError", err.Error())
+			return
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		f, err := d.save(id, meta, data)
+		if err != nil {
+			fakeDriveError(w, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+		fakeDriveJSON(w, &f.file)
+	case "resumable":
+		meta := make(map[string]json.RawMessage)
+		if b, err := ioutil.ReadAll(r.Body); err != nil {
+			fakeDriveError(w, http.StatusBadRequest, "parseError", err.Error())
+			return
+		} else if len(b) > 0 {
+			if err := json.Unmarshal(b, &meta); err != nil {
+				fakeDriveError(w, http.StatusBadRequest, "parseError", err.Error())
+				return
+			}
+		}
+		if t := r.Header.Get("X-Upload-Content-Type"); t != "" && meta["mimeType"] == nil {
+			meta["mimeType"], _ = json.Marshal(t)
+		}
+		d.mu.Lock()
+		d.nextId++
+		session := fmt.Sprintf("session%06d", d.nextId)
+		d.sessions[session] = &fakeSession{fileId: id, meta: meta}
+		d.mu.Unlock()
+		w.Header().Set("Location", "http://"+r.Host+fakeSessionPath+session)
+		w.WriteHeader(http.StatusOK)
+	default:
+		fakeDriveError(w, http.StatusBadRequest, "invalid", "Unsupported uploadType")
+	}
+}
+
+// readMultipart reads the metadata and content of a multipart upload.
+func readMultipart(r *http.Request) (map[string]json.RawMessage, []byte, error) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, nil, fmt.Errorf("not a multipart upload")
+	}
+	parts := multipart.NewReader(r.Body, params["boundary"])
+	part, err := parts.NextPart()
+	if err != nil {
+		return nil, nil, err
+	}
+	meta := make(map[string]json.RawMessage)
+	if err := json.NewDecoder(part).Decode(&meta); err != nil {
+		return nil, nil, err
+	}
+	if part, err = parts.NextPart(); err != nil {
+		return nil, nil, err
+	}
+	data, err := ioutil.ReadAll(part)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meta["mimeType"] == nil && part.Header.Get("Content-Type") != "" {
+		meta["mimeType"], _ = json.Marshal(part.Header.Get("Content-Type"))
+	}
+	return meta, data, nil
+}
+
+// chunk takes a chunk of a resumable upload, saving the file with the last
+// one.
+func (d *Drive) chunk(w http.ResponseWriter, r *http.Request, id string) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := d.sessions[id]
+	if s == nil {
+		fakeDriveError(w, http.StatusNotFound, "notFound", "No upload session "+id)
+		return
+	}
+	// Content-Range is "bytes first-last/total", total "*" until the last
+	// chunk, or "bytes */total" for an empty last one.
+	var first, total int64 = int64(len(s.data)), -1
+	spec := strings.TrimPrefix(r.Header.Get("Content-Range"), "bytes ")
+	if i := strings.IndexByte(spec, '/'); i >= 0 {
+		if spec[i+1:] != "*" {
+			if total, err = strconv.ParseInt(spec[i+1:], 10, 64); err != nil {
+				fakeDriveError(w, http.StatusBadRequest, "invalid", "Invalid Content-Range")
+				return
+			}
+		}
+		if rng := spec[:i]; rng != "*" {
+			if first, err = strconv.ParseInt(strings.Split(rng, "-")[0], 10, 64); err != nil {
+				fakeDriveError(w, http.StatusBadRequest, "invalid", "Invalid Content-Range")
+				return
+			}
+		}
+	}
+	if first > int64(len(s.data)) {
+		fakeDriveError(w, http.StatusBadRequest, "invalid", "Chunk beyond the bytes received")
+		return
+	}
+	// A chunk sent again replaces what was received of it.
+	s.data = append(s.data[:first], data...)
+	if total < 0 || int64(len(s.data)) < total {
+		w.Header().Set("X-Http-Status-Code-Override", "308")
+		if len(s.data) > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(s.data)-1))
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	delete(d.sessions, id)
+	f, err := d.save(s.fileId, s.meta, s.data)
+	if err != nil {
+		fakeDriveError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+	fakeDriveJSON(w, &f.file)
+}
+
+// fakeQuery tells whether a file matches a Files.List query.
+type fakeQuery func(f *drive.File) bool
+
+// parseFakeQuery parses the subset of the query language the client uses:
+// "'id' in parents", "'labels/id' in labels", name, mimeType and trashed
+// compared with =, != or contains, and, or, not and parentheses.
+func parseFakeQuery(q string) (fakeQuery, error) {
+	tokens, err := queryTokens(q)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return func(*drive.File) bool { return true }, nil
+	}
+	p := &queryParser{tokens: tokens}
+	match, err := p.or()
+	if err == nil && p.pos < len(tokens) {
+		err = fmt.Errorf("unexpected %q", tokens[p.pos].text)
+	}
+	return match, err
+}
+
+type queryToken struct {
+	text   string
+	quoted bool
+}
+
+func queryTokens(q string) ([]queryToken, error) {
+	var tokens []queryToken
+	for i := 0; i < len(q); {
+		switch c := q[i]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '\'':
+			var b strings.Builder
+			for i++; ; i++ {
+				if i >= len(q) {
+					return nil, fmt.Errorf("unterminated string")
+				}
+				if q[i] == '\\' && i+1 < len(q) {
+					i++
+				} else if q[i] == '\'' {
+					break
+				}
+				b.WriteByte(q[i])
+			}
+			tokens = append(tokens, queryToken{text: b.String(), quoted: true})
+			i++
+		case c == '(' || c == ')' || c == '=':
+			tokens = append(tokens, queryToken{text: string(c)})
+			i++
+		case strings.HasPrefix(q[i:], "!="):
+			tokens = append(tokens, queryToken{text: "!="})
+			i += 2
+		default:
+			j := i
+			for j < len(q) && strings.IndexByte(" \t\n'()=!", q[j]) < 0 {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("unexpected %q", q[i:i+1])
+			}
+			tokens = append(tokens, queryToken{text: q[i:j]})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) next() (queryToken, error) {
+	if p.pos >= len(p.tokens) {
+		return queryToken{}, fmt.Errorf("unexpected end of query")
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+// peek reports whether the next token is the keyword word.
+func (p *queryParser) peek(word string) bool {
+	return p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && p.tokens[p.pos].text == word
+}
+
+func (p *queryParser) or() (fakeQuery, error) {
+	left, err := p.and()
+	for err == nil && p.peek("or") {
+		p.pos++
+		var right fakeQuery
+		if right, err = p.and(); err == nil {
+			l := left
+			left = func(f *drive.File) bool { return l(f) || right(f) }
+		}
+	}
+	return left, err
+}
+
+func (p *queryParser) and() (fakeQuery, error) {
+	left, err := p.unary()
+	for err == nil && p.peek("and") {
+		p.pos++
+		var right fakeQuery
+		if right, err = p.unary(); err == nil {
+			l := left
+			left = func(f *drive.File) bool { return l(f) && right(f) }
+		}
+	}
+	return left, err
+}
+
+func (p *queryParser) unary() (fakeQuery, error) {
+	switch {
+	case p.peek("not"):
+		p.pos++
+		inner, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(f *drive.File) bool { return !inner(f) }, nil
+	case p.peek("("):
+		p.pos++
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return inner, nil
+	}
+	return p.term()
+}
+
+func (p *queryParser) term() (fakeQuery, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if t.quoted {
+		if !p.peek("in") {
+			return nil, fmt.Errorf("expected in after %q", t.text)
+		}
+		p.pos++
+		if p.peek("labels") {
+			p.pos++
+			id := strings.TrimPrefix(t.text, "labels/")
+			return func(f *drive.File) bool {
+				if f.LabelInfo == nil {
+					return false
+				}
+				for _, l := range f.LabelInfo.Labels {
+					if l.Id == id {
+						return true
+					}
+				}
+				return false
+			}, nil
+		}
+		if !p.peek("parents") {
+			return nil, fmt.Errorf("only parents and labels can be searched with in")
+		}
+		p.pos++
+		id := t.text
+		if id == "root" {
+			id = RootId
+		}
+		return func(f *drive.File) bool {
+			for _, parent := range f.Parents {
+				if parent == id {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+	field := t.text
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	value, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if op.quoted || (op.text != "=" && op.text != "!=" && op.text != "contains") {
+		return nil, fmt.Errorf("unknown operator %q", op.text)
+	}
+	var get func(f *drive.File) string
+	switch field {
+	case "name":
+		get = func(f *drive.File) string { return f.Name }
+	case "mimeType":
+		get = func(f *drive.File) string { return f.MimeType }
+	case "trashed":
+		if value.quoted || (value.text != "true" && value.text != "false") || op.text == "contains" {
+			return nil, fmt.Errorf("trashed takes = or != true or false")
+		}
+		get = func(f *drive.File) string { return strconv.FormatBool(f.Trashed) }
+	default:
+		return nil, fmt.Errorf("unknown field %q", field)
+	}
+	if field != "trashed" && !value.quoted {
+		return nil, fmt.Errorf("%s takes a string", field)
+	}
+	want := value.text
+	switch op.text {
+	case "=":
+		return func(f *drive.File) bool { return get(f) == want }, nil
+	case "!=":
+		return func(f *drive.File) bool { return get(f) != want }, nil
+	}
+	return func(f *drive.File) bool { return strings.Contains(get(f), want) }, nil
+}
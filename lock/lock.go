@@ -0,0 +1,52 @@
+// Package lock provides advisory locks on files holding the PID of the
+// process owning them. The operating system releases a lock when the
+// process ends, however it ends, so a stale lock file doesn't block later
+// processes.
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// errLocked is returned by tryLock when another process holds the lock.
+var errLocked = errors.New("locked")
+
+// Lock is a lock held on a file.
+type Lock struct {
+	f *os.File
+}
+
+// Acquire locks file, creating it if needed, or, if another process holds
+// the lock, returns an error naming its PID.
+func Acquire(file string) (*Lock, error) {
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := tryLock(f); err != nil {
+		f.Close()
+		if err != errLocked {
+			return nil, err
+		}
+		if b, _ := ioutil.ReadFile(file); len(b) > 0 {
+			if pid, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil {
+				return nil, fmt.Errorf("another instance (pid %d) is running, see %s", pid, file)
+			}
+		}
+		return nil, fmt.Errorf("another instance is running, see %s", file)
+	}
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &Lock{f: f}, nil
+}
+
+// Release releases the lock.
+func (l *Lock) Release() {
+	l.f.Close()
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package lock
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryLock takes an exclusive flock on f without waiting for it.
+func tryLock(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return errLocked
+	}
+	return err
+}
@@ -0,0 +1,174 @@
+package state
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// JSONStore keeps the state in a JSON file, read and written as a whole.
+// Switching between the plain and the compressed file carries the state
+// over.
+type JSONStore struct {
+	File     string // without the .gz suffix
+	Compress bool
+	Logf     Logf
+}
+
+func (s *JSONStore) paths() (current, other string) {
+	if s.Compress {
+		return s.File + ".gz", s.File
+	}
+	return s.File, s.File + ".gz"
+}
+
+func (s *JSONStore) Load() (*Files, error) {
+	current, other := s.paths()
+	if _, err := os.Stat(current); os.IsNotExist(err) {
+		if _, err := os.Stat(other); err == nil {
+			return readJSON(other, s.Logf)
+		}
+	}
+	return readJSON(current, s.Logf)
+}
+
+func (s *JSONStore) Save(files *Files) error {
+	current, other := s.paths()
+	if err := writeJSON(current, files, s.Compress); err != nil {
+		return err
+	}
+	if err := os.Remove(other); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func readJSON(file string, logf Logf) (*Files, error) {
+	var files Files
+	if f, err := os.Open(file); err == nil {
+		logf.printf("Read %s\n", file)
+		defer f.Close()
+		var r io.Reader = bufio.NewReader(f)
+		// gzip compressed state is told by its magic number.
+		if magic, _ := r.(*bufio.Reader).Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+			gz, err := gzip.NewReader(r)
+			if err != nil {
+				return nil, fmt.Errorf("gzip.NewReader(%s) failed: %v", file, err)
+			}
+			defer gz.Close()
+			r = gz
+		}
+		err = json.NewDecoder(r).Decode(&files)
+		if err != nil {
+			return nil, fmt.Errorf("json.Decode(%s) failed: %v", file, err)
+		}
+	}
+	return &files, nil
+}
+
+// writeJSON writes the state one record per line, encoding each on its
+// own so that no encoded copy of the whole state is held in memory, and
+// gzip compressed if compress is set. It goes to a temporary file first
+// which then replaces file.
+func writeJSON(file string, files *Files, compress bool) error {
+	out, err := ioutil.TempFile(filepath.Dir(file), ".drive-tmp-")
+	if err != nil {
+		return err
+	}
+	var gz *gzip.Writer
+	var w *bufio.Writer
+	if compress {
+		gz = gzip.NewWriter(out)
+		w = bufio.NewWriter(gz)
+	} else {
+		w = bufio.NewWriter(out)
+	}
+	s := &jsonStream{w: w}
+	s.raw(`{"Version":`)
+	s.value(files.Version)
+	s.raw(`,"RootId":`)
+	s.value(files.RootId)
+	s.raw(`,"Remote":[`)
+	for i := range files.Remote {
+		if i > 0 {
+			s.raw(",")
+		}
+		s.raw("\n")
+		s.value(&files.Remote[i])
+	}
+	s.raw("\n],\"Local\":[")
+	for i := range files.Local {
+		if i > 0 {
+			s.raw(",")
+		}
+		s.raw("\n")
+		s.value(&files.Local[i])
+	}
+	s.raw("\n],\"Names\":")
+	s.value(files.Names)
+	if files.ListToken != "" {
+		s.raw(`,"ListToken":`)
+		s.value(files.ListToken)
+	}
+	if files.FollowedShortcuts {
+		s.raw(`,"FollowedShortcuts":true`)
+	}
+	if files.Hash != "" {
+		s.raw(`,"Hash":`)
+		s.value(files.Hash)
+	}
+	if len(files.Failed) > 0 {
+		s.raw(`,"Failed":`)
+		s.value(files.Failed)
+	}
+	s.raw("}\n")
+	if s.err == nil {
+		s.err = w.Flush()
+	}
+	if gz != nil && s.err == nil {
+		s.err = gz.Close()
+	}
+	if err := out.Close(); err != nil && s.err == nil {
+		s.err = err
+	}
+	if s.err != nil {
+		os.Remove(out.Name())
+		return fmt.Errorf("writing %s failed: %v", file, s.err)
+	}
+	if err := os.Chmod(out.Name(), 0644); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	if err := os.Rename(out.Name(), file); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return nil
+}
+
+// jsonStream writes JSON piecewise, keeping the first error.
+type jsonStream struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (s *jsonStream) raw(text string) {
+	if s.err == nil {
+		_, s.err = s.w.WriteString(text)
+	}
+}
+
+func (s *jsonStream) value(v interface{}) {
+	if s.err != nil {
+		return
+	}
+	var b []byte
+	if b, s.err = json.Marshal(v); s.err == nil {
+		_, s.err = s.w.Write(b)
+	}
+}
@@ -0,0 +1,548 @@
+package state
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"google.golang.org/api/drive/v3"
+)
+
+// DBFile is the SQLite database of the state, in the working directory.
+const DBFile = "state.db"
+
+// stateSchema holds the state of all pairs, told apart by the pair column.
+// Remote files are kept as JSON next to the columns they are looked up by.
+const stateSchema = `
+CREATE TABLE IF NOT EXISTS meta (
+	pair TEXT NOT NULL,
+	key TEXT NOT NULL,
+	value TEXT NOT NULL,
+	PRIMARY KEY (pair, key)
+);
+CREATE TABLE IF NOT EXISTS remote (
+	pair TEXT NOT NULL,
+	id TEXT NOT NULL,
+	parent TEXT NOT NULL,
+	md5 TEXT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (pair, id)
+);
+CREATE INDEX IF NOT EXISTS remote_md5 ON remote (pair, md5);
+CREATE INDEX IF NOT EXISTS remote_parent ON remote (pair, parent);
+CREATE TABLE IF NOT EXISTS local (
+	pair TEXT NOT NULL,
+	path TEXT NOT NULL,
+	md5 TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	mtime TEXT NOT NULL,
+	PRIMARY KEY (pair, path)
+);
+CREATE INDEX IF NOT EXISTS local_md5 ON local (pair, md5);
+CREATE TABLE IF NOT EXISTS names (
+	pair TEXT NOT NULL,
+	id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	PRIMARY KEY (pair, id)
+);
+`
+
+// schemaSteps[v] brings the database from schema version v, kept in
+// PRAGMA user_version, to v+1. Append a step to change the schema.
+var schemaSteps = []string{
+	stateSchema,
+	`CREATE TABLE failed (
+		pair TEXT NOT NULL,
+		path TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		mtime TEXT NOT NULL,
+		attempts INTEGER NOT NULL,
+		error TEXT NOT NULL,
+		PRIMARY KEY (pair, path)
+	);`,
+	`CREATE TABLE runs (
+		id TEXT PRIMARY KEY,
+		started TEXT NOT NULL,
+		data TEXT NOT NULL
+	);`,
+	// Files are compared by path, so nothing looks them up by checksum.
+	`DROP INDEX IF EXISTS remote_md5;
+	DROP INDEX IF EXISTS local_md5;`,
+}
+
+// OpenDB opens the database file, upgrading its schema.
+func OpenDB(file string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", file+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	if err := upgradeSchema(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func upgradeSchema(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version > len(schemaSteps) {
+		return fmt.Errorf("%s schema version %d is newer than this program's %d", DBFile, version, len(schemaSteps))
+	}
+	for ; version < len(schemaSteps); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(schemaSteps[version]); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stateTable is a table holding the state of pairs. Its rows are told
+// apart within a pair by the first of columns.
+type stateTable struct {
+	name    string
+	columns []string
+	order   string
+}
+
+var (
+	metaTable   = stateTable{"meta", []string{"key", "value"}, "rowid"}
+	remoteTable = stateTable{"remote", []string{"id", "parent", "md5", "data"}, "rowid"}
+	localTable  = stateTable{"local", []string{"path", "md5", "size", "mtime"}, "rowid"}
+	namesTable  = stateTable{"names", []string{"id", "name"}, "rowid"}
+	failedTable = stateTable{"failed", []string{"path", "size", "mtime", "attempts", "error"}, "path"}
+)
+
+// upsert returns the statement writing a row given the pair and the
+// columns, which keeps the rowid and so the order of a row updated.
+func (t stateTable) upsert() string {
+	params := strings.Repeat(", ?", len(t.columns))
+	var set []string
+	for _, c := range t.columns[1:] {
+		set = append(set, c+" = excluded."+c)
+	}
+	return fmt.Sprintf(`INSERT INTO %s (pair, %s) VALUES (?%s) ON CONFLICT (pair, %s) DO UPDATE SET %s`,
+		t.name, strings.Join(t.columns, ", "), params, t.columns[0], strings.Join(set, ", "))
+}
+
+// rowHash fingerprints the values of a row as the database returns them
+// as text, telling the rows Save has to write from those already there.
+func rowHash(values []interface{}) uint64 {
+	h := fnv.New64a()
+	for _, v := range values {
+		fmt.Fprint(h, v)
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+// SQLiteStore keeps the state of a pair in the SQLite database. Save
+// writes only the rows changed since the state was loaded or saved.
+type SQLiteStore struct {
+	DB   *sql.DB
+	Pair string
+	// LegacyFile is the JSON state file migrated on the first Load.
+	LegacyFile string
+	Logf       Logf
+	// rows holds the rowHash of the rows in the database by table and
+	// key, for the tables read or written so far.
+	rows map[string]map[string]uint64
+}
+
+// saved reports whether the pair's state was ever saved to the database.
+func (s *SQLiteStore) saved() (bool, error) {
+	var n int
+	err := s.DB.QueryRow(`SELECT COUNT(*) FROM meta WHERE pair = ? AND key = 'saved'`, s.Pair).Scan(&n)
+	return n > 0, err
+}
+
+// readRows calls each, if not nil, with the columns of the pair's rows in
+// the table, and records their rowHash.
+func (s *SQLiteStore) readRows(t stateTable, each func(values []string) error) error {
+	rows, err := s.DB.Query(`SELECT `+strings.Join(t.columns, ", ")+` FROM `+t.name+` WHERE pair = ? ORDER BY `+t.order, s.Pair)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	saved := make(map[string]uint64)
+	values := make([]string, len(t.columns))
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		rest := make([]interface{}, len(values)-1)
+		for i, v := range values[1:] {
+			rest[i] = v
+		}
+		saved[values[0]] = rowHash(rest)
+		if each != nil {
+			if err := each(values); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if s.rows == nil {
+		s.rows = make(map[string]map[string]uint64)
+	}
+	s.rows[t.name] = saved
+	return nil
+}
+
+func (s *SQLiteStore) Load() (*Files, error) {
+	files, err := s.LoadMeta()
+	if err != nil {
+		return nil, err
+	}
+	err = s.readRows(remoteTable, func(values []string) error {
+		var file drive.File
+		if err := json.Unmarshal([]byte(values[3]), &file); err != nil {
+			return err
+		}
+		files.Remote = append(files.Remote, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = s.readRows(localTable, func(values []string) error {
+		files.Local = append(files.Local, scanLocal(values))
+		return nil
+	})
+	return files, err
+}
+
+// LoadMeta loads the state of the pair but its remote and local files,
+// which a folder by folder sync looks up one at a time instead.
+func (s *SQLiteStore) LoadMeta() (*Files, error) {
+	if err := s.migrateJSON(); err != nil {
+		return nil, err
+	}
+	files := &Files{Names: make(map[string]string)}
+	err := s.readRows(metaTable, func(values []string) error {
+		var err error
+		switch values[0] {
+		case "version":
+			files.Version, err = strconv.Atoi(values[1])
+		case "rootId":
+			files.RootId = values[1]
+		case "listToken":
+			files.ListToken = values[1]
+		case "followedShortcuts":
+			files.FollowedShortcuts = values[1] == "1"
+		case "hash":
+			files.Hash = values[1]
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = s.readRows(failedTable, func(values []string) error {
+		f := FailedTransfer{Path: values[0], Error: values[4]}
+		f.Size, _ = strconv.ParseInt(values[1], 10, 64)
+		f.ModTime, _ = time.Parse(time.RFC3339Nano, values[2])
+		f.Attempts, _ = strconv.Atoi(values[3])
+		files.Failed = append(files.Failed, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = s.readRows(namesTable, func(values []string) error {
+		files.Names[values[0]] = values[1]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// scanLocal returns the local file of a row of the local table.
+func scanLocal(values []string) LocalFile {
+	file := LocalFile{Path: values[0], Md5Checksum: values[1]}
+	file.Size, _ = strconv.ParseInt(values[2], 10, 64)
+	file.ModTime, _ = time.Parse(time.RFC3339Nano, values[3])
+	return file
+}
+
+func metaRows(files *Files) map[string][]interface{} {
+	rows := map[string][]interface{}{
+		"saved":   {"1"},
+		"version": {files.Version},
+		"rootId":  {files.RootId},
+	}
+	if files.ListToken != "" {
+		rows["listToken"] = []interface{}{files.ListToken}
+	}
+	if files.FollowedShortcuts {
+		rows["followedShortcuts"] = []interface{}{"1"}
+	}
+	if files.Hash != "" {
+		rows["hash"] = []interface{}{files.Hash}
+	}
+	return rows
+}
+
+func localRows(local []LocalFile) map[string][]interface{} {
+	rows := make(map[string][]interface{}, len(local))
+	for _, file := range local {
+		rows[file.Path] = []interface{}{file.Md5Checksum, file.Size, file.ModTime.Format(time.RFC3339Nano)}
+	}
+	return rows
+}
+
+func namesRows(names map[string]string) map[string][]interface{} {
+	rows := make(map[string][]interface{}, len(names))
+	for id, name := range names {
+		rows[id] = []interface{}{name}
+	}
+	return rows
+}
+
+func failedRows(failed []FailedTransfer) map[string][]interface{} {
+	rows := make(map[string][]interface{}, len(failed))
+	for _, f := range failed {
+		rows[f.Path] = []interface{}{f.Size, f.ModTime.Format(time.RFC3339Nano), f.Attempts, f.Error}
+	}
+	return rows
+}
+
+// Save writes the pair's state in one transaction, inserting, updating and
+// deleting only the rows which changed.
+func (s *SQLiteStore) Save(files *Files) error {
+	remote := make(map[string][]interface{}, len(files.Remote))
+	for _, file := range files.Remote {
+		data, err := json.Marshal(file)
+		if err != nil {
+			return err
+		}
+		var parent string
+		if len(file.Parents) > 0 {
+			parent = file.Parents[0]
+		}
+		remote[file.Id] = []interface{}{parent, file.Md5Checksum, string(data)}
+	}
+	return s.write([]tableRows{
+		{metaTable, metaRows(files)},
+		{remoteTable, remote},
+		{localTable, localRows(files.Local)},
+		{namesTable, namesRows(files.Names)},
+		{failedTable, failedRows(files.Failed)},
+	})
+}
+
+// SaveMeta saves the state of the pair like Save but for the local files,
+// which PutLocal keeps, and drops the remote listing, which a folder by
+// folder sync doesn't keep.
+func (s *SQLiteStore) SaveMeta(files *Files) error {
+	return s.write([]tableRows{
+		{metaTable, metaRows(files)},
+		{remoteTable, nil},
+		{namesTable, namesRows(files.Names)},
+		{failedTable, failedRows(files.Failed)},
+	})
+}
+
+// tableRows holds rows of a table keyed by their first column, with the
+// values of the other columns.
+type tableRows struct {
+	table stateTable
+	rows  map[string][]interface{}
+}
+
+// write makes the pair's rows of each table the given ones, in one
+// transaction.
+func (s *SQLiteStore) write(tables []tableRows) error {
+	for _, tr := range tables {
+		if t := tr.table; s.rows[t.name] == nil {
+			if err := s.readRows(t, nil); err != nil {
+				return err
+			}
+		}
+	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	written := make(map[string]map[string]uint64)
+	for _, tr := range tables {
+		t, rows := tr.table, tr.rows
+		saved := s.rows[t.name]
+		hashes := make(map[string]uint64, len(rows))
+		upsert, err := tx.Prepare(t.upsert())
+		if err != nil {
+			return err
+		}
+		for key, values := range rows {
+			h := rowHash(values)
+			hashes[key] = h
+			if old, ok := saved[key]; ok && old == h {
+				continue
+			}
+			if _, err := upsert.Exec(append([]interface{}{s.Pair, key}, values...)...); err != nil {
+				upsert.Close()
+				return err
+			}
+		}
+		upsert.Close()
+		for key := range saved {
+			if _, ok := rows[key]; ok {
+				continue
+			}
+			if _, err := tx.Exec(`DELETE FROM `+t.name+` WHERE pair = ? AND `+t.columns[0]+` = ?`, s.Pair, key); err != nil {
+				return err
+			}
+		}
+		written[t.name] = hashes
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for name, hashes := range written {
+		s.rows[name] = hashes
+	}
+	return nil
+}
+
+// LookupLocal returns the local file at path in the state, looked up by
+// the primary key.
+func (s *SQLiteStore) LookupLocal(path string) (LocalFile, bool, error) {
+	values := make([]string, 4)
+	values[0] = path
+	err := s.DB.QueryRow(`SELECT md5, size, mtime FROM local WHERE pair = ? AND path = ?`, s.Pair, path).Scan(&values[1], &values[2], &values[3])
+	if err == sql.ErrNoRows {
+		return LocalFile{}, false, nil
+	}
+	if err != nil {
+		return LocalFile{}, false, err
+	}
+	return scanLocal(values), true, nil
+}
+
+// PutLocal inserts or updates the rows of the local files in the state.
+func (s *SQLiteStore) PutLocal(files []LocalFile) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	upsert, err := tx.Prepare(localTable.upsert())
+	if err != nil {
+		return err
+	}
+	defer upsert.Close()
+	rows := localRows(files)
+	for path, values := range rows {
+		if _, err := upsert.Exec(append([]interface{}{s.Pair, path}, values...)...); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if saved, ok := s.rows[localTable.name]; ok {
+		for path, values := range rows {
+			saved[path] = rowHash(values)
+		}
+	}
+	return nil
+}
+
+// ClearLocal deletes the local files of the pair from the state.
+func (s *SQLiteStore) ClearLocal() error {
+	if _, err := s.DB.Exec(`DELETE FROM local WHERE pair = ?`, s.Pair); err != nil {
+		return err
+	}
+	delete(s.rows, localTable.name)
+	return nil
+}
+
+// Meta returns the value of a meta key of the pair, "" if unset.
+func (s *SQLiteStore) Meta(key string) (string, error) {
+	var value string
+	err := s.DB.QueryRow(`SELECT value FROM meta WHERE pair = ? AND key = ?`, s.Pair, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+// Children returns the remote files in the folder with the id, looked up
+// by the remote_parent index, each named as it is synced.
+func (s *SQLiteStore) Children(parent string) ([]drive.File, error) {
+	rows, err := s.DB.Query(`SELECT remote.data, names.name FROM remote
+		LEFT JOIN names ON names.pair = remote.pair AND names.id = remote.id
+		WHERE remote.pair = ? AND remote.parent = ?`, s.Pair, parent)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var files []drive.File
+	for rows.Next() {
+		var data string
+		var name sql.NullString
+		if err := rows.Scan(&data, &name); err != nil {
+			return nil, err
+		}
+		var file drive.File
+		if err := json.Unmarshal([]byte(data), &file); err != nil {
+			return nil, err
+		}
+		if name.Valid {
+			file.Name = name.String
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// migrateJSON moves the state of a legacy JSON file into the database,
+// unless the database already has state for the pair. The JSON file is
+// renamed to <file>.migrated afterwards.
+func (s *SQLiteStore) migrateJSON() error {
+	if _, err := os.Stat(s.LegacyFile); err != nil {
+		return nil
+	}
+	saved, err := s.saved()
+	if err != nil || saved {
+		return err
+	}
+	s.Logf.printf("Migrate %s into %s\n", s.LegacyFile, DBFile)
+	files, err := readJSON(s.LegacyFile, s.Logf)
+	if err != nil {
+		return err
+	}
+	if err := s.Save(files); err != nil {
+		return err
+	}
+	return os.Rename(s.LegacyFile, s.LegacyFile+".migrated")
+}
@@ -0,0 +1,113 @@
+// Package state keeps what the sync of a pair knows between runs: the
+// listing of the remote tree, the checksums of the local files and the
+// transfers which failed. It is kept in a JSON file per pair, or in a
+// SQLite database shared by the pairs.
+package state
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Backends.
+const (
+	SQLite = "sqlite"  // DBFile shared by all pairs, the default
+	JSON   = "json"    // files.json or files-<name>.json per pair
+	JSONGz = "json.gz" // the same gzip compressed, files.json.gz
+)
+
+// Version is the version of the Files layout. Raise it and add a
+// migration to migrations when a change makes older state wrong.
+const Version = 3
+
+// LocalFile is a file of the local tree, with its checksum.
+type LocalFile struct {
+	Path        string
+	Md5Checksum string
+	Size        int64
+	ModTime     time.Time
+}
+
+// FailedTransfer records a file whose transfer failed. Size and ModTime
+// are those of the file being transferred; once they change the file is
+// tried again however often it failed.
+type FailedTransfer struct {
+	Path     string
+	Size     int64
+	ModTime  time.Time
+	Attempts int
+	Error    string
+}
+
+// Files is the state of a pair.
+type Files struct {
+	// Version is the Version the state was saved with.
+	Version int `json:",omitempty"`
+	RootId  string
+	Remote  []drive.File
+	Local   []LocalFile
+	// Names holds the local names of remote files whose name had to be
+	// sanitized or is shared with another file in the same folder.
+	// key: File.Id
+	Names map[string]string
+	// Hash is the hash of the checksums of Remote and Local, "" for md5.
+	Hash string `json:",omitempty"`
+	// ListToken is the page token where an interrupted listing of the
+	// whole Drive continues. Remote then holds the files listed so far.
+	ListToken string `json:",omitempty"`
+	// FollowedShortcuts is set when Remote has the shortcuts followed into
+	// their targets, for a pair with FollowShortcuts.
+	FollowedShortcuts bool `json:",omitempty"`
+	// Failed holds the transfers which failed, to be retried next run.
+	Failed []FailedTransfer `json:",omitempty"`
+}
+
+// migrations[v] upgrades state of version v to version v+1.
+var migrations = []func(files *Files){
+	// Version 0 remote listings lack fields added to the fields listed
+	// over time, such as ownedByMe and capabilities, so list the Drive
+	// again. Local checksums stay valid.
+	func(files *Files) {
+		files.Remote = nil
+		files.ListToken = ""
+	},
+	// Version 1 remote listings lack starred, which -starred-only
+	// filters by.
+	func(files *Files) {
+		files.Remote = nil
+		files.ListToken = ""
+	},
+	// Version 2 listings of the whole Drive have the trashed files too.
+	func(files *Files) {
+		files.Remote = nil
+		files.ListToken = ""
+	},
+}
+
+// Migrate upgrades loaded state to Version.
+func Migrate(files *Files) error {
+	if files.Version > Version {
+		return fmt.Errorf("state version %d is newer than this program's %d", files.Version, Version)
+	}
+	for ; files.Version < Version; files.Version++ {
+		migrations[files.Version](files)
+	}
+	return nil
+}
+
+// Store persists the Files state of one sync pair.
+type Store interface {
+	Load() (*Files, error)
+	Save(files *Files) error
+}
+
+// Logf, if not nil, is given the files read and migrated.
+type Logf func(format string, v ...interface{})
+
+func (f Logf) printf(format string, v ...interface{}) {
+	if f != nil {
+		f(format, v...)
+	}
+}
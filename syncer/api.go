@@ -0,0 +1,375 @@
+package syncer
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hiroshi/googledriveclient/drivefs"
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// apiJobsKept is how many finished jobs the API keeps to report on.
+const apiJobsKept = 20
+
+// Kinds and states of API jobs.
+const (
+	jobSync      = "sync"
+	jobDiff      = "diff"
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobCancelled = "cancelled"
+)
+
+// apiJob is a sync or diff started through the API.
+type apiJob struct {
+	ID       int        `json:"id"`
+	Kind     string     `json:"kind"`
+	Pairs    []string   `json:"pairs"`
+	State    string     `json:"state"`
+	Started  time.Time  `json:"started"`
+	Ended    *time.Time `json:"ended,omitempty"`
+	Progress *progress  `json:"progress,omitempty"` // of the transfers, while running
+	Failed   int        `json:"failed"`
+	ExitCode int        `json:"exitCode"`
+	Summary  *runStats  `json:"summary,omitempty"`
+	// Planned holds the transfers and deletions a diff found.
+	Planned []event `json:"planned,omitempty"`
+
+	cancel func()
+	// events, if not nil, receives the events of the job and is closed
+	// when it ends.
+	events chan<- event
+}
+
+// progress is the state of the transfers of a running job.
+type progress struct {
+	Done       int   `json:"done"`
+	Total      int   `json:"total"`
+	Bytes      int64 `json:"bytes"`
+	TotalBytes int64 `json:"totalBytes"`
+	Running    int   `json:"running"`
+}
+
+// apiServer serves the API of serve api. It runs one job at a time: the
+// stats and cancellation of a run are the process's.
+type apiServer struct {
+	srv       *drive.Service
+	fs        *drivefs.FS
+	pairs     []syncPair
+	openState func(*syncPair) stateStore
+	q         string
+	opts      runOptions
+	token     string
+
+	mu      sync.Mutex
+	jobs    []*apiJob // oldest first
+	nextID  int
+	running *apiJob
+}
+
+// apiJobs receives the events of the running job; nil unless serving the
+// API.
+var apiJobs *apiServer
+
+// serveAPI serves the API on addr, and the gRPC interface on grpcAddr if
+// not empty, until the process is stopped. Requests need the token as a
+// bearer token.
+func serveAPI(a *apiServer, addr, grpcAddr string) error {
+	apiJobs = a
+	if grpcAddr != "" {
+		go func() {
+			if err := serveGRPC(a, grpcAddr); err != nil {
+				fatalf("Unable to serve gRPC: %v", err)
+			}
+		}()
+	}
+	server := &http.Server{Addr: addr, Handler: a}
+	go func() {
+		<-processCtx.Done()
+		server.Close()
+	}()
+	infof("Serving the API on %s\n", addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (a *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="drive"`)
+		apiError(w, http.StatusUnauthorized, "missing or wrong token")
+		return
+	}
+	switch p := r.URL.Path; {
+	case p == "/v1/pairs" && r.Method == http.MethodGet:
+		a.listPairs(w)
+	case p == "/v1/files" && r.Method == http.MethodGet:
+		a.listFiles(w, r)
+	case (p == "/v1/sync" || p == "/v1/diff") && r.Method == http.MethodPost:
+		a.start(w, r, strings.TrimPrefix(p, "/v1/"))
+	case p == "/v1/jobs" && r.Method == http.MethodGet:
+		a.mu.Lock()
+		jobs := make([]apiJob, len(a.jobs))
+		for i, job := range a.jobs {
+			jobs[i] = a.snapshot(job)
+			jobs[i].Planned = nil
+		}
+		a.mu.Unlock()
+		apiJSON(w, http.StatusOK, jobs)
+	case strings.HasPrefix(p, "/v1/jobs/"):
+		a.job(w, r, strings.TrimPrefix(p, "/v1/jobs/"))
+	default:
+		apiError(w, http.StatusNotFound, "no such endpoint "+r.Method+" "+p)
+	}
+}
+
+func apiJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func apiError(w http.ResponseWriter, code int, message string) {
+	apiJSON(w, code, map[string]string{"error": message})
+}
+
+func (a *apiServer) listPairs(w http.ResponseWriter) {
+	type pair struct {
+		Name      string `json:"name"`
+		Local     string `json:"local"`
+		Remote    string `json:"remote"`
+		Direction string `json:"direction"`
+	}
+	pairs := make([]pair, len(a.pairs))
+	for i, p := range a.pairs {
+		pairs[i] = pair{p.Name, p.Local, p.Remote, p.Direction}
+	}
+	apiJSON(w, http.StatusOK, pairs)
+}
+
+// listFiles lists the remote folder at the path parameter, / by default.
+func (a *apiServer) listFiles(w http.ResponseWriter, r *http.Request) {
+	info, err := a.fs.Stat(r.Context(), r.URL.Query().Get("path"))
+	if os.IsNotExist(err) {
+		apiError(w, http.StatusNotFound, "no such folder")
+		return
+	} else if err != nil {
+		apiError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	if !info.IsDir() {
+		apiError(w, http.StatusBadRequest, "not a folder")
+		return
+	}
+	infos, err := a.fs.ReadDir(r.Context(), info)
+	if err != nil {
+		apiError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	type file struct {
+		Name     string `json:"name"`
+		Id       string `json:"id"`
+		Folder   bool   `json:"folder"`
+		Size     int64  `json:"size"`
+		Modified string `json:"modified"`
+		Md5      string `json:"md5,omitempty"`
+	}
+	files := make([]file, len(infos))
+	for i, f := range infos {
+		files[i] = file{f.Name(), f.File.Id, f.IsDir(), f.File.Size, f.File.ModifiedTime, f.File.Md5Checksum}
+	}
+	apiJSON(w, http.StatusOK, files)
+}
+
+// start starts a job of kind on the pairs named by the pair parameters,
+// all of them by default.
+func (a *apiServer) start(w http.ResponseWriter, r *http.Request, kind string) {
+	pairs, err := a.selectPairs(r.URL.Query()["pair"])
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	job, err := a.startJob(kind, pairs, nil)
+	if err != nil {
+		apiError(w, http.StatusConflict, err.Error())
+		return
+	}
+	a.mu.Lock()
+	snapshot := a.snapshot(job)
+	a.mu.Unlock()
+	apiJSON(w, http.StatusAccepted, snapshot)
+}
+
+// selectPairs returns the pairs named, all of them if none is.
+func (a *apiServer) selectPairs(names []string) ([]syncPair, error) {
+	if len(names) == 0 {
+		return a.pairs, nil
+	}
+	var pairs []syncPair
+	for _, name := range names {
+		found := false
+		for _, p := range a.pairs {
+			if p.Name == name {
+				pairs, found = append(pairs, p), true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no pair %q", name)
+		}
+	}
+	return pairs, nil
+}
+
+// startJob starts a job of kind on pairs unless one runs. events, if not
+// nil, receives its events.
+func (a *apiServer) startJob(kind string, pairs []syncPair, events chan<- event) (*apiJob, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.running != nil {
+		return nil, fmt.Errorf("job %d is running", a.running.ID)
+	}
+	a.nextID++
+	job := &apiJob{ID: a.nextID, Kind: kind, State: jobRunning, Started: time.Now(), events: events}
+	for i := range pairs {
+		job.Pairs = append(job.Pairs, pairs[i].String())
+	}
+	// Each job has a run context of its own, to cancel it alone.
+	runCtx, stopRun = context.WithCancel(processCtx)
+	job.cancel = stopRun
+	a.running = job
+	a.jobs = append(a.jobs, job)
+	if len(a.jobs) > apiJobsKept {
+		a.jobs = a.jobs[len(a.jobs)-apiJobsKept:]
+	}
+	go a.run(job, pairs)
+	return job, nil
+}
+
+// run runs job on pairs, like a run of the daemon.
+func (a *apiServer) run(job *apiJob, pairs []syncPair) {
+	opts := a.opts
+	// Jobs see the remote changes made since the last one.
+	opts.relist = true
+	opts.planOnly = job.Kind == jobDiff
+	// The stats of the job, which another run may replace as the current
+	// ones before the job is done with them.
+	run := daemonMetrics.startRun()
+	runReport.reset()
+	if job.Kind == jobSync {
+		history.start("api")
+	}
+	failed, err := syncAll(a.srv, pairs, a.openState, a.q, &opts)
+	run.count(&run.Errors, failed)
+	code := runExitCode(run, failed, err)
+	if job.Kind == jobSync {
+		run.print("")
+		runReport.write(run)
+		notifications.finished(run, failed, false)
+		if !stopping() {
+			hooks.finished(run, failed)
+		}
+		history.finish(run, failed, code)
+	}
+	summary := run.snapshot()
+	summary.Elapsed = time.Since(job.Started).Seconds()
+	infof("Job %d (%s) ended, exit %d\n", job.ID, job.Kind, code)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	job.Ended, job.Failed, job.ExitCode, job.Summary = &now, failed, code, summary
+	job.State = jobDone
+	if stopping() {
+		job.State = jobCancelled
+	}
+	a.running = nil
+	if job.events != nil {
+		close(job.events)
+	}
+}
+
+// job answers the requests about a job: GET for its status, DELETE or POST
+// .../cancel to cancel it.
+func (a *apiServer) job(w http.ResponseWriter, r *http.Request, path string) {
+	idText, action := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		idText, action = path[:i], path[i+1:]
+	}
+	id, err := strconv.Atoi(idText)
+	if err != nil {
+		apiError(w, http.StatusNotFound, "no job "+idText)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var job *apiJob
+	for _, j := range a.jobs {
+		if j.ID == id {
+			job = j
+		}
+	}
+	if job == nil {
+		apiError(w, http.StatusNotFound, "no job "+idText)
+		return
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		apiJSON(w, http.StatusOK, a.snapshot(job))
+	case (action == "" && r.Method == http.MethodDelete) || (action == "cancel" && r.Method == http.MethodPost):
+		if job.State == jobRunning {
+			infof("Job %d cancelled\n", job.ID)
+			job.cancel()
+		}
+		apiJSON(w, http.StatusOK, a.snapshot(job))
+	default:
+		apiError(w, http.StatusNotFound, "no such endpoint "+r.Method+" "+r.URL.Path)
+	}
+}
+
+// snapshot returns a copy of job to encode, with the progress of its
+// transfers if it runs. a.mu must be held.
+func (a *apiServer) snapshot(job *apiJob) apiJob {
+	s := *job
+	s.Planned = append([]event(nil), job.Planned...)
+	if job != a.running {
+		return s
+	}
+	currentMu.Lock()
+	xfers := currentTransfers
+	currentMu.Unlock()
+	if xfers != nil {
+		xfers.mu.Lock()
+		s.Progress = &progress{xfers.done, xfers.total, atomic.LoadInt64(&xfers.bytes), xfers.totalBytes, len(xfers.active)}
+		xfers.mu.Unlock()
+	}
+	return s
+}
+
+// add records the planned transfers and deletions of a running diff, and
+// passes the events on to the job's events channel.
+func (a *apiServer) add(e event) {
+	if a == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	a.mu.Lock()
+	job := a.running
+	if job != nil && job.Kind == jobDiff && e.Event == eventPlanned {
+		job.Planned = append(job.Planned, e)
+	}
+	a.mu.Unlock()
+	// The receiver keeps up or makes the run wait.
+	if job != nil && job.events != nil {
+		job.events <- e
+	}
+}
@@ -0,0 +1,248 @@
+package syncer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/hiroshi/googledriveclient/drivefs"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/api/drive/v3"
+)
+
+// Archive formats.
+const (
+	archiveTar    = "tar"
+	archiveTarGz  = "tar.gz"
+	archiveTarZst = "tar.zst"
+	archiveZip    = "zip"
+)
+
+// archiveFormat returns the format of an archive named name, "" if its
+// extension tells none. Encrypted archives may end in ".enc".
+func archiveFormat(name string) string {
+	name = strings.TrimSuffix(strings.ToLower(name), ".enc")
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return archiveTarGz
+	case strings.HasSuffix(name, ".tar.zst"), strings.HasSuffix(name, ".tzst"):
+		return archiveTarZst
+	case strings.HasSuffix(name, ".tar"):
+		return archiveTar
+	case strings.HasSuffix(name, ".zip"):
+		return archiveZip
+	}
+	return ""
+}
+
+// archiveCommand runs archive: it streams the files below a remote folder
+// from the Download API into an archive, without writing them to disk.
+func archiveCommand(srv *drive.Service, args []string) error {
+	const usage = "usage: archive [-output file] [-format tar|tar.gz|tar.zst|zip] [-encrypt] [remotePath]"
+	fs := flag.NewFlagSet("archive", flag.ContinueOnError)
+	output := fs.String("output", "-", "`file` to write the archive to, - for the standard output")
+	format := fs.String("format", "", "tar, tar.gz, tar.zst or zip (default from the -output extension, else tar)")
+	encrypt := fs.Bool("encrypt", false, "encrypt the archive with -encryption-key-file or -encryption-passphrase")
+	if err := fs.Parse(args); err != nil {
+		exit(exitConfig)
+	}
+	if fs.NArg() > 1 {
+		exitf(exitConfig, usage)
+	}
+	if *format == "" {
+		if *format = archiveFormat(*output); *format == "" {
+			*format = archiveTar
+		}
+	}
+	if *format != archiveTar && *format != archiveTarGz && *format != archiveTarZst && *format != archiveZip {
+		exitf(exitConfig, "unknown archive format %q", *format)
+	}
+	if *encrypt && encryptionSecret == nil {
+		exitf(exitConfig, "-encrypt needs -encryption-key-file or -encryption-passphrase")
+	}
+	// The listing would end up in the archive.
+	if *output == "-" {
+		verbosity = quiet
+	}
+	root, err := resolveRemote(srv, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	remoteFS, err := newRemoteFS(srv, root)
+	if err != nil {
+		return err
+	}
+	handleSignals()
+	a := &archiver{fs: remoteFS, ctx: runCtx}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(a.write(pw, *format))
+	}()
+	var content io.Reader = pr
+	if *encrypt {
+		if content, err = encryptArchive(pr); err != nil {
+			return err
+		}
+	}
+	if *output == "-" {
+		_, err = io.Copy(os.Stdout, content)
+	} else {
+		err = saveFile(expandHome(*output), content)
+	}
+	pr.CloseWithError(err)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Archived %d files, %s\n", a.files, formatBytes(a.bytes))
+	return nil
+}
+
+// saltMagic starts encrypted archives, followed by the random salt of their
+// key and the encrypted archive.
+const saltMagic = "DRIVSALT"
+
+// encryptArchive returns the archive r encrypted with a key of a new salt,
+// which goes first.
+func encryptArchive(r io.Reader) (io.Reader, error) {
+	salt, err := randomSalt()
+	if err != nil {
+		return nil, err
+	}
+	key, err := encryptionSecret.key(salt)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := newEncryptReader(r, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.MultiReader(strings.NewReader(saltMagic+string(salt)), encrypted), nil
+}
+
+// archiver writes the remote tree into an archive.
+type archiver struct {
+	fs    *drivefs.FS
+	ctx   context.Context
+	files int
+	bytes int64
+}
+
+// write writes the archive in format to w.
+func (a *archiver) write(w io.Writer, format string) error {
+	root, err := a.fs.Stat(a.ctx, "/")
+	if err != nil {
+		return err
+	}
+	if format == archiveZip {
+		zw := zip.NewWriter(w)
+		err := a.walk(root, "", func(info drivefs.FileInfo, rel string) (io.Writer, error) {
+			h := &zip.FileHeader{Name: rel, Modified: info.ModTime(), Method: zip.Deflate}
+			h.SetMode(0644)
+			if info.IsDir() {
+				h.Name, h.Method = rel+"/", zip.Store
+				h.SetMode(os.ModeDir | 0755)
+			} else if compressedExts[strings.ToLower(path.Ext(rel))] {
+				h.Method = zip.Store
+			}
+			return zw.CreateHeader(h)
+		})
+		if err != nil {
+			return err
+		}
+		return zw.Close()
+	}
+	var compressor io.WriteCloser
+	switch format {
+	case archiveTarGz:
+		compressor = gzip.NewWriter(w)
+	case archiveTarZst:
+		if compressor, err = zstd.NewWriter(w); err != nil {
+			return err
+		}
+	}
+	if compressor != nil {
+		w = compressor
+	}
+	tw := tar.NewWriter(w)
+	err = a.walk(root, "", func(info drivefs.FileInfo, rel string) (io.Writer, error) {
+		h := &tar.Header{Name: rel, Size: info.Size(), Mode: 0644, ModTime: info.ModTime(), Typeflag: tar.TypeReg, Format: tar.FormatPAX}
+		if info.IsDir() {
+			h.Name, h.Size, h.Mode, h.Typeflag = rel+"/", 0, 0755, tar.TypeDir
+		}
+		return tw, tw.WriteHeader(h)
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if compressor != nil {
+		return compressor.Close()
+	}
+	return nil
+}
+
+// walk adds the files below the folder dir at rel to the archive, in name
+// order. add starts an entry and returns where its content goes.
+func (a *archiver) walk(dir drivefs.FileInfo, rel string, add func(drivefs.FileInfo, string) (io.Writer, error)) error {
+	infos, err := a.fs.ReadDir(a.ctx, dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if stopping() {
+			return errors.New("interrupted")
+		}
+		childRel := path.Join(rel, info.Name())
+		w, err := add(info, childRel)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if err := a.walk(info, childRel, add); err != nil {
+				return err
+			}
+			continue
+		}
+		infof("%s\n", childRel)
+		if err := a.copy(w, info); err != nil {
+			return fmt.Errorf("%s: %v", childRel, err)
+		}
+		a.files++
+		a.bytes += info.Size()
+	}
+	return nil
+}
+
+// copy writes the content of the remote file to w. A download cut short
+// continues from where it broke off, as the archive can't take back what
+// was written.
+func (a *archiver) copy(w io.Writer, info drivefs.FileInfo) error {
+	var written int64
+	return retry("Download of "+info.File.Name, func() error {
+		call := a.fs.Service().Files.Get(info.File.Id).Context(a.ctx)
+		if written > 0 {
+			call.Header().Set("Range", fmt.Sprintf("bytes=%d-", written))
+		}
+		resp, err := call.Download()
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		n, err := io.Copy(w, resp.Body)
+		written += n
+		if err == nil && written < info.Size() {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	})
+}
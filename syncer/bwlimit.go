@@ -0,0 +1,189 @@
+package syncer
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+)
+
+// bwLimit holds the token buckets shared by all transfers of a run. A nil
+// limiter leaves its direction unlimited.
+type bwLimit struct {
+	up, down *rate.Limiter
+	// schedule, sorted by start, changes the limits over the day.
+	schedule []bwSlot
+}
+
+// limiter returns the limiter of the pair's transfers, nil if unlimited.
+func (b *bwLimit) limiter(pair *syncPair) *rate.Limiter {
+	if pair.Direction == directionUpload {
+		return b.up
+	}
+	return b.down
+}
+
+// bwSlot is an entry of a bandwidth schedule: from start, a duration since
+// midnight, up and down bytes per second apply, 0 meaning unlimited.
+type bwSlot struct {
+	start    time.Duration
+	up, down int64
+}
+
+// parseBwLimit parses a rate in bytes per second like "2M" for both
+// directions, or "UP:DOWN" like "512k:4M". "off" and "" mean no limit. A
+// space separated list of "HH:MM,RATE" entries like "08:00,512k 23:00,off"
+// is a schedule, each rate applying from its time of day to the next one.
+func parseBwLimit(s string) (bwLimit, error) {
+	var l bwLimit
+	if !strings.ContainsAny(s, " ,") {
+		up, down, err := parseRates(s)
+		if err != nil {
+			return l, fmt.Errorf("invalid bandwidth limit %q: %v", s, err)
+		}
+		l.up, l.down = newLimiter(up), newLimiter(down)
+		return l, nil
+	}
+	for _, entry := range strings.Fields(s) {
+		i := strings.IndexByte(entry, ',')
+		if i < 0 {
+			return l, fmt.Errorf("invalid bandwidth schedule entry %q, want HH:MM,RATE", entry)
+		}
+		t, err := time.Parse("15:04", entry[:i])
+		if err != nil {
+			return l, fmt.Errorf("invalid time in bandwidth schedule entry %q", entry)
+		}
+		slot := bwSlot{start: time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute}
+		if slot.up, slot.down, err = parseRates(entry[i+1:]); err != nil {
+			return l, fmt.Errorf("invalid bandwidth schedule entry %q: %v", entry, err)
+		}
+		l.schedule = append(l.schedule, slot)
+	}
+	sort.Slice(l.schedule, func(i, j int) bool { return l.schedule[i].start < l.schedule[j].start })
+	l.up, l.down = rate.NewLimiter(rate.Inf, 0), rate.NewLimiter(rate.Inf, 0)
+	l.apply(time.Now())
+	return l, nil
+}
+
+// parseRates parses RATE or UP:DOWN into bytes per second, 0 for "off".
+func parseRates(s string) (up, down int64, err error) {
+	upRate, downRate := s, s
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		upRate, downRate = s[:i], s[i+1:]
+	}
+	if up, err = parseRate(upRate); err != nil {
+		return 0, 0, err
+	}
+	down, err = parseRate(downRate)
+	return up, down, err
+}
+
+func parseRate(s string) (int64, error) {
+	if s == "off" || s == "" {
+		return 0, nil
+	}
+	return parseSize(s)
+}
+
+func newLimiter(bps int64) *rate.Limiter {
+	if bps <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bps), burstSize(bps))
+}
+
+// setLimit changes the rate of l, which transfers in progress follow.
+func setLimit(l *rate.Limiter, bps int64) {
+	if bps <= 0 {
+		l.SetLimit(rate.Inf)
+		return
+	}
+	l.SetBurst(burstSize(bps))
+	l.SetLimit(rate.Limit(bps))
+}
+
+// burstSize lets a limiter hand out a tenth of a second's worth of bytes at
+// once, but at least 4 KiB so reads don't get too small.
+func burstSize(bps int64) int {
+	if burst := int(bps / 10); burst > 4<<10 {
+		return burst
+	}
+	return 4 << 10
+}
+
+// slotAt returns the schedule entry in effect at now: the last one started
+// today, or yesterday's last one before the first.
+func (l *bwLimit) slotAt(now time.Time) bwSlot {
+	y, m, d := now.Date()
+	sinceMidnight := now.Sub(time.Date(y, m, d, 0, 0, 0, 0, now.Location()))
+	slot := l.schedule[len(l.schedule)-1]
+	for _, s := range l.schedule {
+		if s.start <= sinceMidnight {
+			slot = s
+		}
+	}
+	return slot
+}
+
+// apply sets the limits of the schedule entry in effect at now.
+func (l *bwLimit) apply(now time.Time) {
+	slot := l.slotAt(now)
+	setLimit(l.up, slot.up)
+	setLimit(l.down, slot.down)
+}
+
+// follow re-evaluates the schedule every minute, for as long as the
+// program runs.
+func (l *bwLimit) follow() {
+	if len(l.schedule) == 0 {
+		return
+	}
+	go func() {
+		current := l.slotAt(time.Now())
+		for now := range time.Tick(time.Minute) {
+			if slot := l.slotAt(now); slot != current {
+				infof("Bandwidth limit now %s:%s\n", formatRate(slot.up), formatRate(slot.down))
+				l.apply(now)
+				current = slot
+			}
+		}
+	}()
+}
+
+func formatRate(bps int64) string {
+	if bps <= 0 {
+		return "off"
+	}
+	return formatBytes(bps) + "/s"
+}
+
+// limitedReader reads from r no faster than l allows, waiting until ctx
+// is done.
+type limitedReader struct {
+	r   io.Reader
+	l   *rate.Limiter
+	ctx context.Context
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if burst := lr.l.Burst(); burst > 0 && len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := lr.r.Read(p)
+	// The burst may shrink meanwhile when a schedule changes the limit.
+	for left := n; left > 0; {
+		wait := left
+		if burst := lr.l.Burst(); burst > 0 && wait > burst {
+			wait = burst
+		}
+		if werr := lr.l.WaitN(lr.ctx, wait); werr != nil {
+			return n, werr
+		}
+		left -= wait
+	}
+	return n, err
+}
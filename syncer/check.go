@@ -0,0 +1,152 @@
+package syncer
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Differences check finds between the local and remote trees.
+const (
+	diffMissingLocally  = "missing locally"
+	diffMissingRemotely = "missing remotely"
+	diffDifferent       = "different"
+	diffCorrupt         = "corrupt" // same size and modification time, other content
+)
+
+// difference is a file whose local and remote copies check found not the
+// same.
+type difference struct {
+	path   string
+	kind   string
+	reason string
+}
+
+// checkAll compares the local and remote trees of every pair with the
+// pair's comparison strategy and prints the differences, without
+// transferring, deleting or saving anything. Every local file is hashed
+// again, ignoring the checksums of the state, to find those whose content
+// changed although their size and modification time didn't. It returns the
+// number of differences. A pair whose check stops at an error is left
+// out, the others are checked still; the first such error is returned.
+func checkAll(srv *drive.Service, pairs []syncPair, q string, opts *runOptions) (int, error) {
+	listRemote := remoteLister(srv, q)
+	total := 0
+	var firstErr error
+	for i := 0; i < len(pairs) && !stopping(); i++ {
+		pair := &pairs[i]
+		currentMu.Lock()
+		currentPair = pair.String()
+		currentMu.Unlock()
+		n, err := checkPair(srv, pair, listRemote, opts)
+		total += n
+		if err != nil {
+			log.Printf("Unable to check %s: %v", pair, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return total, firstErr
+}
+
+// checkPair compares the trees of a pair and returns the number of
+// differences.
+func checkPair(srv *drive.Service, pair *syncPair, listRemote func(*syncPair, *Files, func() error) ([]drive.File, string, error), opts *runOptions) (int, error) {
+	infof("Check %s\n", pair)
+	pair.ignore = loadDriveIgnore(pair.Local)
+	if err := pair.loadLabeled(srv); err != nil {
+		return 0, err
+	}
+
+	// The listing isn't saved: a whole Drive listing which gets
+	// interrupted starts over.
+	all, rootId, err := listRemote(pair, &Files{}, func() error { return nil })
+	if err != nil {
+		return 0, fmt.Errorf("unable to list: %v", err)
+	}
+	if stopping() {
+		return 0, nil
+	}
+	remoteFiles := pair.remoteTree(rootId, all)
+	// A pair with snapshots has its last complete one checked.
+	scanned := pair
+	if pair.Snapshots {
+		snap, err := newSnapshot(pair.Local, false)
+		if err != nil {
+			return 0, fmt.Errorf("unable to list the snapshots: %v", err)
+		}
+		scanned = snap.prevPair(pair)
+	}
+	// The files which couldn't be read aren't compared but count as
+	// differences.
+	total := 0
+	var localFiles []localFile
+	if scanned != nil {
+		hashed := *scanned
+		hashed.Compare = compareMd5
+		var unhashed []failedTransfer
+		localFiles, unhashed = local(&hashed, nil, opts.checkers)
+		pair.leaveUnhashed(unhashed)
+		total += len(unhashed)
+	}
+	if stopping() {
+		return total, nil
+	}
+	idx := newRemoteIndex(&Files{RootId: rootId, Remote: remoteFiles}, pair.nameOptions())
+	diffs := pair.differences(pair.remoteByLocalPath(idx, remoteFiles), localFiles)
+	if err := pair.ignore.failed(); err != nil {
+		return total, err
+	}
+	counts := make(map[string]int)
+	for _, d := range diffs {
+		counts[d.kind]++
+		emit(event{Event: eventDifference, Path: d.path, Action: d.kind, Reason: d.reason})
+		c := colorYellow
+		if d.kind == diffCorrupt {
+			c = colorRed
+		}
+		line := d.path + ": " + d.kind
+		if d.reason != "" {
+			line += ", " + d.reason
+		}
+		fmt.Println(paint(c, line))
+	}
+	fmt.Printf("%s: %d missing locally, %d missing remotely, %d different, %d corrupt\n", pair,
+		counts[diffMissingLocally], counts[diffMissingRemotely], counts[diffDifferent], counts[diffCorrupt])
+	return total + len(diffs), nil
+}
+
+// differences returns, by path, the remote files of remoteByPath the local
+// files don't match under the pair's comparison strategy, and the local
+// files no remote file accounts for.
+func (p *syncPair) differences(remoteByPath map[string]drive.File, localFiles []localFile) []difference {
+	localByPath := make(map[string]*localFile)
+	for i, l := range localFiles {
+		localByPath[pathKey(l.Path)] = &localFiles[i]
+	}
+	var diffs []difference
+	for key, r := range remoteByPath {
+		l := localByPath[key]
+		switch {
+		case l == nil:
+			diffs = append(diffs, difference{key, diffMissingLocally, ""})
+		case r.Md5Checksum == "":
+			// Native documents are exported, with no checksum to compare.
+		case l.Md5Checksum != r.Md5Checksum && l.Size == r.Size && sameModTime(l.ModTime, r):
+			diffs = append(diffs, difference{key, diffCorrupt, p.mismatch(compareMd5, l, r)})
+		case !upToDate(p.Compare, l, r):
+			diffs = append(diffs, difference{key, diffDifferent, p.mismatch(p.Compare, l, r)})
+		}
+	}
+	for _, l := range localFiles {
+		key := pathKey(l.Path)
+		if _, ok := remoteByPath[key]; !ok && !exportPart(l.Path, remoteByPath) {
+			diffs = append(diffs, difference{key, diffMissingRemotely, ""})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].path < diffs[j].path })
+	return diffs
+}
@@ -0,0 +1,160 @@
+package syncer
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// chownCommand runs chown: it transfers the ownership of remote files and
+// folders to another user, and with -recursive of those below the folders
+// too. Drive only transfers ownership outright within a Workspace domain;
+// otherwise the user is made pending owner and has to accept.
+func chownCommand(srv *drive.Service, args []string) error {
+	const usage = "usage: chown -to email [-recursive] [-pending] remotePath..."
+	fs := flag.NewFlagSet("chown", flag.ContinueOnError)
+	to := fs.String("to", "", "the `email` address of the new owner")
+	recursive := fs.Bool("recursive", false, "also transfer every file and folder below the folders")
+	pending := fs.Bool("pending", false, "make the user pending owner, to accept the ownership, rather than owner")
+	if err := fs.Parse(args); err != nil {
+		exit(exitConfig)
+	}
+	if *to == "" || !strings.Contains(*to, "@") || fs.NArg() == 0 {
+		exitf(exitConfig, usage)
+	}
+	handleSignals()
+	items, err := remoteItems(srv, fs.Args(), *recursive)
+	if err != nil {
+		return err
+	}
+	c := &chown{srv: srv, to: *to, pending: *pending}
+	failed := eachItem(items, c.item)
+	fmt.Printf("Transferred %d, %d pending acceptance by %s, %d up to date, %d failed\n", c.transferred, c.pendingOwner, *to, c.upToDate, len(failed))
+	if len(failed) > 0 {
+		fmt.Println("Could not transfer:")
+		paths := make([]string, 0, len(failed))
+		for p := range failed {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			fmt.Printf("  %s: %v\n", p, failed[p])
+		}
+	}
+	if stopping() {
+		return errors.New("interrupted")
+	}
+	if len(failed) > 0 {
+		exit(exitPartial)
+	}
+	return nil
+}
+
+// chown transfers the ownership of remote items to the user to.
+type chown struct {
+	srv     *drive.Service
+	to      string
+	pending bool
+
+	mu           sync.Mutex
+	transferred  int
+	pendingOwner int
+	upToDate     int
+}
+
+// item transfers the ownership of one item and returns what it did.
+func (c *chown) item(item shareItem) (string, error) {
+	var perms []*drive.Permission
+	err := retry("Listing the permissions of "+item.path, func() error {
+		perms = nil
+		return c.srv.Permissions.List(item.file.Id).Fields("nextPageToken, permissions(id, type, role, emailAddress, pendingOwner)").
+			Context(runCtx).Pages(runCtx, func(r *drive.PermissionList) error {
+			perms = append(perms, r.Permissions...)
+			return nil
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	user := grantee{kind: "user", value: c.to}
+	var existing *drive.Permission
+	for _, p := range perms {
+		if user.matches(p) {
+			existing = p
+			break
+		}
+	}
+	switch {
+	case existing != nil && existing.Role == "owner":
+		c.count(&c.upToDate)
+		return "up to date", nil
+	case !item.file.OwnedByMe:
+		return "", errors.New("not owned by you")
+	case existing != nil && existing.PendingOwner && c.pending:
+		c.count(&c.upToDate)
+		return "pending owner already", nil
+	}
+	if !c.pending {
+		err = c.set(item, existing, &drive.Permission{Role: "owner"}, true)
+		if err == nil {
+			c.count(&c.transferred)
+			return "transferred to " + c.to, nil
+		}
+		// Users outside the domain have to accept the ownership.
+		var gerr *googleapi.Error
+		if !errors.As(err, &gerr) || !hasReason(gerr, "consentRequiredForOwnershipTransfer") {
+			return "", err
+		}
+	}
+	// A pending owner has to be a writer.
+	if err := c.set(item, existing, &drive.Permission{Role: "writer", PendingOwner: true}, false); err != nil {
+		return "", err
+	}
+	c.count(&c.pendingOwner)
+	return c.to + " is pending owner", nil
+}
+
+// set gives the user the role and pending ownership of change on the item,
+// updating their permission existing if they have one.
+func (c *chown) set(item shareItem, existing *drive.Permission, change *drive.Permission, transfer bool) error {
+	return retry("Transferring "+item.path, func() error {
+		if existing != nil {
+			call := c.srv.Permissions.Update(item.file.Id, existing.Id, change).Fields("id").Context(runCtx)
+			if transfer {
+				call.TransferOwnership(true)
+			}
+			_, err := call.Do()
+			return err
+		}
+		p := *change
+		p.Type, p.EmailAddress = "user", c.to
+		call := c.srv.Permissions.Create(item.file.Id, &p).Fields("id").Context(runCtx)
+		if transfer {
+			call.TransferOwnership(true)
+		}
+		_, err := call.Do()
+		return err
+	})
+}
+
+func (c *chown) count(n *int) {
+	c.mu.Lock()
+	*n++
+	c.mu.Unlock()
+}
+
+// hasReason reports whether one of the errors of gerr has the reason.
+func hasReason(gerr *googleapi.Error, reason string) bool {
+	for _, e := range gerr.Errors {
+		if e.Reason == reason {
+			return true
+		}
+	}
+	return false
+}
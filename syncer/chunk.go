@@ -0,0 +1,243 @@
+package syncer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// appProperties of chunked files. The file itself is an empty manifest
+// naming its chunk set and the number of chunks; each chunk is a file in
+// the same folder with the set and its index. A new upload makes a new
+// set, so the manifest switches from the old chunks to the new ones at
+// once.
+const (
+	propChunkSet   = "chunkSet"
+	propChunks     = "chunks"
+	propChunkIndex = "chunkIndex"
+	// propChunkIds lists the ids of the chunks in order. Listings add it
+	// to the manifest; Drive never sees it.
+	propChunkIds = "chunkIds"
+)
+
+// chunkIds returns the ids of the chunks of a chunked remote file, nil for
+// other files.
+func chunkIds(f drive.File) []string {
+	if f.AppProperties[propChunks] == "" || f.AppProperties[propChunkIds] == "" {
+		return nil
+	}
+	return strings.Split(f.AppProperties[propChunkIds], ",")
+}
+
+// withChunks drops the chunks from files, adding their ids to the
+// manifests. Chunks of a set no manifest names, left by an interrupted
+// upload, are dropped too.
+func withChunks(files []drive.File) []drive.File {
+	type chunk struct {
+		index int
+		id    string
+	}
+	sets := make(map[string][]chunk)
+	for _, f := range files {
+		if f.AppProperties[propChunkIndex] != "" {
+			index, _ := strconv.Atoi(f.AppProperties[propChunkIndex])
+			set := f.AppProperties[propChunkSet]
+			sets[set] = append(sets[set], chunk{index, f.Id})
+		}
+	}
+	if len(sets) == 0 {
+		return files
+	}
+	kept := make([]drive.File, 0, len(files))
+	for _, f := range files {
+		if f.AppProperties[propChunkIndex] != "" {
+			continue
+		}
+		if chunks, ok := sets[f.AppProperties[propChunkSet]]; ok && f.AppProperties[propChunks] != "" {
+			sort.Slice(chunks, func(i, j int) bool { return chunks[i].index < chunks[j].index })
+			ids := make([]string, len(chunks))
+			for i, c := range chunks {
+				ids[i] = c.id
+			}
+			props := make(map[string]string, len(f.AppProperties)+1)
+			for k, v := range f.AppProperties {
+				props[k] = v
+			}
+			props[propChunkIds] = strings.Join(ids, ",")
+			f.AppProperties = props
+		}
+		kept = append(kept, f)
+	}
+	return kept
+}
+
+// uploadChunks uploads the local file f in chunks of the pair's chunk size
+// into the folder parent, named name with the chunk number, and returns
+// them and the id of their set.
+func (u *uploader) uploadChunks(f *os.File, l *localFile, name, parent, compression string, job *transferJob) (string, []drive.File, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	set := hex.EncodeToString(b)
+	var chunks []drive.File
+	job.restart()
+	for i, offset := 0, int64(0); offset < l.Size; i, offset = i+1, offset+u.pair.chunkSize {
+		meta := &drive.File{
+			Name:          fmt.Sprintf("%s.chunk%04d", name, i),
+			Parents:       []string{parent},
+			AppProperties: map[string]string{propChunkSet: set, propChunkIndex: strconv.Itoa(i)},
+		}
+		if u.pair.Obfuscate {
+			meta.Name = encryptName(u.pair.key, meta.Name)
+		}
+		var r *drive.File
+		err := retry(fmt.Sprintf("Upload of chunk %d of %s", i, l.Path), func() error {
+			// A reader of its own for each attempt, as the compression of
+			// a failed one may still be reading.
+			section := io.NewSectionReader(f, offset, u.pair.chunkSize)
+			content, closeContent, err := u.content(job.reader(section), compression)
+			if err != nil {
+				return err
+			}
+			defer closeContent()
+			r, err = u.srv.Files.Create(meta).Media(content).Fields(fileFields).Context(job.ctx).Do()
+			return err
+		})
+		if err != nil {
+			return "", chunks, err
+		}
+		chunks = append(chunks, *r)
+	}
+	return set, chunks, nil
+}
+
+// trashChunks moves chunks which are no longer used to the Drive trash.
+func trashChunks(srv *drive.Service, name string, ids []string) {
+	var updates []metadataUpdate
+	for _, id := range ids {
+		updates = append(updates, trashRemote(drive.File{Id: id}, name+" (chunk)"))
+	}
+	updateMetadata(srv, updates)
+}
+
+// chunkReader reads the content of a chunked file, one chunk after the
+// other. A chunk whose download breaks off is downloaded again and read
+// from where it broke off.
+type chunkReader struct {
+	srv         *drive.Service
+	job         *transferJob
+	ids         []string
+	key         *[32]byte // nil if not encrypted
+	compression string
+
+	next  int       // index of the chunk after the current one
+	cur   io.Reader // nil between chunks
+	close func()
+	pos   int64 // read of the current chunk
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if c.cur == nil {
+			if c.next == len(c.ids) {
+				return 0, io.EOF
+			}
+			if err := c.open(); err != nil {
+				return 0, err
+			}
+			c.next++
+			c.pos = 0
+		}
+		n, err := c.cur.Read(p)
+		c.pos += int64(n)
+		if err == io.EOF {
+			c.close()
+			c.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		if err != nil && retryable(err) && !stopping() {
+			// Download the chunk again and skip what was read.
+			c.close()
+			c.next--
+			if err := c.open(); err != nil {
+				return n, err
+			}
+			c.next++
+			if _, err := io.CopyN(ioutil.Discard, c.cur, c.pos); err != nil {
+				return n, err
+			}
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// open starts the download of chunk c.next.
+func (c *chunkReader) open() error {
+	id := c.ids[c.next]
+	return retry(fmt.Sprintf("Download of chunk %d", c.next), func() error {
+		resp, err := c.srv.Files.Get(id).Context(c.job.ctx).Download()
+		if err != nil {
+			return err
+		}
+		content, closeContent, err := openContent(resp.Body, c.key, c.compression)
+		if err != nil {
+			resp.Body.Close()
+			return err
+		}
+		c.cur, c.close = content, func() {
+			closeContent()
+			resp.Body.Close()
+		}
+		return nil
+	})
+}
+
+// openContent returns the plaintext of the downloaded body, decrypted if
+// key if it isn't nil and decompressed with compression if it isn't empty,
+// and a function releasing the decompressor.
+func openContent(body io.Reader, key *[32]byte, compression string) (io.Reader, func(), error) {
+	content := body
+	var err error
+	if key != nil {
+		if content, err = newDecryptReader(content, key); err != nil {
+			return nil, nil, err
+		}
+	}
+	if compression == "" {
+		return content, func() {}, nil
+	}
+	d, err := decompressReader(content, compression)
+	if err != nil {
+		return nil, nil, err
+	}
+	return d, func() { d.Close() }, nil
+}
+
+// chunkedContent returns a reader of the content of the chunked remote
+// file.
+func chunkedContent(srv *drive.Service, remote drive.File, key *[32]byte, job *transferJob) (io.Reader, error) {
+	ids := chunkIds(remote)
+	if n, _ := strconv.Atoi(remote.AppProperties[propChunks]); n != len(ids) {
+		return nil, errors.New("chunks are missing on Drive")
+	}
+	c := &chunkReader{srv: srv, job: job, ids: ids, key: key, compression: remote.AppProperties[propCompression]}
+	c.close = func() {}
+	return c, nil
+}
@@ -0,0 +1,45 @@
+package syncer
+
+import (
+	"fmt"
+	"os"
+)
+
+// color is an ANSI escape sequence setting the color of what follows.
+type color string
+
+const (
+	colorGreen  color = "\033[32m" // up to date
+	colorYellow color = "\033[33m" // transferring
+	colorRed    color = "\033[31m" // errors
+	colorReset  color = "\033[0m"
+)
+
+// useColor enables colored output, see setupColor.
+var useColor bool
+
+// setupColor sets useColor after -color: always, never, or auto, which
+// colors the output of a terminal unless NO_COLOR is set or TERM is dumb.
+// It must be called once the standard output is redirected, if it is.
+func setupColor(mode string) error {
+	switch mode {
+	case "always":
+		useColor = true
+	case "never":
+		useColor = false
+	case "auto", "":
+		_, noColor := os.LookupEnv("NO_COLOR")
+		useColor = !noColor && os.Getenv("TERM") != "dumb" && stdoutIsTerminal()
+	default:
+		return fmt.Errorf("invalid -color %q: use auto, always or never", mode)
+	}
+	return nil
+}
+
+// paint returns s in the color c if colors are enabled.
+func paint(c color, s string) string {
+	if !useColor {
+		return s
+	}
+	return string(c) + s + string(colorReset)
+}
@@ -0,0 +1,184 @@
+package syncer
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// commandEnv holds what the commands need of the flags and the config.
+type commandEnv struct {
+	httpOpts     *httpOptions
+	opts         *runOptions
+	flags        *pairFlags
+	cfg          *config
+	configFile   string
+	sources      map[string]string // the settings of the flags, by name
+	unknown      []string          // unknown keys of the config file
+	stateBackend string
+}
+
+// subcommand is a command given after the flags instead of syncing.
+type subcommand struct {
+	name string
+	// run runs the command, which then exits with exitInSync. The
+	// commands without one work on the pairs set up by Main.
+	run func(env *commandEnv, args []string)
+	// hidden commands are left out of the usage and the completion.
+	hidden bool
+}
+
+// subcommands are the commands Main dispatches, lists in the usage and the
+// completion scripts complete.
+var subcommands []subcommand
+
+func init() {
+	subcommands = []subcommand{
+		{name: "purge"},
+		{name: "prune"},
+		{name: "daemon"},
+		{name: "completion", run: func(env *commandEnv, args []string) {
+			if err := writeCompletion(os.Stdout, flag.CommandLine, args); err != nil {
+				exitf(exitConfig, "%v", err)
+			}
+		}},
+		{name: "self-update", run: func(env *commandEnv, args []string) {
+			client, err := env.httpOpts.client()
+			if err != nil {
+				fatalf("%v", err)
+			}
+			if err := selfUpdate(client); err != nil {
+				fatalf("Unable to update: %v", err)
+			}
+		}},
+		{name: "config", run: func(env *commandEnv, args []string) {
+			if len(args) == 0 || (args[0] != "validate" && args[0] != "show") {
+				exitf(exitConfig, "usage: config validate|show [basePath]")
+			}
+			configCommand(args[0], args[1:], flag.CommandLine, env.cfg, env.configFile, env.sources, env.unknown, env.flags)
+		}},
+		{name: "runs", run: func(env *commandEnv, args []string) {
+			runs, closeRuns, err := openRunStore(env.stateBackend)
+			if err != nil {
+				fatalf("Unable to open the state: %v", err)
+			}
+			if err := runsCommand(runs, args); err != nil {
+				closeRuns()
+				exitf(exitConfig, "%v", err)
+			}
+			closeRuns()
+		}},
+		{name: "select", run: func(env *commandEnv, args []string) {
+			// The listings would scroll over the picker.
+			verbosity = quiet
+			selectFolders(driveService(env.httpOpts), env.cfg, env.configFile, args, env.flags)
+		}},
+		{name: "ctl", run: func(env *commandEnv, args []string) {
+			if err := controlCommand(env.opts.controlSocket, args); err != nil {
+				exitf(exitError, "%v", err)
+			}
+		}},
+		// serve api syncs the pairs; the other servers are run by Main.
+		{name: "serve"},
+		{name: "decrypt-name", run: func(env *commandEnv, args []string) {
+			if len(args) == 0 || encryptionSecret == nil {
+				exitf(exitConfig, "usage: -encryption-key-file file|-encryption-passphrase passphrase decrypt-name name...")
+			}
+			// The names are the output.
+			verbosity = quiet
+			keys, err := obfuscationKeys(driveService(env.httpOpts), env.cfg, env.flags)
+			if err != nil {
+				fatalf("Unable to get the keys: %v", err)
+			}
+			for _, name := range args {
+				rel, err := decryptNameWith(keys, name)
+				if err != nil {
+					exitf(exitError, "%s: %v", name, err)
+				}
+				fmt.Println(rel)
+			}
+		}},
+		{name: "archive", run: driveCommand("archive", archiveCommand)},
+		{name: "restore", run: func(env *commandEnv, args []string) {
+			if err := restoreCommand(driveService(env.httpOpts), env.flags.hash, args); err != nil {
+				fatalf("Unable to restore: %v", err)
+			}
+		}},
+		{name: "migrate", run: func(env *commandEnv, args []string) {
+			if err := migrateCommand(driveService(env.httpOpts), env.httpOpts, env.flags.hash, args); err != nil {
+				fatalf("Unable to migrate: %v", err)
+			}
+		}},
+		{name: "stat", run: driveCommand("stat", statCommand)},
+		{name: "label", run: driveCommand("label", labelCommand)},
+		{name: "star", run: func(env *commandEnv, args []string) {
+			if err := starCommand(driveService(env.httpOpts), args, true); err != nil {
+				fatalf("Unable to star: %v", err)
+			}
+		}},
+		{name: "unstar", run: func(env *commandEnv, args []string) {
+			if err := starCommand(driveService(env.httpOpts), args, false); err != nil {
+				fatalf("Unable to unstar: %v", err)
+			}
+		}},
+		{name: "share", run: driveCommand("share", shareCommand)},
+		{name: "chown", run: driveCommand("chown", chownCommand)},
+		{name: "sharing-report", run: driveCommand("report sharing", sharingReportCommand)},
+		{name: "check"},
+		{name: completeCommand, hidden: true, run: func(env *commandEnv, args []string) {
+			verbosity = quiet
+			if len(args) != 2 || args[0] != "remote" {
+				exit(exitConfig)
+			}
+			pairs, err := loadPairs(env.cfg, nil, env.flags)
+			if err != nil {
+				exit(exitConfig)
+			}
+			openState, closeState, err := openStates(env.stateBackend)
+			if err != nil {
+				exit(exitError)
+			}
+			completeRemote(pairs, openState, args[1])
+			closeState()
+		}},
+	}
+}
+
+// driveCommand returns the run of a command working on the Drive, failing
+// with "Unable to <verb>".
+func driveCommand(verb string, command func(srv *drive.Service, args []string) error) func(*commandEnv, []string) {
+	return func(env *commandEnv, args []string) {
+		if err := command(driveService(env.httpOpts), args); err != nil {
+			fatalf("Unable to %s: %v", verb, err)
+		}
+	}
+}
+
+// lookupCommand returns the subcommand named name, or nil.
+func lookupCommand(name string) *subcommand {
+	for i := range subcommands {
+		if subcommands[i].name == name {
+			return &subcommands[i]
+		}
+	}
+	return nil
+}
+
+// commandNames returns the names of the subcommands but the hidden ones.
+func commandNames() []string {
+	var names []string
+	for _, c := range subcommands {
+		if !c.hidden {
+			names = append(names, c.name)
+		}
+	}
+	return names
+}
+
+// usage prints how to run the tool.
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [-config file] [flags] [%s] [basePath]\n", os.Args[0], strings.Join(commandNames(), "|"))
+}
@@ -0,0 +1,105 @@
+package syncer
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Strategies to decide whether a local file matches a remote one.
+const (
+	compareMd5       = "md5"        // same content at the same path, by the checksum of -hash, the default
+	compareSize      = "size"       // same size at the same path
+	compareMtime     = "mtime"      // same modification time at the same path
+	compareSizeMtime = "size+mtime" // both
+)
+
+func validCompare(strategy string) bool {
+	switch strategy {
+	case compareMd5, compareSize, compareMtime, compareSizeMtime:
+		return true
+	}
+	return false
+}
+
+// sameModTime reports whether the local and remote modification times are
+// equal to the second, which is all some file systems store.
+func sameModTime(local time.Time, remote drive.File) bool {
+	t, err := time.Parse(time.RFC3339, remote.ModifiedTime)
+	if err != nil {
+		return false
+	}
+	return local.Truncate(time.Second).Equal(t.Truncate(time.Second))
+}
+
+// upToDate reports whether the local file at the remote file's path is the
+// same as the remote file under the strategy.
+func upToDate(strategy string, local *localFile, remote drive.File) bool {
+	if local == nil {
+		return false
+	}
+	switch strategy {
+	case compareSize:
+		return local.Size == remote.Size
+	case compareMtime:
+		return sameModTime(local.ModTime, remote)
+	case compareSizeMtime:
+		return local.Size == remote.Size && sameModTime(local.ModTime, remote)
+	}
+	return local.Md5Checksum == remote.Md5Checksum
+}
+
+// mismatch tells, for -v, why the local file at the remote file's path
+// isn't the same under the strategy.
+func (p *syncPair) mismatch(strategy string, local *localFile, remote drive.File) string {
+	if local == nil {
+		return "missing locally"
+	}
+	switch strategy {
+	case compareSize:
+		return fmt.Sprintf("size %d locally, %d remotely", local.Size, remote.Size)
+	case compareMtime:
+		return fmt.Sprintf("modified %s locally, %s remotely", local.ModTime.UTC().Format(time.RFC3339), remote.ModifiedTime)
+	case compareSizeMtime:
+		if local.Size != remote.Size {
+			return p.mismatch(compareSize, local, remote)
+		}
+		return p.mismatch(compareMtime, local, remote)
+	}
+	return fmt.Sprintf("%s %s locally, %s remotely", p.hash, local.Md5Checksum, remote.Md5Checksum)
+}
+
+// remoteByLocalPath returns the remote files the pair includes, keyed by the
+// pathKey of every local path they are synced to.
+func (p *syncPair) remoteByLocalPath(idx *remoteIndex, remote []drive.File) map[string]drive.File {
+	m := make(map[string]drive.File)
+	for _, file := range remote {
+		if file.MimeType == folderMimeType {
+			continue
+		}
+		rel, ok := p.includeRemote(idx, file)
+		if !ok {
+			continue
+		}
+		policy, formats := p.nativeFor(rel)
+		if isNative(file) && policy == nativeSkip {
+			continue
+		}
+		rels := []string{rel}
+		if p.Parents != parentsPrimary {
+			rels = append(rels, p.extraPaths(idx, file)...)
+		}
+		for _, rel := range rels {
+			if isNative(file) {
+				if rel, ok = nativeLocalPath(rel, file, policy, formats); !ok {
+					continue
+				}
+			} else if file.MimeType == colabMimeType {
+				rel = notebookPath(rel)
+			}
+			m[pathKey(rel)] = file
+		}
+	}
+	return m
+}
@@ -0,0 +1,207 @@
+package syncer
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/hiroshi/googledriveclient/state"
+)
+
+// completeCommand is the hidden command the completion scripts run to
+// complete remote paths: __complete remote PREFIX.
+const completeCommand = "__complete"
+
+// remotePathFlags take a path below the remote roots, completed from the
+// listing in the state.
+var remotePathFlags = []string{"include", "exclude"}
+
+var completionScripts = map[string]string{
+	"bash": `# bash completion for {{.Name}}, e.g. in ~/.bashrc:
+#   source <({{.Name}} completion bash)
+_{{.Func}}() {
+	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+	local IFS=$'\n'
+	case "$prev" in
+	{{range $i, $f := .PathFlags}}{{if $i}}|{{end}}-{{$f}}|--{{$f}}{{end}})
+		compopt -o nospace
+		COMPREPLY=($({{.Name}} {{.Complete}} remote "$cur" 2>/dev/null))
+		return
+		;;
+	esac
+	if [[ "$cur" == -* ]]; then
+		COMPREPLY=($(compgen -W "{{range .Flags}}-{{.}}
+{{end}}" -- "$cur"))
+		return
+	fi
+	COMPREPLY=($(compgen -W "{{range .Commands}}{{.}}
+{{end}}" -- "$cur") $(compgen -d -- "$cur"))
+}
+complete -F _{{.Func}} {{.Name}}
+`,
+	"zsh": `#compdef {{.Name}}
+# zsh completion for {{.Name}}, e.g. in ~/.zshrc:
+#   source <({{.Name}} completion zsh)
+_{{.Func}}() {
+	case "${words[CURRENT-1]}" in
+	{{range $i, $f := .PathFlags}}{{if $i}}|{{end}}-{{$f}}|--{{$f}}{{end}})
+		compadd -S '' -- ${(f)"$({{.Name}} {{.Complete}} remote "${words[CURRENT]}" 2>/dev/null)"}
+		return
+		;;
+	esac
+	if [[ "${words[CURRENT]}" == -* ]]; then
+		compadd -- {{range .Flags}}-{{.}} {{end}}
+		return
+	fi
+	compadd -- {{range .Commands}}{{.}} {{end}}
+	_files -/
+}
+compdef _{{.Func}} {{.Name}}
+`,
+	"fish": `# fish completion for {{.Name}}, e.g.:
+#   {{.Name}} completion fish > ~/.config/fish/completions/{{.Name}}.fish
+complete -c {{.Name}} -n '__fish_use_subcommand' -a '{{range .Commands}}{{.}} {{end}}'
+{{range .Flags}}complete -c {{$.Name}} -o {{.}}
+{{end}}{{range .PathFlags}}complete -c {{$.Name}} -o {{.}} -x -a '({{$.Name}} {{$.Complete}} remote (commandline -ct) 2>/dev/null)'
+{{end}}`,
+	"powershell": `# PowerShell completion for {{.Name}}, e.g. in $PROFILE:
+#   {{.Name}} completion powershell | Out-String | Invoke-Expression
+Register-ArgumentCompleter -Native -CommandName {{.Name}} -ScriptBlock {
+	param($wordToComplete, $commandAst, $cursorPosition)
+	$words = @($commandAst.CommandElements | ForEach-Object { $_.ToString() })
+	$prev = if ($wordToComplete -eq '') { $words[-1] } elseif ($words.Count -ge 2) { $words[-2] } else { '' }
+	if (@({{range $i, $f := .PathFlags}}{{if $i}}, {{end}}'-{{$f}}', '--{{$f}}'{{end}}) -contains $prev) {
+		$candidates = @({{.Name}} {{.Complete}} remote $wordToComplete 2>$null)
+	} elseif ($wordToComplete -like '-*') {
+		$candidates = @({{range $i, $f := .Flags}}{{if $i}}, {{end}}'-{{$f}}'{{end}})
+	} else {
+		$candidates = @({{range $i, $c := .Commands}}{{if $i}}, {{end}}'{{$c}}'{{end}})
+	}
+	$candidates | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+		[System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+	}
+}
+`,
+}
+
+// writeCompletion writes the completion script of a shell: bash, zsh, fish
+// or powershell. It completes the flags of fs, the commands, and the remote
+// paths of remotePathFlags.
+func writeCompletion(w io.Writer, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: completion bash|zsh|fish|powershell")
+	}
+	script, ok := completionScripts[args[0]]
+	if !ok {
+		return fmt.Errorf("no completion for %q: use bash, zsh, fish or powershell", args[0])
+	}
+	name := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	var flags []string
+	fs.VisitAll(func(f *flag.Flag) { flags = append(flags, f.Name) })
+	return template.Must(template.New(args[0]).Parse(script)).Execute(w, struct {
+		Name      string
+		Func      string // name as a shell function
+		Complete  string
+		Flags     []string
+		PathFlags []string
+		Commands  []string
+	}{name, strings.NewReplacer("-", "_", ".", "_").Replace(name), completeCommand, flags, remotePathFlags, commandNames()})
+}
+
+// completeRemote prints the remote paths of the pairs in the state which
+// continue prefix, one folder level at a time, folders ending in "/".
+func completeRemote(pairs []syncPair, openState func(*syncPair) stateStore, prefix string) {
+	dir := ""
+	if i := strings.LastIndexByte(prefix, '/'); i >= 0 {
+		dir = prefix[:i+1]
+	}
+	found := make(map[string]bool)
+	for i := range pairs {
+		pair := &pairs[i]
+		store := openState(pair)
+		if st, ok := store.(*state.SQLiteStore); ok && completeStored(st, pair, prefix, dir, found) {
+			continue
+		}
+		files, err := store.Load()
+		if err != nil {
+			continue
+		}
+		idx := newRemoteIndex(files, pair.nameOptions())
+		for _, file := range files.Remote {
+			rp := remotePath(idx, file)
+			if !strings.HasPrefix(rp, pair.Remote+"/") {
+				continue
+			}
+			rel := rp[len(pair.Remote)+1:]
+			if !strings.HasPrefix(rel, prefix) || strings.Contains(rel[len(dir):], "/") {
+				continue
+			}
+			if file.MimeType == folderMimeType {
+				rel += "/"
+			}
+			found[rel] = true
+		}
+	}
+	paths := make([]string, 0, len(found))
+	for rel := range found {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+	for _, rel := range paths {
+		fmt.Println(rel)
+	}
+}
+
+// completeStored adds the completions of the pair to found like
+// completeRemote, looking up the folders along dir one at a time instead
+// of loading the whole state. It returns false if the pair's paths can't
+// be told that way: its state has no root, or its remote root isn't a
+// path below My Drive.
+func completeStored(st *state.SQLiteStore, pair *syncPair, prefix string, dir string, found map[string]bool) bool {
+	if pair.SharedWithMe != "" || pair.Computers != "" {
+		return false
+	}
+	parent, err := st.Meta("rootId")
+	if err != nil || parent == "" {
+		return false
+	}
+	for _, name := range strings.Split(strings.Trim(pair.Remote+"/"+dir, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		children, err := st.Children(parent)
+		if err != nil {
+			return false
+		}
+		parent = ""
+		for _, child := range children {
+			if child.Name == name && child.MimeType == folderMimeType {
+				parent = child.Id
+				break
+			}
+		}
+		if parent == "" {
+			return true
+		}
+	}
+	children, err := st.Children(parent)
+	if err != nil {
+		return false
+	}
+	for _, child := range children {
+		rel := dir + child.Name
+		if !strings.HasPrefix(rel, prefix) {
+			continue
+		}
+		if child.MimeType == folderMimeType {
+			rel += "/"
+		}
+		found[rel] = true
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+package syncer
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression algorithms of uploaded files.
+const (
+	compressNone = "none"
+	compressGzip = "gzip"
+	compressZstd = "zstd"
+)
+
+// propCompression is the appProperty naming the algorithm a file was
+// compressed with before upload. Downloads decompress such files whatever
+// the pair's settings.
+const propCompression = "compression"
+
+func validCompression(algorithm string) bool {
+	return algorithm == compressNone || algorithm == compressGzip || algorithm == compressZstd
+}
+
+// compressedExts are the extensions of formats compressed already, which
+// gain nothing from another round.
+var compressedExts = map[string]bool{
+	".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".lz4": true, ".br": true,
+	".zip": true, ".7z": true, ".rar": true, ".jar": true, ".apk": true, ".dmg": true,
+	".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".ods": true, ".epub": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true,
+	".mp3": true, ".m4a": true, ".ogg": true, ".flac": true, ".opus": true,
+	".mp4": true, ".m4v": true, ".mov": true, ".mkv": true, ".avi": true, ".webm": true,
+}
+
+// compression returns the algorithm the file at rel is compressed with
+// before upload, "" for none. Files of compressed formats are left alone,
+// and with CompressTypes so are the files matching none of them.
+func (p *syncPair) compression(rel string) string {
+	if p.Compress == compressNone || compressedExts[strings.ToLower(path.Ext(rel))] {
+		return ""
+	}
+	if len(p.CompressTypes) == 0 {
+		return p.Compress
+	}
+	for _, pattern := range p.CompressTypes {
+		if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+			return p.Compress
+		}
+	}
+	return ""
+}
+
+// compressReader reads r compressed with algorithm. Closing it stops the
+// compression.
+func compressReader(r io.Reader, algorithm string) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		var w io.WriteCloser
+		if algorithm == compressZstd {
+			var err error
+			if w, err = zstd.NewWriter(pw); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		} else {
+			w = gzip.NewWriter(pw)
+		}
+		_, err := io.Copy(w, r)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// decompressReader reads the content of r, compressed with algorithm. An
+// error reading r is returned even where the decompressor takes it for the
+// end of the content, as zstd does at the start of a frame, so a download
+// cut short fails instead of leaving the file cut off.
+func decompressReader(r io.Reader, algorithm string) (io.ReadCloser, error) {
+	in := &inputReader{r: r}
+	var d io.ReadCloser
+	switch algorithm {
+	case compressGzip:
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return nil, err
+		}
+		d = gz
+	case compressZstd:
+		zd, err := zstd.NewReader(in)
+		if err != nil {
+			return nil, err
+		}
+		d = zd.IOReadCloser()
+	default:
+		return nil, fmt.Errorf("unknown compression %q", algorithm)
+	}
+	return &decompressedReader{ReadCloser: d, in: in}, nil
+}
+
+// inputReader keeps the first error other than io.EOF reading r.
+type inputReader struct {
+	r   io.Reader
+	err error
+}
+
+func (in *inputReader) Read(p []byte) (int, error) {
+	n, err := in.r.Read(p)
+	if err != nil && err != io.EOF && in.err == nil {
+		in.err = err
+	}
+	return n, err
+}
+
+// decompressedReader ends with the error of its input, if any.
+type decompressedReader struct {
+	io.ReadCloser
+	in *inputReader
+}
+
+func (d *decompressedReader) Read(p []byte) (int, error) {
+	n, err := d.ReadCloser.Read(p)
+	if err == io.EOF && d.in.err != nil {
+		err = d.in.err
+	}
+	return n, err
+}
@@ -0,0 +1,792 @@
+package syncer
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"sigs.k8s.io/yaml"
+)
+
+// syncPair maps a local root directory to a remote root folder.
+type syncPair struct {
+	// Name is the state namespace of the pair. With JSON state the state
+	// of an unnamed pair is kept in files.json, otherwise in
+	// files-<Name>.json.
+	Name string `json:"name"`
+	// Local is the local root directory.
+	Local string `json:"local"`
+	// Remote is the remote root folder such as "/Docs". Empty means the
+	// whole Drive.
+	Remote string `json:"remote"`
+	// Filter lists "+ pattern" and "- pattern" rules, relative to the
+	// roots, deciding which paths are included in both trees. The first
+	// matching rule wins.
+	Filter []string `json:"filter"`
+	// Exclude lists patterns which are left out of both trees. They are
+	// checked after Filter.
+	Exclude []string `json:"exclude"`
+	// MinSize and MaxSize such as "100k" or "2G" leave smaller or larger
+	// files out of both trees.
+	MinSize string `json:"minSize"`
+	MaxSize string `json:"maxSize"`
+	// NewerThan and OlderThan, durations like "7d" or RFC3339 timestamps,
+	// leave files modified before or after that time out of both trees.
+	NewerThan string `json:"newerThan"`
+	OlderThan string `json:"olderThan"`
+	// MaxDepth limits both trees to that many levels below the roots;
+	// 1 means only the files directly in the roots. 0 means no limit.
+	MaxDepth int `json:"maxDepth"`
+	// SharedWithMe is the local folder, relative to Local, which items
+	// shared with me are synced into. They are left out if it is empty.
+	SharedWithMe string `json:"sharedWithMe"`
+	// Computers is the local folder, relative to Local, which the backups
+	// of Google's desktop client are synced into, one folder per
+	// computer. They are left out if it is empty.
+	Computers string `json:"computers"`
+	// Orphans is the local folder, relative to Local, for files whose
+	// parent chain is broken. It defaults to "_Orphans".
+	Orphans string `json:"orphans"`
+	// Parents is the policy for files in several folders: "primary"
+	// (default), "all" or "link".
+	Parents string `json:"parents"`
+	// WindowsNames replaces characters and names Windows doesn't allow
+	// in remote names. It is the default on Windows.
+	WindowsNames *bool `json:"windowsNames"`
+	// Normalization is the Unicode normalization form of the names of
+	// created local files: "none" (default), "nfc" or "nfd". Names are
+	// compared regardless of their form.
+	Normalization string `json:"normalization"`
+	// CaseInsensitive makes names differing only in case clash, as they
+	// do on the file systems of macOS and Windows. It is detected from the
+	// local root by default.
+	CaseInsensitive *bool `json:"caseInsensitive"`
+	// Compare is how local and remote files are found to be the same:
+	// "md5" (default), "size", "mtime" or "size+mtime".
+	Compare string `json:"compare"`
+	// Native is the policy for Google-native documents: "skip" (default),
+	// "export", "gdoc" or "url".
+	Native string `json:"native"`
+	// Direction is "download" (default) to make the local tree like the
+	// remote one, or "upload" for the reverse.
+	Direction string `json:"direction"`
+	// Delete propagates deletions: files missing on the source side are
+	// moved to the local .drive-trash folder or the Drive trash.
+	Delete bool `json:"delete"`
+	// Encrypt encrypts the files uploaded and decrypts the files
+	// downloaded, with the key of -encryption-key-file or
+	// -encryption-passphrase. Names are left as they are.
+	Encrypt bool `json:"encrypt"`
+	// Obfuscate uploads all files into the remote root, named by their
+	// encrypted paths, so the remote tree shows neither names nor
+	// structure. The state keeps the mapping back to the paths.
+	Obfuscate bool `json:"obfuscate"`
+	// Compress is the algorithm uploaded files are compressed with:
+	// "none" (default), "gzip" or "zstd". Formats compressed already are
+	// left alone. Downloads decompress the files whatever the setting.
+	Compress string `json:"compress"`
+	// CompressTypes limits compression to the files whose names match one
+	// of these patterns, such as "*.log".
+	CompressTypes []string `json:"compressTypes"`
+	// ChunkSize such as "1T" splits larger files into chunks of that size
+	// on upload, for files beyond the size Drive takes. Downloads put
+	// them together again whatever the setting.
+	ChunkSize string `json:"chunkSize"`
+	// Snapshots downloads into a new directory of Local on every run,
+	// named by its time, with the files unchanged since the previous one
+	// hardlinked to it. Files missing on Drive are left out of the new
+	// snapshot rather than deleted.
+	Snapshots bool `json:"snapshots"`
+	// KeepDaily, KeepWeekly and KeepMonthly are the retention policy of
+	// the snapshots, applied by the prune command: the newest snapshot of
+	// each of that many latest days, weeks and months is kept. Without
+	// any, all snapshots are kept.
+	KeepDaily   int `json:"keepDaily"`
+	KeepWeekly  int `json:"keepWeekly"`
+	KeepMonthly int `json:"keepMonthly"`
+	// SheetsCSV exports Google Sheets, with the native policy "export",
+	// to a directory holding a CSV file per tab rather than to one file.
+	SheetsCSV bool `json:"sheetsCSV"`
+	// DocsMarkdown exports Google Docs, with the native policy "export",
+	// to Markdown, their images to a .assets directory next to them.
+	DocsMarkdown bool `json:"docsMarkdown"`
+	// OCR has the images and PDFs uploaded read by Drive's OCR into a
+	// Google Doc next to them, in the language of this ISO 639-1 code,
+	// such as "en", or "auto" to leave it to Drive.
+	OCR string `json:"ocr"`
+	// Description, Properties and AppProperties are set on the files
+	// uploaded. The sidecar file of a file, such as
+	// notes.pdf.drive-meta.json, overrides them with its own.
+	Description   string            `json:"description"`
+	Properties    map[string]string `json:"properties"`
+	AppProperties map[string]string `json:"appProperties"`
+	// Label only downloads the files with the Drive label of this id.
+	Label string `json:"label"`
+	// StarredOnly only downloads the starred files.
+	StarredOnly bool `json:"starredOnly"`
+	// FollowShortcuts downloads the targets of shortcuts at their places,
+	// the trees of folders included, rather than handling them as
+	// Google-native documents.
+	FollowShortcuts bool `json:"followShortcuts"`
+
+	rules     []ignoreRule
+	ignore    *ignoreList
+	minSize   int64
+	maxSize   int64
+	chunkSize int64
+	hash      string    // of -hash, which the checksums are of
+	key       *[32]byte // of Encrypt and Obfuscate, see loadKey
+	newSalt   []byte    // set on the remote root when the upload creates it
+	newerThan time.Time
+	olderThan time.Time
+
+	mimeIncludes []string
+	mimeExcludes []string
+	// labeled holds the ids of the files with Label, set before the sync.
+	labeled map[string]bool
+	// unreadable holds the local files which couldn't be hashed and the
+	// directories which couldn't be read this run, by pathKey.
+	unreadable map[string]bool
+}
+
+const defaultOrphansDir = "_Orphans"
+
+// config is the content of the config file, YAML or JSON.
+type config struct {
+	Pairs []syncPair `json:"pairs"`
+	// ExportFormats maps Google-native MIME types to the format and file
+	// extension they are exported to, overriding the defaults.
+	ExportFormats map[string]exportFormat `json:"exportFormats"`
+
+	// settings holds the other keys, which set the flags of the same
+	// name, e.g. "checkers: 8" or "exclude: ['*.tmp']".
+	settings map[string]json.RawMessage
+	// unknown lists the keys of pairs and export formats which set
+	// nothing, like pairs[0].exlude.
+	unknown []string
+}
+
+// readConfig reads the config file, its export formats merged into
+// exportFormats.
+func readConfig(file string) *config {
+	c, err := parseConfig(file)
+	if err != nil {
+		exitf(exitConfig, "%v", err)
+	}
+	c.applyExportFormats()
+	return c
+}
+
+// parseConfig reads and checks the config file.
+func parseConfig(file string) (*config, error) {
+	var c config
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("ioutil.ReadFile(%s) failed: %v", file, err)
+	}
+	// YAML is a superset of JSON, so both are read as YAML.
+	if b, err = yaml.YAMLToJSON(b); err != nil {
+		return nil, fmt.Errorf("Unable to parse %s: %v", file, err)
+	}
+	if err = json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal(%s) failed: %v", file, err)
+	}
+	if err = json.Unmarshal(b, &c.settings); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal(%s) failed: %v", file, err)
+	}
+	delete(c.settings, "pairs")
+	delete(c.settings, "exportFormats")
+	var raw struct {
+		Pairs         []map[string]json.RawMessage          `json:"pairs"`
+		ExportFormats map[string]map[string]json.RawMessage `json:"exportFormats"`
+	}
+	if err = json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal(%s) failed: %v", file, err)
+	}
+	pairKeys := jsonKeys(syncPair{})
+	for i, p := range raw.Pairs {
+		for key := range p {
+			if !pairKeys[key] {
+				c.unknown = append(c.unknown, fmt.Sprintf("pairs[%d].%s", i, key))
+			}
+		}
+	}
+	formatKeys := jsonKeys(exportFormat{})
+	for mimeType, f := range raw.ExportFormats {
+		for key := range f {
+			if !formatKeys[key] {
+				c.unknown = append(c.unknown, fmt.Sprintf("exportFormats[%s].%s", mimeType, key))
+			}
+		}
+	}
+	names := make(map[string]bool)
+	for i := range c.Pairs {
+		p := &c.Pairs[i]
+		if p.Local == "" {
+			return nil, fmt.Errorf("%s: pair %d has no local root", file, i)
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("%s: duplicate pair name %q", file, p.Name)
+		}
+		names[p.Name] = true
+		p.Local = expandHome(p.Local)
+		p.Remote = cleanRemote(p.Remote)
+	}
+	for mimeType, format := range c.ExportFormats {
+		if format.MimeType == "" || format.Extension == "" {
+			return nil, fmt.Errorf("%s: export format for %s needs mimeType and extension", file, mimeType)
+		}
+		if !strings.HasPrefix(format.Extension, ".") {
+			format.Extension = "." + format.Extension
+			c.ExportFormats[mimeType] = format
+		}
+	}
+	return &c, nil
+}
+
+// applyExportFormats merges the export formats of the config into
+// exportFormats.
+func (c *config) applyExportFormats() {
+	for mimeType, format := range c.ExportFormats {
+		exportFormats[mimeType] = format
+	}
+}
+
+// jsonKeys returns the JSON keys of the fields of a struct.
+func jsonKeys(v interface{}) map[string]bool {
+	keys := make(map[string]bool)
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		if name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+			keys[name] = true
+		}
+	}
+	return keys
+}
+
+// unknownKeys returns the keys of the config file which set nothing,
+// sorted: those of pairs and export formats no field has, and settings no
+// flag of fs has.
+func (c *config) unknownKeys(fs *flag.FlagSet) []string {
+	unknown := append([]string(nil), c.unknown...)
+	for name := range c.settings {
+		if fs.Lookup(name) == nil {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// compileFilters prepares the pair's filters. The command line rules come
+// first, then the pair's Filter and Exclude; command line sizes and times
+// replace the pair's.
+func (p *syncPair) compileFilters(flags *filterFlags) error {
+	p.rules = nil
+	var lines []string
+	lines = append(lines, flags.rules...)
+	lines = append(lines, p.Filter...)
+	for _, pattern := range p.Exclude {
+		lines = append(lines, "- "+pattern)
+	}
+	for _, line := range lines {
+		rule, err := parseFilterRule(line)
+		if err != nil {
+			return fmt.Errorf("pair %s: %v", p, err)
+		}
+		p.rules = append(p.rules, rule)
+	}
+	if flags.minSize != "" {
+		p.MinSize = flags.minSize
+	}
+	if flags.maxSize != "" {
+		p.MaxSize = flags.maxSize
+	}
+	var err error
+	if p.minSize, err = parseSize(p.MinSize); err != nil {
+		return fmt.Errorf("pair %s: min size: %v", p, err)
+	}
+	if p.maxSize, err = parseSize(p.MaxSize); err != nil {
+		return fmt.Errorf("pair %s: max size: %v", p, err)
+	}
+	if flags.newerThan != "" {
+		p.NewerThan = flags.newerThan
+	}
+	if flags.olderThan != "" {
+		p.OlderThan = flags.olderThan
+	}
+	if flags.maxDepth > 0 {
+		p.MaxDepth = flags.maxDepth
+	}
+	p.mimeIncludes = flags.mimeIncludes
+	p.mimeExcludes = flags.mimeExcludes
+	now := time.Now()
+	if p.newerThan, err = parseTimeBound(p.NewerThan, now); err != nil {
+		return fmt.Errorf("pair %s: newer than: %v", p, err)
+	}
+	if p.olderThan, err = parseTimeBound(p.OlderThan, now); err != nil {
+		return fmt.Errorf("pair %s: older than: %v", p, err)
+	}
+	return nil
+}
+
+// Sync directions.
+const (
+	directionDownload = "download"
+	directionUpload   = "upload"
+)
+
+// pairFlags holds the pair settings given on the command line. They apply
+// to every sync pair and take precedence over the pair's own settings.
+type pairFlags struct {
+	filterFlags
+	native          string
+	parents         string
+	normalization   string
+	compare         string
+	direction       string
+	compress        string
+	compressTypes   []string
+	chunkSize       string
+	ocr             string
+	description     string
+	properties      propertyFlag
+	appProperties   propertyFlag
+	label           string
+	starredOnly     boolFlag
+	followShortcuts boolFlag
+	keepDaily       int
+	keepWeekly      int
+	keepMonthly     int
+	windowsNames    boolFlag
+	caseInsensitive boolFlag
+	delete          boolFlag
+	encrypt         boolFlag
+	obfuscate       boolFlag
+	snapshots       boolFlag
+	sheetsCSV       boolFlag
+	docsMarkdown    boolFlag
+	hash            string // of -hash, md5 if empty
+}
+
+func (f *pairFlags) register(fs *flag.FlagSet) {
+	fs.Var(filterFlag{&f.rules, "+ "}, "include", "include paths matching `pattern` (repeatable)")
+	fs.Var(filterFlag{&f.rules, "- "}, "exclude", "exclude paths matching `pattern` (repeatable)")
+	fs.StringVar(&f.minSize, "min-size", "", "skip files smaller than `size` (e.g. 100k)")
+	fs.StringVar(&f.maxSize, "max-size", "", "skip files larger than `size` (e.g. 2G)")
+	fs.StringVar(&f.newerThan, "newer-than", "", "skip files modified before `age` ago (e.g. 7d) or an RFC3339 time")
+	fs.StringVar(&f.olderThan, "older-than", "", "skip files modified after `age` ago (e.g. 30d) or an RFC3339 time")
+	fs.IntVar(&f.maxDepth, "max-depth", 0, "only sync `N` levels below the roots")
+	fs.Var(filterFlag{&f.mimeIncludes, ""}, "mime-include", "only sync files whose MIME type matches `pattern` such as image/* (repeatable)")
+	fs.Var(filterFlag{&f.mimeExcludes, ""}, "mime-exclude", "skip files whose MIME type matches `pattern` such as application/vnd.google-apps.* (repeatable)")
+	fs.StringVar(&f.native, "native", "", "how to handle Google Docs, Sheets, ...: skip, export, gdoc or url (default skip)")
+	fs.Var(&f.sheetsCSV, "sheets-csv", "export Google Sheets to a directory with a CSV file per tab, with -native export")
+	fs.Var(&f.docsMarkdown, "docs-markdown", "export Google Docs to Markdown, their images to a .assets directory, with -native export")
+	fs.StringVar(&f.parents, "parents", "", "how to sync files in several folders: primary, all or link (default primary)")
+	fs.Var(&f.windowsNames, "windows-names", "replace characters Windows doesn't allow in file names (default true on Windows)")
+	fs.StringVar(&f.normalization, "normalize", "", "Unicode normalization of created file names: none, nfc or nfd (default none)")
+	fs.Var(&f.caseInsensitive, "case-insensitive", "treat names differing only in case as clashing (default detected from the local root)")
+	fs.StringVar(&f.compare, "compare", "", "how to tell files are the same: md5, size, mtime or size+mtime (default md5)")
+	fs.StringVar(&f.direction, "direction", "", "download to make the local tree like the remote one, or upload (default download)")
+	fs.StringVar(&f.compress, "compress", "", "compress uploaded files with none, gzip or zstd (default none)")
+	fs.Var(filterFlag{&f.compressTypes, ""}, "compress-type", "only compress files whose names match `pattern` such as *.log (repeatable)")
+	fs.StringVar(&f.chunkSize, "chunk-size", "", "upload files larger than `size` (e.g. 1T) in chunks of that size")
+	fs.Var(&f.delete, "delete", "move files missing on the source side to the local .drive-trash or the Drive trash")
+	fs.Var(&f.encrypt, "encrypt", "encrypt uploaded files and decrypt downloaded ones, with -encryption-key-file or -encryption-passphrase")
+	fs.Var(&f.obfuscate, "obfuscate", "upload files flat into the remote root, named by their encrypted paths")
+	fs.Var(&f.snapshots, "snapshots", "download into a new dated directory of the local root on every run, hardlinking unchanged files to the previous one")
+	fs.StringVar(&f.ocr, "ocr", "", "have uploaded images and PDFs read by Drive's OCR into Google Docs next to them, in `lang` such as en, or auto")
+	fs.StringVar(&f.description, "description", "", "set this `text` as the description of the files uploaded")
+	f.properties, f.appProperties = make(propertyFlag), make(propertyFlag)
+	fs.Var(f.properties, "property", "set the property `key=value` on the files uploaded (repeatable)")
+	fs.Var(f.appProperties, "app-property", "set the private property `key=value` on the files uploaded (repeatable)")
+	fs.StringVar(&f.label, "label", "", "only download the files with the Drive label of this `id`")
+	fs.Var(&f.starredOnly, "starred-only", "only download the starred files")
+	fs.Var(&f.followShortcuts, "follow-shortcuts", "download the targets of shortcuts, folders included, at the shortcuts' places")
+	fs.IntVar(&f.keepDaily, "keep-daily", 0, "prune keeps the newest snapshot of the last `N` days with one")
+	fs.IntVar(&f.keepWeekly, "keep-weekly", 0, "prune keeps the newest snapshot of the last `N` weeks with one")
+	fs.IntVar(&f.keepMonthly, "keep-monthly", 0, "prune keeps the newest snapshot of the last `N` months with one")
+}
+
+// loadPairs returns the pairs of the config, and one for the local root
+// given as argument, set up with the flags.
+func loadPairs(c *config, args []string, flags *pairFlags) ([]syncPair, error) {
+	pairs := c.Pairs
+	if len(args) > 0 {
+		pairs = append(pairs, syncPair{Local: args[0]})
+	}
+	if err := checkNames(pairs); err != nil {
+		return nil, err
+	}
+	for i := range pairs {
+		if err := pairs[i].setup(flags); err != nil {
+			return nil, err
+		}
+	}
+	return pairs, nil
+}
+
+// checkNames returns an error if two pairs have the same name, the state
+// being kept by name: they would share it, whatever their roots.
+func checkNames(pairs []syncPair) error {
+	seen := make(map[string]*syncPair)
+	for i := range pairs {
+		if other, ok := seen[pairs[i].Name]; ok {
+			return fmt.Errorf("pairs %s and %s have the same name, under which their state is kept: name one of them", other, &pairs[i])
+		}
+		seen[pairs[i].Name] = &pairs[i]
+	}
+	return nil
+}
+
+// setup applies the command line flags to the pair, fills in defaults and
+// validates the settings.
+func (p *syncPair) setup(flags *pairFlags) error {
+	if err := p.compileFilters(&flags.filterFlags); err != nil {
+		return err
+	}
+	p.hash = flags.hash
+	if p.hash == "" {
+		p.hash = hashMd5
+	}
+	options := []struct {
+		name     string
+		value    *string
+		flag     string
+		fallback string
+		valid    func(string) bool
+	}{
+		{"native document policy", &p.Native, flags.native, nativeSkip, validNativePolicy},
+		{"parents policy", &p.Parents, flags.parents, parentsPrimary, validParentsPolicy},
+		{"normalization", &p.Normalization, flags.normalization, normalizeNone, validNormalization},
+		{"comparison", &p.Compare, flags.compare, compareMd5, validCompare},
+		{"direction", &p.Direction, flags.direction, directionDownload, validDirection},
+		{"compression", &p.Compress, flags.compress, compressNone, validCompression},
+	}
+	for _, o := range options {
+		if o.flag != "" {
+			*o.value = o.flag
+		}
+		if *o.value == "" {
+			*o.value = o.fallback
+		}
+		if !o.valid(*o.value) {
+			return fmt.Errorf("pair %s: unknown %s %q", p, o.name, *o.value)
+		}
+	}
+	if flags.windowsNames.set {
+		p.WindowsNames = &flags.windowsNames.value
+	}
+	if p.WindowsNames == nil {
+		onWindows := runtime.GOOS == "windows"
+		p.WindowsNames = &onWindows
+	}
+	if flags.caseInsensitive.set {
+		p.CaseInsensitive = &flags.caseInsensitive.value
+	}
+	if p.CaseInsensitive == nil {
+		insensitive := caseInsensitiveFS(p.Local)
+		p.CaseInsensitive = &insensitive
+	}
+	if flags.delete.set {
+		p.Delete = flags.delete.value
+	}
+	if flags.encrypt.set {
+		p.Encrypt = flags.encrypt.value
+	}
+	if flags.obfuscate.set {
+		p.Obfuscate = flags.obfuscate.value
+	}
+	if (p.Encrypt || p.Obfuscate) && p.Remote == "" {
+		return fmt.Errorf("pair %s: encryption needs a remote root folder, which keeps the salt of the key", p)
+	}
+	if flags.snapshots.set {
+		p.Snapshots = flags.snapshots.value
+	}
+	if flags.sheetsCSV.set {
+		p.SheetsCSV = flags.sheetsCSV.value
+	}
+	if flags.docsMarkdown.set {
+		p.DocsMarkdown = flags.docsMarkdown.value
+	}
+	for _, keep := range []struct {
+		value *int
+		flag  int
+	}{{&p.KeepDaily, flags.keepDaily}, {&p.KeepWeekly, flags.keepWeekly}, {&p.KeepMonthly, flags.keepMonthly}} {
+		if keep.flag > 0 {
+			*keep.value = keep.flag
+		}
+		if *keep.value < 0 {
+			return fmt.Errorf("pair %s: negative snapshot retention %d", p, *keep.value)
+		}
+	}
+	if p.Snapshots && p.Direction == directionUpload {
+		return fmt.Errorf("pair %s: snapshots are only downloaded", p)
+	}
+	if flags.ocr != "" {
+		p.OCR = flags.ocr
+	}
+	if p.OCR != "" && (p.Direction != directionUpload || p.Encrypt || p.Obfuscate) {
+		return fmt.Errorf("pair %s: OCR is only done on plain uploads", p)
+	}
+	if flags.description != "" {
+		p.Description = flags.description
+	}
+	for _, props := range []struct {
+		value *map[string]string
+		flag  propertyFlag
+	}{{&p.Properties, flags.properties}, {&p.AppProperties, flags.appProperties}} {
+		if len(props.flag) == 0 {
+			continue
+		}
+		merged := make(map[string]string)
+		for key, value := range *props.value {
+			merged[key] = value
+		}
+		for key, value := range props.flag {
+			merged[key] = value
+		}
+		*props.value = merged
+	}
+	if (p.Description != "" || len(p.Properties) > 0 || len(p.AppProperties) > 0) && p.Direction != directionUpload {
+		return fmt.Errorf("pair %s: descriptions and properties are only set on upload", p)
+	}
+	if flags.label != "" {
+		p.Label = flags.label
+	}
+	if p.Label != "" && p.Direction == directionUpload {
+		return fmt.Errorf("pair %s: label filters only apply to downloads", p)
+	}
+	if flags.starredOnly.set {
+		p.StarredOnly = flags.starredOnly.value
+	}
+	if p.StarredOnly && p.Direction == directionUpload {
+		return fmt.Errorf("pair %s: -starred-only only applies to downloads", p)
+	}
+	if flags.followShortcuts.set {
+		p.FollowShortcuts = flags.followShortcuts.value
+	}
+	if p.FollowShortcuts && p.Direction == directionUpload {
+		return fmt.Errorf("pair %s: shortcuts are only followed on download", p)
+	}
+	if len(flags.compressTypes) > 0 {
+		p.CompressTypes = flags.compressTypes
+	}
+	if flags.chunkSize != "" {
+		p.ChunkSize = flags.chunkSize
+	}
+	var err error
+	if p.chunkSize, err = parseSize(p.ChunkSize); err != nil {
+		return fmt.Errorf("pair %s: chunk size: %v", p, err)
+	}
+	return nil
+}
+
+func validDirection(direction string) bool {
+	return direction == directionDownload || direction == directionUpload
+}
+
+// expandHome replaces a leading "~/" with the current user's home
+// directory. Without one p is kept, and fails where it is used.
+func expandHome(p string) string {
+	if !strings.HasPrefix(p, "~/") {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+	return filepath.Join(home, p[2:])
+}
+
+// cleanRemote normalizes a remote root to "/a/b" form, or "" for the whole Drive.
+func cleanRemote(p string) string {
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return ""
+	}
+	return p
+}
+
+func (p *syncPair) String() string {
+	name := p.Name
+	if name == "" {
+		name = "default"
+	}
+	return fmt.Sprintf("%s (%s <-> %s/)", name, p.Local, p.Remote)
+}
+
+func (p *syncPair) stateFile() string {
+	if p.Name == "" {
+		return "files.json"
+	}
+	return "files-" + p.Name + ".json"
+}
+
+// journalFile is where the transfers of the pair are journaled.
+func (p *syncPair) journalFile() string {
+	if p.Name == "" {
+		return "journal.jsonl"
+	}
+	return "journal-" + p.Name + ".jsonl"
+}
+
+// excluded reports whether the relative path, or any directory leading to
+// it, is excluded by the pair's filter rules or by a .driveignore file.
+// Files of the tool itself are always excluded.
+func (p *syncPair) excluded(rel string, isDir bool) bool {
+	rel = pathKey(rel)
+	if internalPath(rel) || p.unreadableUnder(rel) {
+		return true
+	}
+	for sub, dir := rel, isDir; sub != "." && sub != "/"; sub, dir = path.Dir(sub), true {
+		if filterExcluded(p.rules, sub, dir) {
+			return true
+		}
+		if p.ignore.match(sub, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// unreadableUnder reports whether the path key rel, or a directory leading
+// to it, couldn't be read this run.
+func (p *syncPair) unreadableUnder(rel string) bool {
+	if len(p.unreadable) == 0 {
+		return false
+	}
+	for sub := rel; ; sub = path.Dir(sub) {
+		if p.unreadable[sub] {
+			return true
+		}
+		if sub == "." || sub == "/" {
+			return false
+		}
+	}
+}
+
+func (p *syncPair) nameOptions() nameOptions {
+	return nameOptions{
+		windows: p.WindowsNames != nil && *p.WindowsNames,
+		form:    p.Normalization,
+
+		caseInsensitive: p.CaseInsensitive != nil && *p.CaseInsensitive,
+	}
+}
+
+// sizeIncluded reports whether a file of the given size passes the pair's
+// size limits.
+func (p *syncPair) sizeIncluded(size int64) bool {
+	if p.minSize >= 0 && size < p.minSize {
+		return false
+	}
+	if p.maxSize >= 0 && size > p.maxSize {
+		return false
+	}
+	return true
+}
+
+// timeIncluded reports whether a file modified at t passes the pair's time
+// window.
+func (p *syncPair) timeIncluded(t time.Time) bool {
+	if !p.newerThan.IsZero() && t.Before(p.newerThan) {
+		return false
+	}
+	if !p.olderThan.IsZero() && t.After(p.olderThan) {
+		return false
+	}
+	return true
+}
+
+// relativePath returns the path of a remote file relative to the pair's
+// remote root, and whether the file lives under that root and is not
+// excluded.
+func (p *syncPair) relativePath(remotePath string, isDir bool) (string, bool) {
+	if !strings.HasPrefix(remotePath, p.Remote+"/") {
+		return "", false
+	}
+	rel := strings.TrimPrefix(remotePath, p.Remote+"/")
+	if p.MaxDepth > 0 && pathDepth(rel) > p.MaxDepth {
+		return rel, false
+	}
+	return rel, !p.excluded(rel, isDir)
+}
+
+// includeRemote returns the relative path of a remote file and whether the
+// pair's filters include it.
+func (p *syncPair) includeRemote(idx *remoteIndex, file drive.File) (string, bool) {
+	return p.includeRemoteAt(idx, file, remotePath(idx, file))
+}
+
+// includeRemoteAt is includeRemote for the remote path rp, one of the
+// file's remotePaths.
+func (p *syncPair) includeRemoteAt(idx *remoteIndex, file drive.File, rp string) (string, bool) {
+	isDir := file.MimeType == folderMimeType
+	// Items outside My Drive don't live below the remote root; place
+	// them below their own local folder instead.
+	var sectionDir string
+	switch remoteSection(idx, file) {
+	case sectionShared:
+		sectionDir = p.SharedWithMe
+	case sectionComputers:
+		sectionDir = p.Computers
+	case sectionOrphans:
+		sectionDir = p.Orphans
+		if sectionDir == "" {
+			sectionDir = defaultOrphansDir
+		}
+	default:
+		sectionDir = "."
+	}
+	if sectionDir == "" {
+		return "", false
+	}
+	if sectionDir != "." {
+		rp = p.Remote + "/" + filepath.ToSlash(sectionDir) + rp
+	}
+	rel, ok := p.relativePath(rp, isDir)
+	if ok && !isDir && !p.sizeIncluded(file.Size) {
+		ok = false
+	}
+	if ok && !mimeIncluded(file.MimeType, p.mimeIncludes, p.mimeExcludes) {
+		ok = false
+	}
+	if ok && !isDir && p.labeled != nil && !p.labeled[file.Id] {
+		ok = false
+	}
+	if ok && !isDir && p.StarredOnly && !file.Starred {
+		ok = false
+	}
+	if ok && !isDir {
+		if t, err := time.Parse(time.RFC3339, file.ModifiedTime); err == nil && !p.timeIncluded(t) {
+			ok = false
+		}
+	}
+	return rel, ok
+}
+
+// remoteSubset returns the files under the pair's remote root together with
+// the folders leading to it, so remotePath still resolves full paths.
+func (p *syncPair) remoteSubset(all *Files) []drive.File {
+	idx := newRemoteIndex(all, p.nameOptions())
+	var files []drive.File
+	for _, file := range all.Remote {
+		rp := remotePath(idx, file)
+		if _, ok := p.includeRemote(idx, file); ok || rp == p.Remote ||
+			(file.MimeType == folderMimeType && strings.HasPrefix(p.Remote, rp+"/")) {
+			files = append(files, file)
+		}
+	}
+	return files
+}
@@ -0,0 +1,207 @@
+package syncer
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// secretSettings are left out of config show.
+var secretSettings = map[string]bool{"smtp-password": true}
+
+// configCommand runs config validate or config show, for the config file
+// file and the local root of args if any, then exits.
+func configCommand(command string, args []string, fs *flag.FlagSet, cfg *config, file string, sources map[string]string, unknown []string, flags *pairFlags) {
+	if command == "show" {
+		pairs, err := loadPairs(cfg, args, flags)
+		if err != nil {
+			exitf(exitConfig, "%v", err)
+		}
+		if err := showConfig(fs, pairs, file, sources); err != nil {
+			fatalf("Unable to show the config: %v", err)
+		}
+		exit(exitInSync)
+	}
+	problems := validateConfig(fs, cfg, args, unknown, flags)
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		exit(exitConfig)
+	}
+	if file == "" {
+		file = "the settings"
+	}
+	infof("%s: no problems found\n", file)
+	exit(exitInSync)
+}
+
+// validateConfig returns the problems of the settings: unknown keys,
+// invalid pair settings, filters which contradict each other, local roots
+// inside one another and paths which can't be reached.
+func validateConfig(fs *flag.FlagSet, cfg *config, args []string, unknown []string, flags *pairFlags) []string {
+	var problems []string
+	add := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+	for _, key := range unknown {
+		add("unknown setting %s", key)
+	}
+
+	pairs := cfg.Pairs
+	if len(args) > 0 {
+		pairs = append(pairs, syncPair{Local: args[0]})
+	}
+	var valid []*syncPair
+	for i := range pairs {
+		p := &pairs[i]
+		if err := p.setup(flags); err != nil {
+			add("%v", err)
+			continue
+		}
+		valid = append(valid, p)
+		var lines []string
+		lines = append(lines, flags.rules...)
+		lines = append(lines, p.Filter...)
+		for _, pattern := range p.Exclude {
+			lines = append(lines, "- "+pattern)
+		}
+		first := make(map[string]string)
+		for _, line := range lines {
+			sign, pattern := line[:1], strings.TrimSpace(line[2:])
+			if s, ok := first[pattern]; !ok {
+				first[pattern] = sign
+			} else if s != sign {
+				add("pair %s: %q is both included and excluded, the first rule wins", p, pattern)
+			}
+		}
+		for _, pattern := range p.mimeExcludes {
+			for _, included := range p.mimeIncludes {
+				if pattern == included {
+					add("pair %s: MIME type %q is both included and excluded", p, pattern)
+				}
+			}
+		}
+		if p.minSize >= 0 && p.maxSize >= 0 && p.minSize > p.maxSize {
+			add("pair %s: min size %s is above max size %s, no file is synced", p, p.MinSize, p.MaxSize)
+		}
+		if !p.newerThan.IsZero() && !p.olderThan.IsZero() && !p.newerThan.Before(p.olderThan) {
+			add("pair %s: newer than %s and older than %s, no file is synced", p, p.NewerThan, p.OlderThan)
+		}
+		if info, err := os.Stat(p.Local); err == nil {
+			if !info.IsDir() {
+				add("pair %s: local root %s isn't a directory", p, p.Local)
+			}
+		} else if p.Direction == directionUpload {
+			add("pair %s: local root %s to upload: %v", p, p.Local, err)
+		} else if _, err := os.Stat(filepath.Dir(p.Local)); err != nil {
+			// The root itself is created by the first download.
+			add("pair %s: local root %s can't be created: %v", p, p.Local, err)
+		}
+	}
+	for i, a := range valid {
+		for _, b := range valid[i+1:] {
+			if within(a.Local, b.Local) || within(b.Local, a.Local) {
+				add("pairs %s and %s: the local roots overlap", a, b)
+			}
+		}
+	}
+
+	// Files read, and files whose directory must exist to write them.
+	for _, name := range []string{"client-secret", "ca-cert"} {
+		if f := fs.Lookup(name); f != nil && f.Value.String() != "" {
+			if _, err := ioutil.ReadFile(expandHome(f.Value.String())); err != nil {
+				add("-%s: %v", name, err)
+			}
+		}
+	}
+	for _, name := range []string{"token-file", "log-file", "summary-json", "report-csv", "report-html"} {
+		if f := fs.Lookup(name); f != nil && f.Value.String() != "" && f.Value.String() != "-" {
+			dir := filepath.Dir(expandHome(f.Value.String()))
+			if info, err := os.Stat(dir); err != nil {
+				add("-%s: %v", name, err)
+			} else if !info.IsDir() {
+				add("-%s: %s isn't a directory", name, dir)
+			}
+		}
+	}
+	return problems
+}
+
+// within reports whether the path is dir or below it.
+func within(path, dir string) bool {
+	path, _ = filepath.Abs(path)
+	dir, _ = filepath.Abs(dir)
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// showConfig prints the settings in effect as a config file, with where
+// each value comes from in a comment.
+func showConfig(fs *flag.FlagSet, pairs []syncPair, file string, sources map[string]string) error {
+	if file == "" {
+		file = "none"
+	}
+	fmt.Printf("# config file: %s\n", file)
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || f.Name == "config" || f.Name == "version" {
+			return
+		}
+		var value string
+		if l, ok := f.Value.(interface{ list() []string }); ok {
+			var b []byte
+			if b, err = json.Marshal(l.list()); err != nil {
+				return
+			}
+			value = string(b)
+			if value == "null" {
+				value = "[]"
+			}
+		} else {
+			value = yamlScalar(f.Value.String())
+		}
+		if secretSettings[f.Name] && f.Value.String() != "" {
+			value = `"<redacted>"`
+		}
+		if f.Name == "proxy" {
+			if u, perr := url.Parse(f.Value.String()); perr == nil && u.User != nil {
+				value = yamlScalar(u.Redacted())
+			}
+		}
+		source := sources[f.Name]
+		if source == "" {
+			source = sourceDefault
+		}
+		fmt.Printf("%s: %s # %s\n", f.Name, value, source)
+	})
+	if err != nil {
+		return err
+	}
+	b, err := yaml.Marshal(struct {
+		Pairs         []syncPair              `json:"pairs"`
+		ExportFormats map[string]exportFormat `json:"exportFormats"`
+	}{pairs, exportFormats})
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(b))
+	return nil
+}
+
+// yamlScalar returns a flag value as YAML, strings quoted.
+func yamlScalar(s string) string {
+	if _, err := strconv.ParseFloat(s, 64); err == nil || s == "true" || s == "false" {
+		return s
+	}
+	b, _ := json.Marshal(s)
+	return string(b)
+}
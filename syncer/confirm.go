@@ -0,0 +1,65 @@
+package syncer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// confirmListed is how many of the files deleted or overwritten the
+// question before a destructive plan lists.
+const confirmListed = 10
+
+// stdin reads the answers.
+var stdin = bufio.NewReader(os.Stdin)
+
+// confirmPlan asks before a plan of pair deleting or overwriting more than
+// opts.confirmOver files, and reports whether to go on. -yes goes on
+// without asking; without a terminal to ask on the plan is refused.
+func confirmPlan(pair *syncPair, deletes, overwrites []string, opts *runOptions) bool {
+	if opts.yes || opts.confirmOver < 0 || len(deletes)+len(overwrites) <= opts.confirmOver {
+		return true
+	}
+	fmt.Printf("%s: the sync deletes %d files and overwrites %d\n", pair, len(deletes), len(overwrites))
+	for _, list := range []struct {
+		what  string
+		names []string
+	}{{"delete", deletes}, {"overwrite", overwrites}} {
+		for i, name := range list.names {
+			if i == confirmListed {
+				fmt.Printf("  ... and %d more\n", len(list.names)-i)
+				break
+			}
+			fmt.Printf("  %s %s\n", list.what, name)
+		}
+	}
+	if ui != nil {
+		if ui.ask("Go on?") {
+			return true
+		}
+		fmt.Printf("%s: not synced\n", pair)
+		return false
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Printf("%s: not synced, run with -yes to go on without asking\n", pair)
+		return false
+	}
+	fmt.Print("Go on? [y/N] ")
+	answer, _ := stdin.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	fmt.Printf("%s: not synced\n", pair)
+	return false
+}
+
+// planDeletes emits the deletions of a diff as planned.
+func planDeletes(deletes []string) {
+	for _, name := range deletes {
+		emit(event{Event: eventPlanned, Path: name, Action: actionDelete})
+	}
+}
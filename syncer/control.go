@@ -0,0 +1,225 @@
+package syncer
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// daemonControl is the state of the daemon the control socket reads and
+// changes. The socket is a UNIX domain socket on every system, Windows 10
+// and later included.
+type daemonControl struct {
+	mu      sync.Mutex
+	syncing bool
+	paused  bool
+	nextRun time.Time
+	pairs   []syncPair // being synced
+	// rescan asks for a sync at once, buffered so that a request made
+	// during a sync starts the next one when it ends.
+	rescan chan struct{}
+	// reload reads the config file again, returning the pairs it sets up.
+	reload func() (*config, []syncPair, error)
+	// reloaded holds the reloaded config until the next sync applies it.
+	reloaded      *config
+	reloadedPairs []syncPair
+}
+
+func newDaemonControl(reload func() (*config, []syncPair, error)) *daemonControl {
+	return &daemonControl{rescan: make(chan struct{}, 1), reload: reload}
+}
+
+// listen serves the control commands on the socket file until the daemon
+// exits, removing the socket then.
+func (c *daemonControl) listen(file string) error {
+	// A socket left by a daemon which was killed is in the way.
+	if conn, err := net.Dial("unix", file); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s: a daemon is listening already", file)
+	}
+	os.Remove(file)
+	l, err := net.Listen("unix", file)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(file, 0600); err != nil {
+		l.Close()
+		return err
+	}
+	atExit(func() {
+		l.Close()
+		os.Remove(file)
+	})
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go c.serve(conn)
+		}
+	}()
+	infof("Control socket %s\n", file)
+	return nil
+}
+
+// serve answers the command on a line of conn.
+func (c *daemonControl) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+	reply, err := c.command(strings.TrimSpace(line))
+	if err != nil {
+		reply = "error: " + err.Error() + "\n"
+	}
+	fmt.Fprint(conn, reply)
+}
+
+// command runs a control command and returns its reply.
+func (c *daemonControl) command(command string) (string, error) {
+	switch command {
+	case "status":
+		return c.status(), nil
+	case "pause":
+		c.mu.Lock()
+		c.paused = true
+		c.mu.Unlock()
+		transferPause.set(true)
+		infof("Paused from the control socket\n")
+		return "Paused\n", nil
+	case "resume":
+		c.mu.Lock()
+		c.paused = false
+		c.mu.Unlock()
+		transferPause.set(false)
+		infof("Resumed from the control socket\n")
+		return "Resumed\n", nil
+	case "rescan", "rescan-now":
+		select {
+		case c.rescan <- struct{}{}:
+		default:
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		switch {
+		case c.paused:
+			return "Sync requested, it starts once resumed\n", nil
+		case c.syncing:
+			return "Sync requested, it starts after the current one\n", nil
+		}
+		return "Sync requested\n", nil
+	case "reload", "reload-config":
+		cfg, pairs, err := c.reload()
+		if err != nil {
+			return "", err
+		}
+		c.mu.Lock()
+		c.reloaded, c.reloadedPairs = cfg, pairs
+		c.mu.Unlock()
+		return fmt.Sprintf("Reloaded %d pairs, the next sync uses them\n", len(pairs)), nil
+	}
+	return "", fmt.Errorf("unknown command %q, want status, pause, resume, rescan or reload", command)
+}
+
+func (c *daemonControl) status() string {
+	var b strings.Builder
+	c.mu.Lock()
+	syncing, paused, nextRun, reloaded := c.syncing, c.paused, c.nextRun, c.reloaded != nil
+	c.mu.Unlock()
+	switch {
+	case paused && syncing:
+		b.WriteString("State: paused, in a sync\n")
+	case paused:
+		b.WriteString("State: paused\n")
+	case syncing || nextRun.IsZero():
+		b.WriteString("State: syncing\n")
+	default:
+		fmt.Fprintf(&b, "State: waiting, next sync at %s\n", nextRun.Format("15:04:05"))
+	}
+	run, last := history.current()
+	if run != nil {
+		fmt.Fprintf(&b, "Run %s started at %s\n", run.ID, run.Started.Format("15:04:05"))
+	}
+	currentMu.Lock()
+	xfers := currentTransfers
+	currentMu.Unlock()
+	if xfers != nil {
+		xfers.mu.Lock()
+		fmt.Fprintf(&b, "Transfers: %d/%d files, %s / %s, %d running\n", xfers.done, xfers.total,
+			formatBytes(atomic.LoadInt64(&xfers.bytes)), formatBytes(xfers.totalBytes), len(xfers.active))
+		xfers.mu.Unlock()
+	}
+	if last != nil {
+		fmt.Fprintf(&b, "Last run %s ended at %s, exit %d: %s\n", last.ID, last.Ended.Format("15:04:05"), last.ExitCode, runCounts(last))
+	}
+	if reloaded {
+		b.WriteString("Reloaded config pending\n")
+	}
+	return b.String()
+}
+
+// setSyncing records whether a sync runs, and when the next one starts
+// otherwise.
+func (c *daemonControl) setSyncing(syncing bool, nextRun time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.syncing, c.nextRun = syncing, nextRun
+}
+
+// setPairs records the pairs the daemon syncs.
+func (c *daemonControl) setPairs(pairs []syncPair) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pairs = pairs
+}
+
+// takeReloaded returns the reloaded config and pairs, if any, once.
+func (c *daemonControl) takeReloaded() (*config, []syncPair) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cfg, pairs := c.reloaded, c.reloadedPairs
+	c.reloaded, c.reloadedPairs = nil, nil
+	return cfg, pairs
+}
+
+// controlCommand runs ctl: it sends the command to the daemon's socket and
+// prints the reply.
+func controlCommand(file string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: ctl status|pause|resume|rescan|reload")
+	}
+	if file == "" {
+		return fmt.Errorf("-control-socket is off")
+	}
+	conn, err := net.DialTimeout("unix", file, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("no daemon listening on %s: %v", file, err)
+	}
+	defer conn.Close()
+	if _, err := fmt.Fprintln(conn, args[0]); err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	var reply strings.Builder
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "error: ") {
+			return fmt.Errorf("%s", strings.TrimPrefix(line, "error: "))
+		}
+		reply.WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	fmt.Print(reply.String())
+	return nil
+}
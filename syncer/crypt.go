@@ -0,0 +1,513 @@
+package syncer
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hiroshi/googledriveclient/drivefs"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"google.golang.org/api/drive/v3"
+)
+
+// Encrypted files start with cryptMagic and a random nonce, followed by
+// the content in chunks of cryptChunk bytes, each sealed with NaCl
+// secretbox. The nonce of a chunk is the file's nonce with the chunk
+// number added to its first 8 bytes, and the top bit of its last byte set
+// for the last chunk, so chunks can't be reordered, dropped or cut off.
+const (
+	cryptMagic = "DRIVENC1"
+	cryptChunk = 64 << 10
+)
+
+// appProperties of compressed or chunked files keeping the checksum and
+// size of the plaintext, which the sync compares with the local files. The
+// checksum is of -hash.
+const (
+	propPlainMd5    = "plainMd5"
+	propPlainSha256 = "plainSha256"
+	propPlainSize   = "plainSize"
+)
+
+// appProperties of encrypted files keeping the checksum and size of the
+// plaintext sealed with the key of the pair, so Drive can't tell files by
+// their plaintext.
+const (
+	propSealedMd5    = "sealedMd5"
+	propSealedSha256 = "sealedSha256"
+	propSealedSize   = "sealedSize"
+)
+
+// plainChecksumProp returns the appProperty of the plaintext checksum of
+// hash.
+func plainChecksumProp(hash string) string {
+	if hash == hashSha256 {
+		return propPlainSha256
+	}
+	return propPlainMd5
+}
+
+// sealedChecksumProp returns the appProperty of the sealed plaintext
+// checksum of hash.
+func sealedChecksumProp(hash string) string {
+	if hash == hashSha256 {
+		return propSealedSha256
+	}
+	return propSealedMd5
+}
+
+// propSalt is the appProperty of the remote root of an encrypted pair
+// keeping the random salt of the key derived from a passphrase, so every
+// machine derives the same key while no two roots share one.
+const propSalt = "encryptionSalt"
+
+// saltSize is the size of the random salts.
+const saltSize = 16
+
+// legacySalt salts the keys of the roots encrypted before they kept a salt
+// of their own.
+var legacySalt = []byte("googledriveclient encryption")
+
+// encryptionSecret is what the keys of the pairs with Encrypt or Obfuscate
+// set are made of, nil if neither -encryption-key-file nor
+// -encryption-passphrase is given.
+var encryptionSecret *secret
+
+// secret is a key file or a passphrase.
+type secret struct {
+	fileKey    *[32]byte // the SHA-256 of the key file
+	passphrase string
+	mu         sync.Mutex
+	keys       map[string]*[32]byte // derived from the passphrase, by salt
+}
+
+// loadEncryptionSecret returns the secret of keyFile or else passphrase.
+func loadEncryptionSecret(keyFile, passphrase string) (*secret, error) {
+	switch {
+	case keyFile != "" && passphrase != "":
+		return nil, errors.New("-encryption-key-file and -encryption-passphrase can't be combined")
+	case keyFile != "":
+		b, err := ioutil.ReadFile(expandHome(keyFile))
+		if err != nil {
+			return nil, err
+		}
+		if len(b) < 32 {
+			return nil, fmt.Errorf("%s: a key file needs at least 32 bytes", keyFile)
+		}
+		key := sha256.Sum256(b)
+		return &secret{fileKey: &key}, nil
+	case passphrase != "":
+		return &secret{passphrase: passphrase, keys: make(map[string]*[32]byte)}, nil
+	}
+	return nil, nil
+}
+
+// key returns the key of a key file, which needs no salt, or else the key
+// derived from the passphrase with scrypt and salt.
+func (s *secret) key(salt []byte) (*[32]byte, error) {
+	if s.fileKey != nil {
+		return s.fileKey, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, ok := s.keys[string(salt)]; ok {
+		return key, nil
+	}
+	b, err := scrypt.Key([]byte(s.passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	var key [32]byte
+	copy(key[:], b)
+	s.keys[string(salt)] = &key
+	return &key, nil
+}
+
+// randomSalt returns a new random salt.
+func randomSalt() ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// loadKey sets the key of a pair encrypting or obfuscating, salted with the
+// salt of its remote root.
+func (p *syncPair) loadKey(srv *drive.Service) error {
+	if (!p.Encrypt && !p.Obfuscate) || p.key != nil {
+		return nil
+	}
+	salt, err := p.rootSalt(srv, p.Direction != directionDownload)
+	if err != nil {
+		return fmt.Errorf("pair %s: unable to get the salt of the remote root: %v", p, err)
+	}
+	p.key, err = encryptionSecret.key(salt)
+	return err
+}
+
+// contentKey returns the key of the content of the pair's files, nil if
+// they aren't encrypted.
+func (p *syncPair) contentKey() *[32]byte {
+	if !p.Encrypt {
+		return nil
+	}
+	return p.key
+}
+
+// rootSalt returns the salt kept on the remote root. A root which doesn't
+// exist yet gets a new salt when the upload creates it, and an empty root
+// without a salt gets one if store is set. Roots holding files but no salt
+// were encrypted with legacySalt.
+func (p *syncPair) rootSalt(srv *drive.Service, store bool) ([]byte, error) {
+	folders, ok, err := remoteRootFolders(srv, p.Remote)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		salt, err := randomSalt()
+		p.newSalt = salt
+		return salt, err
+	}
+	root := folders[len(folders)-1]
+	if s := root.AppProperties[propSalt]; s != "" {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	var children *drive.FileList
+	err = retry("Listing "+p.Remote, func() (err error) {
+		children, err = srv.Files.List().Q(fmt.Sprintf("%s in parents", drivefs.QueryQuote(root.Id))).
+			PageSize(1).Fields("files(id)").Context(runCtx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(children.Files) > 0 {
+		return legacySalt, nil
+	}
+	salt, err := randomSalt()
+	if err != nil || !store {
+		return salt, err
+	}
+	err = retry("Setting the salt of "+p.Remote, func() error {
+		_, err := srv.Files.Update(root.Id, &drive.File{AppProperties: map[string]string{propSalt: base64.RawURLEncoding.EncodeToString(salt)}}).
+			Fields("id").Context(runCtx).Do()
+		return err
+	})
+	return salt, err
+}
+
+// chunkNonce returns the nonce of chunk n of a file.
+func chunkNonce(base *[24]byte, n uint64, last bool) *[24]byte {
+	nonce := *base
+	carry := n
+	for i := 0; i < 8; i++ {
+		sum := uint64(nonce[i]) + carry&0xff
+		nonce[i] = byte(sum)
+		carry = carry>>8 + sum>>8
+	}
+	if last {
+		nonce[23] ^= 0x80
+	}
+	return &nonce
+}
+
+// readChunk fills buf from r unless r ends first. Unlike io.ReadFull it
+// tells the end of r from an error such as a download cut short.
+func readChunk(r io.Reader, buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		m, err := r.Read(buf[n:])
+		n += m
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// encryptReader reads r encrypted with key.
+type encryptReader struct {
+	key   *[32]byte
+	r     *bufio.Reader
+	nonce [24]byte
+	n     uint64
+	buf   []byte // sealed and not read yet
+	plain []byte
+	done  bool
+}
+
+func newEncryptReader(r io.Reader, key *[32]byte) (io.Reader, error) {
+	e := &encryptReader{key: key, r: bufio.NewReaderSize(r, cryptChunk), plain: make([]byte, cryptChunk)}
+	if _, err := io.ReadFull(rand.Reader, e.nonce[:]); err != nil {
+		return nil, err
+	}
+	e.buf = append([]byte(cryptMagic), e.nonce[:]...)
+	return e, nil
+}
+
+func (e *encryptReader) Read(p []byte) (int, error) {
+	for len(e.buf) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		n, err := readChunk(e.r, e.plain)
+		if err != nil {
+			return 0, err
+		}
+		// The chunk is the last one when nothing follows it.
+		if n < len(e.plain) {
+			e.done = true
+		} else if _, err := e.r.Peek(1); err == io.EOF {
+			e.done = true
+		} else if err != nil {
+			return 0, err
+		}
+		e.buf = secretbox.Seal(e.buf[:0], e.plain[:n], chunkNonce(&e.nonce, e.n, e.done), e.key)
+		e.n++
+	}
+	n := copy(p, e.buf)
+	e.buf = e.buf[n:]
+	return n, nil
+}
+
+// decryptReader reads the plaintext of the encrypted r.
+type decryptReader struct {
+	key    *[32]byte
+	r      *bufio.Reader
+	nonce  [24]byte
+	n      uint64
+	buf    []byte // opened and not read yet
+	sealed []byte
+	done   bool
+}
+
+func newDecryptReader(r io.Reader, key *[32]byte) (io.Reader, error) {
+	d := &decryptReader{key: key, r: bufio.NewReaderSize(r, cryptChunk+secretbox.Overhead), sealed: make([]byte, cryptChunk+secretbox.Overhead)}
+	header := make([]byte, len(cryptMagic)+len(d.nonce))
+	if n, err := readChunk(d.r, header); err != nil {
+		return nil, err
+	} else if n < len(header) || string(header[:len(cryptMagic)]) != cryptMagic {
+		return nil, errors.New("not an encrypted file")
+	}
+	copy(d.nonce[:], header[len(cryptMagic):])
+	return d, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		n, err := readChunk(d.r, d.sealed)
+		if err != nil {
+			return 0, err
+		}
+		if n < len(d.sealed) {
+			d.done = true
+		} else if _, err := d.r.Peek(1); err == io.EOF {
+			d.done = true
+		} else if err != nil {
+			return 0, err
+		}
+		var ok bool
+		d.buf, ok = secretbox.Open(d.buf[:0], d.sealed[:n], chunkNonce(&d.nonce, d.n, d.done), d.key)
+		if !ok {
+			return 0, errors.New("unable to decrypt: wrong key, or the file is damaged or cut off")
+		}
+		d.n++
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// plainView returns the remote file as the pair compares it: a file
+// compressed or chunked, or encrypted with the pair encrypting, with the
+// checksum and size of its plaintext.
+func (p *syncPair) plainView(f drive.File) drive.File {
+	f.Md5Checksum = remoteChecksum(f, p.hash)
+	if p.Encrypt && f.AppProperties[sealedChecksumProp(p.hash)] != "" {
+		if sum, size, err := p.openPlain(f); err == nil {
+			f.Md5Checksum, f.Size = sum, size
+		}
+		return f
+	}
+	// Files uploaded with another -hash lack the checksum and keep that of
+	// their content, which matches no local file.
+	if f.AppProperties[plainChecksumProp(p.hash)] == "" || (!p.Encrypt && f.AppProperties[propCompression] == "" && f.AppProperties[propChunks] == "") {
+		return f
+	}
+	f.Md5Checksum = f.AppProperties[plainChecksumProp(p.hash)]
+	f.Size, _ = strconv.ParseInt(f.AppProperties[propPlainSize], 10, 64)
+	return f
+}
+
+// sealPlain sets the appProperties keeping the checksum and size of the
+// plaintext, sealed with the key of the pair.
+func (p *syncPair) sealPlain(props map[string]string, checksum string, size int64) error {
+	sum, err := hex.DecodeString(checksum)
+	if err != nil {
+		return err
+	}
+	for prop, value := range map[string][]byte{sealedChecksumProp(p.hash): sum, propSealedSize: []byte(strconv.FormatInt(size, 10))} {
+		var nonce [24]byte
+		if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+			return err
+		}
+		props[prop] = base64.RawURLEncoding.EncodeToString(secretbox.Seal(nonce[:], value, &nonce, subKey(p.key, "properties")))
+	}
+	return nil
+}
+
+// openPlain returns the checksum and size of the plaintext sealed in the
+// appProperties of the remote file.
+func (p *syncPair) openPlain(f drive.File) (string, int64, error) {
+	var values [2][]byte
+	for i, prop := range []string{sealedChecksumProp(p.hash), propSealedSize} {
+		b, err := base64.RawURLEncoding.DecodeString(f.AppProperties[prop])
+		if err != nil || len(b) < 24+secretbox.Overhead {
+			return "", 0, fmt.Errorf("%s: not a sealed property", prop)
+		}
+		var nonce [24]byte
+		copy(nonce[:], b)
+		var ok bool
+		if values[i], ok = secretbox.Open(nil, b[24:], &nonce, subKey(p.key, "properties")); !ok {
+			return "", 0, fmt.Errorf("%s: wrong key, or the property is damaged", prop)
+		}
+	}
+	size, err := strconv.ParseInt(string(values[1]), 10, 64)
+	return hex.EncodeToString(values[0]), size, err
+}
+
+// obfuscatedFolderPrefix starts the ids of the folders plainTree makes up
+// for the paths of obfuscated files. They exist only in the state.
+const obfuscatedFolderPrefix = "obfuscated:"
+
+// nameEncoding encodes obfuscated names, in lower case to survive case
+// insensitive comparisons.
+var nameEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// subKey returns the key derived from key for purpose, so names,
+// properties and contents are never sealed with the same key.
+func subKey(key *[32]byte, purpose string) *[32]byte {
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte(purpose))
+	var sub [32]byte
+	copy(sub[:], mac.Sum(nil))
+	return &sub
+}
+
+// encryptName returns the obfuscated name of the relative path rel. The
+// nonce is derived from rel, so a path always gets the same name.
+func encryptName(key *[32]byte, rel string) string {
+	mac := hmac.New(sha256.New, subKey(key, "name nonce")[:])
+	mac.Write([]byte(rel))
+	var nonce [24]byte
+	copy(nonce[:], mac.Sum(nil))
+	return nameEncoding.EncodeToString(secretbox.Seal(nonce[:], []byte(rel), &nonce, subKey(key, "name")))
+}
+
+// decryptName returns the relative path an obfuscated name stands for.
+func decryptName(key *[32]byte, name string) (string, error) {
+	b, err := nameEncoding.DecodeString(name)
+	if err != nil || len(b) < 24+secretbox.Overhead {
+		return "", errors.New("not an obfuscated name")
+	}
+	var nonce [24]byte
+	copy(nonce[:], b)
+	rel, ok := secretbox.Open(nil, b[24:], &nonce, subKey(key, "name"))
+	if !ok {
+		return "", errors.New("unable to decrypt the name: wrong key, or not an obfuscated name")
+	}
+	return string(rel), nil
+}
+
+// obfuscationKeys returns the keys of the obfuscated pairs of cfg, salted
+// like their remote roots.
+func obfuscationKeys(srv *drive.Service, cfg *config, flags *pairFlags) ([]*[32]byte, error) {
+	pairs, err := loadPairs(cfg, nil, flags)
+	if err != nil {
+		return nil, err
+	}
+	var keys []*[32]byte
+	for i := range pairs {
+		if !pairs[i].Obfuscate {
+			continue
+		}
+		salt, err := pairs[i].rootSalt(srv, false)
+		if err != nil {
+			return nil, fmt.Errorf("pair %s: %v", &pairs[i], err)
+		}
+		key, err := encryptionSecret.key(salt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no pair obfuscates")
+	}
+	return keys, nil
+}
+
+// decryptNameWith returns the relative path the obfuscated name stands for
+// with the first of keys it decrypts with.
+func decryptNameWith(keys []*[32]byte, name string) (string, error) {
+	var err error
+	for _, key := range keys {
+		var rel string
+		if rel, err = decryptName(key, name); err == nil {
+			return rel, nil
+		}
+	}
+	return "", err
+}
+
+// plainTree returns the remote files as the pair syncs them: with the
+// checksum and size of their plaintext, and for obfuscated pairs with the
+// names and folders of their decrypted paths. The folders are made up,
+// with ids starting with obfuscatedFolderPrefix, and kept in the state as
+// the mapping of paths to remote files. Chunks are left out, their ids
+// added to the files they make up.
+func (p *syncPair) plainTree(files []drive.File) []drive.File {
+	plain := make([]drive.File, 0, len(files))
+	folders := make(map[string]bool) // key: id of a made up folder
+	for _, f := range withChunks(files) {
+		f = p.plainView(f)
+		if p.Obfuscate && f.MimeType != folderMimeType && len(f.Parents) == 1 {
+			if rel, err := decryptName(p.key, f.Name); err == nil {
+				root, parent := f.Parents[0], f.Parents[0]
+				if dir := path.Dir(rel); dir != "." {
+					names := strings.Split(dir, "/")
+					for i, name := range names {
+						id := obfuscatedFolderPrefix + root + "/" + strings.Join(names[:i+1], "/")
+						if !folders[id] {
+							folders[id] = true
+							plain = append(plain, drive.File{Id: id, Name: name, MimeType: folderMimeType, Parents: []string{parent}, OwnedByMe: true})
+						}
+						parent = id
+					}
+				}
+				f.Name, f.Parents = path.Base(rel), []string{parent}
+			}
+		}
+		plain = append(plain, f)
+	}
+	return plain
+}
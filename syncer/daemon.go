@@ -0,0 +1,81 @@
+package syncer
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// runDaemon syncs the pairs every opts.interval until the process is
+// killed. Each sync lists the remote side again, and failures are reported
+// without stopping the daemon. ctl pauses it, starts syncs early and
+// reloads the config; relock locks the local roots of reloaded pairs. The
+// dashboard, if served, shows ctl.
+func runDaemon(srv *drive.Service, pairs []syncPair, openState func(*syncPair) stateStore, q string, opts *runOptions, summaryJSON string, ctl *daemonControl, relock func([]syncPair) error) {
+	if opts.metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", serveMetrics)
+		health := &healthCheck{srv: srv, maxAge: opts.readyMaxAge}
+		if health.maxAge == 0 {
+			health.maxAge = 3 * opts.interval
+		}
+		mux.HandleFunc("/healthz", health.serveHealthz)
+		mux.HandleFunc("/readyz", health.serveReadyz)
+		go func() {
+			fatal(http.ListenAndServe(opts.metricsAddr, mux))
+		}()
+		infof("Serving metrics on %s/metrics, probes on /healthz and /readyz\n", opts.metricsAddr)
+	}
+	ctl.setPairs(pairs)
+	if opts.guiAddr != "" {
+		serveGUI(opts.guiAddr, srv, ctl)
+	}
+	for {
+		if transferPause.wait(runCtx); stopping() {
+			return
+		}
+		if cfg, reloaded := ctl.takeReloaded(); cfg != nil {
+			if err := relock(reloaded); err != nil {
+				log.Printf("Unable to apply the reloaded config: %v", err)
+			} else {
+				pairs = reloaded
+				ctl.setPairs(pairs)
+				cfg.applyExportFormats()
+				infof("Applied the reloaded config, %d pairs\n", len(pairs))
+			}
+		}
+		ctl.setSyncing(true, time.Time{})
+		daemonMetrics.startRun()
+		runReport.reset()
+		history.start("daemon")
+		// A pair which couldn't be synced is logged and counted as
+		// failed, and synced again next time.
+		failed, err := syncAll(srv, pairs, openState, q, opts)
+		stats.count(&stats.Errors, failed)
+		stats.print(summaryJSON)
+		runReport.write(stats)
+		notifications.finished(stats, failed, false)
+		if !stopping() {
+			hooks.finished(stats, failed)
+		}
+		daemonMetrics.record(stats, failed == 0)
+		history.finish(stats, failed, runExitCode(stats, failed, err))
+		// Later syncs need the remote changes made in the meantime.
+		opts.relist = true
+		if stopping() {
+			return
+		}
+		next := time.Now().Add(opts.interval)
+		ctl.setSyncing(false, next)
+		infof("Next sync at %s\n", next.Format("15:04:05"))
+		select {
+		case <-time.After(opts.interval):
+		case <-ctl.rescan:
+			infof("Sync requested\n")
+		case <-runCtx.Done():
+			return
+		}
+	}
+}
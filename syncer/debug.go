@@ -0,0 +1,51 @@
+package syncer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// started is when the process started, shown on the status page.
+var started = time.Now()
+
+// serveDebug serves the pprof profiles at /debug/pprof/ and a status page
+// of the running process at / on addr.
+func serveDebug(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/", serveStatus)
+	go func() {
+		fatal(http.ListenAndServe(addr, mux))
+	}()
+	infof("Serving debug information on %s\n", addr)
+}
+
+func serveStatus(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "Up %s, %d goroutines\n", time.Since(started).Round(time.Second), runtime.NumGoroutine())
+	fmt.Fprintf(w, "Memory: %s in use, %s from the OS, %d GCs\n",
+		formatBytes(int64(mem.HeapAlloc)), formatBytes(int64(mem.Sys)), mem.NumGC)
+	fmt.Fprintf(w, "Drive requests: %d, %d retried\n", atomic.LoadInt64(&apiCalls), atomic.LoadInt64(&apiRetries))
+	fmt.Fprintf(w, "Transfers queued: %d\n", atomic.LoadInt64(&transfersQueued))
+	daemonMetrics.mu.Lock()
+	s := stats
+	daemonMetrics.mu.Unlock()
+	fmt.Fprintf(w, "Current run: started %s, %d checked, %d downloaded, %d uploaded, %s transferred, %d errors\n",
+		s.start.Format(time.RFC3339), atomic.LoadInt64(&s.Checked), atomic.LoadInt64(&s.Downloaded),
+		atomic.LoadInt64(&s.Uploaded), formatBytes(atomic.LoadInt64(&s.Bytes)), atomic.LoadInt64(&s.Errors))
+	fmt.Fprintln(w, "\nProfiles: /debug/pprof/")
+}
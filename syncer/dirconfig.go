@@ -0,0 +1,163 @@
+package syncer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+const dirConfigFileName = ".drive.yaml"
+
+// dirConfig is a .drive.yaml file, overriding the settings of the pair for
+// the files below its directory, e.g. to keep secrets/ out or export the
+// Docs in contracts/ as PDF.
+type dirConfig struct {
+	// Filter and Exclude are like those of the pair, the patterns
+	// relative to the directory. They apply like a .driveignore file,
+	// overriding those of the directories above; what the pair's filters
+	// exclude stays out.
+	Filter  []string `json:"filter"`
+	Exclude []string `json:"exclude"`
+	// Native replaces the pair's native document policy.
+	Native string `json:"native"`
+	// ExportFormats are merged into those of the config file.
+	ExportFormats map[string]exportFormat `json:"exportFormats"`
+
+	base string // the directory, relative to the root
+}
+
+// readDirConfig reads the .drive.yaml file of the directory base, if any.
+// Its filters join the rules, which apply like those of .driveignore files;
+// its other settings are kept in l.dirs.
+func (l *ignoreList) readDirConfig(file string, base string) error {
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		if inUnreadableDir(file) {
+			return nil
+		}
+		return err
+	}
+	if b, err = yaml.YAMLToJSON(b); err != nil {
+		return configErrorf("Unable to parse %s: %v", file, err)
+	}
+	var keys map[string]json.RawMessage
+	if err = json.Unmarshal(b, &keys); err != nil {
+		return configErrorf("json.Unmarshal(%s) failed: %v", file, err)
+	}
+	known := jsonKeys(dirConfig{})
+	for key := range keys {
+		if !known[key] {
+			return configErrorf("%s: unknown setting %q", file, key)
+		}
+	}
+	c := dirConfig{base: base}
+	if err = json.Unmarshal(b, &c); err != nil {
+		return configErrorf("json.Unmarshal(%s) failed: %v", file, err)
+	}
+
+	// In the list the last matching rule wins, whereas the first one does
+	// in a filter, checked before Exclude.
+	var rules []ignoreRule
+	for _, pattern := range c.Exclude {
+		rule, ok := parseIgnoreRule(pattern, base)
+		if !ok {
+			return configErrorf("%s: invalid exclude pattern %q", file, pattern)
+		}
+		rules = append(rules, rule)
+	}
+	for i := len(c.Filter) - 1; i >= 0; i-- {
+		line := c.Filter[i]
+		if !strings.HasPrefix(line, "+ ") && !strings.HasPrefix(line, "- ") {
+			return configErrorf("%s: filter rule %q must start with \"+ \" or \"- \"", file, line)
+		}
+		rule, ok := parseIgnoreRule(strings.TrimSpace(line[2:]), base)
+		if !ok {
+			return configErrorf("%s: invalid filter rule %q", file, line)
+		}
+		rule.negate = line[0] == '+'
+		rules = append(rules, rule)
+	}
+	l.rules = append(l.rules, rules...)
+
+	if c.Native != "" && !validNativePolicy(c.Native) {
+		return configErrorf("%s: unknown native document policy %q", file, c.Native)
+	}
+	for mimeType, format := range c.ExportFormats {
+		if format.MimeType == "" || format.Extension == "" {
+			return configErrorf("%s: export format for %s needs mimeType and extension", file, mimeType)
+		}
+		if !strings.HasPrefix(format.Extension, ".") {
+			format.Extension = "." + format.Extension
+			c.ExportFormats[mimeType] = format
+		}
+	}
+	if c.Native != "" || len(c.ExportFormats) > 0 {
+		l.dirs = append(l.dirs, c)
+	}
+	return nil
+}
+
+// configs returns the .drive.yaml settings read so far, once those of the
+// slash separated directory dir and of the ones above it are.
+func (l *ignoreList) configs(dir string) []dirConfig {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.loadDir(dirKey(dir), filepath.FromSlash(dirKey(dir)), nil)
+	return l.dirs[:len(l.dirs):len(l.dirs)]
+}
+
+// nativeFor returns the native document policy and the export formats of
+// the document at the slash separated path rel: those of the pair and the
+// config file, overridden by the .drive.yaml files above rel, the deeper
+// ones last. SheetsCSV and DocsMarkdown set the formats of spreadsheets and
+// documents unless these say otherwise.
+func (p *syncPair) nativeFor(rel string) (string, map[string]exportFormat) {
+	policy, formats := p.Native, exportFormats
+	if p.SheetsCSV || p.DocsMarkdown {
+		formats = make(map[string]exportFormat, len(exportFormats))
+		for mimeType, format := range exportFormats {
+			formats[mimeType] = format
+		}
+		if p.SheetsCSV {
+			formats[spreadsheetMimeType] = sheetsCSV
+		}
+		if p.DocsMarkdown {
+			formats[documentMimeType] = docsMarkdown
+		}
+	}
+	if p.ignore == nil {
+		return policy, formats
+	}
+	dir := path.Dir(pathKey(rel))
+	merged := false
+	for _, c := range p.ignore.configs(dir) {
+		if c.base != "" && dir != c.base && !strings.HasPrefix(dir, c.base+"/") {
+			continue
+		}
+		if c.Native != "" {
+			policy = c.Native
+		}
+		if len(c.ExportFormats) > 0 {
+			if !merged {
+				inherited := formats
+				formats = make(map[string]exportFormat, len(inherited))
+				for mimeType, format := range inherited {
+					formats[mimeType] = format
+				}
+				merged = true
+			}
+			for mimeType, format := range c.ExportFormats {
+				formats[mimeType] = format
+			}
+		}
+	}
+	return policy, formats
+}
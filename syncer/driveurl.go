@@ -0,0 +1,93 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// driveId matches a bare Drive file id.
+var driveId = regexp.MustCompile(`^[A-Za-z0-9_-]{25,}$`)
+
+// driveURLId returns the id of the file of a drive.google.com or
+// docs.google.com URL, such as those of the web UI: .../folders/ID,
+// .../file/d/ID/view, .../document/d/ID/edit or .../open?id=ID.
+func driveURLId(s string) (string, bool) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") ||
+		(u.Host != "drive.google.com" && u.Host != "docs.google.com") {
+		return "", false
+	}
+	if id := u.Query().Get("id"); id != "" {
+		return id, true
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i, part := range parts {
+		if (part == "folders" || part == "d") && i+1 < len(parts) && parts[i+1] != "" {
+			return parts[i+1], true
+		}
+	}
+	return "", false
+}
+
+// resolveRemote returns the remote path arg stands for, cleaned like
+// cleanRemote: arg itself, or the path in My Drive of the file of a Drive
+// URL or id. A bare id no file has is taken as a path.
+func resolveRemote(srv *drive.Service, arg string) (string, error) {
+	id, isURL := driveURLId(arg)
+	if !isURL {
+		if !driveId.MatchString(arg) {
+			return cleanRemote(arg), nil
+		}
+		id = arg
+	}
+	p, err := remotePathOfId(srv, id)
+	var gerr *googleapi.Error
+	if !isURL && errors.As(err, &gerr) && gerr.Code == http.StatusNotFound {
+		return cleanRemote(arg), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", arg, err)
+	}
+	return p, nil
+}
+
+// remotePathOfId returns the path in My Drive of the file id, following
+// its parents up to the root.
+func remotePathOfId(srv *drive.Service, id string) (string, error) {
+	var root *drive.File
+	err := retry("Getting the root folder", func() (err error) {
+		root, err = srv.Files.Get("root").Fields("id").Context(runCtx).Do()
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for id != root.Id {
+		var f *drive.File
+		err := retry("Getting "+id, func() (err error) {
+			f, err = srv.Files.Get(id).Fields("id, name, parents, trashed").Context(runCtx).Do()
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		if f.Trashed {
+			return "", fmt.Errorf("%s is in the trash", f.Name)
+		}
+		if len(f.Parents) == 0 {
+			return "", fmt.Errorf("%s is not in My Drive", f.Name)
+		}
+		names = append([]string{f.Name}, names...)
+		id = f.Parents[0]
+	}
+	return cleanRemote(path.Join(names...)), nil
+}
@@ -0,0 +1,299 @@
+package syncer_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hiroshi/googledriveclient/internal/drivetest"
+	"github.com/hiroshi/googledriveclient/syncer"
+)
+
+const folderMimeType = "application/vnd.google-apps.folder"
+
+// modTime is the modification time of the files the tests make.
+var modTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// startDrive serves a fake Drive with the folder /proj holding a.txt and
+// sub/b.txt.
+func startDrive(t *testing.T) (*drivetest.Drive, string) {
+	d := drivetest.New()
+	proj := d.Add(drivetest.RootId, "proj", folderMimeType, nil, modTime)
+	d.Add(proj, "a.txt", "text/plain", []byte("alpha\n"), modTime)
+	sub := d.Add(proj, "sub", folderMimeType, nil, modTime)
+	d.Add(sub, "b.txt", "text/plain", []byte("beta\n"), modTime)
+	s := httptest.NewServer(d)
+	t.Cleanup(s.Close)
+	return d, s.URL
+}
+
+// newEngine returns an Engine syncing with the Drive at endpoint, its JSON
+// state kept in a directory of its own.
+func newEngine(t *testing.T, endpoint string) *syncer.Engine {
+	return newEngineState(t, endpoint, "json")
+}
+
+func newEngineState(t *testing.T, endpoint, backend string) *syncer.Engine {
+	t.Chdir(t.TempDir())
+	e, err := syncer.New(syncer.Options{Endpoint: endpoint, State: backend, Quiet: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	return e
+}
+
+func syncOnce(t *testing.T, e *syncer.Engine, pair syncer.Pair) *syncer.Summary {
+	t.Helper()
+	summary, err := e.Sync([]syncer.Pair{pair})
+	if err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if summary.Errors != 0 {
+		t.Fatalf("sync: %d errors", summary.Errors)
+	}
+	return summary
+}
+
+func checkFile(t *testing.T, path, content string) {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != content {
+		t.Errorf("%s: got %q, want %q", path, b, content)
+	}
+}
+
+// remoteFile returns the file named name on the Drive which isn't trashed.
+func remoteFile(d *drivetest.Drive, name string) (string, bool) {
+	for id, f := range d.Files() {
+		if f.Name == name && !f.Trashed {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+func TestDownload(t *testing.T) {
+	_, endpoint := startDrive(t)
+	e := newEngine(t, endpoint)
+	local := t.TempDir()
+	pair := syncer.Pair{Local: local, Remote: "/proj"}
+
+	summary := syncOnce(t, e, pair)
+	if summary.Downloaded != 2 {
+		t.Errorf("downloaded %d files, want 2", summary.Downloaded)
+	}
+	checkFile(t, filepath.Join(local, "a.txt"), "alpha\n")
+	checkFile(t, filepath.Join(local, "sub", "b.txt"), "beta\n")
+	if info, err := os.Stat(filepath.Join(local, "a.txt")); err != nil {
+		t.Fatal(err)
+	} else if !info.ModTime().Equal(modTime) {
+		t.Errorf("a.txt modified %v, want %v", info.ModTime(), modTime)
+	}
+
+	summary = syncOnce(t, e, pair)
+	if summary.Downloaded != 0 || summary.Skipped != 2 {
+		t.Errorf("second sync downloaded %d and skipped %d files, want 0 and 2", summary.Downloaded, summary.Skipped)
+	}
+}
+
+func TestSQLiteState(t *testing.T) {
+	_, endpoint := startDrive(t)
+	e := newEngineState(t, endpoint, "sqlite")
+	local := t.TempDir()
+	pair := syncer.Pair{Local: local, Remote: "/proj"}
+
+	syncOnce(t, e, pair)
+	if err := os.Remove(filepath.Join(local, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	summary := syncOnce(t, e, pair)
+	if summary.Downloaded != 1 || summary.Skipped != 1 {
+		t.Errorf("second sync downloaded %d and skipped %d files, want 1 and 1", summary.Downloaded, summary.Skipped)
+	}
+	checkFile(t, filepath.Join(local, "a.txt"), "alpha\n")
+}
+
+func TestEnginesKeepTheirHash(t *testing.T) {
+	_, endpoint := startDrive(t)
+	md5 := newEngineState(t, endpoint, "json")
+	sha, err := syncer.New(syncer.Options{Endpoint: endpoint, State: "json", Hash: "sha256", Quiet: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sha.Close)
+	md5Pair := syncer.Pair{Name: "md5", Local: t.TempDir(), Remote: "/proj"}
+	shaPair := syncer.Pair{Name: "sha256", Local: t.TempDir(), Remote: "/proj"}
+
+	syncOnce(t, md5, md5Pair)
+	syncOnce(t, sha, shaPair)
+	summary := syncOnce(t, md5, md5Pair)
+	if summary.Downloaded != 0 || summary.Skipped != 2 {
+		t.Errorf("second sync downloaded %d and skipped %d files, want 0 and 2", summary.Downloaded, summary.Skipped)
+	}
+	for file, want := range map[string]bool{"files-md5.json": false, "files-sha256.json": true} {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := bytes.Contains(b, []byte(`"Hash":"sha256"`)); got != want {
+			t.Errorf("%s has sha256 checksums: %v, want %v", file, got, want)
+		}
+	}
+}
+
+func TestDownloadRetries(t *testing.T) {
+	d, endpoint := startDrive(t)
+	d.PageSize = 1
+	d.FailNext(http.StatusServiceUnavailable)
+	e := newEngine(t, endpoint)
+	local := t.TempDir()
+
+	syncOnce(t, e, syncer.Pair{Local: local, Remote: "/proj"})
+	checkFile(t, filepath.Join(local, "a.txt"), "alpha\n")
+	checkFile(t, filepath.Join(local, "sub", "b.txt"), "beta\n")
+}
+
+func TestUploadDelete(t *testing.T) {
+	d, endpoint := startDrive(t)
+	e := newEngine(t, endpoint)
+	local := t.TempDir()
+	if err := os.WriteFile(filepath.Join(local, "c.txt"), []byte("gamma\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pair := syncer.Pair{Local: local, Remote: "/proj", Direction: "upload", Delete: true}
+
+	summary := syncOnce(t, e, pair)
+	if summary.Uploaded != 1 {
+		t.Errorf("uploaded %d files, want 1", summary.Uploaded)
+	}
+	id, ok := remoteFile(d, "c.txt")
+	if !ok {
+		t.Fatal("c.txt wasn't uploaded")
+	}
+	if got := d.Content(id); !bytes.Equal(got, []byte("gamma\n")) {
+		t.Errorf("c.txt holds %q on Drive", got)
+	}
+	// The files missing locally went to the trash.
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if _, ok := remoteFile(d, name); ok {
+			t.Errorf("%s wasn't trashed", name)
+		}
+	}
+
+	if err := os.Remove(filepath.Join(local, "c.txt")); err != nil {
+		t.Fatal(err)
+	}
+	syncOnce(t, e, pair)
+	if _, ok := remoteFile(d, "c.txt"); ok {
+		t.Error("c.txt wasn't trashed after it was deleted locally")
+	}
+}
+
+func TestUnreadableDirIsKept(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root reads any directory")
+	}
+	d, endpoint := startDrive(t)
+	e := newEngine(t, endpoint)
+	local := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(local, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(local, "a.txt"), []byte("alpha\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(local, "sub"), 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(local, "sub"), 0755)
+
+	summary, err := e.Sync([]syncer.Pair{{Local: local, Remote: "/proj", Direction: "upload", Delete: true}})
+	if err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if summary.Errors != 1 {
+		t.Errorf("%d errors, want 1 for the unreadable directory", summary.Errors)
+	}
+	if _, ok := remoteFile(d, "b.txt"); !ok {
+		t.Error("b.txt was trashed though its local directory couldn't be read")
+	}
+}
+
+func TestMissingRoot(t *testing.T) {
+	_, endpoint := startDrive(t)
+	e := newEngine(t, endpoint)
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	if _, err := e.Sync([]syncer.Pair{{Local: missing, Remote: "/proj"}}); err == nil {
+		t.Fatal("a pair whose local root is missing was synced")
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("the missing root was created: %v", err)
+	}
+}
+
+func TestSameNames(t *testing.T) {
+	_, endpoint := startDrive(t)
+	e := newEngine(t, endpoint)
+
+	_, err := e.Sync([]syncer.Pair{
+		{Name: "docs", Local: t.TempDir(), Remote: "/proj"},
+		{Name: "docs", Local: t.TempDir(), Remote: "/proj/sub"},
+	})
+	if err == nil {
+		t.Fatal("pairs with the same name were synced")
+	}
+}
+
+func TestBadDirConfig(t *testing.T) {
+	d, endpoint := startDrive(t)
+	e := newEngine(t, endpoint)
+	local := t.TempDir()
+	if err := os.WriteFile(filepath.Join(local, ".drive.yaml"), []byte("unknown: 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.Sync([]syncer.Pair{{Local: local, Remote: "/proj", Direction: "upload", Delete: true}}); err == nil {
+		t.Fatal("a pair with an invalid .drive.yaml was synced")
+	}
+	if _, ok := remoteFile(d, "a.txt"); !ok {
+		t.Error("a.txt was trashed though the rules couldn't be read")
+	}
+}
+
+func TestBadSidecar(t *testing.T) {
+	d, endpoint := startDrive(t)
+	e := newEngine(t, endpoint)
+	local := t.TempDir()
+	for name, content := range map[string]string{
+		"c.txt":                 "gamma\n",
+		"c.txt.drive-meta.json": "{",
+		"d.txt":                 "delta\n",
+	} {
+		if err := os.WriteFile(filepath.Join(local, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	summary, err := e.Sync([]syncer.Pair{{Local: local, Remote: "/proj", Direction: "upload"}})
+	if err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if summary.Errors != 1 {
+		t.Errorf("%d errors, want 1 for the invalid sidecar file", summary.Errors)
+	}
+	if _, ok := remoteFile(d, "c.txt"); ok {
+		t.Error("c.txt was uploaded without its metadata")
+	}
+	if _, ok := remoteFile(d, "d.txt"); !ok {
+		t.Error("d.txt wasn't uploaded")
+	}
+}
@@ -0,0 +1,174 @@
+package syncer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hiroshi/googledriveclient/auth"
+	"github.com/hiroshi/googledriveclient/state"
+	"google.golang.org/api/drive/v3"
+)
+
+// Pair is a sync pair, as in the pairs of the config file.
+type Pair = syncPair
+
+// Summary counts what a sync did, over all pairs.
+type Summary = runStats
+
+// Options are the settings of an Engine, those of the flags of the same
+// names.
+type Options struct {
+	// Endpoint is the base URL of a stand-in for the Drive API, like
+	// -drive-endpoint. Empty means Drive, authorized with
+	// ClientSecretFile and TokenFile.
+	Endpoint         string
+	ClientSecretFile string // default client_secret.json
+	TokenFile        string // default ~/.credentials/drive-go-quickstart.json
+	// State is the state backend, "sqlite" (default), "json" or
+	// "json.gz", kept in the working directory.
+	State string
+	// Checkers and Transfers default to 4.
+	Checkers  int
+	Transfers int
+	// Hash is the checksum files are compared by, "md5" (default) or
+	// "sha256".
+	Hash string
+	// LowMemory syncs download pairs folder by folder where possible.
+	LowMemory bool
+	// Quiet leaves out the output but for errors.
+	Quiet bool
+}
+
+// Engine syncs pairs from another program. Each Engine has its own
+// settings, and several may be used at once, their syncs taking turns:
+// the summary and the output are the process's. It never exits the
+// process: errors are returned.
+type Engine struct {
+	srv        *drive.Service
+	openState  func(*syncPair) stateStore
+	closeState func()
+	opts       runOptions
+	hash       string
+	verbosity  int
+}
+
+// engineRuns makes the syncs of the Engines take turns.
+var engineRuns sync.Mutex
+
+// New connects to Drive and opens the state.
+func New(o Options) (*Engine, error) {
+	var err error
+	if o.Hash == "" {
+		o.Hash = hashMd5
+	}
+	if o.Hash, err = parseHash(o.Hash); err != nil {
+		return nil, err
+	}
+	if o.State == "" {
+		o.State = state.SQLite
+	}
+	eng := &Engine{hash: o.Hash, verbosity: normal, opts: runOptions{
+		checkers:    o.Checkers,
+		transfers:   o.Transfers,
+		lowMemory:   o.LowMemory,
+		confirmOver: -1,
+		quotaCheck:  quotaWarn,
+	}}
+	if o.Quiet {
+		eng.verbosity = quiet
+	}
+	if eng.opts.checkers < 1 {
+		eng.opts.checkers = 4
+	}
+	if eng.opts.transfers < 1 {
+		eng.opts.transfers = 4
+	}
+	eng.srv, err = newDriveService(&httpOptions{
+		connectTimeout:  30 * time.Second,
+		responseTimeout: 5 * time.Minute,
+		idleConns:       16,
+		idleTimeout:     90 * time.Second,
+		keepAlive:       30 * time.Second,
+		endpoint:        o.Endpoint,
+		auth:            auth.Config{ClientSecretFile: o.ClientSecretFile, TokenFile: o.TokenFile},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if eng.openState, eng.closeState, err = openStates(o.State); err != nil {
+		return nil, err
+	}
+	return eng, nil
+}
+
+// LoadConfig returns the pairs of a YAML or JSON config file.
+func LoadConfig(file string) ([]Pair, error) {
+	c, err := parseConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	return c.Pairs, nil
+}
+
+// Sync syncs every pair once, never asking before deleting or overwriting
+// files. The transfers which failed are counted in the Errors of the
+// summary, and retried by the next sync. A pair whose sync stopped at an
+// error counts as one too, and the first such error is returned.
+func (e *Engine) Sync(pairs []Pair) (*Summary, error) {
+	var summary *Summary
+	err := e.do(pairs, func(pairs []syncPair) error {
+		run := daemonMetrics.startRun()
+		failed, err := syncAll(e.srv, pairs, e.openState, "", &e.opts)
+		run.count(&run.Errors, failed)
+		summary = run.snapshot()
+		summary.Elapsed = time.Since(run.start).Seconds()
+		return err
+	})
+	return summary, err
+}
+
+// Check compares the pairs with their remote folders without changing
+// anything, and returns the number of differences.
+func (e *Engine) Check(pairs []Pair) (int, error) {
+	diffs := 0
+	err := e.do(pairs, func(pairs []syncPair) (err error) {
+		diffs, err = checkAll(e.srv, pairs, "", &e.opts)
+		return err
+	})
+	return diffs, err
+}
+
+// Close closes the state.
+func (e *Engine) Close() {
+	engineRuns.Lock()
+	defer engineRuns.Unlock()
+	e.closeState()
+}
+
+// do sets up copies of the pairs with the settings of the Engine, locks
+// them and runs f on them.
+func (e *Engine) do(pairs []Pair, f func([]syncPair) error) error {
+	engineRuns.Lock()
+	defer engineRuns.Unlock()
+	defer func(v int) { verbosity = v }(verbosity)
+	verbosity = e.verbosity
+	pairs = append([]Pair(nil), pairs...)
+	if err := checkNames(pairs); err != nil {
+		return err
+	}
+	for i := range pairs {
+		if err := pairs[i].setup(&pairFlags{hash: e.hash}); err != nil {
+			return err
+		}
+		if (pairs[i].Encrypt || pairs[i].Obfuscate) && encryptionSecret == nil {
+			return fmt.Errorf("pair %s: Encrypt and Obfuscate need an encryption key, which an Engine has none of", &pairs[i])
+		}
+	}
+	unlock, err := lockRun(pairs)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return f(pairs)
+}
@@ -0,0 +1,138 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+// Exit codes, for scripts and monitoring.
+const (
+	exitInSync      = 0   // nothing needed to change
+	exitChanged     = 1   // files were transferred or deleted
+	exitPartial     = 2   // some transfers failed, the others were done
+	exitAuth        = 3   // the authorization failed or was revoked
+	exitConfig      = 4   // invalid flags, settings or config file
+	exitError       = 5   // any other error which stopped the run
+	exitInterrupted = 130 // stopped by a signal, the next run continues
+)
+
+var (
+	atExitMu sync.Mutex
+	atExitFn []func()
+)
+
+// atExit registers f to run before exiting, in reverse order, which defers
+// in main don't do when exiting through exit or fatalf.
+func atExit(f func()) {
+	atExitMu.Lock()
+	defer atExitMu.Unlock()
+	atExitFn = append(atExitFn, f)
+}
+
+// exit runs the functions registered with atExit and exits with code.
+func exit(code int) {
+	atExitMu.Lock()
+	fns := atExitFn
+	atExitFn = nil
+	atExitMu.Unlock()
+	for i := len(fns) - 1; i >= 0; i-- {
+		fns[i]()
+	}
+	os.Exit(code)
+}
+
+// exitf logs like log.Fatalf and exits with code.
+func exitf(code int, format string, v ...interface{}) {
+	message := fmt.Sprintf(format, v...)
+	log.Output(2, message)
+	exit(code)
+}
+
+// fatalf replaces log.Fatalf, whose exit code 1 means a successful run
+// here. It exits with exitAuth if an argument is an authorization error,
+// with exitError otherwise.
+func fatalf(format string, v ...interface{}) {
+	message := fmt.Sprintf(format, v...)
+	log.Output(2, message)
+	code := errorExitCode(v)
+	history.fatal(message, code)
+	notifications.fatal(message)
+	hooks.fatal(message)
+	exit(code)
+}
+
+// fatal replaces log.Fatal like fatalf.
+func fatal(v ...interface{}) {
+	message := fmt.Sprint(v...)
+	log.Output(2, message)
+	code := errorExitCode(v)
+	history.fatal(message, code)
+	notifications.fatal(message)
+	hooks.fatal(message)
+	exit(code)
+}
+
+func errorExitCode(v []interface{}) int {
+	for _, a := range v {
+		err, ok := a.(error)
+		if !ok {
+			continue
+		}
+		if isAuthError(err) {
+			return exitAuth
+		}
+		var cerr *configError
+		if errors.As(err, &cerr) {
+			return exitConfig
+		}
+	}
+	return exitError
+}
+
+// configError is an invalid setting found during a sync, in a .drive.yaml
+// or sidecar file, which exits with exitConfig.
+type configError struct {
+	message string
+}
+
+func (e *configError) Error() string {
+	return e.message
+}
+
+func configErrorf(format string, v ...interface{}) error {
+	return &configError{message: fmt.Sprintf(format, v...)}
+}
+
+// isAuthError reports whether err comes from a refused or revoked
+// authorization.
+func isAuthError(err error) bool {
+	var rerr *oauth2.RetrieveError
+	if errors.As(err, &rerr) {
+		return true
+	}
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusUnauthorized
+}
+
+// runExitCode returns the exit code of a sync in which failed transfers
+// failed, and the sync of a pair stopped at err if not nil.
+func runExitCode(s *runStats, failed int, err error) int {
+	switch {
+	case stopping():
+		return exitInterrupted
+	case err != nil:
+		return errorExitCode([]interface{}{err})
+	case failed > 0:
+		return exitPartial
+	case s.Downloaded+s.Uploaded+s.Updated+s.Deleted > 0:
+		return exitChanged
+	}
+	return exitInSync
+}
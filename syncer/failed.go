@@ -0,0 +1,100 @@
+package syncer
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxAttempts is how many runs in a row may fail to transfer a file before
+// later runs give up on it, 0 meaning never.
+var maxAttempts = 5
+
+// retryQueue holds the failed transfers of a pair across runs. Transfers
+// failing again count another attempt, succeeding ones are removed, and so
+// are those which aren't needed any more.
+type retryQueue struct {
+	mu    sync.Mutex
+	files map[string]*failedTransfer // key: Path
+	// queued holds the paths of the transfers of this run.
+	queued map[string]bool
+}
+
+func newRetryQueue(failed []failedTransfer) *retryQueue {
+	q := &retryQueue{files: make(map[string]*failedTransfer), queued: make(map[string]bool)}
+	for i := range failed {
+		q.files[failed[i].Path] = &failed[i]
+	}
+	return q
+}
+
+// givenUp is called for each transfer to queue and reports whether the
+// transfer of the file at path failed maxAttempts times already without
+// the file changing since.
+func (q *retryQueue) givenUp(path string, size int64, modTime time.Time) bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.queued[path] = true
+	f, ok := q.files[path]
+	if !ok || f.Size != size || !f.ModTime.Equal(modTime) {
+		return false
+	}
+	if maxAttempts <= 0 || f.Attempts < maxAttempts {
+		return false
+	}
+	fmt.Printf("%s: not transferred after %d failed attempts, last: %s\n", paint(colorRed, path), f.Attempts, f.Error)
+	return true
+}
+
+// done records the outcome of a transfer.
+func (q *retryQueue) done(job *transferJob, err error) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err == nil {
+		delete(q.files, job.name)
+		return
+	}
+	q.record(job.name, job.size, job.modTime, err.Error())
+}
+
+// fail records a local file which couldn't be read, so wasn't compared
+// or transferred, like a failed transfer.
+func (q *retryQueue) fail(f failedTransfer) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.queued[f.Path] = true
+	q.record(f.Path, f.Size, f.ModTime, f.Error)
+}
+
+// record counts another failed attempt of the file at path. q.mu is held.
+func (q *retryQueue) record(path string, size int64, modTime time.Time, err string) {
+	f, ok := q.files[path]
+	if !ok || f.Size != size || !f.ModTime.Equal(modTime) {
+		f = &failedTransfer{Path: path, Size: size, ModTime: modTime}
+		q.files[path] = f
+	}
+	f.Attempts++
+	f.Error = err
+}
+
+// list returns the failed transfers of files queued in this run, sorted by
+// path, for the state.
+func (q *retryQueue) list() []failedTransfer {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var failed []failedTransfer
+	for path, f := range q.files {
+		if q.queued[path] {
+			failed = append(failed, *f)
+		}
+	}
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Path < failed[j].Path })
+	return failed
+}
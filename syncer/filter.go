@@ -0,0 +1,165 @@
+package syncer
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// filterFlags holds the filters given on the command line.
+type filterFlags struct {
+	rules     []string
+	minSize   string
+	maxSize   string
+	newerThan string
+	olderThan string
+	maxDepth  int
+
+	mimeIncludes []string
+	mimeExcludes []string
+}
+
+// filterFlag is a repeatable flag adding patterns with a prefix to a list.
+// --include and --exclude share one list with "+ " and "- " prefixes so
+// that they keep their relative order.
+type filterFlag struct {
+	rules  *[]string
+	prefix string
+}
+
+func (f filterFlag) String() string {
+	return ""
+}
+
+// list returns the patterns given with the flag.
+func (f filterFlag) list() []string {
+	var patterns []string
+	for _, rule := range *f.rules {
+		if strings.HasPrefix(rule, f.prefix) {
+			patterns = append(patterns, rule[len(f.prefix):])
+		}
+	}
+	return patterns
+}
+
+func (f filterFlag) Set(pattern string) error {
+	*f.rules = append(*f.rules, f.prefix+pattern)
+	return nil
+}
+
+// boolFlag is a boolean flag which remembers whether it was given, so it
+// can override a config setting either way.
+type boolFlag struct {
+	set   bool
+	value bool
+}
+
+func (f *boolFlag) String() string {
+	return strconv.FormatBool(f.value)
+}
+
+func (f *boolFlag) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	f.set, f.value = true, v
+	return nil
+}
+
+func (f *boolFlag) IsBoolFlag() bool {
+	return true
+}
+
+// parseFilterRule parses a "+ pattern" (include) or "- pattern" (exclude)
+// rule. Patterns use .driveignore syntax.
+func parseFilterRule(s string) (ignoreRule, error) {
+	var include bool
+	switch {
+	case strings.HasPrefix(s, "+ "):
+		include = true
+	case strings.HasPrefix(s, "- "):
+	default:
+		return ignoreRule{}, fmt.Errorf("filter rule %q must start with \"+ \" or \"- \"", s)
+	}
+	rule, ok := parseIgnoreRule(strings.TrimSpace(s[2:]), "")
+	if !ok {
+		return rule, fmt.Errorf("invalid filter rule %q", s)
+	}
+	rule.negate = include
+	return rule, nil
+}
+
+// filterExcluded applies the rules rsync style: the first matching rule
+// decides, and a path no rule matches is included.
+func filterExcluded(rules []ignoreRule, rel string, isDir bool) bool {
+	for i := range rules {
+		if rules[i].matches(rel, isDir) {
+			return !rules[i].negate
+		}
+	}
+	return false
+}
+
+// parseSize parses a size such as "512", "100k", "2M" or "1.5G" using binary
+// units. An empty string means no limit and yields -1.
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return -1, nil
+	}
+	orig := s
+	multiplier := int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "B":
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	case "T":
+		multiplier = 1 << 40
+	default:
+		multiplier = 0
+	}
+	if multiplier != 0 {
+		s = s[:len(s)-1]
+	} else {
+		multiplier = 1
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", orig)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// parseTimeBound parses either an RFC3339 timestamp or a duration before now
+// such as "36h" or "7d". An empty string means no bound and yields the zero
+// time.
+func parseTimeBound(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseFloat(s[:len(s)-1], 64)
+		if err == nil {
+			return now.Add(-time.Duration(days * float64(24*time.Hour))), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: want a duration like 36h or 7d, or an RFC3339 timestamp", s)
+	}
+	return now.Add(-d), nil
+}
+
+// pathDepth returns the number of components of a relative path.
+func pathDepth(rel string) int {
+	return strings.Count(filepath.ToSlash(rel), "/") + 1
+}
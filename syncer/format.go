@@ -0,0 +1,108 @@
+package syncer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Output formats of -format.
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// Events of the -format json output.
+const (
+	eventDecision    = "decision"    // why a file is transferred or not, with -v
+	eventPlanned     = "planned"     // a transfer is queued
+	eventTransferred = "transferred" // a transfer succeeded
+	eventFailed      = "failed"      // a transfer failed
+	eventInterrupted = "interrupted" // a transfer was stopped, the next run does it
+	eventDeleted     = "deleted"     // a file was moved to the local or Drive trash
+	eventDifference  = "difference"  // a file check found not the same on both sides
+	eventSummary     = "summary"     // the stats of the run, last
+)
+
+// Actions of decision and planned events.
+const (
+	actionUpToDate = "up to date"
+	actionDownload = "download"
+	actionExport   = "export"
+	actionUpload   = "upload"
+	actionUpdate   = "update"
+	actionOCR      = "ocr"
+	actionDelete   = "delete" // planned by a diff only
+)
+
+// event is a line of the -format json output. Fields which don't apply to
+// an event are left out; new fields may be added, but those here keep
+// their meaning.
+type event struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	Path    string    `json:"path,omitempty"` // relative to the local root
+	Action  string    `json:"action,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	Size    int64     `json:"size,omitempty"`
+	Md5     string    `json:"md5,omitempty"`
+	To      string    `json:"to,omitempty"` // where a deleted file went
+	Error   string    `json:"error,omitempty"`
+	Summary *runStats `json:"summary,omitempty"`
+}
+
+var (
+	outputFormat = formatText
+	// jsonOut receives the events. The text output goes to the standard
+	// error instead, so that the standard output is only JSON.
+	jsonOut io.Writer
+	jsonMu  sync.Mutex
+)
+
+// setupFormat applies -format. It must be called before the standard
+// output is redirected to the log file, if it is.
+func setupFormat(format string) error {
+	switch format {
+	case formatText:
+	case formatJSON:
+		jsonOut = os.Stdout
+		os.Stdout = os.Stderr
+	default:
+		return fmt.Errorf("invalid -format %q: use text or json", format)
+	}
+	outputFormat = format
+	return nil
+}
+
+// emit writes e as a line of JSON with -format json.
+// The report of the run, the TUI, notifications and the run history see it
+// in any case.
+func emit(e event) {
+	if runReport != nil {
+		runReport.add(e)
+	}
+	if ui != nil {
+		ui.add(e)
+	}
+	if notifications != nil {
+		notifications.add(e)
+	}
+	history.add(e)
+	apiJobs.add(e)
+	if outputFormat != formatJSON {
+		return
+	}
+	e.Time = time.Now().UTC()
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("json.Marshal(event) failed: %v", err)
+		return
+	}
+	jsonMu.Lock()
+	defer jsonMu.Unlock()
+	jsonOut.Write(append(b, '\n'))
+}
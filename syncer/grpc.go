@@ -0,0 +1,161 @@
+package syncer
+
+import (
+	"crypto/subtle"
+	"net"
+	"strings"
+
+	"github.com/hiroshi/googledriveclient/syncpb"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServer serves the SyncEngine service of syncpb, running the jobs of
+// the REST API.
+type grpcServer struct {
+	syncpb.UnimplementedSyncEngineServer
+	a *apiServer
+}
+
+// serveGRPC serves the gRPC interface on addr until the process is
+// stopped.
+func serveGRPC(a *apiServer, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := a.grpcAuthorized(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := a.grpcAuthorized(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	syncpb.RegisterSyncEngineServer(s, &grpcServer{a: a})
+	go func() {
+		<-processCtx.Done()
+		s.Stop()
+	}()
+	infof("Serving gRPC on %s\n", addr)
+	return s.Serve(l)
+}
+
+// grpcAuthorized checks the bearer token in the authorization metadata.
+func (a *apiServer) grpcAuthorized(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		token := strings.TrimPrefix(value, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or wrong token")
+}
+
+func (g *grpcServer) ListPairs(ctx context.Context, req *syncpb.ListPairsRequest) (*syncpb.ListPairsResponse, error) {
+	resp := &syncpb.ListPairsResponse{}
+	for _, p := range g.a.pairs {
+		resp.Pairs = append(resp.Pairs, &syncpb.Pair{Name: p.Name, Local: p.Local, Remote: p.Remote, Direction: p.Direction})
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) Plan(req *syncpb.RunRequest, stream syncpb.SyncEngine_PlanServer) error {
+	return g.run(jobDiff, req, stream)
+}
+
+func (g *grpcServer) Apply(req *syncpb.RunRequest, stream syncpb.SyncEngine_ApplyServer) error {
+	return g.run(jobSync, req, stream)
+}
+
+// run runs a job, streaming its events and then its summary. The job stops
+// when the call is cancelled.
+func (g *grpcServer) run(kind string, req *syncpb.RunRequest, stream grpc.ServerStreamingServer[syncpb.Event]) error {
+	pairs, err := g.a.selectPairs(req.Pairs)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	events := make(chan event, 64)
+	job, err := g.a.startJob(kind, pairs, events)
+	if err != nil {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	ended := make(chan struct{})
+	defer close(ended)
+	go func() {
+		select {
+		case <-stream.Context().Done():
+			job.cancel()
+		case <-ended:
+		}
+	}()
+	// Once the client is gone the events are drained until the job ends.
+	var sendErr error
+	for e := range events {
+		// The summary comes last, with the exit code.
+		if sendErr != nil || e.Event == eventSummary {
+			continue
+		}
+		if sendErr = stream.Send(eventProto(e)); sendErr != nil {
+			job.cancel()
+		}
+	}
+	if sendErr != nil {
+		return sendErr
+	}
+	g.a.mu.Lock()
+	summary := summaryProto(job)
+	g.a.mu.Unlock()
+	return stream.Send(&syncpb.Event{Time: timestamppb.Now(), Event: eventSummary, Summary: summary})
+}
+
+func eventProto(e event) *syncpb.Event {
+	return &syncpb.Event{
+		Time:   timestamppb.New(e.Time),
+		Event:  e.Event,
+		Path:   e.Path,
+		Action: e.Action,
+		Reason: e.Reason,
+		Size:   e.Size,
+		Md5:    e.Md5,
+		To:     e.To,
+		Error:  e.Error,
+	}
+}
+
+// summaryProto returns the summary of the ended job. a.mu must be held.
+func summaryProto(job *apiJob) *syncpb.Summary {
+	s := job.Summary
+	summary := &syncpb.Summary{
+		Checked:        s.Checked,
+		Skipped:        s.Skipped,
+		Downloaded:     s.Downloaded,
+		Uploaded:       s.Uploaded,
+		Updated:        s.Updated,
+		Deleted:        s.Deleted,
+		Errors:         s.Errors,
+		Bytes:          s.Bytes,
+		PhaseSeconds:   make(map[string]float64),
+		ElapsedSeconds: s.Elapsed,
+		Failed:         int32(job.Failed),
+		ExitCode:       int32(job.ExitCode),
+		Cancelled:      job.State == jobCancelled,
+	}
+	s.mu.Lock()
+	for name, seconds := range s.Phases {
+		summary.PhaseSeconds[name] = seconds
+	}
+	s.mu.Unlock()
+	return summary
+}
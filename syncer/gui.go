@@ -0,0 +1,212 @@
+package syncer
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// guiQuotaTTL is how long the dashboard keeps the storage quota.
+const guiQuotaTTL = time.Minute
+
+// guiQueued is how many queued transfers the dashboard lists.
+const guiQueued = 20
+
+// dashboard is the web UI of the daemon.
+type dashboard struct {
+	srv      *drive.Service
+	ctl      *daemonControl
+	password string // of basic authentication, "" for none
+	// csrf is the token the forms send, telling them from requests other
+	// sites make the browser send.
+	csrf string
+
+	mu       sync.Mutex
+	quota    *drive.AboutStorageQuota
+	quotaAt  time.Time
+	quotaErr error
+}
+
+// serveGUI serves the dashboard on addr until the daemon exits. With
+// DRIVE_GUI_PASSWORD set, it asks for that password.
+func serveGUI(addr string, srv *drive.Service, ctl *daemonControl) {
+	b := make([]byte, 16)
+	rand.Read(b)
+	d := &dashboard{srv: srv, ctl: ctl, password: os.Getenv("DRIVE_GUI_PASSWORD"), csrf: hex.EncodeToString(b)}
+	go func() {
+		fatal(http.ListenAndServe(addr, d))
+	}()
+	infof("Serving the dashboard on http://%s/\n", addr)
+}
+
+func (d *dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if d.password != "" {
+		_, p, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(p), []byte(d.password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="drive"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	switch {
+	case r.URL.Path == "/" && r.Method == http.MethodGet:
+		d.page(w)
+	case (r.URL.Path == "/rescan" || r.URL.Path == "/pause" || r.URL.Path == "/resume") && r.Method == http.MethodPost:
+		if subtle.ConstantTimeCompare([]byte(r.FormValue("csrf")), []byte(d.csrf)) != 1 {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if _, err := d.ctl.command(r.URL.Path[1:]); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// guiTransfer is a running or queued transfer on the dashboard.
+type guiTransfer struct {
+	Name    string
+	Size    int64
+	Percent int64 // -1 when unknown
+	Speed   string
+}
+
+func (d *dashboard) page(w http.ResponseWriter) {
+	d.ctl.mu.Lock()
+	syncing, paused, nextRun, pairs := d.ctl.syncing, d.ctl.paused, d.ctl.nextRun, d.ctl.pairs
+	d.ctl.mu.Unlock()
+	currentMu.Lock()
+	xfers, pair := currentTransfers, currentPair
+	currentMu.Unlock()
+	data := struct {
+		CSRF     string
+		Syncing  bool
+		Paused   bool
+		NextRun  time.Time
+		Pairs    []syncPair
+		Pair     string
+		Progress *progress
+		Active   []guiTransfer
+		Queued   []guiTransfer
+		More     int
+		Run      *runRecord
+		Last     *runRecord
+		Quota    *drive.AboutStorageQuota
+		QuotaErr error
+	}{CSRF: d.csrf, Syncing: syncing, Paused: paused, NextRun: nextRun, Pairs: pairs, Pair: pair}
+	if xfers != nil {
+		now := time.Now()
+		xfers.mu.Lock()
+		data.Progress = &progress{xfers.done, xfers.total, atomic.LoadInt64(&xfers.bytes), xfers.totalBytes, len(xfers.active)}
+		for _, job := range xfers.active {
+			done := atomic.LoadInt64(&job.done)
+			t := guiTransfer{Name: job.name, Size: job.size, Percent: -1, Speed: formatSpeed(done, now.Sub(job.started))}
+			if job.size > 0 {
+				t.Percent = done * 100 / job.size
+			}
+			data.Active = append(data.Active, t)
+		}
+		for _, job := range xfers.queue[xfers.dispatched:] {
+			if len(data.Queued) == guiQueued {
+				data.More++
+				continue
+			}
+			data.Queued = append(data.Queued, guiTransfer{Name: job.name, Size: job.size})
+		}
+		xfers.mu.Unlock()
+	}
+	data.Run, data.Last = history.current()
+	data.Quota, data.QuotaErr = d.storageQuota()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := guiTemplate.Execute(w, data); err != nil {
+		log.Printf("Dashboard: %v", err)
+	}
+}
+
+// storageQuota returns the storage quota of the account, asking Drive at
+// most every guiQuotaTTL.
+func (d *dashboard) storageQuota() (*drive.AboutStorageQuota, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if time.Since(d.quotaAt) < guiQuotaTTL {
+		return d.quota, d.quotaErr
+	}
+	about, err := d.srv.About.Get().Fields("storageQuota").Do()
+	d.quota, d.quotaErr, d.quotaAt = nil, err, time.Now()
+	if err == nil {
+		d.quota = about.StorageQuota
+	}
+	return d.quota, d.quotaErr
+}
+
+var guiTemplate = template.Must(template.New("gui").Funcs(template.FuncMap{
+	"bytes": formatBytes,
+	"percent": func(part, whole int64) int64 {
+		if whole <= 0 {
+			return 0
+		}
+		return part * 100 / whole
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="5">
+<title>Drive sync</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.5em; text-align: left; }
+td.size { text-align: right; }
+form { display: inline; }
+.syncing { background: #ffd; }
+.errors li { color: #a00; }
+</style>
+</head>
+<body>
+<h1>Drive sync</h1>
+<p>{{if .Paused}}Paused{{if .Syncing}}, in a sync{{end}}.{{else if or .Syncing .NextRun.IsZero}}Syncing{{with .Pair}} {{.}}{{end}}.{{else}}Waiting, next sync at {{.NextRun.Format "15:04:05"}}.{{end}}
+<form method="post" action="/rescan"><input type="hidden" name="csrf" value="{{.CSRF}}"><button>Sync now</button></form>
+{{if .Paused}}<form method="post" action="/resume"><input type="hidden" name="csrf" value="{{.CSRF}}"><button>Resume</button></form>
+{{else}}<form method="post" action="/pause"><input type="hidden" name="csrf" value="{{.CSRF}}"><button>Pause</button></form>{{end}}</p>
+
+<h2>Pairs</h2>
+<table>
+<tr><th>Name</th><th>Local</th><th>Remote</th><th>Direction</th></tr>
+{{range .Pairs}}<tr{{if eq .String $.Pair}} class="syncing"{{end}}><td>{{or .Name "default"}}</td><td>{{.Local}}</td><td>{{or .Remote "/"}}</td><td>{{or .Direction "download"}}</td></tr>
+{{end}}</table>
+
+<h2>Transfers</h2>
+{{with .Progress}}<p>{{.Done}}/{{.Total}} files, {{bytes .Bytes}} / {{bytes .TotalBytes}}.</p>{{else}}<p>None running.</p>{{end}}
+{{if .Active}}<table>
+<tr><th>Running</th><th>Size</th><th>Done</th><th>Speed</th></tr>
+{{range .Active}}<tr><td>{{.Name}}</td><td class="size">{{bytes .Size}}</td><td class="size">{{if ge .Percent 0}}{{.Percent}}%{{else}}?{{end}}</td><td class="size">{{.Speed}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Queued}}<table>
+<tr><th>Queued</th><th>Size</th></tr>
+{{range .Queued}}<tr><td>{{.Name}}</td><td class="size">{{bytes .Size}}</td></tr>
+{{end}}{{if .More}}<tr><td colspan="2">and {{.More}} more</td></tr>{{end}}</table>{{end}}
+
+<h2>Errors</h2>
+{{with .Run}}{{if .Errors}}<p>This run:</p><ul class="errors">{{range .Errors}}<li>{{.}}</li>{{end}}</ul>{{end}}{{end}}
+{{with .Last}}<p>Last run {{.ID}} ended at {{.Ended.Format "15:04:05"}}, exit {{.ExitCode}}{{if not .Errors}}, without errors{{end}}.</p>
+{{if .Errors}}<ul class="errors">{{range .Errors}}<li>{{.}}</li>{{end}}</ul>{{end}}{{else}}<p>No run ended yet.</p>{{end}}
+
+<h2>Storage</h2>
+{{with .Quota}}<p>{{bytes .Usage}} used{{if .Limit}} of {{bytes .Limit}} ({{percent .Usage .Limit}}%){{end}}, {{bytes .UsageInDrive}} in Drive, {{bytes .UsageInDriveTrash}} in its trash.</p>
+{{else}}<p>Unknown{{with .QuotaErr}}: {{.}}{{end}}.</p>{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,40 @@
+package syncer
+
+import (
+	"fmt"
+
+	"github.com/hiroshi/googledriveclient/checksum"
+	"google.golang.org/api/drive/v3"
+)
+
+// Checksums of -hash, which files are compared by and the state keeps.
+const (
+	hashMd5    = checksum.MD5 // the default
+	hashSha256 = checksum.SHA256
+)
+
+func parseHash(s string) (string, error) {
+	if checksum.Valid(s) {
+		return s, nil
+	}
+	return "", fmt.Errorf("-hash %s: must be md5 or sha256", s)
+}
+
+// remoteChecksum returns the checksum of hash of the remote file Drive
+// computed. The checksums of the listings and the state are of the hash of
+// the pair, in the Md5Checksum fields whatever it is.
+func remoteChecksum(f drive.File, hash string) string {
+	if hash == hashSha256 {
+		return f.Sha256Checksum
+	}
+	return f.Md5Checksum
+}
+
+// stateHash returns the hash of the checksums the state files holds, which
+// is md5 for state saved before -hash.
+func stateHash(files *Files) string {
+	if files.Hash == "" {
+		return hashMd5
+	}
+	return files.Hash
+}
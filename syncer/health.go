@@ -0,0 +1,76 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// healthAuthTTL is how long the probes keep the result of checking the
+// credentials.
+const healthAuthTTL = time.Minute
+
+// healthCheck answers the liveness and readiness probes of container
+// orchestrators for the daemon.
+type healthCheck struct {
+	srv    *drive.Service
+	maxAge time.Duration // of the last successful sync, for readiness
+
+	mu      sync.Mutex
+	checked time.Time
+	authErr error // nil unless Drive refused the credentials
+}
+
+// auth returns the error of the credentials Drive refused, nil if it took
+// them or couldn't be reached: a network failure isn't the process's.
+func (h *healthCheck) auth() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if time.Since(h.checked) < healthAuthTTL {
+		return h.authErr
+	}
+	_, err := h.srv.About.Get().Fields("user(emailAddress)").Do()
+	h.checked, h.authErr = time.Now(), nil
+	var gerr *googleapi.Error
+	var rerr *oauth2.RetrieveError
+	if (errors.As(err, &gerr) && gerr.Code == http.StatusUnauthorized) || errors.As(err, &rerr) {
+		h.authErr = err
+	}
+	return h.authErr
+}
+
+// serveHealthz fails once the credentials are refused, which a restart
+// won't fix but tells the orchestrator.
+func (h *healthCheck) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	if err := h.auth(); err != nil {
+		http.Error(w, fmt.Sprintf("credentials refused: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// serveReadyz fails until a sync succeeded, and when the last one to
+// succeed is older than maxAge.
+func (h *healthCheck) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	if err := h.auth(); err != nil {
+		http.Error(w, fmt.Sprintf("credentials refused: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	daemonMetrics.mu.Lock()
+	last := daemonMetrics.lastSuccess
+	daemonMetrics.mu.Unlock()
+	switch age := time.Since(last).Round(time.Second); {
+	case last.IsZero():
+		http.Error(w, "no successful sync yet", http.StatusServiceUnavailable)
+	case age > h.maxAge:
+		http.Error(w, fmt.Sprintf("last successful sync %s ago, over %s", age, h.maxAge), http.StatusServiceUnavailable)
+	default:
+		fmt.Fprintf(w, "ok, last successful sync %s ago\n", age)
+	}
+}
@@ -0,0 +1,339 @@
+package syncer
+
+import (
+	"bufio"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hiroshi/googledriveclient/state"
+)
+
+const (
+	runsFile     = "runs.jsonl" // the history with JSON state
+	runsKept     = 1000         // runs kept in the history, the oldest dropped
+	runErrorsMax = 100          // errors kept per run
+)
+
+// runRecord is a run in the history.
+type runRecord struct {
+	ID       string    `json:"id"`
+	Command  string    `json:"command"` // sync or daemon
+	Started  time.Time `json:"started"`
+	Ended    time.Time `json:"ended"`
+	ExitCode int       `json:"exitCode"`
+	Failed   int       `json:"failed"` // failed transfers
+	Summary  *runStats `json:"summary,omitempty"`
+	// Errors holds the errors of the failed transfers, "path: error",
+	// and the one which stopped the run if any.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// runStore keeps the history of the runs, oldest first.
+type runStore interface {
+	AddRun(r *runRecord) error
+	Runs() ([]runRecord, error)
+}
+
+// openRunStore returns the run history of the state backend.
+func openRunStore(backend string) (runStore, func(), error) {
+	switch backend {
+	case state.JSON, state.JSONGz:
+		return &jsonRunStore{file: runsFile}, func() {}, nil
+	case state.SQLite:
+		db, err := state.OpenDB(state.DBFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &sqliteRunStore{db: db}, func() { db.Close() }, nil
+	}
+	return nil, nil, fmt.Errorf("unknown state backend %q", backend)
+}
+
+// jsonRunStore keeps the history in a file of a JSON object per line.
+type jsonRunStore struct {
+	file string
+}
+
+func (s *jsonRunStore) Runs() ([]runRecord, error) {
+	f, err := os.Open(s.file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var runs []runRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var r runRecord
+		// A line cut short by a crash is skipped.
+		if json.Unmarshal(scanner.Bytes(), &r) == nil {
+			runs = append(runs, r)
+		}
+	}
+	return runs, scanner.Err()
+}
+
+func (s *jsonRunStore) AddRun(r *runRecord) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	runs, err := s.Runs()
+	if err != nil {
+		return err
+	}
+	if len(runs) < runsKept {
+		f, err := os.OpenFile(s.file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(append(line, '\n')); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	// The file is rewritten without the oldest runs.
+	var b []byte
+	for _, old := range runs[len(runs)-runsKept+1:] {
+		l, err := json.Marshal(old)
+		if err != nil {
+			return err
+		}
+		b = append(append(b, l...), '\n')
+	}
+	b = append(append(b, line...), '\n')
+	tmp := s.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+// sqliteRunStore keeps the history in the runs table of the state
+// database.
+type sqliteRunStore struct {
+	db *sql.DB
+}
+
+func (s *sqliteRunStore) Runs() ([]runRecord, error) {
+	rows, err := s.db.Query(`SELECT data FROM runs ORDER BY started, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var runs []runRecord
+	for rows.Next() {
+		var data string
+		var r runRecord
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(data), &r); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+func (s *sqliteRunStore) AddRun(r *runRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`INSERT OR REPLACE INTO runs (id, started, data) VALUES (?, ?, ?)`,
+		r.ID, r.Started.UTC().Format(time.RFC3339Nano), string(data))
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM runs WHERE id NOT IN (SELECT id FROM runs ORDER BY started DESC, id DESC LIMIT ?)`, runsKept)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// runHistory records the current run in the store.
+type runHistory struct {
+	store runStore
+	mu    sync.Mutex
+	run   *runRecord
+	last  *runRecord // the last run recorded
+}
+
+// history records the runs of sync and daemon, nil for other commands.
+var history *runHistory
+
+// newRunID returns an ID sorting like the start time, with random bits
+// telling apart runs started in the same second.
+func newRunID(t time.Time) string {
+	b := make([]byte, 2)
+	rand.Read(b)
+	return t.Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// start starts recording a run of command.
+func (h *runHistory) start(command string) {
+	if h == nil {
+		return
+	}
+	now := time.Now()
+	h.mu.Lock()
+	h.run = &runRecord{ID: newRunID(now), Command: command, Started: now}
+	h.mu.Unlock()
+	infof("Run %s\n", h.run.ID)
+}
+
+// add records the errors of the failed transfers of an event.
+func (h *runHistory) add(e event) {
+	if h == nil || e.Event != eventFailed {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.run != nil && len(h.run.Errors) < runErrorsMax {
+		h.run.Errors = append(h.run.Errors, e.Path+": "+e.Error)
+	}
+}
+
+// finish records the end of the run with the stats s and failed failures.
+func (h *runHistory) finish(s *runStats, failed int, code int) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.run == nil {
+		return
+	}
+	h.run.Summary, h.run.Failed, h.run.ExitCode = s, failed, code
+	h.save()
+}
+
+// fatal records the end of a run stopped by an error.
+func (h *runHistory) fatal(message string, code int) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.run == nil {
+		return
+	}
+	h.run.Errors = append(h.run.Errors, message)
+	h.run.Summary, h.run.ExitCode = stats, code
+	h.save()
+}
+
+func (h *runHistory) save() {
+	h.run.Ended = time.Now()
+	if err := h.store.AddRun(h.run); err != nil {
+		// Failing to keep the history doesn't fail the run.
+		fmt.Fprintf(os.Stderr, "Unable to record run %s: %v\n", h.run.ID, err)
+	}
+	h.last, h.run = h.run, nil
+}
+
+// current returns the run being recorded and the last one recorded, nil if
+// none.
+func (h *runHistory) current() (run, last *runRecord) {
+	if h == nil {
+		return nil, nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.run != nil {
+		r := *h.run
+		run = &r
+	}
+	if h.last != nil {
+		r := *h.last
+		last = &r
+	}
+	return run, last
+}
+
+// runsCommand runs runs list or runs show ID.
+func runsCommand(store runStore, args []string) error {
+	runs, err := store.Runs()
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(args) == 1 && args[0] == "list":
+		if outputFormat == formatJSON {
+			for i := range runs {
+				if err := writeJSON(&runs[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		for _, r := range runs {
+			fmt.Printf("%s  %s  %-8s %9s  exit %-3d %s\n", r.ID, r.Started.Format("Mon 2006-01-02 15:04"), r.Command,
+				r.Ended.Sub(r.Started).Round(time.Second), r.ExitCode, runCounts(&r))
+		}
+		return nil
+	case len(args) == 2 && args[0] == "show":
+		for i := range runs {
+			r := &runs[i]
+			if r.ID != args[1] {
+				continue
+			}
+			if outputFormat == formatJSON {
+				return writeJSON(r)
+			}
+			fmt.Printf("Run %s (%s)\n", r.ID, r.Command)
+			fmt.Printf("Started %s, ended %s, took %s\n", r.Started.Format(time.RFC1123), r.Ended.Format(time.RFC1123),
+				r.Ended.Sub(r.Started).Round(time.Millisecond))
+			fmt.Printf("Exit code %d\n", r.ExitCode)
+			if r.Summary != nil {
+				fmt.Printf("Checked %d files, %d up to date or skipped\n", r.Summary.Checked, r.Summary.Skipped)
+			}
+			fmt.Println(runCounts(r))
+			for _, e := range r.Errors {
+				fmt.Println("  " + paint(colorRed, e))
+			}
+			return nil
+		}
+		return fmt.Errorf("no run %s", args[1])
+	}
+	return fmt.Errorf("usage: runs list|show ID")
+}
+
+func runCounts(r *runRecord) string {
+	s := r.Summary
+	if s == nil {
+		s = &runStats{}
+	}
+	return fmt.Sprintf("downloaded %d, uploaded %d, updated %d, deleted %d, %s, %d errors",
+		s.Downloaded, s.Uploaded, s.Updated, s.Deleted, formatBytes(s.Bytes), s.Errors)
+}
+
+// writeJSON writes v as a line of the -format json output.
+func writeJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	jsonMu.Lock()
+	defer jsonMu.Unlock()
+	_, err = jsonOut.Write(append(b, '\n'))
+	return err
+}
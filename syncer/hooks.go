@@ -0,0 +1,133 @@
+package syncer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// hookTimeout limits the webhook request.
+const hookTimeout = 30 * time.Second
+
+// runHooks report the end of runs to a webhook and failures by email, so
+// that unattended syncs don't fail unnoticed.
+type runHooks struct {
+	// webhook receives a POST of each run's summary.
+	webhook string
+	// An email goes from mailFrom to mailTo through smtpServer when a
+	// run fails. smtpUser and smtpPassword authenticate, if set.
+	smtpServer   string // host:port
+	smtpUser     string
+	smtpPassword string
+	mailFrom     string
+	mailTo       string // comma separated
+}
+
+// hooks are the hooks of the runs.
+var hooks runHooks
+
+// webhookPayload is the JSON posted to the webhook. text makes it a Slack
+// message; other receivers can use the summary.
+type webhookPayload struct {
+	Text    string    `json:"text"`
+	Host    string    `json:"host"`
+	Failed  int       `json:"failed"`
+	Error   string    `json:"error,omitempty"` // of a run stopped by an error
+	Summary *runStats `json:"summary,omitempty"`
+}
+
+// validate checks that the email settings are complete.
+func (h *runHooks) validate() error {
+	if h.smtpServer == "" && h.mailTo == "" {
+		return nil
+	}
+	if h.smtpServer == "" || h.mailTo == "" || h.mailFrom == "" {
+		return fmt.Errorf("emails need -smtp-server, -mail-from and -mail-to")
+	}
+	if _, _, err := net.SplitHostPort(h.smtpServer); err != nil {
+		return fmt.Errorf("invalid -smtp-server %q, want host:port", h.smtpServer)
+	}
+	return nil
+}
+
+// finished runs the hooks at the end of a run with failed failures.
+func (h *runHooks) finished(s *runStats, failed int) {
+	text := fmt.Sprintf("Downloaded %d, uploaded %d, updated %d, deleted %d, %s transferred, %d errors",
+		s.Downloaded, s.Uploaded, s.Updated, s.Deleted, formatBytes(s.Bytes), s.Errors)
+	if failed > 0 {
+		text = fmt.Sprintf("Sync on %s: %d transfers failed. %s", hostname(), failed, text)
+		h.mail(fmt.Sprintf("Drive sync on %s: %d transfers failed", hostname(), failed), text)
+	} else {
+		text = fmt.Sprintf("Sync on %s: %s", hostname(), text)
+	}
+	h.post(webhookPayload{Text: text, Failed: failed, Summary: s})
+}
+
+// fatal runs the hooks for an error stopping the run.
+func (h *runHooks) fatal(message string) {
+	text := fmt.Sprintf("Sync on %s stopped: %s", hostname(), message)
+	h.post(webhookPayload{Text: text, Error: message})
+	h.mail(fmt.Sprintf("Drive sync on %s stopped", hostname()), text)
+}
+
+func (h *runHooks) post(payload webhookPayload) {
+	if h.webhook == "" {
+		return
+	}
+	payload.Host = hostname()
+	b, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("json.Marshal(webhook) failed: %v", err)
+		return
+	}
+	client := &http.Client{Timeout: hookTimeout}
+	resp, err := client.Post(h.webhook, "application/json", bytes.NewReader(b))
+	if err != nil {
+		log.Printf("Webhook failed: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		log.Printf("Webhook failed: %s", resp.Status)
+	}
+}
+
+func (h *runHooks) mail(subject, body string) {
+	if h.smtpServer == "" {
+		return
+	}
+	to := strings.Split(h.mailTo, ",")
+	for i := range to {
+		to[i] = strings.TrimSpace(to[i])
+	}
+	msg := "From: " + h.mailFrom + "\r\n" +
+		"To: " + strings.Join(to, ", ") + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + body + "\r\n"
+	var auth smtp.Auth
+	if h.smtpUser != "" {
+		host, _, _ := net.SplitHostPort(h.smtpServer)
+		auth = smtp.PlainAuth("", h.smtpUser, h.smtpPassword, host)
+	}
+	// SendMail upgrades to TLS if the server offers STARTTLS.
+	if err := smtp.SendMail(h.smtpServer, auth, h.mailFrom, to, []byte(msg)); err != nil {
+		log.Printf("Sending the email failed: %v", err)
+	}
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown host"
+	}
+	return name
+}
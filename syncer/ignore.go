@@ -0,0 +1,278 @@
+package syncer
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const ignoreFileName = ".driveignore"
+
+// ignoreRule is a single line of a .driveignore file.
+type ignoreRule struct {
+	base    string // directory holding the .driveignore, relative to the root ("" for the root)
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+	// anchored rules match the path relative to base, others match the base name.
+	anchored bool
+}
+
+// ignoreList holds the rules of all .driveignore files below a root, in the
+// order they apply: a deeper file overrides its parents and a later line
+// overrides earlier ones, as with .gitignore. The filters of .drive.yaml
+// files join them, their other settings are in dirs, outer ones first.
+// The files of a directory are read once a path in it is matched, or as
+// the local scan reads it.
+type ignoreList struct {
+	root string
+	mu   sync.Mutex
+	// searched tells the directories whose files were looked for, false
+	// for those ignored by an outer file, which aren't. key: pathKey,
+	// "" for the root
+	searched map[string]bool
+	rules    []ignoreRule
+	dirs     []dirConfig
+	// err is the first error reading or parsing a file, which stops the
+	// sync of the pair before anything is transferred or deleted.
+	err error
+}
+
+// loadDriveIgnore reads the .driveignore and .drive.yaml files of root;
+// those below it are read as their directories are reached.
+func loadDriveIgnore(root string) *ignoreList {
+	l := &ignoreList{root: root, searched: make(map[string]bool)}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.loadDir("", "", nil)
+	return l
+}
+
+// scanned reads the files of the directory rel below root among its
+// entries, as the local scan read them. A scan of another tree, such as
+// a previous snapshot, leaves the rules alone.
+func (l *ignoreList) scanned(root string, rel string, entries []fs.DirEntry) {
+	if l == nil || root != l.root {
+		return
+	}
+	has := func(name string) bool {
+		for _, entry := range entries {
+			if entry.Name() == name {
+				return true
+			}
+		}
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.loadDir(dirKey(rel), rel, has)
+}
+
+// dirKey returns the key of the directory rel in searched.
+func dirKey(rel string) string {
+	rel = pathKey(rel)
+	if rel == "." || rel == "/" {
+		return ""
+	}
+	return rel
+}
+
+// loadDir reads the files of the directory dir, a pathKey found at rel
+// below the root, after those of the directories above it, unless an outer
+// file ignores it. has, if not nil, tells the names in the directory. It
+// returns whether the directory was searched. l.mu is held.
+func (l *ignoreList) loadDir(dir string, rel string, has func(name string) bool) bool {
+	if searched, ok := l.searched[dir]; ok {
+		return searched
+	}
+	searched := true
+	if dir != "" {
+		parent := dirKey(path.Dir(dir))
+		searched = l.loadDir(parent, filepath.FromSlash(parent), nil) && !l.matchRules(dir, true)
+	}
+	l.searched[dir] = searched
+	if !searched {
+		return false
+	}
+	p := filepath.Join(l.root, rel)
+	if has == nil || has(ignoreFileName) {
+		l.fail(l.readFile(filepath.Join(p, ignoreFileName), dir))
+	}
+	if has == nil || has(dirConfigFileName) {
+		l.fail(l.readDirConfig(filepath.Join(p, dirConfigFileName), dir))
+	}
+	return true
+}
+
+// fail records err unless an error was already. l.mu is held.
+func (l *ignoreList) fail(err error) {
+	if l.err == nil {
+		l.err = err
+	}
+}
+
+// failed returns the first error reading or parsing the files read so far.
+func (l *ignoreList) failed() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+func (l *ignoreList) readFile(file string, base string) error {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		if inUnreadableDir(file) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(scanner.Text(), base); ok {
+			l.rules = append(l.rules, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading %s failed: %v", file, err)
+	}
+	return nil
+}
+
+// inUnreadableDir reports whether the directory of file can't be read. The
+// scan reports such directories and keeps what is below them out of the
+// sync, so their files are left alone.
+func inUnreadableDir(file string) bool {
+	_, err := os.ReadDir(filepath.Dir(file))
+	return err != nil
+}
+
+func parseIgnoreRule(line string, base string) (ignoreRule, bool) {
+	rule := ignoreRule{base: base}
+	line = norm.NFC.String(strings.TrimRight(line, " \t\r"))
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false
+	}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return rule, false
+	}
+	re, err := regexp.Compile("^" + globToRegexp(line) + "$")
+	if err != nil {
+		log.Printf("%s: invalid pattern %q: %v", path.Join(base, ignoreFileName), line, err)
+		return rule, false
+	}
+	rule.re = re
+	return rule, true
+}
+
+// globToRegexp translates a gitignore glob, including "**", to a regexp.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**"):
+			b.WriteString("(/.*)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			j := strings.IndexByte(glob[i:], ']')
+			if j < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += j
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// matches reports whether the rule's pattern applies to the slash separated
+// path rel, relative to the root.
+func (rule *ignoreRule) matches(rel string, isDir bool) bool {
+	if rule.dirOnly && !isDir {
+		return false
+	}
+	sub := rel
+	if rule.base != "" {
+		if !strings.HasPrefix(rel, rule.base+"/") {
+			return false
+		}
+		sub = rel[len(rule.base)+1:]
+	}
+	if !rule.anchored {
+		sub = path.Base(sub)
+	}
+	return rule.re.MatchString(sub)
+}
+
+// match reports whether the slash separated path rel, relative to the root,
+// is ignored by the rules. It does not consider the directories above rel.
+func (l *ignoreList) match(rel string, isDir bool) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.loadDir(dirKey(path.Dir(rel)), filepath.FromSlash(dirKey(path.Dir(rel))), nil)
+	return l.matchRules(rel, isDir)
+}
+
+// matchRules is match with the rules read so far. l.mu is held.
+func (l *ignoreList) matchRules(rel string, isDir bool) bool {
+	ignored := false
+	for i := range l.rules {
+		if l.rules[i].matches(rel, isDir) {
+			ignored = !l.rules[i].negate
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,182 @@
+package syncer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hiroshi/googledriveclient/drivefs"
+	"golang.org/x/text/unicode/norm"
+	"google.golang.org/api/drive/v3"
+)
+
+// remoteIndex resolves the paths of remote files.
+type remoteIndex struct {
+	folders map[string]drive.File // key: File.Id
+	rootId  string                // id of the My Drive root, if known
+	// names holds the local names of files whose name is shared with
+	// another file in the same folder. key: File.Id
+	names map[string]string
+	// folderPaths and tops hold the path of each folder and the id of the
+	// outermost folder above it, following first parents. key: File.Id
+	folderPaths map[string]string
+	tops        map[string]string
+}
+
+// nameOptions controls how remote names are turned into local names.
+type nameOptions struct {
+	windows         bool   // replace characters and names Windows doesn't allow
+	form            string // Unicode normalization form, see normalizeName
+	caseInsensitive bool   // names differing only in case clash
+}
+
+// newRemoteIndex indexes files.Remote. Names that can't be used locally are
+// sanitized, and files whose names clash within one folder get distinct
+// local names. The local names are recorded in files.Names so that they stay
+// the same across runs and can be mapped back to the remote files.
+func newRemoteIndex(files *Files, opts nameOptions) *remoteIndex {
+	idx := &remoteIndex{
+		folders: *remoteFolders(&files.Remote),
+		rootId:  files.RootId,
+		names:   make(map[string]string),
+	}
+	for _, file := range files.Remote {
+		if n := normalizeName(drivefs.SanitizeName(file.Name, opts.windows), opts.form); n != file.Name {
+			idx.names[file.Id] = n
+		}
+	}
+	fold := func(name string) string { return name }
+	if opts.caseInsensitive {
+		fold = func(name string) string { return strings.ToLower(norm.NFC.String(name)) }
+	}
+	siblings := make(map[string][]drive.File) // key: parent id + "/" + folded local name
+	for _, file := range files.Remote {
+		for _, parent := range file.Parents {
+			key := parent + "/" + fold(idx.name(file))
+			siblings[key] = append(siblings[key], file)
+		}
+	}
+	keys := make([]string, 0, len(siblings))
+	for key := range siblings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if group := siblings[key]; len(group) > 1 {
+			idx.disambiguate(group, files.Names, fold)
+		}
+	}
+	files.Names = make(map[string]string)
+	for id, n := range idx.names {
+		files.Names[id] = n
+	}
+	idx.indexPaths()
+	return idx
+}
+
+// sortedByPath returns the files sorted by their remote path, then id, so
+// that what is planned for them comes out in the same order every run
+// rather than in the order of the listing.
+func sortedByPath(idx *remoteIndex, files []drive.File) []drive.File {
+	paths := make(map[string]string, len(files)) // key: File.Id
+	for _, file := range files {
+		paths[file.Id] = remotePath(idx, file)
+	}
+	sorted := append([]drive.File(nil), files...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if paths[a.Id] != paths[b.Id] {
+			return paths[a.Id] < paths[b.Id]
+		}
+		return a.Id < b.Id
+	})
+	return sorted
+}
+
+// indexPaths computes the paths of all folders in one traversal down from
+// the outermost ones, so that the path of a file is its parent's path plus
+// its name. A folder in a parent cycle, which never reaches an outermost
+// folder, counts as outermost itself.
+func (idx *remoteIndex) indexPaths() {
+	idx.folderPaths = make(map[string]string, len(idx.folders))
+	idx.tops = make(map[string]string, len(idx.folders))
+	children := make(map[string][]drive.File) // key: first parent id
+	var tops []drive.File
+	for _, folder := range idx.folders {
+		if len(folder.Parents) > 0 {
+			if _, ok := idx.folders[folder.Parents[0]]; ok {
+				children[folder.Parents[0]] = append(children[folder.Parents[0]], folder)
+				continue
+			}
+		}
+		tops = append(tops, folder)
+	}
+	walk := func(top drive.File) {
+		idx.folderPaths[top.Id] = "/" + idx.name(top)
+		idx.tops[top.Id] = top.Id
+		queue := []drive.File{top}
+		for len(queue) > 0 {
+			folder := queue[0]
+			queue = queue[1:]
+			for _, child := range children[folder.Id] {
+				if _, done := idx.folderPaths[child.Id]; done {
+					continue
+				}
+				idx.folderPaths[child.Id] = idx.folderPaths[folder.Id] + "/" + idx.name(child)
+				idx.tops[child.Id] = top.Id
+				queue = append(queue, child)
+			}
+		}
+	}
+	for _, top := range tops {
+		walk(top)
+	}
+	for _, folder := range idx.folders {
+		if _, done := idx.folderPaths[folder.Id]; !done {
+			walk(folder)
+		}
+	}
+}
+
+// disambiguate assigns local names to files in one folder whose names are
+// the same after fold. A file keeps the name recorded for it earlier;
+// otherwise files in id order keep their name unless another file already
+// has it, and get their id appended if it does.
+func (idx *remoteIndex) disambiguate(group []drive.File, recorded map[string]string, fold func(string) string) {
+	sort.Slice(group, func(i, j int) bool { return group[i].Id < group[j].Id })
+	plain := make(map[string]string)
+	for _, file := range group {
+		plain[file.Id] = idx.name(file)
+	}
+	taken := make(map[string]bool)
+	assigned := make(map[string]string)
+	for _, file := range group {
+		n, ok := recorded[file.Id]
+		if ok && (n == plain[file.Id] || n == drivefs.IdName(plain[file.Id], file.Id)) && !taken[fold(n)] {
+			assigned[file.Id] = n
+			taken[fold(n)] = true
+		}
+	}
+	for _, file := range group {
+		n, ok := assigned[file.Id]
+		if !ok {
+			n = plain[file.Id]
+			if taken[fold(n)] {
+				n = drivefs.IdName(n, file.Id)
+			}
+			taken[fold(n)] = true
+		}
+		idx.names[file.Id] = n
+		if n != plain[file.Id] {
+			fmt.Printf("%s: name clashes with another file in its folder, synced as %s\n", plain[file.Id], n)
+		}
+	}
+}
+
+// name returns the local name of a remote file.
+func (idx *remoteIndex) name(file drive.File) string {
+	if n, ok := idx.names[file.Id]; ok {
+		return n
+	}
+	return file.Name
+}
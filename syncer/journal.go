@@ -0,0 +1,153 @@
+package syncer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// journal is a write-ahead log of the transfers of a pair. The transfers
+// planned are written before the first one starts and each is marked done
+// once it finished, successfully or not. The journal is removed when all
+// are done; if it is still there the run was interrupted, and the next run
+// only does the transfers not done yet instead of scanning and planning
+// again. A journal which can't be written is removed, the next run
+// scanning and planning again.
+type journal struct {
+	file string
+	f    *os.File
+	// pending holds the transfers an interrupted run didn't finish,
+	// nil if there was none.
+	pending map[string]bool
+}
+
+// journalEntry is a line of the journal.
+type journalEntry struct {
+	Planned string `json:"planned,omitempty"`
+	Done    string `json:"done,omitempty"`
+}
+
+// openJournal reads the journal left by an interrupted run, if any.
+func openJournal(file string) (*journal, error) {
+	j := &journal{file: file}
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	j.pending = make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var e journalEntry
+		// A line cut short by the crash is ignored.
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		if e.Planned != "" {
+			j.pending[e.Planned] = true
+		}
+		if e.Done != "" {
+			delete(j.pending, e.Done)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s failed: %v", file, err)
+	}
+	return j, nil
+}
+
+// resuming reports whether an interrupted run left transfers to do.
+func (j *journal) resuming() bool {
+	return j != nil && len(j.pending) > 0
+}
+
+// skip reports whether a transfer is left out when resuming, having been
+// done before the interruption or not planned at all.
+func (j *journal) skip(name string) bool {
+	return j.resuming() && !j.pending[name]
+}
+
+// plan writes the planned transfers and syncs them to disk.
+func (j *journal) plan(jobs []*transferJob) {
+	if j == nil {
+		return
+	}
+	f, err := os.OpenFile(j.file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		j.fail(err)
+		return
+	}
+	j.f = f
+	w := bufio.NewWriter(f)
+	for _, job := range jobs {
+		if err := writeEntry(w, journalEntry{Planned: job.name}); err != nil {
+			j.fail(err)
+			return
+		}
+	}
+	if err := w.Flush(); err != nil {
+		j.fail(err)
+		return
+	}
+	if err := f.Sync(); err != nil {
+		j.fail(err)
+	}
+}
+
+// done marks a transfer done. Calls must not overlap.
+func (j *journal) done(job *transferJob) {
+	if j == nil || j.f == nil {
+		return
+	}
+	err := writeEntry(j.f, journalEntry{Done: job.name})
+	if err == nil {
+		err = j.f.Sync()
+	}
+	if err != nil {
+		j.fail(err)
+	}
+}
+
+func writeEntry(w io.Writer, e journalEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// fail gives up the journal after err, removing it so that it doesn't
+// tell the next run what is left.
+func (j *journal) fail(err error) {
+	log.Printf("Unable to write %s, an interrupted run will be planned again: %v", j.file, err)
+	if j.f != nil {
+		j.f.Close()
+		j.f = nil
+	}
+	if err := os.Remove(j.file); err != nil && !os.IsNotExist(err) {
+		log.Printf("Unable to remove %s: %v", j.file, err)
+	}
+}
+
+// finish removes the journal once all transfers are done.
+func (j *journal) finish() {
+	if j == nil {
+		return
+	}
+	if j.f != nil {
+		j.f.Close()
+		j.f = nil
+	}
+	if err := os.Remove(j.file); err != nil && !os.IsNotExist(err) {
+		log.Printf("Unable to remove %s: %v", j.file, err)
+	}
+	j.pending = nil
+}
@@ -0,0 +1,385 @@
+package syncer
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hiroshi/googledriveclient/drivefs"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/drivelabels/v2"
+	"google.golang.org/api/googleapi"
+)
+
+// labelsService is the Drive Labels API of the account of -token-file, set
+// up by the first driveService. It lists the labels; applying them and
+// searching by them goes through the Drive API.
+var labelsService *drivelabels.Service
+
+// labelQuery returns the query of the files with the label id.
+func labelQuery(id string) string {
+	return drivefs.QueryQuote("labels/"+id) + " in labels"
+}
+
+// labeledFiles returns the ids of the files with the label id.
+func labeledFiles(srv *drive.Service, id string) (map[string]bool, error) {
+	ids := make(map[string]bool)
+	pageToken := ""
+	for {
+		call := srv.Files.List().Q(labelQuery(id) + " and trashed = false").
+			PageSize(1000).Fields("nextPageToken, files(id)").Context(runCtx)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		var r *drive.FileList
+		err := retry("Listing the files labeled "+id, func() (err error) {
+			r, err = call.Do()
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range r.Files {
+			ids[f.Id] = true
+		}
+		if pageToken = r.NextPageToken; pageToken == "" {
+			return ids, nil
+		}
+	}
+}
+
+// labelCommand runs label: it lists the labels, the files with a label or
+// the labels of files, and applies labels to files or removes them.
+func labelCommand(srv *drive.Service, args []string) error {
+	const usage = "usage: label list | files labelId | show remotePath... | apply [-text|-selection|-integer|-date field=value]... labelId remotePath... | remove labelId remotePath..."
+	if len(args) == 0 {
+		exitf(exitConfig, usage)
+	}
+	handleSignals()
+	switch args[0] {
+	case "list":
+		return listLabels()
+	case "files":
+		if len(args) != 2 {
+			exitf(exitConfig, usage)
+		}
+		return listLabeled(srv, args[1])
+	case "show":
+		if len(args) < 2 {
+			exitf(exitConfig, usage)
+		}
+		return showLabels(srv, args[1:])
+	case "apply", "remove":
+		fs := flag.NewFlagSet("label "+args[0], flag.ContinueOnError)
+		values := map[string]propertyFlag{"text": {}, "selection": {}, "integer": {}, "date": {}}
+		if args[0] == "apply" {
+			fs.Var(values["text"], "text", "set the text field `field=value` (repeatable)")
+			fs.Var(values["selection"], "selection", "set the selection field `field=choiceId` (repeatable)")
+			fs.Var(values["integer"], "integer", "set the integer field `field=value` (repeatable)")
+			fs.Var(values["date"], "date", "set the date field `field=YYYY-MM-DD` (repeatable)")
+		}
+		if err := fs.Parse(args[1:]); err != nil {
+			exit(exitConfig)
+		}
+		if fs.NArg() < 2 {
+			exitf(exitConfig, usage)
+		}
+		change := &drive.LabelModification{LabelId: fs.Arg(0), RemoveLabel: args[0] == "remove"}
+		for kind, fields := range values {
+			for id, value := range fields {
+				m := &drive.LabelFieldModification{FieldId: id}
+				switch kind {
+				case "text":
+					m.SetTextValues = []string{value}
+				case "selection":
+					m.SetSelectionValues = []string{value}
+				case "integer":
+					n, err := strconv.ParseInt(value, 10, 64)
+					if err != nil {
+						exitf(exitConfig, "-integer %s=%s: not an integer", id, value)
+					}
+					m.SetIntegerValues = googleapi.Int64s{n}
+				case "date":
+					m.SetDateValues = []string{value}
+				}
+				change.FieldModifications = append(change.FieldModifications, m)
+			}
+		}
+		return modifyLabels(srv, change, fs.Args()[1:])
+	}
+	exitf(exitConfig, usage)
+	return nil
+}
+
+// listLabels prints the published labels the user may apply, with their
+// fields, one JSON object per label with -format json.
+func listLabels() error {
+	var labels []*drivelabels.GoogleAppsDriveLabelsV2Label
+	err := retry("Listing labels", func() error {
+		labels = nil
+		return labelsService.Labels.List().PublishedOnly(true).View("LABEL_VIEW_FULL").Context(runCtx).
+			Pages(runCtx, func(r *drivelabels.GoogleAppsDriveLabelsV2ListLabelsResponse) error {
+				labels = append(labels, r.Labels...)
+				return nil
+			})
+	})
+	if err != nil {
+		var gerr *googleapi.Error
+		if errors.As(err, &gerr) && gerr.Code == http.StatusForbidden {
+			return fmt.Errorf("%v; delete the token file to sign in again with access to labels", err)
+		}
+		return err
+	}
+	for _, l := range labels {
+		r := labelRecord{Id: l.Id}
+		if l.Properties != nil {
+			r.Title = l.Properties.Title
+		}
+		for _, f := range l.Fields {
+			field := labelFieldRecord{Id: f.Id, Type: fieldType(f)}
+			if f.Properties != nil {
+				field.Name = f.Properties.DisplayName
+			}
+			if f.SelectionOptions != nil {
+				for _, c := range f.SelectionOptions.Choices {
+					if c.Properties != nil {
+						field.Choices = append(field.Choices, labelChoiceRecord{c.Id, c.Properties.DisplayName})
+					}
+				}
+			}
+			r.Fields = append(r.Fields, field)
+		}
+		if outputFormat == formatJSON {
+			if err := writeJSON(&r); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Printf("%s\t%s\n", r.Id, r.Title)
+		for _, f := range r.Fields {
+			fmt.Printf("  %s\t%s\t%s\n", f.Id, f.Name, f.Type)
+			for _, c := range f.Choices {
+				fmt.Printf("    %s\t%s\n", c.Id, c.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// labelRecord is a label as label list prints it with -format json.
+type labelRecord struct {
+	Id     string             `json:"id"`
+	Title  string             `json:"title"`
+	Fields []labelFieldRecord `json:"fields,omitempty"`
+}
+
+type labelFieldRecord struct {
+	Id      string              `json:"id"`
+	Name    string              `json:"name"`
+	Type    string              `json:"type"`
+	Choices []labelChoiceRecord `json:"choices,omitempty"` // of selection fields
+}
+
+type labelChoiceRecord struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// fieldType returns the type of the label field f, as the flags of label
+// apply name it.
+func fieldType(f *drivelabels.GoogleAppsDriveLabelsV2Field) string {
+	switch {
+	case f.TextOptions != nil:
+		return "text"
+	case f.SelectionOptions != nil:
+		return "selection"
+	case f.IntegerOptions != nil:
+		return "integer"
+	case f.DateOptions != nil:
+		return "date"
+	case f.UserOptions != nil:
+		return "user"
+	}
+	return "unknown"
+}
+
+// listLabeled prints the files with the label id, one JSON object per
+// file with -format json.
+func listLabeled(srv *drive.Service, id string) error {
+	ids, err := labeledFiles(srv, id)
+	if err != nil {
+		return err
+	}
+	sorted := make([]string, 0, len(ids))
+	for fileId := range ids {
+		sorted = append(sorted, fileId)
+	}
+	sort.Strings(sorted)
+	for _, fileId := range sorted {
+		var f *drive.File
+		err := retry("Getting "+fileId, func() (err error) {
+			f, err = srv.Files.Get(fileId).Fields("id, name, mimeType").Context(runCtx).Do()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if outputFormat == formatJSON {
+			err := writeJSON(&struct {
+				Id       string `json:"id"`
+				Name     string `json:"name"`
+				MimeType string `json:"mimeType"`
+			}{f.Id, f.Name, f.MimeType})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Printf("%s\t%s\t%s\n", f.Id, f.Name, f.MimeType)
+	}
+	return nil
+}
+
+// remoteFileAt returns the remote file at the path name, natives included.
+func remoteFileAt(srv *drive.Service, name string) (drive.File, error) {
+	fs, err := newRemoteFS(srv, "")
+	if err != nil {
+		return drive.File{}, err
+	}
+	fs.Natives = true
+	p, err := resolveRemote(srv, name)
+	if err != nil {
+		return drive.File{}, err
+	}
+	info, err := fs.Stat(runCtx, p)
+	if os.IsNotExist(err) {
+		return drive.File{}, fmt.Errorf("no file %s in My Drive", name)
+	}
+	return info.File, err
+}
+
+// showLabels prints the labels of the files at the paths names, with the
+// values of their fields, one JSON object per file with -format json.
+func showLabels(srv *drive.Service, names []string) error {
+	for _, name := range names {
+		file, err := remoteFileAt(srv, name)
+		if err != nil {
+			return err
+		}
+		var labels *drive.LabelList
+		err = retry("Listing the labels of "+name, func() (err error) {
+			labels, err = srv.Files.ListLabels(file.Id).Context(runCtx).Do()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if outputFormat == formatJSON {
+			r := fileLabelsRecord{Path: name, Id: file.Id, Labels: []appliedLabelRecord{}}
+			for _, l := range labels.Labels {
+				applied := appliedLabelRecord{Id: l.Id, Fields: make(map[string]string, len(l.Fields))}
+				for id, f := range l.Fields {
+					applied.Fields[id] = fieldValue(f)
+				}
+				r.Labels = append(r.Labels, applied)
+			}
+			if err := writeJSON(&r); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Printf("%s:", name)
+		if len(labels.Labels) == 0 {
+			fmt.Print(" no labels")
+		}
+		fmt.Println()
+		for _, l := range labels.Labels {
+			fmt.Printf("  %s\n", l.Id)
+			ids := make([]string, 0, len(l.Fields))
+			for id := range l.Fields {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			for _, id := range ids {
+				fmt.Printf("    %s = %s\n", id, fieldValue(l.Fields[id]))
+			}
+		}
+	}
+	return nil
+}
+
+// fileLabelsRecord is a file as label show prints it with -format json.
+type fileLabelsRecord struct {
+	Path   string               `json:"path"`
+	Id     string               `json:"id"`
+	Labels []appliedLabelRecord `json:"labels"`
+}
+
+type appliedLabelRecord struct {
+	Id     string            `json:"id"`
+	Fields map[string]string `json:"fields"` // values as text, by field id
+}
+
+// fieldValue returns the value of an applied label field as text.
+func fieldValue(f drive.LabelField) string {
+	var values []string
+	switch {
+	case len(f.Text) > 0:
+		values = f.Text
+	case len(f.Selection) > 0:
+		values = f.Selection
+	case len(f.DateString) > 0:
+		values = f.DateString
+	case len(f.Integer) > 0:
+		for _, n := range f.Integer {
+			values = append(values, strconv.FormatInt(n, 10))
+		}
+	case len(f.User) > 0:
+		for _, u := range f.User {
+			values = append(values, u.EmailAddress)
+		}
+	}
+	return strings.Join(values, ", ")
+}
+
+// modifyLabels applies change to the files at the paths names.
+func modifyLabels(srv *drive.Service, change *drive.LabelModification, names []string) error {
+	for _, name := range names {
+		file, err := remoteFileAt(srv, name)
+		if err != nil {
+			return err
+		}
+		err = retry("Labeling "+name, func() error {
+			_, err := srv.Files.ModifyLabels(file.Id, &drive.ModifyLabelsRequest{LabelModifications: []*drive.LabelModification{change}}).Context(runCtx).Do()
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if change.RemoveLabel {
+			fmt.Printf("%s: removed %s\n", name, change.LabelId)
+		} else {
+			fmt.Printf("%s: applied %s\n", name, change.LabelId)
+		}
+	}
+	return nil
+}
+
+// loadLabeled lists the files with the label of the pair, if it has one,
+// which are then the only ones it syncs.
+func (p *syncPair) loadLabeled(srv *drive.Service) error {
+	if p.Label == "" {
+		return nil
+	}
+	ids, err := labeledFiles(srv, p.Label)
+	if err != nil {
+		return fmt.Errorf("unable to list the files labeled %s: %v", p.Label, err)
+	}
+	p.labeled = ids
+	return nil
+}
@@ -0,0 +1,90 @@
+package syncer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hiroshi/googledriveclient/drivefs"
+	"google.golang.org/api/drive/v3"
+)
+
+// listConcurrency is how many folders a folder scoped listing lists at once.
+const listConcurrency = 8
+
+// scopedListing reports whether the remote files of the pair can be listed
+// folder by folder below its remote root instead of listing the whole
+// Drive. Items shared with me and the Computers section live elsewhere, and
+// so do orphans, which such a listing leaves out, and the targets of the
+// shortcuts followed.
+func (p *syncPair) scopedListing() bool {
+	return p.Remote != "" && p.SharedWithMe == "" && p.Computers == "" && !p.FollowShortcuts
+}
+
+// listSubtree lists the folder at the slash separated path remoteRoot
+// below My Drive, the folders above it and everything below it, one query
+// per folder. q narrows the listing like in remote. It returns false if
+// there is no such folder.
+func listSubtree(srv *drive.Service, remoteRoot string, q string) ([]drive.File, bool, error) {
+	files, ok, err := remoteRootFolders(srv, remoteRoot)
+	if !ok || err != nil {
+		return nil, false, err
+	}
+	parent := files[len(files)-1].Id
+	if q != "" {
+		q = " and (" + q + ")"
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	// listErr is the first listing which failed.
+	var listErr error
+	sem := make(chan struct{}, listConcurrency)
+	var list func(folderId string)
+	list = func(folderId string) {
+		defer wg.Done()
+		sem <- struct{}{}
+		children, err := remote(srv, drivefs.QueryQuote(folderId)+" in parents and trashed = false"+q, nil, "", nil)
+		<-sem
+		mu.Lock()
+		files = append(files, children...)
+		if err != nil && listErr == nil {
+			listErr = err
+		}
+		mu.Unlock()
+		for _, child := range children {
+			if child.MimeType == folderMimeType {
+				wg.Add(1)
+				go list(child.Id)
+			}
+		}
+	}
+	wg.Add(1)
+	list(parent)
+	wg.Wait()
+	if listErr != nil {
+		return nil, false, listErr
+	}
+	return files, true, nil
+}
+
+// remoteRootFolders returns the folders along the slash separated path
+// remoteRoot below My Drive, outermost first, or false if there is no such
+// folder.
+func remoteRootFolders(srv *drive.Service, remoteRoot string) ([]drive.File, bool, error) {
+	var folders []drive.File
+	parent := "root"
+	for _, name := range strings.Split(strings.Trim(remoteRoot, "/"), "/") {
+		q := fmt.Sprintf("%s in parents and name = %s and mimeType = '%s' and trashed = false",
+			drivefs.QueryQuote(parent), drivefs.QueryQuote(name), folderMimeType)
+		found, err := remote(srv, q, nil, "", nil)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(found) == 0 {
+			return nil, false, nil
+		}
+		folders = append(folders, found[0])
+		parent = found[0].Id
+	}
+	return folders, true, nil
+}
@@ -0,0 +1,54 @@
+package syncer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hiroshi/googledriveclient/lock"
+)
+
+// Lock files keeping two runs from syncing the same state or local root at
+// once, e.g. overlapping cron jobs.
+const (
+	stateLockFile = "drive.lock"  // next to the state, in the working directory
+	localLockFile = ".drive-lock" // in each local root
+)
+
+// lockRun locks the state in the working directory and the local roots of
+// the pairs, returning a function releasing them. The roots must exist.
+func lockRun(pairs []syncPair) (func(), error) {
+	var locks []*lock.Lock
+	release := func() {
+		for _, l := range locks {
+			l.Release()
+		}
+	}
+	files := []string{stateLockFile}
+	for i := range pairs {
+		if err := checkRoot(&pairs[i]); err != nil {
+			release()
+			return nil, err
+		}
+		files = append(files, filepath.Join(pairs[i].Local, localLockFile))
+	}
+	for _, file := range files {
+		l, err := lock.Acquire(file)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		locks = append(locks, l)
+	}
+	return release, nil
+}
+
+// checkRoot returns an error if the local root of the pair is missing. It
+// isn't created, as an unmounted disk synced as empty would have the remote
+// tree deleted.
+func checkRoot(pair *syncPair) error {
+	if fi, err := os.Stat(pair.Local); err != nil || !fi.IsDir() {
+		return fmt.Errorf("pair %s: the local root %s doesn't exist or isn't a directory, create it first", pair, pair.Local)
+	}
+	return nil
+}
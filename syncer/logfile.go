@@ -0,0 +1,64 @@
+package syncer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// logFileOptions rotate the log file once it reaches maxSize bytes, and
+// remove rotated files older than maxAge or beyond the newest maxBackups.
+type logFileOptions struct {
+	file       string
+	maxSize    int64
+	maxAge     time.Duration // 0 keeps them regardless of age
+	maxBackups int           // 0 keeps all
+}
+
+// logToFile writes the output, which would otherwise go to the standard
+// output and error, to the log file, with the time at the start of each
+// line. Rotated files are gzip compressed. The returned function writes
+// out what is pending.
+func logToFile(o logFileOptions) func() {
+	w := &lumberjack.Logger{
+		Filename:   o.file,
+		MaxSize:    int(math.Ceil(float64(o.maxSize) / (1 << 20))),
+		MaxAge:     int(math.Ceil(o.maxAge.Hours() / 24)),
+		MaxBackups: o.maxBackups,
+		LocalTime:  true,
+		Compress:   true,
+	}
+	log.SetOutput(w)
+	// What is printed goes through a pipe to be stamped line by line.
+	r, pw, err := os.Pipe()
+	if err != nil {
+		fatalf("os.Pipe() failed: %v", err)
+	}
+	os.Stdout = pw
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		copyStamped(w, r)
+	}()
+	return func() {
+		pw.Close()
+		<-done
+		w.Close()
+	}
+}
+
+// copyStamped copies the lines of r to w, prefixed with the time like those
+// of the log package.
+func copyStamped(w io.Writer, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		fmt.Fprintf(w, "%s %s\n", time.Now().Format("2006/01/02 15:04:05"), scanner.Bytes())
+	}
+}
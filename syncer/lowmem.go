@@ -0,0 +1,338 @@
+package syncer
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/hiroshi/googledriveclient/drivefs"
+	"github.com/hiroshi/googledriveclient/state"
+	"google.golang.org/api/drive/v3"
+)
+
+// lowMemoryBatch is how many downloads a folder by folder sync queues
+// before running them.
+const lowMemoryBatch = 1000
+
+// lowMemory reports whether the pair can be synced folder by folder. That
+// takes a remote root to start from and downloading only: deletions need
+// the whole remote tree, and so do the paths of a file's other parents and
+// of obfuscated files.
+func (p *syncPair) lowMemory() bool {
+	return p.scopedListing() && p.Direction != directionUpload && !p.Delete && p.Parents == parentsPrimary && !p.Obfuscate && !p.Snapshots
+}
+
+// syncPairLowMemory syncs a pair like syncPairFiles, but lists the remote
+// side one folder at a time and compares each folder's files with the
+// local directory and their rows in the state before listing the next, so
+// neither tree is ever held as a whole. Downloads run in batches of
+// lowMemoryBatch. The listing isn't kept in the state, so every run lists
+// the remote side again. An error stops the sync of the pair like in
+// syncPairFiles.
+func syncPairLowMemory(srv *drive.Service, pair *syncPair, state stateStore, opts *runOptions) (int, error) {
+	infof("Sync %s folder by folder\n", pair)
+	pair.ignore = loadDriveIgnore(pair.Local)
+	if err := pair.loadLabeled(srv); err != nil {
+		return 0, err
+	}
+	files, locals, err := loadLowMemoryState(state, pair)
+	if err != nil {
+		return 0, err
+	}
+	files.Remote, files.ListToken = nil, ""
+
+	done := stats.phase(phaseListing)
+	if files.RootId, err = remoteRootId(srv); err != nil {
+		return 0, err
+	}
+	roots, ok, err := remoteRootFolders(srv, pair.Remote)
+	done()
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		fmt.Printf("%s: no folder %s in My Drive\n", pair, pair.Remote)
+		return 1, nil
+	}
+	q := mimeQuery(pair.mimeIncludes, pair.mimeExcludes)
+	if q != "" {
+		q = " and (" + q + ")"
+	}
+	// folders holds the folders found so far, reduced to what their
+	// paths are resolved by. key: File.Id
+	folders := make(map[string]drive.File)
+	for _, folder := range roots {
+		folders[folder.Id] = folderStub(folder)
+	}
+	// names collects the local names chosen in each folder, recorded as
+	// Files.Names.
+	names := make(map[string]string)
+
+	retries := newRetryQueue(files.Failed)
+	failed := 0
+	plan := newDownloadPlan(srv, pair, nil, nil)
+	newBatch := func() {
+		plan.xfers = newTransfers(opts.transfers, opts.bwlimit.limiter(pair), opts.order, retries)
+		plan.overwrites = nil
+	}
+	newBatch()
+	queue := []string{roots[len(roots)-1].Id}
+	for len(queue) > 0 && !stopping() {
+		id := queue[0]
+		queue = queue[1:]
+		done := stats.phase(phaseListing)
+		children, err := remote(srv, drivefs.QueryQuote(id)+" in parents and trashed = false"+q, nil, "", nil)
+		done()
+		if err != nil {
+			return failed, err
+		}
+		children = pair.plainTree(children)
+		// Index the folder's files together with the folders above
+		// it, which keep the names chosen when they were listed.
+		listed := &Files{RootId: files.RootId, Remote: children, Names: files.Names}
+		var above []string
+		for f := id; len(above) < len(folders); {
+			folder, ok := folders[f]
+			if !ok {
+				break
+			}
+			listed.Remote = append(listed.Remote, folder)
+			above = append(above, f)
+			if len(folder.Parents) == 0 {
+				break
+			}
+			f = folder.Parents[0]
+		}
+		idx := newRemoteIndex(listed, pair.nameOptions())
+		for _, id := range above {
+			if n, ok := names[id]; ok {
+				idx.names[id] = n
+			}
+		}
+		idx.indexPaths()
+		done = stats.phase(phaseScanning)
+		byPath, unhashed, err := localFolder(pair, idx, children, locals, opts.checkers)
+		done()
+		if err != nil {
+			return failed, fmt.Errorf("unable to update the state: %v", err)
+		}
+		pair.leaveUnhashed(unhashed)
+		for _, f := range unhashed {
+			retries.fail(f)
+		}
+		failed += len(unhashed)
+		plan.localByPath = byPath
+		for _, child := range sortedByPath(idx, children) {
+			if n, ok := listed.Names[child.Id]; ok {
+				names[child.Id] = n
+			}
+			if child.MimeType == folderMimeType {
+				if _, ok := pair.includeRemote(idx, child); ok {
+					folders[child.Id] = folderStub(child)
+					queue = append(queue, child.Id)
+				}
+				continue
+			}
+			plan.add(idx, child)
+			if len(plan.xfers.queue) >= lowMemoryBatch {
+				n, err := runLowMemoryBatch(pair, plan, opts)
+				failed += n
+				if err != nil {
+					return failed, err
+				}
+				newBatch()
+			}
+		}
+	}
+	n, err := runLowMemoryBatch(pair, plan, opts)
+	failed += n
+	if err != nil {
+		return failed, err
+	}
+	files.Names = names
+	files.Failed = retries.list()
+	return failed, saveLowMemoryState(state, pair, files, locals)
+}
+
+// localLookup looks up and records the local files in the state of a pair
+// one at a time.
+type localLookup interface {
+	LookupLocal(path string) (localFile, bool, error)
+	PutLocal(files []localFile) error
+}
+
+// localIndex holds the local files of a JSON state by path, which is read
+// and written as a whole anyway.
+type localIndex map[string]localFile
+
+func (idx localIndex) LookupLocal(path string) (localFile, bool, error) {
+	file, ok := idx[path]
+	return file, ok, nil
+}
+
+func (idx localIndex) PutLocal(files []localFile) error {
+	for _, file := range files {
+		idx[file.Path] = file
+	}
+	return nil
+}
+
+// loadLowMemoryState loads the state of the pair for a folder by folder
+// sync. The SQLite state leaves the local files in the database, where
+// they are looked up by path.
+func loadLowMemoryState(store stateStore, pair *syncPair) (*Files, localLookup, error) {
+	st, ok := store.(*state.SQLiteStore)
+	if !ok {
+		files, err := loadState(store, pair)
+		if err != nil {
+			return nil, nil, err
+		}
+		idx := make(localIndex, len(files.Local))
+		for _, file := range files.Local {
+			idx[file.Path] = file
+		}
+		files.Local = nil
+		return files, idx, nil
+	}
+	files, err := st.LoadMeta()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to load the state: %v", err)
+	}
+	if err := migrateState(files); err != nil {
+		return nil, nil, fmt.Errorf("unable to migrate the state: %v", err)
+	}
+	if stateHash(files) != pair.hash {
+		infof("The state of %s has %s checksums, hashing again with %s\n", pair, stateHash(files), pair.hash)
+		if err := st.ClearLocal(); err != nil {
+			return nil, nil, fmt.Errorf("unable to load the state: %v", err)
+		}
+	}
+	return files, st, nil
+}
+
+// saveLowMemoryState saves the state of a folder by folder sync, whose
+// local files are kept by locals.
+func saveLowMemoryState(store stateStore, pair *syncPair, files *Files, locals localLookup) error {
+	if idx, ok := locals.(localIndex); ok {
+		for _, file := range idx {
+			files.Local = append(files.Local, file)
+		}
+		sort.Slice(files.Local, func(i, j int) bool {
+			return filepath.ToSlash(files.Local[i].Path) < filepath.ToSlash(files.Local[j].Path)
+		})
+		return saveState(store, pair, files)
+	}
+	stampState(files, pair.hash)
+	if err := store.(*state.SQLiteStore).SaveMeta(files); err != nil {
+		return fmt.Errorf("unable to save the state: %v", err)
+	}
+	return nil
+}
+
+// localFolder reads the local directory of a remote folder's files once
+// and returns the local files at their paths, by pathKey. Files whose size
+// and mtime match their row in the state keep its checksum; the others are
+// hashed by checkers goroutines and their rows updated. The files which
+// couldn't be hashed are returned apart.
+func localFolder(pair *syncPair, idx *remoteIndex, children []drive.File, locals localLookup, checkers int) (map[string]*localFile, []failedTransfer, error) {
+	byPath := make(map[string]*localFile)
+	wanted := make(map[string]bool)
+	dir := ""
+	for _, child := range children {
+		if child.Md5Checksum == "" {
+			continue
+		}
+		if path, ok := pair.includeRemote(idx, child); ok {
+			wanted[pathKey(path)] = true
+			dir = filepath.Dir(filepath.FromSlash(path))
+		}
+	}
+	if len(wanted) == 0 {
+		return byPath, nil, nil
+	}
+	entries, err := os.ReadDir(filepath.Join(pair.Local, dir))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			// Its files would look missing and be downloaded over.
+			fmt.Println(paint(colorRed, fmt.Sprintf("%s: unable to read, not synced: %v", filepath.Join(pair.Local, dir), err)))
+			return byPath, []failedTransfer{{Path: filepath.ToSlash(filepath.Clean(dir)), Error: err.Error()}}, nil
+		}
+		return byPath, nil, nil
+	}
+	cached := make(map[string]*localFile)
+	var matched []fs.DirEntry
+	for _, entry := range entries {
+		rel := filepath.Join(dir, entry.Name())
+		if entry.IsDir() || !wanted[pathKey(rel)] {
+			continue
+		}
+		matched = append(matched, entry)
+		file, ok, err := locals.LookupLocal(rel)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			cached[rel] = &file
+		}
+	}
+	toHash := make(chan *localFile, len(matched))
+	wait := hashWorkers(pair, toHash, checkers)
+	sc := &scanner{pair: pair, cached: cached, toHash: toHash}
+	var found []*localFile
+	for _, entry := range matched {
+		if file := sc.visit(filepath.Join(dir, entry.Name()), entry); file != nil {
+			found = append(found, file)
+		}
+	}
+	close(toHash)
+	unhashed := wait()
+	skip := make(map[string]bool, len(unhashed))
+	for _, f := range unhashed {
+		skip[f.Path] = true
+	}
+	var changed []localFile
+	for _, file := range found {
+		if skip[filepath.ToSlash(file.Path)] {
+			continue
+		}
+		byPath[pathKey(file.Path)] = file
+		c := cached[file.Path]
+		if c == nil || c.Md5Checksum != file.Md5Checksum || c.Size != file.Size || !c.ModTime.Equal(file.ModTime) {
+			changed = append(changed, *file)
+		}
+	}
+	if len(changed) == 0 {
+		return byPath, unhashed, nil
+	}
+	return byPath, unhashed, locals.PutLocal(changed)
+}
+
+// runLowMemoryBatch runs the downloads of a batch, once confirmed if it
+// overwrites many files, and returns the number of failures. An error
+// reading the rules of the pair stops it before the batch runs.
+func runLowMemoryBatch(pair *syncPair, plan *downloadPlan, opts *runOptions) (int, error) {
+	if err := pair.ignore.failed(); err != nil {
+		return 0, err
+	}
+	if !confirmPlan(pair, nil, plan.overwrites, opts) {
+		return 1, nil
+	}
+	failed := plan.xfers.run()
+	stats.count(&stats.Downloaded, plan.xfers.succeeded())
+	return failed, nil
+}
+
+// folderStub keeps the fields of a folder needed to tell its path and
+// section.
+func folderStub(folder drive.File) drive.File {
+	return drive.File{
+		Id:               folder.Id,
+		Name:             folder.Name,
+		MimeType:         folder.MimeType,
+		Parents:          folder.Parents,
+		OwnedByMe:        folder.OwnedByMe,
+		SharedWithMeTime: folder.SharedWithMeTime,
+	}
+}
@@ -0,0 +1,1324 @@
+package syncer
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hiroshi/googledriveclient/auth"
+	"github.com/hiroshi/googledriveclient/checksum"
+	"github.com/hiroshi/googledriveclient/state"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/drivelabels/v2"
+	"google.golang.org/api/sheets/v4"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// driveScopes are the OAuth scopes asked for. If modifying them, delete
+// your previously saved credentials at
+// ~/.credentials/drive-go-quickstart.json
+var driveScopes = []string{drive.DriveScope, drivelabels.DriveLabelsReadonlyScope}
+
+// newDriveService returns the Drive service of httpOpts, authorized with
+// httpOpts.auth unless it is that of a stand-in. The Sheets and labels
+// services are set up with the first one.
+func newDriveService(httpOpts *httpOptions) (*drive.Service, error) {
+	base, err := httpOpts.client()
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	client := base
+	if httpOpts.endpoint == "" {
+		config := httpOpts.auth
+		config.Scopes = driveScopes
+		if client, err = config.Client(context.WithValue(ctx, oauth2.HTTPClient, base)); err != nil {
+			return nil, err
+		}
+	}
+	// A stand-in serves the APIs below its base URL.
+	options := func(path string) []option.ClientOption {
+		opts := []option.ClientOption{option.WithHTTPClient(client)}
+		if httpOpts.endpoint != "" {
+			opts = append(opts, option.WithEndpoint(strings.TrimSuffix(httpOpts.endpoint, "/")+path))
+		}
+		return opts
+	}
+	srv, err := drive.NewService(ctx, options("/drive/v3/")...)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve drive Client %v", err)
+	}
+	if sheetsService == nil {
+		if sheetsService, err = sheets.NewService(ctx, options("/")...); err != nil {
+			return nil, fmt.Errorf("Unable to retrieve sheets Client %v", err)
+		}
+	}
+	if labelsService == nil {
+		if labelsService, err = drivelabels.NewService(ctx, options("/")...); err != nil {
+			return nil, fmt.Errorf("Unable to retrieve labels Client %v", err)
+		}
+	}
+	return srv, nil
+}
+
+// driveService returns the Drive service of httpOpts, exiting if there is
+// none.
+func driveService(httpOpts *httpOptions) *drive.Service {
+	srv, err := newDriveService(httpOpts)
+	var authErr *auth.Error
+	if errors.As(err, &authErr) {
+		exitf(exitAuth, "%v", err)
+	} else if err != nil {
+		fatalf("%v", err)
+	}
+	return srv
+}
+
+// fileFields are the fields of drive.File the sync uses.
+const fileFields = "id, name, md5Checksum, sha256Checksum, mimeType, parents, size, modifiedTime, webViewLink, " +
+	"ownedByMe, sharedWithMeTime, trashed, capabilities(canEdit), appProperties, description, properties, starred, " +
+	"shortcutDetails(targetId, targetMimeType)"
+
+// notTrashed narrows the listing query q to the files out of the trash,
+// which Drive lists too otherwise.
+func notTrashed(q string) string {
+	if q == "" {
+		return "trashed = false"
+	}
+	return "(" + q + ") and trashed = false"
+}
+
+// listCheckpointInterval is how often a long listing saves its progress.
+const listCheckpointInterval = time.Minute
+
+// Read from remote. The listing continues at pageToken after the files
+// listed before, if any. checkpoint, if not nil, is called now and then
+// with the files listed so far and the token of the next page.
+func remote(srv *drive.Service, q string, files []drive.File, pageToken string, checkpoint func([]drive.File, string)) ([]drive.File, error) {
+	numFiles := len(files)
+	lastCheckpoint := time.Now()
+	resumed := pageToken != ""
+	for {
+		list := srv.Files.List().
+			PageSize(1000).
+			Fields("nextPageToken, files(" + fileFields + ")")
+		if q != "" {
+			list = list.Q(q)
+		}
+		if pageToken != "" {
+			list = list.PageToken(pageToken)
+		}
+		var r *drive.FileList
+		err := retry("Listing files", func() (err error) {
+			r, err = list.Do()
+			return err
+		})
+		var gerr *googleapi.Error
+		if errors.As(err, &gerr) && gerr.Code == http.StatusBadRequest && resumed {
+			// The token of an interrupted listing may have expired.
+			fmt.Printf("Unable to resume the listing, starting over: %v\n", err)
+			files, pageToken, numFiles, resumed = nil, "", 0, false
+			continue
+		}
+		resumed = false
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve files: %v", err)
+		}
+		numFiles += len(r.Files)
+		for _, i := range r.Files {
+			extra := ""
+			if i.Description != "" {
+				extra += fmt.Sprintf(", description: %q", i.Description)
+			}
+			if len(i.Properties) > 0 {
+				extra += ", properties: " + formatProperties(i.Properties)
+			}
+			if i.Starred {
+				extra += ", starred"
+			}
+			if i.Sha256Checksum != "" {
+				extra += ", sha256: " + i.Sha256Checksum
+			}
+			infof("%s (md5: %s, type: %s, id: %s, parents: %v%s)\n", i.Name, i.Md5Checksum, i.MimeType, i.Id, i.Parents, extra)
+			files = append(files, *i)
+		}
+		infof("count:%d\n\n", numFiles)
+		if r.NextPageToken == "" {
+			break
+		}
+		pageToken = r.NextPageToken
+		if stopping() {
+			if checkpoint != nil {
+				checkpoint(files, pageToken)
+			}
+			break
+		}
+		if checkpoint != nil && time.Since(lastCheckpoint) > listCheckpointInterval {
+			checkpoint(files, pageToken)
+			lastCheckpoint = time.Now()
+		}
+	}
+	return files, nil
+}
+
+// local scans the local tree of the pair. Files whose size and mtime match
+// their entry in cache keep its md5 checksum instead of being hashed again.
+// The others are hashed by checkers goroutines while up to checkers
+// directories are read at once; the result is sorted by path either way.
+// The files which couldn't be hashed are returned apart.
+func local(pair *syncPair, cache []localFile, checkers int) ([]localFile, []failedTransfer) {
+	cached := make(map[string]*localFile)
+	for i := range cache {
+		cached[cache[i].Path] = &cache[i]
+	}
+	toHash := make(chan *localFile, checkers)
+	wait := hashWorkers(pair, toHash, checkers)
+	sc := &scanner{pair: pair, cached: cached, toHash: toHash, sem: make(chan struct{}, checkers)}
+	found := sc.scanDir("")
+	close(toHash)
+	failed := append(wait(), sc.unreadable...)
+	unhashed := make(map[string]bool, len(failed))
+	for _, f := range failed {
+		unhashed[f.Path] = true
+	}
+	files := make([]localFile, 0, len(found))
+	for _, file := range found {
+		if !unhashed[filepath.ToSlash(file.Path)] {
+			files = append(files, *file)
+		}
+	}
+	// Walk order puts "a/b" before "a.txt"; the same order on every
+	// system makes the output of runs comparable.
+	sort.SliceStable(files, func(i, j int) bool {
+		return filepath.ToSlash(files[i].Path) < filepath.ToSlash(files[j].Path)
+	})
+	// fmt.Printf("files:%v", files)
+	return files, failed
+}
+
+// hashWorkers starts checkers goroutines hashing the files received from
+// toHash, relative to the local root of the pair, and returns a function waiting for them
+// once toHash is closed. It returns the files which couldn't be hashed.
+func hashWorkers(pair *syncPair, toHash <-chan *localFile, checkers int) func() []failedTransfer {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed []failedTransfer
+	for i := 0; i < checkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range toHash {
+				if stopping() {
+					continue
+				}
+				path := filepath.Join(pair.Local, file.Path)
+				sum, err := checksum.File(path, pair.hash)
+				if err != nil {
+					fmt.Println(paint(colorRed, fmt.Sprintf("%s: unable to hash, not synced: %v", file.Path, err)))
+					mu.Lock()
+					failed = append(failed, failedTransfer{Path: filepath.ToSlash(file.Path), Size: file.Size, ModTime: file.ModTime, Error: err.Error()})
+					mu.Unlock()
+					continue
+				}
+				file.Md5Checksum = sum
+				infof("%s (%s: %s)\n", file.Path, pair.hash, sum)
+			}
+		}()
+	}
+	return func() []failedTransfer {
+		wg.Wait()
+		return failed
+	}
+}
+
+// leaveUnhashed leaves the local files which couldn't be hashed, and the
+// directories which couldn't be read with all below them, out of the
+// comparison like excluded ones, so neither side's copy is transferred or
+// deleted this run.
+func (p *syncPair) leaveUnhashed(failed []failedTransfer) {
+	for _, f := range failed {
+		if p.unreadable == nil {
+			p.unreadable = make(map[string]bool)
+		}
+		p.unreadable[pathKey(f.Path)] = true
+	}
+}
+
+// scanner walks the local tree of a pair, reading sibling directories
+// concurrently.
+type scanner struct {
+	pair   *syncPair
+	cached map[string]*localFile
+	toHash chan<- *localFile
+	sem    chan struct{} // limits the directories read at once
+
+	mu sync.Mutex
+	// unreadable holds the directories which couldn't be read.
+	unreadable []failedTransfer
+}
+
+// scanDir returns the included files below the directory rel, "" being the
+// root, in the lexical order filepath.Walk visits them. Subdirectories are
+// read on their own goroutine while there are free slots in sem.
+func (s *scanner) scanDir(rel string) []*localFile {
+	dir := filepath.Join(s.pair.Local, rel)
+	// Unlike filepath.Walk, ReadDir doesn't stat every entry, which
+	// matters on network file systems.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		// Its files would look deleted.
+		fmt.Println(paint(colorRed, fmt.Sprintf("%s: unable to read, not synced: %v", dir, err)))
+		s.mu.Lock()
+		s.unreadable = append(s.unreadable, failedTransfer{Path: filepath.ToSlash(filepath.Clean(rel)), Error: err.Error()})
+		s.mu.Unlock()
+		return nil
+	}
+	s.pair.ignore.scanned(s.pair.Local, rel, entries)
+	results := make([][]*localFile, len(entries))
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		if stopping() {
+			break
+		}
+		childRel := filepath.Join(rel, entry.Name())
+		if !entry.IsDir() {
+			if file := s.visit(childRel, entry); file != nil {
+				results[i] = []*localFile{file}
+			}
+			continue
+		}
+		if s.pair.excluded(childRel, true) || (s.pair.MaxDepth > 0 && pathDepth(childRel) >= s.pair.MaxDepth) {
+			continue
+		}
+		select {
+		case s.sem <- struct{}{}:
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i] = s.scanDir(childRel)
+				<-s.sem
+			}(i)
+		default:
+			results[i] = s.scanDir(childRel)
+		}
+	}
+	wg.Wait()
+	var files []*localFile
+	for _, r := range results {
+		files = append(files, r...)
+	}
+	return files
+}
+
+// visit returns the local file at rel if the pair includes it, queuing it
+// for hashing unless its checksum is cached or not needed.
+func (s *scanner) visit(rel string, entry fs.DirEntry) *localFile {
+	pair := s.pair
+	path := filepath.Join(pair.Local, rel)
+	if pair.excluded(rel, false) {
+		return nil
+	}
+	f, err := entry.Info()
+	if err != nil {
+		log.Printf("Info(%s) with error: %v", path, err)
+		return nil
+	}
+	if !pair.sizeIncluded(f.Size()) || !pair.timeIncluded(f.ModTime()) ||
+		!mimeIncluded(localMimeType(path), pair.mimeIncludes, pair.mimeExcludes) {
+		return nil
+	}
+	file := &localFile{Path: rel, Size: f.Size(), ModTime: f.ModTime()}
+	if c, ok := s.cached[rel]; ok && c.Size == file.Size && c.ModTime.Equal(file.ModTime) {
+		file.Md5Checksum = c.Md5Checksum
+	}
+	if pair.Compare != compareMd5 || file.Md5Checksum != "" {
+		infof("%s (size: %d, mtime: %s)\n", rel, file.Size, file.ModTime.Format(time.RFC3339))
+		return file
+	}
+	s.toHash <- file
+	return file
+}
+
+const folderMimeType = "application/vnd.google-apps.folder"
+
+// remotePath returns the path of the file following first parents.
+func remotePath(idx *remoteIndex, file drive.File) string {
+	if p, ok := idx.folderPaths[file.Id]; ok {
+		return p
+	}
+	if len(file.Parents) > 0 {
+		if _, ok := idx.folders[file.Parents[0]]; ok {
+			return idx.folderPaths[file.Parents[0]] + "/" + idx.name(file)
+		}
+	}
+	return "/" + idx.name(file)
+}
+
+func remoteFolders(remote *[]drive.File) *map[string]drive.File {
+	folders := make(map[string]drive.File) // key: File.Id
+	for _, file := range *remote {
+		if file.MimeType == folderMimeType {
+			folders[file.Id] = file
+		}
+	}
+	return &folders
+}
+
+// Main runs the command line tool with the arguments of the process, and
+// exits.
+func Main() {
+	configFile := flag.String("config", "", "YAML or JSON file defining sync pairs and settings (default ~/.config/drive/config.yaml if it exists)")
+	var opts runOptions
+	flag.IntVar(&opts.checkers, "checkers", 4, "number of files hashed and directories read in parallel")
+	flag.IntVar(&opts.transfers, "transfers", 4, "number of simultaneous downloads or uploads")
+	bwlimit := flag.String("bwlimit", "", "bandwidth limit in bytes per second, e.g. 2M, UP:DOWN like 512k:4M, or a schedule like \"08:00,512k 23:00,off\"")
+	flag.IntVar(&maxRetries, "retries", maxRetries, "how many times to retry a Drive request failing with a rate limit or server error")
+	flag.IntVar(&maxAttempts, "max-attempts", maxAttempts, "how many runs in a row may fail to transfer a file before it is left alone until it changes, 0 for no limit")
+	var httpOpts httpOptions
+	flag.StringVar(&httpOpts.auth.ClientSecretFile, "client-secret", "client_secret.json", "OAuth client secret file of the application")
+	flag.StringVar(&httpOpts.auth.TokenFile, "token-file", "", "file caching the OAuth token (default ~/.credentials/drive-go-quickstart.json)")
+	var logFile logFileOptions
+	flag.StringVar(&logFile.file, "log-file", "", "write the output to this file instead, rotating it, e.g. for the daemon")
+	logMaxSize := flag.String("log-max-size", "100M", "size at which the log file is rotated")
+	logMaxAge := flag.String("log-max-age", "30d", "age after which rotated log files are removed, 0 to keep them")
+	flag.IntVar(&logFile.maxBackups, "log-max-backups", 10, "number of rotated log files kept, 0 for all")
+	flag.DurationVar(&httpOpts.connectTimeout, "connect-timeout", 30*time.Second, "timeout for connecting to Drive, TLS handshake included")
+	flag.DurationVar(&httpOpts.responseTimeout, "response-timeout", 5*time.Minute, "timeout for Drive to start answering a request, 0 for none")
+	flag.IntVar(&httpOpts.idleConns, "idle-conns", 16, "idle connections to Drive kept open for reuse")
+	flag.DurationVar(&httpOpts.idleTimeout, "idle-timeout", 90*time.Second, "how long idle connections are kept open")
+	flag.DurationVar(&httpOpts.keepAlive, "keep-alive", 30*time.Second, "interval of TCP keep-alive probes")
+	lowSpeedLimit := flag.String("low-speed-limit", "", "abort and retry requests slower than this many bytes per second for -low-speed-time, e.g. 1k")
+	flag.DurationVar(&httpOpts.lowSpeedTime, "low-speed-time", time.Minute, "how long a request may stay below -low-speed-limit")
+	proxy := flag.String("proxy", "", "proxy for Drive requests, e.g. http://proxy:3128 or socks5://proxy:1080, instead of HTTPS_PROXY")
+	flag.StringVar(&httpOpts.endpoint, "drive-endpoint", "", "base URL of a stand-in for the Drive API, e.g. that of the tests, sent no credentials")
+	flag.StringVar(&httpOpts.caCerts, "ca-cert", "", "PEM file of CA certificates to trust besides the system's, e.g. of a TLS intercepting proxy")
+	flag.DurationVar(&drivePacer.minSleep, "pacer-min-sleep", drivePacer.minSleep, "minimum time between Drive requests")
+	flag.BoolVar(&opts.relist, "relist", false, "list the remote side again instead of reusing the listing of the last run, as pairs with -delete always do")
+	flag.BoolVar(&opts.lowMemory, "low-memory", false, "sync download pairs with a remote root folder by folder instead of holding the whole remote listing")
+	flag.IntVar(&opts.confirmOver, "confirm-over", 50, "ask before a sync deleting or overwriting more than this many files, -1 never to ask")
+	flag.BoolVar(&opts.yes, "yes", false, "don't ask before deleting or overwriting files")
+	flag.BoolVar(&opts.yes, "force", false, "same as -yes")
+	flag.Var(filterFlag{&internalDomains, ""}, "internal-domain", "domain sharing-report doesn't report sharing with (repeatable, default the account's)")
+	hashName := flag.String("hash", hashMd5, "checksum files are compared by and the state keeps: md5, or sha256 where MD5 isn't allowed")
+	quotaCheck := flag.String("quota-check", quotaWarn, "when an upload needs more than the storage quota left: warn, abort the sync of the pair, or off not to check")
+	flag.DurationVar(&opts.interval, "interval", 5*time.Minute, "time between syncs in daemon mode")
+	flag.StringVar(&opts.metricsAddr, "metrics-addr", "", "address serving Prometheus metrics at /metrics in daemon mode, e.g. :9100")
+	flag.DurationVar(&opts.readyMaxAge, "ready-max-age", 0, "age of the last successful sync beyond which /readyz fails in daemon mode, 0 for 3 times -interval")
+	flag.StringVar(&opts.guiAddr, "gui-addr", "", "address serving the web dashboard in daemon mode, e.g. 127.0.0.1:8384")
+	flag.StringVar(&opts.controlSocket, "control-socket", "drive.sock", "UNIX socket the daemon takes ctl commands on, \"\" for none")
+	summaryJSON := flag.String("summary-json", "", "also write the run summary as JSON to this file, - for standard output")
+	orderBy := flag.String("order-by", "", "order of transfers: size, name or mtime, optionally followed by ,ascending or ,descending")
+	debugAddr := flag.String("debug-addr", "", "address serving pprof profiles and a status page, e.g. localhost:6060")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "export OpenTelemetry traces over OTLP/HTTP to this URL, e.g. http://localhost:4318")
+	reportCSV := flag.String("report-csv", "", "write every action of the run with its size, checksum and error to this CSV file")
+	reportHTML := flag.String("report-html", "", "write every action of the run with its size, checksum and error to this HTML page")
+	format := flag.String("format", formatText, "output format: text, or json for a JSON object per line on the standard output, the text going to the standard error")
+	colorMode := flag.String("color", "auto", "color the output: auto (on a terminal unless NO_COLOR is set), always or never")
+	notify := flag.String("notify", "", "desktop notifications: errors, or all to also tell when a sync finishes")
+	flag.StringVar(&hooks.webhook, "webhook", "", "POST the summary of each run as JSON to this URL, e.g. a Slack incoming webhook")
+	flag.StringVar(&hooks.smtpServer, "smtp-server", "", "SMTP server host:port sending an email when a run fails")
+	flag.StringVar(&hooks.smtpUser, "smtp-user", "", "user name for the SMTP server")
+	flag.StringVar(&hooks.smtpPassword, "smtp-password", "", "password for the SMTP server, better set as DRIVE_SMTP_PASSWORD")
+	flag.StringVar(&hooks.mailFrom, "mail-from", "", "sender of the failure emails")
+	flag.StringVar(&hooks.mailTo, "mail-to", "", "comma separated recipients of the failure emails")
+	tuiMode := flag.Bool("tui", false, "show the transfers full-screen, with keys to pause, skip a file or stop")
+	beQuiet := flag.Bool("q", false, "print only errors and the summary")
+	beVerbose := flag.Bool("v", false, "also print why each file is transferred or not")
+	wireDebug := flag.Bool("vv", false, "like -v, also logging the Drive API requests and responses without credentials")
+	printVersion := flag.Bool("version", false, "print the version and exit")
+	keyFile := flag.String("encryption-key-file", "", "file whose content is the key of pairs with -encrypt, e.g. 32 random bytes")
+	passphrase := flag.String("encryption-passphrase", "", "passphrase the key of pairs with -encrypt is derived from, better set as DRIVE_ENCRYPTION_PASSPHRASE")
+	stateBackend := flag.String("state", state.SQLite, "where to keep the state: sqlite (state.db), json (files.json) or json.gz (files.json.gz)")
+	var flags pairFlags
+	flags.register(flag.CommandLine)
+	// Invalid flags exit with exitConfig rather than the flag package's 2.
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(os.Args[1:]); err == flag.ErrHelp {
+		exit(exitInSync)
+	} else if err != nil {
+		exit(exitConfig)
+	}
+	if *printVersion {
+		fmt.Println(version)
+		exit(exitInSync)
+	}
+
+	cfg := &config{}
+	if *configFile == "" {
+		*configFile = os.Getenv(envName("config"))
+	}
+	if *configFile == "" {
+		*configFile = defaultConfigFile()
+	}
+	if *configFile != "" {
+		cfg = readConfig(*configFile)
+	}
+	// config validate reports the unknown keys with the other problems.
+	unknown := cfg.unknownKeys(flag.CommandLine)
+	if len(unknown) > 0 && flag.Arg(0) != "config" {
+		exitf(exitConfig, "%s: unknown settings: %s", *configFile, strings.Join(unknown, ", "))
+	}
+	sources, err := applySettings(flag.CommandLine, cfg.settings)
+	if err != nil {
+		exitf(exitConfig, "%v", err)
+	}
+
+	switch {
+	case *beQuiet && (*beVerbose || *wireDebug):
+		exitf(exitConfig, "-q can't be combined with -v or -vv")
+	case *beQuiet:
+		verbosity = quiet
+	case *wireDebug:
+		verbosity = wire
+	case *beVerbose:
+		verbosity = verbose
+	}
+	if opts.checkers < 1 {
+		opts.checkers = 1
+	}
+	if opts.transfers < 1 {
+		opts.transfers = 1
+	}
+	if opts.bwlimit, err = parseBwLimit(*bwlimit); err != nil {
+		exitf(exitConfig, "%v", err)
+	}
+	opts.bwlimit.follow()
+	if opts.order, err = parseOrder(*orderBy); err != nil {
+		exitf(exitConfig, "%v", err)
+	}
+	if opts.quotaCheck, err = parseQuotaCheck(*quotaCheck); err != nil {
+		exitf(exitConfig, "%v", err)
+	}
+	if flags.hash, err = parseHash(*hashName); err != nil {
+		exitf(exitConfig, "%v", err)
+	}
+	if httpOpts.lowSpeedLimit, err = parseRate(*lowSpeedLimit); err != nil {
+		exitf(exitConfig, "%v", err)
+	}
+	if httpOpts.proxy, err = parseProxy(*proxy); err != nil {
+		exitf(exitConfig, "%v", err)
+	}
+	if err := setupFormat(*format); err != nil {
+		exitf(exitConfig, "%v", err)
+	}
+	if notifications, err = newNotifier(*notify); err != nil {
+		exitf(exitConfig, "%v", err)
+	}
+	if err := hooks.validate(); err != nil {
+		exitf(exitConfig, "%v", err)
+	}
+	if *reportCSV != "" || *reportHTML != "" {
+		runReport = newReport(*reportCSV, *reportHTML)
+	}
+	if logFile.file != "" {
+		if logFile.maxSize, err = parseSize(*logMaxSize); err != nil {
+			exitf(exitConfig, "-log-max-size: %v", err)
+		}
+		if *logMaxAge != "0" {
+			since, err := parseTimeBound(*logMaxAge, time.Now())
+			if err != nil {
+				exitf(exitConfig, "-log-max-age: %v", err)
+			}
+			logFile.maxAge = time.Since(since)
+		}
+		atExit(logToFile(logFile))
+	}
+	if err := setupColor(*colorMode); err != nil {
+		exitf(exitConfig, "%v", err)
+	}
+
+	if encryptionSecret, err = loadEncryptionSecret(*keyFile, *passphrase); err != nil {
+		exitf(exitConfig, "Unable to load the encryption key: %v", err)
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		args = envArgs()
+	}
+	command := "sync"
+	var apiOpts apiOptions
+	if len(args) > 0 {
+		if c := lookupCommand(args[0]); c != nil {
+			command, args = c.name, args[1:]
+			if c.run != nil {
+				env := &commandEnv{httpOpts: &httpOpts, opts: &opts, flags: &flags, cfg: cfg, configFile: *configFile,
+					sources: sources, unknown: unknown, stateBackend: *stateBackend}
+				c.run(env, args)
+				exit(exitInSync)
+			}
+		}
+	}
+	if command == "serve" {
+		// The API syncs the pairs, which are set up below.
+		if len(args) > 0 && args[0] == "api" {
+			command = "serve api"
+			apiOpts, args = apiFlags(args[1:])
+		} else {
+			if err := serveCommand(driveService(&httpOpts), args); err != nil {
+				fatalf("Unable to serve: %v", err)
+			}
+			exit(exitInSync)
+		}
+	}
+	pairs, err := loadPairs(cfg, args, &flags)
+	if err != nil {
+		exitf(exitConfig, "%v", err)
+	}
+	for i := range pairs {
+		if (pairs[i].Encrypt || pairs[i].Obfuscate) && encryptionSecret == nil {
+			exitf(exitConfig, "pair %s: -encrypt and -obfuscate need -encryption-key-file or -encryption-passphrase", &pairs[i])
+		}
+	}
+	if *tuiMode && (command != "sync" || outputFormat != formatText || logFile.file != "") {
+		exitf(exitConfig, "-tui only applies to sync on a terminal, without -format json or -log-file")
+	}
+	if len(pairs) == 0 {
+		usage()
+		exit(exitConfig)
+	}
+	unlock, err := lockRun(pairs)
+	if err != nil {
+		fatalf("Unable to lock: %v", err)
+	}
+	// The daemon locks other roots when the config is reloaded.
+	atExit(func() { unlock() })
+	if command == "purge" {
+		for i := range pairs {
+			purgeTrash(&pairs[i])
+		}
+		exit(exitInSync)
+	}
+	if command == "prune" {
+		for i := range pairs {
+			pruneSnapshots(&pairs[i])
+		}
+		exit(exitInSync)
+	}
+
+	openState, closeState, err := openStates(*stateBackend)
+	if err != nil {
+		fatalf("Unable to open the state: %v", err)
+	}
+	atExit(closeState)
+	runs, closeRuns, err := openRunStore(*stateBackend)
+	if err != nil {
+		fatalf("Unable to open the state: %v", err)
+	}
+	atExit(closeRuns)
+	history = &runHistory{store: runs}
+
+	if *debugAddr != "" {
+		serveDebug(*debugAddr)
+	}
+	handleSignals()
+	stopTracing := func() {}
+	if *otlpEndpoint != "" {
+		stopTracing = setupTracing(*otlpEndpoint)
+	}
+	srv := driveService(&httpOpts)
+	q := mimeQuery(flags.mimeIncludes, flags.mimeExcludes)
+	if command == "serve api" {
+		fs, err := newRemoteFS(srv, "")
+		if err != nil {
+			fatalf("Unable to serve: %v", err)
+		}
+		a := &apiServer{srv: srv, fs: fs, pairs: pairs, openState: openState, q: q, opts: opts, token: apiOpts.token}
+		if err := serveAPI(a, apiOpts.addr, apiOpts.grpcAddr); err != nil {
+			fatalf("Unable to serve: %v", err)
+		}
+		exit(exitInSync)
+	}
+	if command == "check" {
+		diffs, err := checkAll(srv, pairs, q, &opts)
+		switch {
+		case stopping():
+			exit(exitInterrupted)
+		case err != nil:
+			exit(errorExitCode([]interface{}{err}))
+		case diffs > 0:
+			exit(exitChanged)
+		}
+		exit(exitInSync)
+	}
+	if command == "daemon" {
+		// reload reads the config file again, as at the start.
+		reload := func() (*config, []syncPair, error) {
+			c := &config{}
+			if *configFile != "" {
+				var err error
+				if c, err = parseConfig(*configFile); err != nil {
+					return nil, nil, err
+				}
+			}
+			if unknown := c.unknownKeys(flag.CommandLine); len(unknown) > 0 {
+				return nil, nil, fmt.Errorf("%s: unknown settings: %s", *configFile, strings.Join(unknown, ", "))
+			}
+			pairs, err := loadPairs(c, args, &flags)
+			return c, pairs, err
+		}
+		locked := pairs
+		relock := func(reloaded []syncPair) error {
+			unlock()
+			var err error
+			if unlock, err = lockRun(reloaded); err != nil {
+				// The pairs synced so far stay.
+				var err2 error
+				if unlock, err2 = lockRun(locked); err2 != nil {
+					unlock = func() {}
+					fatalf("Unable to lock: %v", err2)
+				}
+				return err
+			}
+			locked = reloaded
+			return nil
+		}
+		ctl := newDaemonControl(reload)
+		if opts.controlSocket != "" {
+			if err := ctl.listen(opts.controlSocket); err != nil {
+				exitf(exitConfig, "-control-socket: %v", err)
+			}
+		}
+		runDaemon(srv, pairs, openState, q, &opts, *summaryJSON, ctl, relock)
+		exit(exitInSync)
+	}
+	if *tuiMode {
+		if err := startTUI(); err != nil {
+			exitf(exitConfig, "%v", err)
+		}
+		atExit(stopTUI)
+	}
+	history.start(command)
+	failed, err := syncAll(srv, pairs, openState, q, &opts)
+	stopTUI()
+	stats.count(&stats.Errors, failed)
+	stats.print(*summaryJSON)
+	runReport.write(stats)
+	notifications.finished(stats, failed, true)
+	if !stopping() {
+		hooks.finished(stats, failed)
+	}
+	stopTracing()
+	code := runExitCode(stats, failed, err)
+	history.finish(stats, failed, code)
+	switch code {
+	case exitInterrupted:
+		fmt.Println("Interrupted, the next run continues where this one stopped")
+	case exitPartial:
+		log.Printf("%d transfers failed", failed)
+	}
+	exit(code)
+}
+
+// syncAll syncs every pair once and returns the number of failures. A pair
+// whose sync stops at an error counts as one, the others are synced still;
+// the first such error is returned. q narrows the listing of the whole
+// Drive like in remote.
+func syncAll(srv *drive.Service, pairs []syncPair, openState func(*syncPair) stateStore, q string, opts *runOptions) (int, error) {
+	listRemote := remoteLister(srv, q)
+	defer enterSpan("sync")()
+	failed := 0
+	var firstErr error
+	for i := 0; i < len(pairs) && !stopping(); i++ {
+		pair := &pairs[i]
+		// The root may have gone since it was locked, in the daemon.
+		err := checkRoot(pair)
+		if err == nil {
+			err = pair.loadKey(srv)
+		}
+		if err != nil {
+			log.Print(err)
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		end := enterSpan("pair", attribute.String("pair", pair.String()))
+		currentMu.Lock()
+		currentPair = pair.String()
+		currentMu.Unlock()
+		// A diff plans from the whole listing.
+		lowMemory := opts.lowMemory && !opts.planOnly
+		var n int
+		if lowMemory && pair.lowMemory() {
+			n, err = syncPairLowMemory(srv, pair, openState(pair), opts)
+		} else {
+			if lowMemory {
+				fmt.Printf("%s: can't be synced folder by folder, it needs a remote root, downloading without -delete and -parents primary\n", pair)
+			}
+			n, err = syncPairFiles(srv, pair, openState(pair), listRemote, opts)
+		}
+		failed += n
+		if err != nil {
+			log.Printf("Unable to sync %s: %v", pair, err)
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		end()
+	}
+	currentMu.Lock()
+	currentPair = ""
+	currentMu.Unlock()
+	return failed, firstErr
+}
+
+// runOptions holds the settings of a run which apply to all pairs.
+type runOptions struct {
+	checkers  int // number of files hashed and directories read in parallel
+	transfers int // number of simultaneous downloads or uploads
+	bwlimit   bwLimit
+	order     transferOrder
+	// relist lists the remote side even if the state has a listing.
+	relist bool
+	// lowMemory syncs pairs folder by folder where possible.
+	lowMemory bool
+	// A sync deleting or overwriting more than confirmOver files asks
+	// first, unless yes is set. confirmOver < 0 never asks.
+	confirmOver int
+	yes         bool
+	// quotaCheck is what an upload needing more than the storage quota
+	// left does: quotaWarn, quotaAbort or quotaOff.
+	quotaCheck string
+	// planOnly lists, scans and plans without changing anything, the
+	// planned transfers and deletions being emitted, for a diff.
+	planOnly bool
+	// interval, metricsAddr, readyMaxAge, guiAddr and controlSocket are
+	// the daemon's.
+	interval      time.Duration
+	metricsAddr   string
+	readyMaxAge   time.Duration
+	guiAddr       string
+	controlSocket string
+}
+
+// remoteLister returns the listRemote of syncPairFiles for a run. q narrows
+// the listing of the whole Drive like in remote.
+func remoteLister(srv *drive.Service, q string) func(*syncPair, *Files, func() error) ([]drive.File, string, error) {
+	// The whole Drive is listed at most once and shared by all pairs.
+	var all []drive.File
+	var rootId string
+	// Pairs syncing a subtree list it folder by folder instead, unless the
+	// whole Drive is listed already.
+	// A listing of the whole Drive saves its progress in the state of the
+	// pair it was made for, and continues there when it was interrupted.
+	return func(pair *syncPair, files *Files, save func() error) ([]drive.File, string, error) {
+		if rootId == "" {
+			var err error
+			if rootId, err = remoteRootId(srv); err != nil {
+				return nil, "", err
+			}
+		}
+		if pair.scopedListing() && all == nil {
+			files, ok, err := listSubtree(srv, pair.Remote, mimeQuery(pair.mimeIncludes, pair.mimeExcludes))
+			if err != nil {
+				return nil, "", err
+			}
+			if ok {
+				return files, rootId, nil
+			}
+			fmt.Printf("%s: no folder %s in My Drive, listing the whole Drive\n", pair, pair.Remote)
+		}
+		if all == nil {
+			var listed []drive.File
+			if files.ListToken != "" {
+				infof("Resume the listing after %d files\n", len(files.Remote))
+				listed = files.Remote
+			}
+			var err error
+			all, err = remote(srv, notTrashed(q), listed, files.ListToken,
+				func(listed []drive.File, next string) {
+					files.RootId, files.Remote, files.ListToken = rootId, listed, next
+					if err := save(); err != nil {
+						log.Printf("Unable to save the progress of the listing: %v", err)
+					}
+				})
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		return all, rootId, nil
+	}
+}
+
+// remoteTree returns the remote files of the pair among those listed.
+func (p *syncPair) remoteTree(rootId string, all []drive.File) []drive.File {
+	tree := p.plainTree(all)
+	if p.FollowShortcuts {
+		tree = followShortcuts(tree)
+	}
+	return p.remoteSubset(&Files{RootId: rootId, Remote: tree})
+}
+
+// syncPairFiles syncs a pair and returns the number of failed transfers.
+// An error stops the sync of the pair: the listing failed, or the state
+// couldn't be loaded or saved.
+func syncPairFiles(srv *drive.Service, pair *syncPair, state stateStore, listRemote func(*syncPair, *Files, func() error) ([]drive.File, string, error), opts *runOptions) (int, error) {
+	infof("Sync %s\n", pair)
+	pair.ignore = loadDriveIgnore(pair.Local)
+	if err := pair.loadLabeled(srv); err != nil {
+		return 0, err
+	}
+
+	files, err := loadState(state, pair)
+	if err != nil {
+		return 0, err
+	}
+	// After an interruption the transfers left are planned from the state
+	// saved before, without listing and scanning again.
+	j, err := openJournal(pair.journalFile())
+	if err != nil {
+		return 0, fmt.Errorf("unable to read the journal: %v", err)
+	}
+	// A snapshot is compared with the previous one and downloaded into a
+	// new directory.
+	scanned := pair
+	var snap *snapshot
+	if pair.Snapshots {
+		var err error
+		if snap, err = newSnapshot(pair.Local, j.resuming()); err != nil {
+			return 0, fmt.Errorf("unable to list the snapshots: %v", err)
+		}
+		scanned = snap.prevPair(pair)
+	}
+	// unhashed holds the local files which couldn't be hashed, failed
+	// without a transfer.
+	var unhashed []failedTransfer
+	if j.resuming() && len(files.Remote) > 0 {
+		infof("Resume %d transfers of an interrupted run\n", len(j.pending))
+	} else {
+		j.pending = nil
+		// A listing made with shortcuts followed, or not, doesn't do for the
+		// other way. Deletions are decided on a fresh listing, as files
+		// may have been added or removed since the last one.
+		if len(files.Remote) == 0 || files.ListToken != "" || opts.relist || pair.Delete || files.FollowedShortcuts != pair.FollowShortcuts {
+			var all []drive.File
+			done := stats.phase(phaseListing)
+			all, files.RootId, err = listRemote(pair, files, func() error { return saveState(state, pair, files) })
+			done()
+			if err != nil {
+				return 0, err
+			}
+			// An interrupted listing is incomplete; the listing of the
+			// whole Drive saved its progress.
+			if stopping() {
+				return 0, nil
+			}
+			files.ListToken = ""
+			files.Remote = pair.remoteTree(files.RootId, all)
+			files.FollowedShortcuts = pair.FollowShortcuts
+		}
+		done := stats.phase(phaseScanning)
+		if scanned != nil {
+			files.Local, unhashed = local(scanned, files.Local, opts.checkers)
+			pair.leaveUnhashed(unhashed)
+		} else {
+			files.Local = nil
+		}
+		done()
+		if stopping() {
+			return 0, nil
+		}
+	}
+	planned := enterSpan(spanPlanning)
+	idx := newRemoteIndex(files, pair.nameOptions())
+	if err := saveState(state, pair, files); err != nil {
+		return 0, err
+	}
+
+
+	remoteByPath := pair.remoteByLocalPath(idx, files.Remote)
+	// Nothing is transferred or deleted with the rules of a .driveignore
+	// or .drive.yaml file missing.
+	if err := pair.ignore.failed(); err != nil {
+		return 0, err
+	}
+	retries := newRetryQueue(files.Failed)
+	for _, f := range unhashed {
+		retries.fail(f)
+	}
+	xfers := newTransfers(opts.transfers, opts.bwlimit.limiter(pair), opts.order, retries)
+	xfers.journal = j
+	if pair.Direction == directionUpload {
+		planned()
+		failed := len(unhashed) + uploadPair(srv, pair, idx, files, remoteByPath, xfers, opts)
+		files.Failed = retries.list()
+		if err := saveState(state, pair, files); err != nil {
+			return failed, err
+		}
+		if !stopping() {
+			j.finish()
+		}
+		return failed, nil
+	}
+	var plan *downloadPlan
+	if snap != nil {
+		plan = newDownloadPlan(srv, snap.pair(pair), files.Local, xfers)
+		plan.linkDest = snap.prev
+	} else {
+		plan = newDownloadPlan(srv, pair, files.Local, xfers)
+	}
+	for _, remote := range sortedByPath(idx, files.Remote) {
+		plan.add(idx, remote)
+	}
+	planned()
+	if err := pair.ignore.failed(); err != nil {
+		return 0, err
+	}
+	// Deletions were done before the transfers of an interrupted run. A
+	// snapshot has only the remote files anyway.
+	var deletes []string
+	if pair.Delete && !j.resuming() && snap == nil {
+		deletes = pair.extraneousLocal(files, remoteByPath)
+	}
+	if opts.planOnly {
+		planDeletes(deletes)
+		return 0, nil
+	}
+	// A refused plan counts as a failure, for the exit code.
+	if !confirmPlan(pair, deletes, plan.overwrites, opts) {
+		return 1, nil
+	}
+	failed := len(unhashed)
+	if len(deletes) > 0 {
+		trashed, n := pair.trashExtraneousLocal(files, remoteByPath)
+		stats.count(&stats.Deleted, trashed)
+		failed += n
+		if err := saveState(state, pair, files); err != nil {
+			return failed, err
+		}
+	}
+	if snap != nil {
+		if err := snap.start(); err != nil {
+			return failed, fmt.Errorf("unable to start the snapshot: %v", err)
+		}
+		for _, link := range plan.links {
+			if err := link(); err != nil {
+				log.Print(err)
+				failed++
+			}
+		}
+	}
+	failed += xfers.run()
+	stats.count(&stats.Downloaded, xfers.succeeded())
+	files.Failed = retries.list()
+	if err := saveState(state, pair, files); err != nil {
+		return failed, err
+	}
+	// Failed downloads are left to the next snapshot, an interrupted
+	// run continues this one.
+	if snap != nil && !stopping() {
+		if err := snap.finish(); err != nil {
+			return failed, fmt.Errorf("unable to finish the snapshot: %v", err)
+		}
+	}
+	// The journal of an interrupted run tells the next what is left.
+	if !stopping() {
+		j.finish()
+	}
+	return failed, nil
+}
+
+// loadState loads the state of the pair, upgraded to state.Version.
+func loadState(state stateStore, pair *syncPair) (*Files, error) {
+	files, err := state.Load()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the state: %v", err)
+	}
+	if err := migrateState(files); err != nil {
+		return nil, fmt.Errorf("unable to migrate the state: %v", err)
+	}
+	// Checksums of another hash match nothing: list and hash again.
+	if stateHash(files) != pair.hash {
+		infof("The state of %s has %s checksums, listing and hashing again with %s\n", pair, stateHash(files), pair.hash)
+		files.Remote, files.Local, files.ListToken = nil, nil, ""
+	}
+	return files, nil
+}
+
+// downloadPlan compares remote files with the local tree and queues the
+// downloads of those missing or differing locally.
+type downloadPlan struct {
+	srv         *drive.Service
+	pair        *syncPair
+	localByMd5  map[string]*localFile
+	localByPath map[string]*localFile // key: pathKey(Path)
+	xfers       *transfers
+	// overwrites holds the paths of the downloads replacing a local
+	// file.
+	overwrites []string
+	// linkDest is the previous snapshot, when downloading into a new one.
+	// The files up to date in it are hardlinked by links.
+	linkDest string
+	links    []func() error
+}
+
+func newDownloadPlan(srv *drive.Service, pair *syncPair, local []localFile, xfers *transfers) *downloadPlan {
+	plan := &downloadPlan{
+		srv:         srv,
+		pair:        pair,
+		localByMd5:  make(map[string]*localFile),
+		localByPath: make(map[string]*localFile),
+		xfers:       xfers,
+	}
+	for i, file := range local {
+		plan.localByMd5[file.Md5Checksum] = &local[i]
+		plan.localByPath[pathKey(file.Path)] = &local[i]
+	}
+	return plan
+}
+
+// localPath returns where the file at rel lives locally, which may be a
+// name in another Unicode normalization form.
+func (plan *downloadPlan) localPath(rel string) string {
+	if file, ok := plan.localByPath[pathKey(rel)]; ok {
+		rel = file.Path
+	}
+	return filepath.Join(plan.pair.Local, rel)
+}
+
+// add queues the download of remote unless the pair leaves it out or it is
+// up to date locally.
+func (plan *downloadPlan) add(idx *remoteIndex, remote drive.File) {
+	pair, srv := plan.pair, plan.srv
+	if remote.Md5Checksum != "" {
+		path, ok := pair.includeRemote(idx, remote)
+		if !ok {
+			return
+		}
+		stats.count(&stats.Checked, 1)
+		// The file is up to date at its path only: one moved on the remote
+		// side is downloaded to its new path. A snapshot links the same
+		// content from the previous one, wherever it was.
+		var local *localFile
+		if l := plan.localByPath[pathKey(path)]; upToDate(pair.Compare, l, remote) {
+			local = l
+		} else if plan.linkDest != "" && pair.Compare == compareMd5 {
+			local = plan.localByMd5[remote.Md5Checksum]
+		}
+		if local == nil {
+			decide(path, actionDownload, pair.mismatch(pair.Compare, plan.localByPath[pathKey(path)], remote))
+			if plan.localByPath[pathKey(path)] != nil && plan.linkDest == "" {
+				plan.overwrites = append(plan.overwrites, path)
+			}
+			emit(event{Event: eventPlanned, Path: path, Action: actionDownload, Size: remote.Size, Md5: remote.Md5Checksum})
+			infof("%s (%s=%s)\n", paint(colorYellow, path), pair.hash, remote.Md5Checksum)
+			// download
+			localPath := plan.localPath(path)
+			mtime, _ := time.Parse(time.RFC3339, remote.ModifiedTime)
+			plan.xfers.add(path, remote.Size, mtime, func(job *transferJob) error {
+				if err := download(srv, remote, localPath, pair.contentKey(), job); err != nil {
+					return err
+				}
+				return pair.materializeParents(idx, remote, localPath)
+			})
+			return
+		}
+		decide(path, actionUpToDate, "as "+local.Path)
+		stats.count(&stats.Skipped, 1)
+		if plan.linkDest != "" {
+			source, localPath := filepath.Join(plan.linkDest, local.Path), plan.localPath(path)
+			plan.links = append(plan.links, func() error {
+				if err := linkFile(source, localPath); err != nil {
+					return err
+				}
+				if err := pair.materializeParents(idx, remote, localPath); err != nil {
+					log.Print(err)
+				}
+				return nil
+			})
+			return
+		}
+		if err := pair.materializeParents(idx, remote, filepath.Join(pair.Local, local.Path)); err != nil {
+			log.Print(err)
+		}
+	} else if isNative(remote) {
+		rel, ok := pair.includeRemote(idx, remote)
+		if !ok {
+			return
+		}
+		policy, formats := pair.nativeFor(rel)
+		rel, ok = nativeLocalPath(rel, remote, policy, formats)
+		if !ok {
+			return
+		}
+		stats.count(&stats.Checked, 1)
+		localPath := plan.localPath(rel)
+		current := localPath
+		if plan.linkDest != "" {
+			current = filepath.Join(plan.linkDest, rel)
+		}
+		if nativeUpToDate(current, remote, policy) {
+			decide(rel, actionUpToDate, "not modified since "+remote.ModifiedTime)
+			stats.count(&stats.Skipped, 1)
+			if current != localPath {
+				plan.links = append(plan.links, func() error {
+					if err := linkFile(current, localPath); err != nil {
+						return err
+					}
+					if policy == nativeExport && formats[remote.MimeType].MimeType == markdownMimeType {
+						return linkAssets(current, localPath)
+					}
+					return nil
+				})
+			}
+			return
+		}
+		decide(rel, actionExport, "modified "+remote.ModifiedTime)
+		if _, err := os.Stat(localPath); err == nil {
+			plan.overwrites = append(plan.overwrites, rel)
+		}
+		emit(event{Event: eventPlanned, Path: rel, Action: actionExport})
+		infof("%s (%s) => %s\n", paint(colorYellow, rel), remote.MimeType, localPath)
+		mtime, _ := time.Parse(time.RFC3339, remote.ModifiedTime)
+		plan.xfers.add(rel, 0, mtime, func(job *transferJob) error {
+			if err := materializeNative(srv, remote, localPath, policy, formats, job); err != nil {
+				return err
+			}
+			return protectReadOnly(localPath, remote)
+		})
+	} else if remote.MimeType == colabMimeType {
+		rel, ok := pair.includeRemote(idx, remote)
+		if !ok {
+			return
+		}
+		stats.count(&stats.Checked, 1)
+		rel = notebookPath(rel)
+		localPath := plan.localPath(rel)
+		current := localPath
+		if plan.linkDest != "" {
+			current = filepath.Join(plan.linkDest, rel)
+		}
+		// Notebooks have no checksum either, they are compared by
+		// modification time like exports.
+		if nativeUpToDate(current, remote, nativeExport) {
+			decide(rel, actionUpToDate, "not modified since "+remote.ModifiedTime)
+			stats.count(&stats.Skipped, 1)
+			if current != localPath {
+				plan.links = append(plan.links, func() error { return linkFile(current, localPath) })
+			}
+			return
+		}
+		decide(rel, actionDownload, "modified "+remote.ModifiedTime)
+		if _, err := os.Stat(localPath); err == nil && plan.linkDest == "" {
+			plan.overwrites = append(plan.overwrites, rel)
+		}
+		emit(event{Event: eventPlanned, Path: rel, Action: actionDownload, Size: remote.Size})
+		infof("%s (%s)\n", paint(colorYellow, rel), remote.MimeType)
+		mtime, _ := time.Parse(time.RFC3339, remote.ModifiedTime)
+		plan.xfers.add(rel, remote.Size, mtime, func(job *transferJob) error {
+			return download(srv, remote, localPath, nil, job)
+		})
+	}
+}
+
+// download saves the content of a remote file at localPath with the remote
+// modification time and permissions, decrypted with key if it isn't nil,
+// decompressed if it was compressed on upload and put together from its
+// chunks if it was chunked.
+func download(srv *drive.Service, remote drive.File, localPath string, key *[32]byte, job *transferJob) error {
+	err := retry("Download of "+remote.Name, func() error {
+		job.restart()
+		if remote.AppProperties[propChunks] != "" {
+			content, err := chunkedContent(srv, remote, key, job)
+			if err != nil {
+				return err
+			}
+			return saveFile(localPath, job.reader(content))
+		}
+		resp, err := srv.Files.Get(contentId(remote)).Context(job.ctx).Download()
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		content, closeContent, err := openContent(resp.Body, key, remote.AppProperties[propCompression])
+		if err != nil {
+			return err
+		}
+		defer closeContent()
+		return saveFile(localPath, job.reader(content))
+	})
+	if err != nil {
+		return fmt.Errorf("download failed: %v", err)
+	}
+	if err := setModTime(localPath, remote); err != nil {
+		return err
+	}
+	return protectReadOnly(localPath, remote)
+}
+
+func saveState(state stateStore, pair *syncPair, files *Files) error {
+	stampState(files, pair.hash)
+	if err := state.Save(files); err != nil {
+		return fmt.Errorf("unable to save the state: %v", err)
+	}
+	return nil
+}
+
+// stampState records the state version and the checksum hash in files
+// before they are saved.
+func stampState(files *Files, hash string) {
+	files.Version = state.Version
+	files.Hash = ""
+	if hash != hashMd5 {
+		files.Hash = hash
+	}
+}
+
+// setModTime gives the local copy of a remote file the remote modification
+// time, so the mtime comparison strategies see them as equal.
+func setModTime(localPath string, remote drive.File) error {
+	t, err := time.Parse(time.RFC3339, remote.ModifiedTime)
+	if err != nil {
+		return nil
+	}
+	return os.Chtimes(localPath, t, t)
+}
+
+// saveFile writes r to localPath, creating missing parent directories. The
+// content goes to a temporary file first which then replaces localPath, so
+// read-only files can be updated and readers never see a partial file.
+func saveFile(localPath string, r io.Reader) error {
+	dir := filepath.Dir(localPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	out, err := ioutil.TempFile(dir, ".drive-tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return fmt.Errorf("writing %s failed: %w", localPath, err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return fmt.Errorf("writing %s failed: %v", localPath, err)
+	}
+	if err := os.Chmod(out.Name(), 0644); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	if err := os.Rename(out.Name(), localPath); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package syncer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+const documentMimeType = googleAppsPrefix + "document"
+
+// docsMarkdown is the export format of documents in pairs with DocsMarkdown
+// set. Any export to markdownMimeType has its images extracted.
+var docsMarkdown = exportFormat{MimeType: markdownMimeType, Extension: ".md"}
+
+const markdownMimeType = "text/markdown"
+
+// markdownImage matches the images Drive embeds in Markdown exports as data
+// URIs, such as [image1]: <data:image/png;base64,iVBOR...>.
+var markdownImage = regexp.MustCompile(`data:(image/[\w.+-]+);base64,([A-Za-z0-9+/=]+)`)
+
+// imageExtensions are the extensions of the images of Markdown exports.
+var imageExtensions = map[string]string{
+	"image/png":     ".png",
+	"image/jpeg":    ".jpg",
+	"image/gif":     ".gif",
+	"image/svg+xml": ".svg",
+	"image/webp":    ".webp",
+	"image/bmp":     ".bmp",
+}
+
+// markdownAssets returns the directory the images of the Markdown export
+// at localPath go to: Notes.md has them in Notes.assets.
+func markdownAssets(localPath string) string {
+	return strings.TrimSuffix(localPath, filepath.Ext(localPath)) + ".assets"
+}
+
+// exportMarkdown exports the document file to Markdown at localPath, with
+// its images written to the files of markdownAssets rather than inline.
+// The assets are written to a temporary directory first which then
+// replaces the previous ones.
+func exportMarkdown(srv *drive.Service, file drive.File, localPath string, job *transferJob) error {
+	var md []byte
+	err := retry("Export of "+file.Name, func() error {
+		job.restart()
+		resp, err := srv.Files.Export(contentId(file), markdownMimeType).Context(job.ctx).Download()
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		md, err = ioutil.ReadAll(job.reader(resp.Body))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("export failed: %v", err)
+	}
+	assets := markdownAssets(localPath)
+	if err := os.MkdirAll(filepath.Dir(assets), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(filepath.Dir(assets), ".drive-tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return err
+	}
+	images := 0
+	var imageErr error
+	md = markdownImage.ReplaceAllFunc(md, func(uri []byte) []byte {
+		m := markdownImage.FindSubmatch(uri)
+		data, err := base64.StdEncoding.DecodeString(string(m[2]))
+		if err != nil {
+			return uri
+		}
+		ext, ok := imageExtensions[string(m[1])]
+		if !ok {
+			ext = ".bin"
+		}
+		images++
+		name := fmt.Sprintf("image%d%s", images, ext)
+		if err := ioutil.WriteFile(filepath.Join(tmp, name), data, 0644); err != nil && imageErr == nil {
+			imageErr = err
+		}
+		link := url.URL{Path: filepath.Base(assets) + "/" + name}
+		return []byte(link.String())
+	})
+	if imageErr != nil {
+		return imageErr
+	}
+	if err := os.RemoveAll(assets); err != nil {
+		return err
+	}
+	if images > 0 {
+		if err := os.Rename(tmp, assets); err != nil {
+			return err
+		}
+	}
+	return saveFile(localPath, bytes.NewReader(md))
+}
+
+// linkAssets links the images of the Markdown export at source, if any, to
+// those of the one at localPath, like linkFile.
+func linkAssets(source, localPath string) error {
+	if _, err := os.Stat(markdownAssets(source)); err != nil {
+		return nil
+	}
+	return linkFile(markdownAssets(source), markdownAssets(localPath))
+}
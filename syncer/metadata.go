@@ -0,0 +1,55 @@
+package syncer
+
+import (
+	"fmt"
+	"sync"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// metadataConcurrency is how many metadata updates run at once. The Go
+// client has no support for the batch endpoint, so groups of concurrent
+// requests, kept in check by the pacer, take its place.
+const metadataConcurrency = 8
+
+// metadataUpdate changes the metadata of a remote file, such as its
+// modification time or trashed flag, without touching its content.
+type metadataUpdate struct {
+	name   string // shown in messages
+	fileId string
+	change *drive.File
+}
+
+// updateMetadata applies the updates and returns the updated files by id
+// and the number of failed updates. A failure is reported and doesn't stop
+// the other updates.
+func updateMetadata(srv *drive.Service, updates []metadataUpdate) (map[string]drive.File, int) {
+	updated := make(map[string]drive.File)
+	failed := 0
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, metadataConcurrency)
+	for _, update := range updates {
+		update := update
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			var r *drive.File
+			err := retry("Updating "+update.name, func() (err error) {
+				r, err = srv.Files.Update(update.fileId, update.change).Fields(fileFields).Do()
+				return err
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				fmt.Printf("%s: update failed: %v\n", update.name, err)
+				failed++
+				return
+			}
+			updated[r.Id] = *r
+		}()
+	}
+	wg.Wait()
+	return updated, failed
+}
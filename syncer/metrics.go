@@ -0,0 +1,101 @@
+package syncer
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// syncMetrics accumulates the stats of the daemon's runs for /metrics.
+type syncMetrics struct {
+	mu          sync.Mutex
+	totals      runStats
+	runs        int64
+	failedRuns  int64
+	lastRun     time.Time
+	lastSuccess time.Time
+}
+
+var daemonMetrics = &syncMetrics{}
+
+// startRun replaces the stats for a new run and returns them.
+func (m *syncMetrics) startRun() *runStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats = newRunStats()
+	return stats
+}
+
+// record adds the stats of a finished run.
+func (m *syncMetrics) record(s *runStats, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.totals.Checked += s.Checked
+	m.totals.Downloaded += s.Downloaded
+	m.totals.Uploaded += s.Uploaded
+	m.totals.Updated += s.Updated
+	m.totals.Deleted += s.Deleted
+	m.totals.Errors += s.Errors
+	m.totals.Bytes += s.Bytes
+	m.runs++
+	m.lastRun = time.Now()
+	if ok {
+		m.lastSuccess = m.lastRun
+	} else {
+		m.failedRuns++
+	}
+}
+
+// serveMetrics writes the metrics in the Prometheus text format. Counters
+// of the run in progress are included.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	m := daemonMetrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current := stats
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	metric("drive_sync_files_total", "counter", "Files synced by operation.")
+	for _, op := range []struct {
+		name    string
+		total   int64
+		running *int64
+	}{
+		{"checked", m.totals.Checked, &current.Checked},
+		{"downloaded", m.totals.Downloaded, &current.Downloaded},
+		{"uploaded", m.totals.Uploaded, &current.Uploaded},
+		{"updated", m.totals.Updated, &current.Updated},
+		{"deleted", m.totals.Deleted, &current.Deleted},
+	} {
+		fmt.Fprintf(w, "drive_sync_files_total{op=%q} %d\n", op.name, op.total+atomic.LoadInt64(op.running))
+	}
+	metric("drive_sync_bytes_transferred_total", "counter", "Bytes downloaded or uploaded.")
+	fmt.Fprintf(w, "drive_sync_bytes_transferred_total %d\n", m.totals.Bytes+atomic.LoadInt64(&current.Bytes))
+	metric("drive_sync_errors_total", "counter", "Failed transfers and updates of finished runs.")
+	fmt.Fprintf(w, "drive_sync_errors_total %d\n", m.totals.Errors)
+	metric("drive_sync_api_calls_total", "counter", "Drive API requests.")
+	fmt.Fprintf(w, "drive_sync_api_calls_total %d\n", atomic.LoadInt64(&apiCalls))
+	metric("drive_sync_api_retries_total", "counter", "Drive API requests retried after a transient error.")
+	fmt.Fprintf(w, "drive_sync_api_retries_total %d\n", atomic.LoadInt64(&apiRetries))
+	metric("drive_sync_queue_depth", "gauge", "Transfers waiting to start.")
+	fmt.Fprintf(w, "drive_sync_queue_depth %d\n", atomic.LoadInt64(&transfersQueued))
+	metric("drive_sync_runs_total", "counter", "Finished sync runs by result.")
+	fmt.Fprintf(w, "drive_sync_runs_total{result=\"success\"} %d\n", m.runs-m.failedRuns)
+	fmt.Fprintf(w, "drive_sync_runs_total{result=\"failure\"} %d\n", m.failedRuns)
+	metric("drive_sync_last_run_timestamp_seconds", "gauge", "When the last sync run finished.")
+	fmt.Fprintf(w, "drive_sync_last_run_timestamp_seconds %d\n", unixOrZero(m.lastRun))
+	metric("drive_sync_last_success_timestamp_seconds", "gauge", "When the last sync run without errors finished.")
+	fmt.Fprintf(w, "drive_sync_last_success_timestamp_seconds %d\n", unixOrZero(m.lastSuccess))
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
@@ -0,0 +1,264 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/hiroshi/googledriveclient/drivefs"
+	"google.golang.org/api/drive/v3"
+)
+
+// migrateCommand runs migrate: it copies the tree below a folder of the
+// account of -token-file to a folder of another account, files with the
+// same checksum of hash being left alone.
+func migrateCommand(src *drive.Service, httpOpts *httpOptions, hash string, args []string) error {
+	const usage = "usage: migrate -to-token-file file [-to remotePath] [-server-side=false] remotePath"
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	toToken := fs.String("to-token-file", "", "`file` caching the OAuth token of the account copied to, which signs in on first use")
+	toEndpoint := fs.String("to-endpoint", "", "base `URL` of a stand-in for the Drive API of the account copied to")
+	to := fs.String("to", "", "remote `folder` of the account copied to, created if missing (default My Drive)")
+	serverSide := fs.Bool("server-side", true, "share the tree with the account copied to and copy it on Drive, downloading and uploading only what can't be copied")
+	if err := fs.Parse(args); err != nil {
+		exit(exitConfig)
+	}
+	if fs.NArg() != 1 || (*toToken == "" && *toEndpoint == "") {
+		exitf(exitConfig, usage)
+	}
+	// The other account signs in with its own token file.
+	dstOpts := *httpOpts
+	dstOpts.endpoint = *toEndpoint
+	dstOpts.auth.TokenFile = *toToken
+	dst := driveService(&dstOpts)
+
+	handleSignals()
+	m := &migration{src: src, dst: dst, ctx: runCtx, hash: hash}
+	srcUser, err := accountEmail(src)
+	if err != nil {
+		return err
+	}
+	dstUser, err := accountEmail(dst)
+	if err != nil {
+		return err
+	}
+	if srcUser != "" && srcUser == dstUser {
+		exitf(exitConfig, "-token-file and -to-token-file are both of %s", srcUser)
+	}
+	source, err := resolveRemote(src, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if m.from, err = newRemoteFS(src, source); err != nil {
+		return err
+	}
+	m.from.Natives = true
+	target, err := resolveRemote(dst, *to)
+	if err != nil {
+		return err
+	}
+	root, err := newRemoteFS(dst, "")
+	if err != nil {
+		return err
+	}
+	if err := root.MkdirAll(m.ctx, target); err != nil {
+		return err
+	}
+	if m.to, err = newRemoteFS(dst, target); err != nil {
+		return err
+	}
+	// Files are copied on Drive when the account copied to can read them,
+	// which sharing the source folder gives it for the time of the run.
+	if *serverSide && source != "" && dstUser != "" {
+		unshare, err := m.share(dstUser)
+		if err != nil {
+			log.Printf("Unable to share %s with %s, downloading and uploading instead: %v", source, dstUser, err)
+		} else {
+			m.shared = true
+			defer unshare()
+		}
+	}
+	from, err := m.from.Stat(m.ctx, "/")
+	if err != nil {
+		return err
+	}
+	err = m.walk(from, "")
+	fmt.Printf("Copied %d files on Drive, transferred %d (%s), %d up to date, %d failed\n", m.copied, m.transferred, formatBytes(m.bytes), m.skipped, m.failed)
+	if err != nil {
+		return err
+	}
+	if m.failed > 0 {
+		exit(exitPartial)
+	}
+	return nil
+}
+
+// accountEmail returns the email address of the account of srv, "" if
+// Drive doesn't tell it.
+func accountEmail(srv *drive.Service) (string, error) {
+	var about *drive.About
+	err := retry("Getting the account", func() (err error) {
+		about, err = srv.About.Get().Fields("user(emailAddress)").Do()
+		return err
+	})
+	if err != nil || about.User == nil {
+		return "", err
+	}
+	return about.User.EmailAddress, nil
+}
+
+// migration copies a remote tree from one account to another.
+type migration struct {
+	src, dst *drive.Service
+	from, to *drivefs.FS
+	ctx      context.Context
+	hash     string
+	// shared is set when the account copied to can read the source tree.
+	shared bool
+
+	copied      int
+	transferred int
+	skipped     int
+	failed      int
+	bytes       int64
+}
+
+// share gives the account email read access to the source tree, without
+// notifying it, and returns the function taking it back.
+func (m *migration) share(email string) (func(), error) {
+	var p *drive.Permission
+	err := retry("Sharing with "+email, func() (err error) {
+		p, err = m.src.Permissions.Create(m.from.RootId(), &drive.Permission{Type: "user", Role: "reader", EmailAddress: email}).
+			SendNotificationEmail(false).Fields("id").Context(m.ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		err := retry("Unsharing with "+email, func() error {
+			return m.src.Permissions.Delete(m.from.RootId(), p.Id).Context(context.Background()).Do()
+		})
+		if err != nil {
+			log.Printf("Unable to take back the access of %s: %v", email, err)
+		}
+	}, nil
+}
+
+// walk copies the files below the folder dir at the slash separated path
+// rel, creating the folders on the other side with the same modification
+// times.
+func (m *migration) walk(dir drivefs.FileInfo, rel string) error {
+	infos, err := m.from.ReadDir(m.ctx, dir)
+	if err != nil {
+		return err
+	}
+	if err := m.to.MkdirAll(m.ctx, rel); err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if stopping() {
+			return errors.New("interrupted")
+		}
+		childRel := path.Join(rel, info.Name())
+		if info.IsDir() {
+			if err := m.walk(info, childRel); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := m.file(info, childRel); err != nil {
+			fmt.Println(paint(colorRed, fmt.Sprintf("%s failed: %v", childRel, err)))
+			m.failed++
+		}
+	}
+	// Last, as adding to the folder changes its modification time.
+	if rel == "" || dir.File.ModifiedTime == "" {
+		return nil
+	}
+	return m.to.Update(m.ctx, rel, &drive.File{ModifiedTime: dir.File.ModifiedTime})
+}
+
+// file copies the file info to rel, on Drive if the account copied to can
+// read it and nothing is at rel yet, else by downloading and uploading it.
+// A file with the same content at rel is left alone.
+func (m *migration) file(info drivefs.FileInfo, rel string) error {
+	existing, err := m.to.Stat(m.ctx, rel)
+	if sum := remoteChecksum(info.File, m.hash); err == nil && sum != "" && remoteChecksum(existing.File, m.hash) == sum {
+		decide(rel, actionUpToDate, m.hash+" "+sum)
+		m.skipped++
+		return nil
+	}
+	native := strings.HasPrefix(info.File.MimeType, googleAppsPrefix)
+	if m.shared && existing.File.Id == "" {
+		if err = m.copy(info, rel); err == nil {
+			m.copied++
+			return nil
+		}
+		if native {
+			return err
+		}
+		log.Printf("Unable to copy %s on Drive, downloading and uploading it: %v", rel, err)
+	}
+	if native {
+		return errors.New("Google documents can only be copied on Drive, which needs sharing")
+	}
+	return m.transfer(info, rel)
+}
+
+// copy copies the file info to rel on Drive, as the account copied to.
+func (m *migration) copy(info drivefs.FileInfo, rel string) error {
+	parent, err := m.to.Stat(m.ctx, path.Dir(rel))
+	if err != nil {
+		return err
+	}
+	defer m.to.Forget(parent.File.Id)
+	infof("%s (copy)\n", rel)
+	return retry("Copy of "+rel, func() error {
+		_, err := m.dst.Files.Copy(info.File.Id, &drive.File{Name: path.Base(rel), Parents: []string{parent.File.Id}, ModifiedTime: info.File.ModifiedTime}).
+			Fields("id").Context(m.ctx).Do()
+		return err
+	})
+}
+
+// transfer downloads the file info and uploads it to rel, with its
+// modification time.
+func (m *migration) transfer(info drivefs.FileInfo, rel string) error {
+	tmp, err := ioutil.TempFile("", "drive-migrate-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	infof("%s\n", rel)
+	err = retry("Download of "+rel, func() error {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := tmp.Truncate(0); err != nil {
+			return err
+		}
+		resp, err := m.src.Files.Get(info.File.Id).Context(m.ctx).Download()
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.Copy(tmp, resp.Body)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := m.to.Upload(m.ctx, rel, tmp, info.ModTime()); err != nil {
+		return err
+	}
+	m.transferred++
+	m.bytes += info.Size()
+	return nil
+}
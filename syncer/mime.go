@@ -0,0 +1,82 @@
+package syncer
+
+import (
+	"mime"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// mimeIncluded reports whether a file of the given MIME type passes the
+// --mime-include and --mime-exclude patterns. Folders always pass so that
+// paths can still be resolved.
+func mimeIncluded(mimeType string, includes, excludes []string) bool {
+	if mimeType == folderMimeType {
+		return true
+	}
+	for _, pattern := range excludes {
+		if ok, _ := path.Match(pattern, mimeType); ok {
+			return false
+		}
+	}
+	if len(includes) == 0 {
+		return true
+	}
+	for _, pattern := range includes {
+		if ok, _ := path.Match(pattern, mimeType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// localMimeType guesses the MIME type of a local file from its extension.
+func localMimeType(name string) string {
+	t := mime.TypeByExtension(filepath.Ext(name))
+	if i := strings.IndexByte(t, ';'); i >= 0 {
+		t = t[:i]
+	}
+	return t
+}
+
+// mimeQuery translates MIME patterns into a Files.List query so that
+// filtered files are not listed at all. Patterns which can't be expressed
+// are left to mimeIncluded; an empty string means no query.
+func mimeQuery(includes, excludes []string) string {
+	var clauses []string
+	var terms []string
+	for _, pattern := range includes {
+		term, ok := mimeTerm(pattern)
+		if !ok {
+			// Can't narrow the listing without dropping matches.
+			terms = nil
+			break
+		}
+		terms = append(terms, term)
+	}
+	if len(terms) > 0 {
+		clauses = append(clauses, "("+strings.Join(terms, " or ")+")")
+	}
+	for _, pattern := range excludes {
+		if term, ok := mimeTerm(pattern); ok {
+			clauses = append(clauses, "not "+term)
+		}
+	}
+	if len(clauses) == 0 {
+		return ""
+	}
+	return "mimeType = '" + folderMimeType + "' or (" + strings.Join(clauses, " and ") + ")"
+}
+
+// mimeTerm translates "type/sub" or "prefix*" to a query term.
+func mimeTerm(pattern string) (string, bool) {
+	prefix := strings.TrimSuffix(pattern, "*")
+	if strings.ContainsAny(prefix, "*?[") {
+		return "", false
+	}
+	prefix = strings.Replace(prefix, `'`, `\'`, -1)
+	if prefix != pattern {
+		return "mimeType contains '" + prefix + "'", true
+	}
+	return "mimeType = '" + prefix + "'", true
+}
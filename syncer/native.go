@@ -0,0 +1,179 @@
+package syncer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Policies for Google-native documents (Docs, Sheets, ...), which have no
+// binary content or md5Checksum.
+const (
+	nativeSkip   = "skip"   // leave them out
+	nativeExport = "export" // export them to an office format
+	nativeGdoc   = "gdoc"   // write a .gdoc style JSON stub pointing at the document
+	nativeURL    = "url"    // write a .url internet shortcut
+)
+
+const googleAppsPrefix = "application/vnd.google-apps."
+
+// shortcutMimeType is the type of shortcuts to other files.
+const shortcutMimeType = googleAppsPrefix + "shortcut"
+
+// colabMimeType is the type of Colab notebooks. They have content, the
+// notebook JSON, but no md5Checksum.
+const colabMimeType = "application/vnd.google.colaboratory"
+
+// notebookPath returns the local path of the Colab notebook at rel, with the
+// .ipynb extension Jupyter expects.
+func notebookPath(rel string) string {
+	if strings.HasSuffix(strings.ToLower(rel), ".ipynb") {
+		return rel
+	}
+	return rel + ".ipynb"
+}
+
+type exportFormat struct {
+	MimeType  string `json:"mimeType"`
+	Extension string `json:"extension"`
+}
+
+// exportFormats maps Google-native types to the format they are exported to.
+// The config's exportFormats are merged into it.
+var exportFormats = map[string]exportFormat{
+	documentMimeType:                  {"application/vnd.openxmlformats-officedocument.wordprocessingml.document", ".docx"},
+	spreadsheetMimeType:               {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", ".xlsx"},
+	googleAppsPrefix + "presentation": {"application/pdf", ".pdf"},
+	googleAppsPrefix + "drawing":      {"image/svg+xml", ".svg"},
+	googleAppsPrefix + "script":       {"application/vnd.google-apps.script+json", ".json"},
+}
+
+// stubExtensions are the extensions Drive for desktop uses for its stubs.
+var stubExtensions = map[string]string{
+	documentMimeType:                  ".gdoc",
+	googleAppsPrefix + "spreadsheet":  ".gsheet",
+	googleAppsPrefix + "presentation": ".gslides",
+	googleAppsPrefix + "drawing":      ".gdraw",
+	googleAppsPrefix + "form":         ".gform",
+	googleAppsPrefix + "map":          ".gmap",
+	googleAppsPrefix + "site":         ".gsite",
+}
+
+func validNativePolicy(policy string) bool {
+	switch policy {
+	case nativeSkip, nativeExport, nativeGdoc, nativeURL:
+		return true
+	}
+	return false
+}
+
+// isNative reports whether the file is a Google-native document.
+func isNative(file drive.File) bool {
+	return strings.HasPrefix(file.MimeType, googleAppsPrefix) && file.MimeType != folderMimeType
+}
+
+// nativeLocalPath returns the local path a native document is materialized
+// at under the policy and export formats, or false if they leave it out.
+func nativeLocalPath(rel string, file drive.File, policy string, formats map[string]exportFormat) (string, bool) {
+	switch policy {
+	case nativeExport:
+		format, ok := formats[file.MimeType]
+		if !ok {
+			return "", false
+		}
+		return rel + format.Extension, true
+	case nativeGdoc:
+		ext, ok := stubExtensions[file.MimeType]
+		if !ok {
+			ext = ".glink"
+		}
+		return rel + ext, true
+	case nativeURL:
+		return rel + ".url", true
+	}
+	return "", false
+}
+
+// materializeNative writes a native document to localPath under the policy
+// and export formats. Exports are counted in job and their failure is
+// returned.
+func materializeNative(srv *drive.Service, file drive.File, localPath string, policy string, formats map[string]exportFormat, job *transferJob) error {
+	link := file.WebViewLink
+	if link == "" {
+		link = "https://drive.google.com/open?id=" + contentId(file)
+	}
+	switch policy {
+	case nativeExport:
+		format := formats[file.MimeType]
+		if format == sheetsCSV {
+			if err := exportSheets(file, localPath, job); err != nil {
+				return err
+			}
+			return setModTime(localPath, file)
+		}
+		if format.MimeType == markdownMimeType {
+			if err := exportMarkdown(srv, file, localPath, job); err != nil {
+				return err
+			}
+			return setModTime(localPath, file)
+		}
+		err := retry("Export of "+file.Name, func() error {
+			job.restart()
+			resp, err := srv.Files.Export(contentId(file), format.MimeType).Context(job.ctx).Download()
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			return saveFile(localPath, job.reader(resp.Body))
+		})
+		if err != nil {
+			return fmt.Errorf("export failed: %v", err)
+		}
+		// The export carries the document's modifiedTime so that
+		// nativeUpToDate can tell when it changes.
+		return setModTime(localPath, file)
+	case nativeGdoc:
+		stub, err := json.Marshal(map[string]string{
+			"url":         link,
+			"doc_id":      contentId(file),
+			"resource_id": strings.TrimPrefix(file.MimeType, googleAppsPrefix) + ":" + contentId(file),
+		})
+		if err != nil {
+			return err
+		}
+		return writeStub(localPath, stub)
+	case nativeURL:
+		return writeStub(localPath, []byte(fmt.Sprintf("[InternetShortcut]\r\nURL=%s\r\n", link)))
+	}
+	return nil
+}
+
+// nativeUpToDate reports whether the native document materialized at
+// localPath needs no update. Exports are compared by modification time as
+// they have no checksum, stubs only need to exist.
+func nativeUpToDate(localPath string, file drive.File, policy string) bool {
+	fi, err := os.Stat(localPath)
+	if err != nil {
+		return false
+	}
+	if policy != nativeExport {
+		return true
+	}
+	if _, err := time.Parse(time.RFC3339, file.ModifiedTime); err != nil {
+		return true
+	}
+	return sameModTime(fi.ModTime(), file)
+}
+
+func writeStub(localPath string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(localPath, b, 0644)
+}
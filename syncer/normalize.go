@@ -0,0 +1,59 @@
+package syncer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Unicode normalization forms for the names of created local files.
+const (
+	normalizeNone = "none" // keep the remote form
+	normalizeNFC  = "nfc"  // composed, as Drive and most systems use
+	normalizeNFD  = "nfd"  // decomposed, as HFS+ on macOS stores names
+)
+
+func validNormalization(form string) bool {
+	switch form {
+	case normalizeNone, normalizeNFC, normalizeNFD:
+		return true
+	}
+	return false
+}
+
+// normalizeName converts a name to the given normalization form.
+func normalizeName(name string, form string) string {
+	switch form {
+	case normalizeNFC:
+		return norm.NFC.String(name)
+	case normalizeNFD:
+		return norm.NFD.String(name)
+	}
+	return name
+}
+
+// pathKey returns the form of a relative path used to compare local and
+// remote paths, so that names differing only in their Unicode normalization
+// are the same.
+func pathKey(rel string) string {
+	return norm.NFC.String(filepath.ToSlash(rel))
+}
+
+// caseInsensitiveFS reports whether the file system holding dir treats
+// names differing only in case as the same, by creating a file there and
+// looking it up in upper case. If that fails it guesses from the OS.
+func caseInsensitiveFS(dir string) bool {
+	f, err := ioutil.TempFile(dir, ".drive-case-probe-")
+	if err != nil {
+		return runtime.GOOS == "darwin" || runtime.GOOS == "windows"
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	upper := filepath.Join(dir, strings.ToUpper(filepath.Base(f.Name())))
+	_, err = os.Stat(upper)
+	return err == nil
+}
@@ -0,0 +1,89 @@
+package syncer
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Notification modes of -notify.
+const (
+	notifyOff    = ""
+	notifyErrors = "errors" // failures only
+	notifyAll    = "all"    // also finished syncs
+)
+
+// notifyTitle is the title of the desktop notifications.
+const notifyTitle = "Drive sync"
+
+// notifier shows desktop notifications about runs.
+type notifier struct {
+	mode string
+	mu   sync.Mutex
+	// failed tells whether this run notified a failure already, so that
+	// a run failing many transfers notifies once.
+	failed bool
+}
+
+// notifications is the notifier, nil without -notify.
+var notifications *notifier
+
+func newNotifier(mode string) (*notifier, error) {
+	switch mode {
+	case notifyOff:
+		return nil, nil
+	case notifyErrors, notifyAll:
+		return &notifier{mode: mode}, nil
+	}
+	return nil, fmt.Errorf("invalid -notify %q: use errors or all", mode)
+}
+
+// show shows a notification. A failure to do so is logged and otherwise
+// ignored.
+func (n *notifier) show(message string) {
+	if err := desktopNotify(notifyTitle, message); err != nil {
+		log.Printf("Unable to show a notification: %v", err)
+	}
+}
+
+// add notifies the first failed transfer of a run at once.
+func (n *notifier) add(e event) {
+	if e.Event != eventFailed {
+		return
+	}
+	n.mu.Lock()
+	first := !n.failed
+	n.failed = true
+	n.mu.Unlock()
+	if first {
+		go n.show(fmt.Sprintf("%s failed: %s", e.Path, e.Error))
+	}
+}
+
+// fatal notifies an error stopping the run.
+func (n *notifier) fatal(message string) {
+	if n != nil {
+		n.show(message)
+	}
+}
+
+// finished notifies the end of a run which failed or, with notifyAll,
+// changed files; always is set for a single sync, which notifies its end
+// in any case.
+func (n *notifier) finished(s *runStats, failed int, always bool) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	n.failed = false
+	n.mu.Unlock()
+	changed := s.Downloaded+s.Uploaded+s.Updated+s.Deleted > 0
+	if failed == 0 && (n.mode != notifyAll || !(changed || always)) {
+		return
+	}
+	message := fmt.Sprintf("Downloaded %d, uploaded %d, updated %d, deleted %d, %s", s.Downloaded, s.Uploaded, s.Updated, s.Deleted, formatBytes(s.Bytes))
+	if failed > 0 {
+		message = fmt.Sprintf("%d failed. %s", failed, message)
+	}
+	n.show(message)
+}
@@ -0,0 +1,14 @@
+//go:build darwin
+
+package syncer
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// desktopNotify shows a notification in the Notification Center.
+func desktopNotify(title, message string) error {
+	script := "display notification " + strconv.Quote(message) + " with title " + strconv.Quote(title)
+	return exec.Command("osascript", "-e", script).Run()
+}
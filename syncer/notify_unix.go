@@ -0,0 +1,10 @@
+//go:build !darwin && !windows
+
+package syncer
+
+import "os/exec"
+
+// desktopNotify shows a notification through libnotify's notify-send.
+func desktopNotify(title, message string) error {
+	return exec.Command("notify-send", "--app-name=drive", title, message).Run()
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package syncer
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// toastScript shows a toast with the title and message in $args.
+const toastScript = `
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $template.GetElementsByTagName('text')
+$text.Item(0).AppendChild($template.CreateTextNode('%TITLE%')) | Out-Null
+$text.Item(1).AppendChild($template.CreateTextNode('%MESSAGE%')) | Out-Null
+$toast = [Windows.UI.Notifications.ToastNotification]::new($template)
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('Drive sync').Show($toast)
+`
+
+// desktopNotify shows a toast notification through PowerShell.
+func desktopNotify(title, message string) error {
+	// Single quotes are doubled in PowerShell's single quoted strings.
+	quote := strings.NewReplacer("'", "''").Replace
+	script := strings.NewReplacer("%TITLE%", quote(title), "%MESSAGE%", quote(message)).Replace(toastScript)
+	return exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script).Run()
+}
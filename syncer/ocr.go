@@ -0,0 +1,104 @@
+package syncer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// Pairs with OCR set have the images and PDFs they upload read by Drive's
+// OCR into a Google Doc next to them, named like the file with ocrSuffix
+// appended. The Doc has the id of the file in its propOCROf appProperty.
+const (
+	propOCROf = "ocrOf"
+	ocrSuffix = " (OCR)"
+	// ocrAuto leaves the language of the text to Drive.
+	ocrAuto = "auto"
+)
+
+// ocrable reports whether Drive's OCR reads the file at rel.
+func ocrable(rel string) bool {
+	t := localMimeType(rel)
+	return strings.HasPrefix(t, "image/") || t == "application/pdf"
+}
+
+// ocrDocs returns the ids of the OCR Docs of the remote files, by the ids
+// of the files.
+func ocrDocs(remote []drive.File) map[string][]string {
+	docs := make(map[string][]string)
+	for _, r := range remote {
+		if of := r.AppProperties[propOCROf]; of != "" && r.MimeType == documentMimeType {
+			docs[of] = append(docs[of], r.Id)
+		}
+	}
+	return docs
+}
+
+// ocr converts the local file l, uploaded as the remote file r, into a
+// Google Doc with Drive's OCR. The Docs of earlier versions of r are
+// trashed once the new one is there.
+func (u *uploader) ocr(l *localFile, r drive.File, job *transferJob) error {
+	f, err := os.Open(filepath.Join(u.pair.Local, l.Path))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	u.filesMu.Lock()
+	old := ocrDocs(u.files.Remote)[r.Id]
+	u.filesMu.Unlock()
+	meta := &drive.File{
+		Name:          r.Name + ocrSuffix,
+		MimeType:      documentMimeType,
+		Parents:       r.Parents,
+		AppProperties: map[string]string{propOCROf: r.Id},
+	}
+	var doc *drive.File
+	err = retry("OCR of "+l.Path, func() (err error) {
+		job.restart()
+		content := job.reader(io.NewSectionReader(f, 0, l.Size))
+		call := u.srv.Files.Create(meta).Media(content, googleapi.ContentType(localMimeType(l.Path))).Fields(fileFields).Context(job.ctx)
+		if u.pair.OCR != ocrAuto {
+			call.OcrLanguage(u.pair.OCR)
+		}
+		doc, err = call.Do()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("OCR failed: %v", err)
+	}
+	u.record(*doc)
+	var updates []metadataUpdate
+	for _, id := range old {
+		updates = append(updates, trashRemote(drive.File{Id: id}, l.Path+ocrSuffix))
+	}
+	trashed, _ := updateMetadata(u.srv, updates)
+	var gone []string
+	for id := range trashed {
+		gone = append(gone, id)
+	}
+	u.forget(gone)
+	return nil
+}
+
+// forget drops the remote files ids from the state.
+func (u *uploader) forget(ids []string) {
+	gone := make(map[string]bool)
+	for _, id := range ids {
+		gone[id] = true
+	}
+	u.filesMu.Lock()
+	defer u.filesMu.Unlock()
+	var kept []drive.File
+	for _, r := range u.files.Remote {
+		if !gone[r.Id] {
+			kept = append(kept, r)
+		}
+	}
+	u.files.Remote = kept
+	u.indexRemote()
+}
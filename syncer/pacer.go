@@ -0,0 +1,64 @@
+package syncer
+
+import (
+	"sync"
+	"time"
+)
+
+// pacer spaces out the Drive requests of all goroutines. The interval
+// doubles on each rate limit error and shrinks again by a quarter with each
+// request that goes through, so a long sync settles at a rate the quota
+// allows instead of alternating between bursts and backoff.
+type pacer struct {
+	mu       sync.Mutex
+	minSleep time.Duration
+	maxSleep time.Duration
+	sleep    time.Duration // current interval between requests
+	next     time.Time     // when the next request may start
+}
+
+const (
+	pacerMaxSleep = 2 * time.Second
+	// pacerMinBackoff is the interval after a rate limit error even when
+	// minSleep is 0.
+	pacerMinBackoff = 20 * time.Millisecond
+)
+
+// drivePacer paces all requests to Drive; -pacer-min-sleep sets minSleep.
+var drivePacer = &pacer{minSleep: 10 * time.Millisecond, maxSleep: pacerMaxSleep}
+
+// wait blocks until the caller may make its request.
+func (p *pacer) wait() {
+	p.mu.Lock()
+	now := time.Now()
+	start := p.next
+	if start.Before(now) {
+		start = now
+	}
+	if p.sleep < p.minSleep {
+		p.sleep = p.minSleep
+	}
+	p.next = start.Add(p.sleep)
+	p.mu.Unlock()
+	time.Sleep(start.Sub(now))
+}
+
+// observe adapts the interval to the outcome of a request.
+func (p *pacer) observe(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if rateLimited(err) {
+		p.sleep *= 2
+		if p.sleep < pacerMinBackoff {
+			p.sleep = pacerMinBackoff
+		}
+		if p.sleep > p.maxSleep {
+			p.sleep = p.maxSleep
+		}
+		return
+	}
+	p.sleep -= p.sleep / 4
+	if p.sleep < p.minSleep {
+		p.sleep = p.minSleep
+	}
+}
@@ -0,0 +1,102 @@
+package syncer
+
+import (
+	"os"
+	"path/filepath"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Policies for remote files with more than one parent folder.
+const (
+	parentsPrimary = "primary" // sync only the path through the first parent
+	parentsAll     = "all"     // materialize a copy in every location
+	parentsLink    = "link"    // symlink the other locations to the first
+)
+
+func validParentsPolicy(policy string) bool {
+	switch policy {
+	case parentsPrimary, parentsAll, parentsLink:
+		return true
+	}
+	return false
+}
+
+// remotePaths returns every path of the file, following all parents of the
+// file and of its folders. The first one is remotePath's.
+func remotePaths(idx *remoteIndex, file drive.File) []string {
+	name := idx.name(file)
+	if len(file.Parents) == 0 {
+		return []string{"/" + name}
+	}
+	var paths []string
+	seen := make(map[string]bool)
+	for _, parent := range file.Parents {
+		parentPaths := []string{""}
+		if d, ok := idx.folders[parent]; ok {
+			parentPaths = remotePaths(idx, d)
+		}
+		for _, p := range parentPaths {
+			if path := p + "/" + name; !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+// extraPaths returns the included relative paths of the file other than
+// its primary one.
+func (p *syncPair) extraPaths(idx *remoteIndex, file drive.File) []string {
+	var rels []string
+	paths := remotePaths(idx, file)
+	for _, rp := range paths[1:] {
+		if rel, ok := p.includeRemoteAt(idx, file, rp); ok {
+			rels = append(rels, rel)
+		}
+	}
+	return rels
+}
+
+// materializeParents makes the file, whose content is at source, appear at
+// the local paths of its other parents as the pair's policy says.
+func (p *syncPair) materializeParents(idx *remoteIndex, file drive.File, source string) error {
+	if p.Parents == parentsPrimary || len(file.Parents) < 2 {
+		return nil
+	}
+	for _, rel := range p.extraPaths(idx, file) {
+		localPath := filepath.Join(p.Local, rel)
+		if _, err := os.Lstat(localPath); err == nil {
+			continue
+		}
+		infof("%s (another parent of %s)\n", rel, file.Name)
+		switch p.Parents {
+		case parentsAll:
+			in, err := os.Open(source)
+			if err != nil {
+				return err
+			}
+			err = saveFile(localPath, in)
+			in.Close()
+			if err != nil {
+				return err
+			}
+			if err := protectReadOnly(localPath, file); err != nil {
+				return err
+			}
+		case parentsLink:
+			if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+				return err
+			}
+			target, err := filepath.Rel(filepath.Dir(localPath), source)
+			if err != nil {
+				target = source
+			}
+			if err := os.Symlink(target, localPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package syncer
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const (
+	progressInterval = 500 * time.Millisecond
+	progressNameLen  = 40
+)
+
+// stdoutIsTerminal reports whether progress can be redrawn in place.
+func stdoutIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// showProgress redraws the progress of the transfers until stop is closed.
+func (t *transfers) showProgress(stop chan struct{}) {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			t.mu.Lock()
+			t.clearProgress()
+			t.drawProgress()
+			t.mu.Unlock()
+		}
+	}
+}
+
+// drawProgress prints a line per active transfer with its percentage and
+// speed, and a line with the totals and the estimated time left. t.mu must
+// be held.
+func (t *transfers) drawProgress() {
+	now := time.Now()
+	for _, job := range t.active {
+		done := atomic.LoadInt64(&job.done)
+		percent := "  ?%"
+		if job.size > 0 {
+			percent = fmt.Sprintf("%3d%%", done*100/job.size)
+		}
+		fmt.Printf("  %-*s %s %12s\n", progressNameLen, shortName(job.name), percent, formatSpeed(done, now.Sub(job.started)))
+	}
+	bytes := atomic.LoadInt64(&t.bytes)
+	elapsed := now.Sub(t.start)
+	eta := "-"
+	if bytes > 0 && t.totalBytes > bytes {
+		left := time.Duration(float64(elapsed) * float64(t.totalBytes-bytes) / float64(bytes))
+		eta = left.Round(time.Second).String()
+	}
+	fmt.Printf("%d/%d files, %s / %s, %s, ETA %s\n", t.done, t.total,
+		formatBytes(bytes), formatBytes(t.totalBytes), formatSpeed(bytes, elapsed), eta)
+	t.drawn = len(t.active) + 1
+}
+
+// clearProgress erases the drawn progress lines. t.mu must be held.
+func (t *transfers) clearProgress() {
+	if t.drawn > 0 {
+		fmt.Printf("\033[%dA\033[J", t.drawn)
+		t.drawn = 0
+	}
+}
+
+// shortName shortens name to progressNameLen characters, keeping its end.
+func shortName(name string) string {
+	r := []rune(name)
+	if len(r) <= progressNameLen {
+		return name
+	}
+	return "…" + string(r[len(r)-progressNameLen+1:])
+}
+
+func formatSpeed(bytes int64, d time.Duration) string {
+	if d <= 0 {
+		return "-"
+	}
+	return formatBytes(int64(float64(bytes)/d.Seconds())) + "/s"
+}
@@ -0,0 +1,229 @@
+package syncer
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// sidecarSuffix ends the names of the sidecar files of an upload sync,
+// which hold the metadata of the file they are named after:
+// notes.pdf.drive-meta.json for notes.pdf. They aren't uploaded themselves.
+const sidecarSuffix = ".drive-meta.json"
+
+// fileMetadata is the metadata set on uploaded files besides their content.
+type fileMetadata struct {
+	Description   string            `json:"description"`
+	Properties    map[string]string `json:"properties"`
+	AppProperties map[string]string `json:"appProperties"`
+}
+
+// isSidecar reports whether the file at rel is a sidecar file.
+func isSidecar(rel string) bool {
+	return strings.HasSuffix(rel, sidecarSuffix)
+}
+
+// propertyFlag is a repeatable key=value flag.
+type propertyFlag map[string]string
+
+func (f propertyFlag) String() string {
+	return ""
+}
+
+func (f propertyFlag) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("%q is not key=value", s)
+	}
+	f[key] = value
+	return nil
+}
+
+// metadata returns the metadata of the local file at rel: that of the pair,
+// overridden by its sidecar file if it has one.
+func (u *uploader) metadata(rel string) (fileMetadata, error) {
+	m := fileMetadata{
+		Description:   u.pair.Description,
+		Properties:    make(map[string]string),
+		AppProperties: make(map[string]string),
+	}
+	for key, value := range u.pair.Properties {
+		m.Properties[key] = value
+	}
+	for key, value := range u.pair.AppProperties {
+		m.AppProperties[key] = value
+	}
+	if !u.sidecars[pathKey(rel)] {
+		return m, nil
+	}
+	sidecar := filepath.Join(u.pair.Local, rel+sidecarSuffix)
+	b, err := ioutil.ReadFile(sidecar)
+	if err != nil {
+		return m, fmt.Errorf("unable to read %s: %v", sidecar, err)
+	}
+	var s fileMetadata
+	if err := json.Unmarshal(b, &s); err != nil {
+		return m, configErrorf("Unable to parse %s: %v", sidecar, err)
+	}
+	if s.Description != "" {
+		m.Description = s.Description
+	}
+	for key, value := range s.Properties {
+		m.Properties[key] = value
+	}
+	for key, value := range s.AppProperties {
+		m.AppProperties[key] = value
+	}
+	return m, nil
+}
+
+// apply sets the metadata on the change f. The appProperties the tool keeps
+// in f take precedence.
+func (m fileMetadata) apply(f *drive.File) {
+	if m.Description != "" {
+		f.Description = m.Description
+	}
+	if len(m.Properties) > 0 {
+		f.Properties = m.Properties
+	}
+	if len(m.AppProperties) > 0 {
+		app := make(map[string]string)
+		for key, value := range m.AppProperties {
+			app[key] = value
+		}
+		for key, value := range f.AppProperties {
+			app[key] = value
+		}
+		f.AppProperties = app
+	}
+}
+
+// differs reports whether the remote file r lacks some of the metadata.
+// Properties missing from the metadata are left alone on r.
+func (m fileMetadata) differs(r drive.File) bool {
+	if m.Description != "" && m.Description != r.Description {
+		return true
+	}
+	for key, value := range m.Properties {
+		if got, ok := r.Properties[key]; !ok || got != value {
+			return true
+		}
+	}
+	for key, value := range m.AppProperties {
+		if got, ok := r.AppProperties[key]; !ok || got != value {
+			return true
+		}
+	}
+	return false
+}
+
+// formatProperties returns the properties as sorted key=value pairs.
+func formatProperties(props map[string]string) string {
+	pairs := make([]string, 0, len(props))
+	for key, value := range props {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// statRecord is a file as stat prints it with -format json.
+type statRecord struct {
+	Path          string            `json:"path"`
+	Id            string            `json:"id"`
+	Type          string            `json:"type"`
+	Size          *int64            `json:"size,omitempty"` // not of folders
+	Modified      string            `json:"modified"`
+	Md5           string            `json:"md5,omitempty"`
+	Sha256        string            `json:"sha256,omitempty"`
+	Description   string            `json:"description,omitempty"`
+	Properties    map[string]string `json:"properties,omitempty"`
+	AppProperties map[string]string `json:"appProperties,omitempty"`
+}
+
+// statCommand runs stat: it prints the metadata of remote files, the
+// description and properties included, one JSON object per file with
+// -format json.
+func statCommand(srv *drive.Service, args []string) error {
+	const usage = "usage: stat remotePath..."
+	fs := flag.NewFlagSet("stat", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		exit(exitConfig)
+	}
+	if fs.NArg() == 0 {
+		exitf(exitConfig, usage)
+	}
+	handleSignals()
+	remoteFS, err := newRemoteFS(srv, "")
+	if err != nil {
+		return err
+	}
+	remoteFS.Natives = true
+	for i, name := range fs.Args() {
+		p, err := resolveRemote(srv, name)
+		if err != nil {
+			return err
+		}
+		info, err := remoteFS.Stat(runCtx, p)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no file %s in My Drive", name)
+		} else if err != nil {
+			return err
+		}
+		f := info.File
+		if outputFormat == formatJSON {
+			r := statRecord{
+				Path:          path.Clean("/" + p),
+				Id:            f.Id,
+				Type:          f.MimeType,
+				Modified:      f.ModifiedTime,
+				Md5:           f.Md5Checksum,
+				Sha256:        f.Sha256Checksum,
+				Description:   f.Description,
+				Properties:    f.Properties,
+				AppProperties: f.AppProperties,
+			}
+			if !info.IsDir() {
+				r.Size = &f.Size
+			}
+			if err := writeJSON(&r); err != nil {
+				return err
+			}
+			continue
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("Path: %s\n", path.Clean("/"+p))
+		fmt.Printf("Id: %s\n", f.Id)
+		fmt.Printf("Type: %s\n", f.MimeType)
+		if !info.IsDir() {
+			fmt.Printf("Size: %s\n", formatBytes(f.Size))
+		}
+		fmt.Printf("Modified: %s\n", f.ModifiedTime)
+		if f.Md5Checksum != "" {
+			fmt.Printf("MD5: %s\n", f.Md5Checksum)
+		}
+		if f.Sha256Checksum != "" {
+			fmt.Printf("SHA-256: %s\n", f.Sha256Checksum)
+		}
+		if f.Description != "" {
+			fmt.Printf("Description: %s\n", f.Description)
+		}
+		if len(f.Properties) > 0 {
+			fmt.Printf("Properties: %s\n", formatProperties(f.Properties))
+		}
+		if len(f.AppProperties) > 0 {
+			fmt.Printf("App properties: %s\n", formatProperties(f.AppProperties))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package syncer
+
+import (
+	"fmt"
+	"log"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// What a sync does when an upload plan needs more than the free storage
+// quota, with -quota-check.
+const (
+	quotaWarn  = "warn"  // says so and uploads anyway
+	quotaAbort = "abort" // doesn't sync the pair
+	quotaOff   = "off"   // doesn't ask Drive
+)
+
+func parseQuotaCheck(s string) (string, error) {
+	switch s {
+	case quotaWarn, quotaAbort, quotaOff:
+		return s, nil
+	}
+	return "", fmt.Errorf("-quota-check %s: must be warn, abort or off", s)
+}
+
+// planned returns the bytes of the transfers queued.
+func (t *transfers) planned() int64 {
+	var n int64
+	for _, job := range t.queue {
+		n += job.size
+	}
+	return n
+}
+
+// checkQuota compares the bytes an upload plan of the pair sends with the
+// storage quota left, and reports whether the sync goes on. Replaced files
+// count in full, as Drive keeps their previous revisions for a while.
+func checkQuota(srv *drive.Service, pair *syncPair, planned int64, opts *runOptions) bool {
+	if opts.quotaCheck == quotaOff || planned == 0 {
+		return true
+	}
+	var about *drive.About
+	err := retry("Getting the storage quota", func() (err error) {
+		about, err = srv.About.Get().Fields("storageQuota").Context(runCtx).Do()
+		return err
+	})
+	if err != nil {
+		log.Printf("%s: unable to check the storage quota: %v", pair, err)
+		return true
+	}
+	q := about.StorageQuota
+	// Accounts without a limit, such as some Workspace ones, have none.
+	if q == nil || q.Limit == 0 {
+		return true
+	}
+	free := q.Limit - q.Usage
+	if free < 0 {
+		free = 0
+	}
+	if planned <= free {
+		return true
+	}
+	msg := fmt.Sprintf("%s: the upload of %s exceeds the %s of storage quota left", pair, formatBytes(planned), formatBytes(free))
+	if opts.quotaCheck == quotaAbort && !opts.planOnly {
+		fmt.Println(paint(colorRed, msg+"; not synced"))
+		return false
+	}
+	fmt.Println(paint(colorYellow, msg))
+	return true
+}
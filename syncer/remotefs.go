@@ -0,0 +1,27 @@
+package syncer
+
+import (
+	"fmt"
+
+	"github.com/hiroshi/googledriveclient/drivefs"
+	"google.golang.org/api/drive/v3"
+)
+
+// newRemoteFS returns the tree below the folder at the slash separated
+// path remoteRoot, "" for the whole My Drive.
+func newRemoteFS(srv *drive.Service, remoteRoot string) (*drivefs.FS, error) {
+	rootId := "root"
+	if remoteRoot != "" {
+		folders, ok, err := remoteRootFolders(srv, remoteRoot)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("no folder %s in My Drive", remoteRoot)
+		}
+		rootId = folders[len(folders)-1].Id
+	}
+	fs := drivefs.New(srv, rootId, retry)
+	fs.Fields = fileFields
+	return fs, nil
+}
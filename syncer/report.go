@@ -0,0 +1,181 @@
+package syncer
+
+import (
+	"encoding/csv"
+	"html/template"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Statuses of the rows of a report.
+const (
+	statusUpToDate    = "up to date"
+	statusPlanned     = "planned" // not done, the run stopped before
+	statusDone        = "done"
+	statusFailed      = "failed"
+	statusInterrupted = "interrupted"
+)
+
+// reportRow is an action of a run.
+type reportRow struct {
+	Path   string
+	Action string
+	Status string
+	Size   int64
+	Md5    string
+	Error  string
+}
+
+// report collects the actions of a run from its events for -report-csv and
+// -report-html.
+type report struct {
+	csvFile  string
+	htmlFile string
+
+	mu      sync.Mutex
+	started time.Time
+	rows    []reportRow
+	byPath  map[string]int // index of the latest row of a path
+}
+
+// runReport is the report of the current run, nil without -report-csv or
+// -report-html.
+var runReport *report
+
+func newReport(csvFile, htmlFile string) *report {
+	return &report{csvFile: csvFile, htmlFile: htmlFile, started: time.Now(), byPath: make(map[string]int)}
+}
+
+// add records an event.
+func (r *report) add(e event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	row := func(status string) {
+		if i, ok := r.byPath[e.Path]; ok {
+			r.rows[i].Status = status
+			r.rows[i].Error = e.Error
+		}
+	}
+	switch e.Event {
+	case eventDecision:
+		if e.Action == actionUpToDate {
+			r.append(reportRow{Path: e.Path, Action: e.Action, Status: statusUpToDate})
+		}
+	case eventPlanned:
+		r.append(reportRow{Path: e.Path, Action: e.Action, Status: statusPlanned, Size: e.Size, Md5: e.Md5})
+	case eventTransferred:
+		row(statusDone)
+	case eventFailed:
+		row(statusFailed)
+	case eventInterrupted:
+		row(statusInterrupted)
+	case eventDeleted:
+		r.append(reportRow{Path: e.Path, Action: "delete to " + e.To, Status: statusDone})
+	}
+}
+
+func (r *report) append(row reportRow) {
+	r.byPath[row.Path] = len(r.rows)
+	r.rows = append(r.rows, row)
+}
+
+// reset starts the report of the next run in daemon mode.
+func (r *report) reset() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.started = time.Now()
+	r.rows = nil
+	r.byPath = make(map[string]int)
+}
+
+// write writes the report with the summary s.
+func (r *report) write(s *runStats) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// The rows come in the order the transfers ended, which varies.
+	sort.SliceStable(r.rows, func(i, j int) bool { return r.rows[i].Path < r.rows[j].Path })
+	for i, row := range r.rows {
+		r.byPath[row.Path] = i
+	}
+	if r.csvFile != "" {
+		r.writeCSV(r.csvFile)
+	}
+	if r.htmlFile != "" {
+		r.writeHTML(r.htmlFile, s)
+	}
+}
+
+func (r *report) writeCSV(file string) {
+	f, err := os.Create(file)
+	if err != nil {
+		fatalf("os.Create(%s) failed: %v", file, err)
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"path", "action", "status", "size", "md5", "error"})
+	for _, row := range r.rows {
+		w.Write([]string{row.Path, row.Action, row.Status, strconv.FormatInt(row.Size, 10), row.Md5, row.Error})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		fatalf("Writing %s failed: %v", file, err)
+	}
+	if err := f.Close(); err != nil {
+		fatalf("Writing %s failed: %v", file, err)
+	}
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"bytes": formatBytes,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Sync report {{.Started.Format "2006-01-02 15:04:05"}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.5em; text-align: left; }
+td.size { text-align: right; }
+tr.failed, tr.interrupted { background: #fdd; }
+tr.planned { background: #ffd; }
+</style>
+</head>
+<body>
+<h1>Sync report</h1>
+<p>Started {{.Started.Format "2006-01-02 15:04:05 MST"}}.
+{{with .Stats}}Checked {{.Checked}} files, {{.Skipped}} up to date or skipped.
+Downloaded {{.Downloaded}}, uploaded {{.Uploaded}}, updated {{.Updated}}, deleted {{.Deleted}}, {{bytes .Bytes}} transferred, {{.Errors}} errors.{{end}}</p>
+<table>
+<tr><th>Path</th><th>Action</th><th>Status</th><th>Size</th><th>MD5</th><th>Error</th></tr>
+{{range .Rows}}<tr class="{{.Status}}"><td>{{.Path}}</td><td>{{.Action}}</td><td>{{.Status}}</td><td class="size">{{if .Size}}{{bytes .Size}}{{end}}</td><td><code>{{.Md5}}</code></td><td>{{.Error}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func (r *report) writeHTML(file string, s *runStats) {
+	f, err := os.Create(file)
+	if err != nil {
+		fatalf("os.Create(%s) failed: %v", file, err)
+	}
+	err = reportTemplate.Execute(f, struct {
+		Started time.Time
+		Stats   *runStats
+		Rows    []reportRow
+	}{r.started, s, r.rows})
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		fatalf("Writing %s failed: %v", file, err)
+	}
+}
@@ -0,0 +1,249 @@
+package syncer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/hiroshi/googledriveclient/checksum"
+	"github.com/hiroshi/googledriveclient/drivefs"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/api/drive/v3"
+)
+
+// Magic numbers telling the archive formats apart.
+const (
+	gzipMagic = "\x1f\x8b"
+	zstdMagic = "\x28\xb5\x2f\xfd"
+	zipMagic  = "PK\x03\x04"
+)
+
+// restoreCommand runs restore: it uploads the files of a tar or zip
+// archive, such as archive writes, below a remote folder, files with the
+// same checksum of hash being left alone.
+func restoreCommand(srv *drive.Service, hash string, args []string) error {
+	const usage = "usage: restore [-to remotePath] archive|-"
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	to := fs.String("to", "", "remote `folder` to restore into, created if missing (default My Drive)")
+	if err := fs.Parse(args); err != nil {
+		exit(exitConfig)
+	}
+	if fs.NArg() != 1 {
+		exitf(exitConfig, usage)
+	}
+	handleSignals()
+	remoteRoot, err := resolveRemote(srv, *to)
+	if err != nil {
+		return err
+	}
+	root, err := newRemoteFS(srv, "")
+	if err != nil {
+		return err
+	}
+	if err := root.MkdirAll(runCtx, remoteRoot); err != nil {
+		return err
+	}
+	remoteFS, err := newRemoteFS(srv, remoteRoot)
+	if err != nil {
+		return err
+	}
+	in := os.Stdin
+	if fs.Arg(0) != "-" {
+		if in, err = os.Open(expandHome(fs.Arg(0))); err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+	r := &restorer{fs: remoteFS, ctx: runCtx, hash: hash}
+	if err := r.read(in); err != nil {
+		return err
+	}
+	fmt.Printf("Restored %d files, %d up to date, %s\n", r.files, r.skipped, formatBytes(r.bytes))
+	return nil
+}
+
+// restorer uploads the entries of an archive.
+type restorer struct {
+	fs      *drivefs.FS
+	ctx     context.Context
+	hash    string
+	files   int
+	skipped int
+	bytes   int64
+}
+
+// read restores the archive in, telling its format by its first bytes.
+// Encrypted archives are decrypted with the key of their salt, or of
+// legacySalt if they have none.
+func (r *restorer) read(in *os.File) error {
+	br := bufio.NewReader(in)
+	salt := legacySalt
+	if hasMagic(br, saltMagic) {
+		header := make([]byte, len(saltMagic)+saltSize)
+		if _, err := io.ReadFull(br, header); err != nil {
+			return err
+		}
+		salt = header[len(saltMagic):]
+	}
+	encrypted := hasMagic(br, cryptMagic)
+	if encrypted {
+		if encryptionSecret == nil {
+			return errors.New("the archive is encrypted, it needs -encryption-key-file or -encryption-passphrase")
+		}
+		key, err := encryptionSecret.key(salt)
+		if err != nil {
+			return err
+		}
+		d, err := newDecryptReader(br, key)
+		if err != nil {
+			return err
+		}
+		br = bufio.NewReader(d)
+	}
+	switch {
+	case hasMagic(br, zipMagic):
+		return r.readZip(in, br, encrypted)
+	case hasMagic(br, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		return r.readTar(gz)
+	case hasMagic(br, zstdMagic):
+		zd, err := zstd.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zd.Close()
+		return r.readTar(zd)
+	}
+	return r.readTar(br)
+}
+
+// hasMagic reports whether br starts with magic.
+func hasMagic(br *bufio.Reader, magic string) bool {
+	b, _ := br.Peek(len(magic))
+	return string(b) == magic
+}
+
+func (r *restorer) readTar(in io.Reader) error {
+	tr := tar.NewReader(in)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if stopping() {
+			return errors.New("interrupted")
+		}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			err = r.fs.MkdirAll(r.ctx, h.Name)
+		case tar.TypeReg, tar.TypeRegA:
+			err = r.file(h.Name, tr, h.ModTime)
+		default:
+			infof("%s: skipped, not a file or folder\n", h.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readZip restores a zip archive, which is read from its end. One which
+// isn't a plain file is written to a temporary file first.
+func (r *restorer) readZip(in *os.File, br *bufio.Reader, encrypted bool) error {
+	var ra io.ReaderAt = in
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+	if encrypted || !fi.Mode().IsRegular() {
+		tmp, err := ioutil.TempFile("", "drive-restore-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if size, err = io.Copy(tmp, br); err != nil {
+			return err
+		}
+		ra = tmp
+	}
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if stopping() {
+			return errors.New("interrupted")
+		}
+		if f.FileInfo().IsDir() {
+			if err := r.fs.MkdirAll(r.ctx, f.Name); err != nil {
+				return err
+			}
+			continue
+		}
+		content, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+		err = r.file(f.Name, content, f.Modified)
+		content.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// file uploads content as the file name, unless the remote file there has
+// the same content already. The content goes to a temporary file first,
+// for its checksum and for the retries of the upload.
+func (r *restorer) file(name string, content io.Reader, modTime time.Time) error {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return nil
+	}
+	if err := r.fs.MkdirAll(r.ctx, path.Dir(name)); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile("", "drive-restore-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	h := checksum.New(r.hash)
+	size, err := io.Copy(io.MultiWriter(tmp, h), content)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if existing, err := r.fs.Stat(r.ctx, name); err == nil && remoteChecksum(existing.File, r.hash) == sum {
+		decide(name, actionUpToDate, r.hash+" "+sum)
+		r.skipped++
+		return nil
+	}
+	infof("%s\n", name)
+	if err := r.fs.Upload(r.ctx, name, tmp, modTime); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	r.files++
+	r.bytes += size
+	return nil
+}
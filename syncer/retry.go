@@ -0,0 +1,108 @@
+package syncer
+
+import (
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+// maxRetries is how many times a failed Drive call is retried when the
+// error is transient.
+var maxRetries = 5
+
+// apiCalls and apiRetries count the Drive requests made and retried since
+// the start, accessed atomically.
+var apiCalls, apiRetries int64
+
+const (
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 64 * time.Second
+)
+
+// retry calls call until it succeeds, fails with an error that is not
+// worth retrying, or maxRetries retries are used up. It waits between
+// attempts as the server asks in Retry-After, otherwise with exponential
+// backoff and jitter as the Drive API documentation recommends.
+func retry(what string, call func() error) error {
+	for attempt := 0; ; attempt++ {
+		drivePacer.wait()
+		span := startSpan(spanDriveAPI, attribute.String("drive.request", what), attribute.Int("attempt", attempt))
+		err := call()
+		endSpan(span, err)
+		atomic.AddInt64(&apiCalls, 1)
+		drivePacer.observe(err)
+		if err == nil || attempt >= maxRetries || !retryable(err) || stopping() {
+			return err
+		}
+		atomic.AddInt64(&apiRetries, 1)
+		delay := retryDelay(err, attempt)
+		log.Printf("%s failed, retrying in %s: %v", what, delay.Round(time.Millisecond), err)
+		select {
+		case <-time.After(delay):
+		case <-runCtx.Done():
+			return err
+		}
+	}
+}
+
+// retryable tells rate limiting, server errors and broken connections from
+// errors which would only happen again.
+func retryable(err error) bool {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		return gerr.Code >= 500 || rateLimited(err)
+	}
+	if errors.Is(err, errLowSpeed) {
+		return true
+	}
+	// A request cancelled on purpose, e.g. a skipped transfer, stays so.
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var uerr *url.Error
+	return errors.As(err, &uerr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// rateLimited reports whether err says that requests are made too fast.
+func rateLimited(err error) bool {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		return false
+	}
+	if gerr.Code == http.StatusTooManyRequests {
+		return true
+	}
+	if gerr.Code == http.StatusForbidden {
+		for _, e := range gerr.Errors {
+			switch e.Reason {
+			case "userRateLimitExceeded", "rateLimitExceeded":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func retryDelay(err error, attempt int) time.Duration {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && gerr.Header != nil {
+		if s, err := strconv.Atoi(gerr.Header.Get("Retry-After")); err == nil && s > 0 {
+			return time.Duration(s) * time.Second
+		}
+	}
+	delay := retryBaseDelay << uint(attempt)
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+	return delay + time.Duration(rand.Int63n(int64(time.Second)))
+}
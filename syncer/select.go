@@ -0,0 +1,426 @@
+package syncer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hiroshi/googledriveclient/drivefs"
+	"golang.org/x/term"
+	"google.golang.org/api/drive/v3"
+	"sigs.k8s.io/yaml"
+)
+
+// pickerNode is a remote folder in the picker of select.
+type pickerNode struct {
+	id       string
+	name     string // the local name
+	rel      string // slash separated, below the remote root
+	depth    int
+	checked  bool // synced, with its files
+	expanded bool
+	loaded   bool // children listed
+	children []*pickerNode
+}
+
+// picker lets the user choose the folders of a pair to sync, as a tree of
+// checkboxes. Unchecked folders become "- /path/" filter rules, so folders
+// created later are synced.
+type picker struct {
+	srv    *drive.Service
+	pair   *syncPair
+	roots  []*pickerNode
+	cursor int
+	offset int // first row shown
+}
+
+// selectFolders runs select for the pair of args, a pair name or a local
+// root, and saves the choice in the config file.
+func selectFolders(srv *drive.Service, cfg *config, file string, args []string, flags *pairFlags) {
+	if len(args) > 1 {
+		exitf(exitConfig, "usage: select [pair name|basePath]")
+	}
+	index := -1
+	var pair syncPair
+	switch {
+	case len(args) == 0 && len(cfg.Pairs) == 1:
+		index = 0
+	case len(args) == 0:
+		exitf(exitConfig, "usage: select [pair name|basePath], naming one of the %d pairs of the config", len(cfg.Pairs))
+	default:
+		local, _ := filepath.Abs(expandHome(args[0]))
+		for i := range cfg.Pairs {
+			if l, _ := filepath.Abs(cfg.Pairs[i].Local); cfg.Pairs[i].Name == args[0] || l == local {
+				index = i
+			}
+		}
+	}
+	if index >= 0 {
+		pair = cfg.Pairs[index]
+	} else {
+		local, err := filepath.Abs(expandHome(args[0]))
+		if err != nil {
+			exitf(exitConfig, "%v", err)
+		}
+		pair = syncPair{Local: local}
+	}
+	if err := pair.setup(flags); err != nil {
+		exitf(exitConfig, "%v", err)
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !stdoutIsTerminal() {
+		exitf(exitConfig, "select needs a terminal")
+	}
+
+	rootId := "root"
+	if pair.Remote != "" {
+		folders, ok, err := remoteRootFolders(srv, pair.Remote)
+		if err != nil {
+			fatalf("Unable to list %s: %v", pair.Remote, err)
+		}
+		if !ok {
+			exitf(exitConfig, "%s: no folder %s in My Drive", &pair, pair.Remote)
+		}
+		rootId = folders[len(folders)-1].Id
+	}
+	// The folders below which the filter excludes some are listed from
+	// the start, so that saving keeps those exclusions.
+	var kept, excludedDirs []string
+	for _, line := range pair.Filter {
+		if rel, ok := pickerRule(line); ok {
+			excludedDirs = append(excludedDirs, rel)
+		} else {
+			kept = append(kept, line)
+		}
+	}
+	p := &picker{srv: srv, pair: &pair}
+	p.roots = p.list(rootId, "", 0)
+	p.expandAbove(p.roots, excludedDirs)
+
+	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		fatalf("Unable to use the terminal: %v", err)
+	}
+	restore := func() {
+		fmt.Print("\033[?25h\033[?1049l")
+		term.Restore(int(os.Stdin.Fd()), oldState)
+	}
+	atExit(restore)
+	fmt.Print("\033[?1049h\033[?25l")
+	save := p.run()
+	restore()
+	if !save {
+		fmt.Println("Not saved")
+		return
+	}
+	filter := append(pickerRules(p.roots), kept...)
+	if file == "" {
+		file = defaultConfigPath()
+	}
+	if err := saveFilter(file, index, &pair, filter); err != nil {
+		fatalf("Unable to save %s: %v", file, err)
+	}
+	fmt.Printf("Saved the folders of %s to %s\n", &pair, file)
+}
+
+// list returns the folders in the folder id, at rel, their boxes checked
+// unless the pair's filters exclude them.
+func (p *picker) list(id, rel string, depth int) []*pickerNode {
+	q := fmt.Sprintf("%s in parents and mimeType = '%s' and trashed = false", drivefs.QueryQuote(id), folderMimeType)
+	opts := p.pair.nameOptions()
+	folders, err := remote(p.srv, q, nil, "", nil)
+	if err != nil {
+		fatal(err)
+	}
+	var nodes []*pickerNode
+	for _, f := range folders {
+		name := normalizeName(drivefs.SanitizeName(f.Name, opts.windows), opts.form)
+		nodes = append(nodes, &pickerNode{
+			id:      f.Id,
+			name:    name,
+			rel:     path.Join(rel, name),
+			depth:   depth,
+			checked: !p.pair.excluded(path.Join(rel, name), true),
+		})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].name < nodes[j].name })
+	return nodes
+}
+
+func (p *picker) expand(n *pickerNode) {
+	if !n.loaded {
+		n.children = p.list(n.id, n.rel, n.depth+1)
+		n.loaded = true
+	}
+	n.expanded = len(n.children) > 0
+}
+
+// expandAbove expands the folders above those of dirs.
+func (p *picker) expandAbove(nodes []*pickerNode, dirs []string) {
+	for _, n := range nodes {
+		for _, dir := range dirs {
+			if strings.HasPrefix(dir, n.rel+"/") {
+				p.expand(n)
+				p.expandAbove(n.children, dirs)
+				break
+			}
+		}
+	}
+}
+
+// rows returns the nodes shown, the children of expanded ones below them.
+func (p *picker) rows() []*pickerNode {
+	var rows []*pickerNode
+	var add func([]*pickerNode)
+	add = func(nodes []*pickerNode) {
+		for _, n := range nodes {
+			rows = append(rows, n)
+			if n.expanded {
+				add(n.children)
+			}
+		}
+	}
+	add(p.roots)
+	return rows
+}
+
+// parentOf returns the row index of the folder above rows[i], or i.
+func parentOf(rows []*pickerNode, i int) int {
+	for j := i - 1; j >= 0; j-- {
+		if rows[j].depth < rows[i].depth {
+			return j
+		}
+	}
+	return i
+}
+
+// run shows the picker until the user saves or quits, and reports whether
+// to save.
+func (p *picker) run() bool {
+	buf := make([]byte, 8)
+	for {
+		rows := p.rows()
+		if p.cursor >= len(rows) {
+			p.cursor = len(rows) - 1
+		}
+		if p.cursor < 0 {
+			p.cursor = 0
+		}
+		p.draw(rows)
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return false
+		}
+		if len(rows) == 0 {
+			switch string(buf[:n]) {
+			case "s":
+				return true
+			case "q", "\033", "\x03":
+				return false
+			}
+			continue
+		}
+		row := rows[p.cursor]
+		switch string(buf[:n]) {
+		case "\033[A", "k":
+			p.cursor--
+		case "\033[B", "j":
+			p.cursor++
+		case "\033[C", "l", "\r":
+			p.expand(row)
+		case "\033[D", "h":
+			if row.expanded {
+				row.expanded = false
+			} else {
+				p.cursor = parentOf(rows, p.cursor)
+			}
+		case " ":
+			check := !row.checked || !allChecked(row.children)
+			setChecked(row, check)
+			if check {
+				// The folders above must be synced for it to be.
+				for i := p.cursor; ; {
+					j := parentOf(rows, i)
+					if j == i {
+						break
+					}
+					rows[j].checked, i = true, j
+				}
+			}
+		case "s":
+			return true
+		case "q", "\033", "\x03":
+			return false
+		}
+	}
+}
+
+func allChecked(nodes []*pickerNode) bool {
+	for _, n := range nodes {
+		if !n.checked || !allChecked(n.children) {
+			return false
+		}
+	}
+	return true
+}
+
+func setChecked(n *pickerNode, checked bool) {
+	n.checked = checked
+	for _, c := range n.children {
+		setChecked(c, checked)
+	}
+}
+
+func (p *picker) draw(rows []*pickerNode) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		width, height = 80, 24
+	}
+	room := height - 3
+	if room < 1 {
+		room = 1
+	}
+	if p.cursor < p.offset {
+		p.offset = p.cursor
+	}
+	if p.cursor >= p.offset+room {
+		p.offset = p.cursor - room + 1
+	}
+	var b strings.Builder
+	b.WriteString("\033[H" + fmt.Sprintf("Folders of %s to sync", p.pair) + "\033[K\r\n\033[K\r\n")
+	if len(rows) == 0 {
+		b.WriteString("No folders\033[K\r\n")
+	}
+	for i := p.offset; i < len(rows) && i < p.offset+room; i++ {
+		n := rows[i]
+		box := "[ ]"
+		switch {
+		case n.checked && allChecked(n.children):
+			box = "[x]"
+		case n.checked:
+			box = "[-]" // some folders below are left out
+		}
+		arrow := "  "
+		switch {
+		case n.expanded:
+			arrow = "v "
+		case !n.loaded || len(n.children) > 0:
+			arrow = "> "
+		}
+		line := []rune(strings.Repeat("  ", n.depth) + arrow + box + " " + n.name)
+		if len(line) > width {
+			line = line[:width]
+		}
+		if i == p.cursor {
+			b.WriteString("\033[7m" + string(line) + "\033[0m")
+		} else {
+			b.WriteString(string(line))
+		}
+		b.WriteString("\033[K\r\n")
+	}
+	b.WriteString("\033[J\033[" + fmt.Sprint(height) + ";1H\033[7mspace check  right/left open/close  s save  q quit\033[0m\033[K")
+	fmt.Print(b.String())
+}
+
+// pickerRules returns the filter rules leaving out the unchecked folders.
+func pickerRules(nodes []*pickerNode) []string {
+	var rules []string
+	for _, n := range nodes {
+		if !n.checked {
+			rules = append(rules, "- /"+escapeGlob(n.rel)+"/")
+			continue
+		}
+		rules = append(rules, pickerRules(n.children)...)
+	}
+	return rules
+}
+
+// pickerRule returns the folder a filter rule made by select leaves out.
+func pickerRule(line string) (string, bool) {
+	if !strings.HasPrefix(line, "- /") || !strings.HasSuffix(line, "/") || len(line) < 5 {
+		return "", false
+	}
+	var b strings.Builder
+	glob := line[3 : len(line)-1]
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteByte(glob[i])
+		case strings.IndexByte("*?[", c) >= 0:
+			return "", false // a glob of the user's
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), true
+}
+
+// escapeGlob escapes the characters of a name which are special in
+// patterns.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`\*?[`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// saveFilter sets the filter of the pair at index in the config file, or
+// adds pair with it if index is -1. Other settings are kept, though not
+// the comments and layout of a YAML file.
+func saveFilter(file string, index int, pair *syncPair, filter []string) error {
+	doc := make(map[string]interface{})
+	b, err := ioutil.ReadFile(file)
+	if err == nil {
+		if b, err = yaml.YAMLToJSON(b); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(b, &doc); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if doc == nil {
+		doc = make(map[string]interface{})
+	}
+	pairs, _ := doc["pairs"].([]interface{})
+	if index >= 0 && index < len(pairs) {
+		entry, ok := pairs[index].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("pair %d isn't an object", index)
+		}
+		entry["filter"] = filter
+	} else {
+		entry := map[string]interface{}{"local": pair.Local, "filter": filter}
+		if pair.Remote != "" {
+			entry["remote"] = pair.Remote
+		}
+		doc["pairs"] = append(pairs, entry)
+	}
+	if strings.EqualFold(filepath.Ext(file), ".json") {
+		b, err = json.MarshalIndent(doc, "", "  ")
+		b = append(b, '\n')
+	} else {
+		b, err = yaml.Marshal(doc)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
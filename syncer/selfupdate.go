@@ -0,0 +1,255 @@
+package syncer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// version and releaseKey are set when building a release, with -ldflags
+// "-X github.com/hiroshi/googledriveclient/syncer.version=v1.2.0 ..." as make
+// release does.
+var (
+	version = "dev"
+	// releaseKey is the base64 ed25519 public key signing the checksums
+	// of the releases.
+	releaseKey = ""
+)
+
+// latestReleaseURL describes the latest release on GitHub.
+const latestReleaseURL = "https://api.github.com/repos/hiroshi/googledriveclient/releases/latest"
+
+// Each release has a binary per platform, named like drive_linux_arm64
+// (.exe on Windows), the SHA-256 of each in checksumsAsset, in the format
+// of sha256sum, and its ed25519 signature in signatureAsset. The version
+// of the release is signed along, on a line "# version v1.2.0" of
+// checksumsAsset, as the tag of the release isn't: an older release would
+// pass for the latest otherwise.
+const (
+	checksumsAsset = "SHA256SUMS"
+	signatureAsset = "SHA256SUMS.sig"
+)
+
+type release struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func (r *release) assetURL(name string) (string, error) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL, nil
+		}
+	}
+	return "", fmt.Errorf("release %s has no %s", r.TagName, name)
+}
+
+// selfUpdate replaces the running binary with that of the latest release,
+// if newer, once the signature of the checksums and the checksum of the
+// binary are verified.
+func selfUpdate(client *http.Client) error {
+	if version == "dev" || releaseKey == "" {
+		return fmt.Errorf("this binary wasn't built as a release, update it from the source")
+	}
+	key, err := base64.StdEncoding.DecodeString(releaseKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid release key")
+	}
+	b, err := fetch(client, latestReleaseURL)
+	if err != nil {
+		return err
+	}
+	var latest release
+	if err := json.Unmarshal(b, &latest); err != nil {
+		return fmt.Errorf("unable to read the latest release: %v", err)
+	}
+	if compareVersions(latest.TagName, version) <= 0 {
+		fmt.Printf("%s is the latest version\n", version)
+		return nil
+	}
+
+	name := "drive_" + runtime.GOOS + "_" + runtime.GOARCH
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	get := func(asset string) ([]byte, error) {
+		url, err := latest.assetURL(asset)
+		if err != nil {
+			return nil, err
+		}
+		return fetch(client, url)
+	}
+	sums, err := get(checksumsAsset)
+	if err != nil {
+		return err
+	}
+	sig, err := get(signatureAsset)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), sums, bytes.TrimSpace(sig)) {
+		// The signature may also be in base64.
+		raw, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+		if err != nil || !ed25519.Verify(ed25519.PublicKey(key), sums, raw) {
+			return fmt.Errorf("the signature of %s %s doesn't match, not updating", latest.TagName, checksumsAsset)
+		}
+	}
+	signed, err := signedVersion(sums)
+	if err != nil {
+		return fmt.Errorf("%s %s: %v", latest.TagName, checksumsAsset, err)
+	}
+	if signed != latest.TagName {
+		return fmt.Errorf("release %s is signed as %s, not updating", latest.TagName, signed)
+	}
+	if compareVersions(signed, version) <= 0 {
+		return fmt.Errorf("release %s isn't newer than %s, not updating", signed, version)
+	}
+	want, err := checksumOf(sums, name)
+	if err != nil {
+		return fmt.Errorf("%s %s: %v", latest.TagName, checksumsAsset, err)
+	}
+	fmt.Printf("Downloading %s %s\n", latest.TagName, name)
+	bin, err := get(name)
+	if err != nil {
+		return err
+	}
+	if sum := sha256.Sum256(bin); hex.EncodeToString(sum[:]) != want {
+		return fmt.Errorf("the checksum of %s %s doesn't match, not updating", latest.TagName, name)
+	}
+	if err := replaceExecutable(bin); err != nil {
+		return err
+	}
+	fmt.Printf("Updated from %s to %s\n", version, latest.TagName)
+	return nil
+}
+
+func fetch(client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "drive/"+version)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// checksumOf returns the hex SHA-256 of name in a sha256sum listing.
+func checksumOf(sums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// sha256sum marks binary mode with a star before the name.
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("no checksum of %s", name)
+}
+
+// signedVersion returns the version on the "# version" line of a signed
+// sha256sum listing.
+func signedVersion(sums []byte) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "#" && fields[1] == "version" {
+			return fields[2], nil
+		}
+	}
+	return "", fmt.Errorf("no version")
+}
+
+// compareVersions compares versions like v1.10.2, returning -1, 0 or 1.
+func compareVersions(a, b string) int {
+	pa := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	pb := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var na, nb int
+		if i < len(pa) {
+			na, _ = strconv.Atoi(pa[i])
+		}
+		if i < len(pb) {
+			nb, _ = strconv.Atoi(pb[i])
+		}
+		switch {
+		case na < nb:
+			return -1
+		case na > nb:
+			return 1
+		}
+	}
+	return 0
+}
+
+// replaceExecutable replaces the running binary with bin. The new binary
+// is written next to it and renamed over it, so that it's never left half
+// written. Windows doesn't replace a running binary, which is moved aside
+// first.
+func replaceExecutable(bin []byte) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	info, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(exe), "."+filepath.Base(exe)+".new")
+	if err != nil {
+		return fmt.Errorf("unable to write next to %s: %v", exe, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, bytes.NewReader(bin)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode()|0111); err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp.Name(), exe); err != nil {
+			os.Rename(old, exe)
+			return err
+		}
+		return nil
+	}
+	return os.Rename(tmp.Name(), exe)
+}
@@ -0,0 +1,88 @@
+package syncer
+
+import (
+	"flag"
+	"os"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// serveCommand runs serve: it serves the remote tree through a gateway
+// until the process ends.
+func serveCommand(srv *drive.Service, args []string) error {
+	const usage = "usage: serve webdav|sftp|api [-addr host:port] [-user name] [-host-key file] [-authorized-keys file] [remotePath]"
+	if len(args) == 0 || (args[0] != "webdav" && args[0] != "sftp") {
+		exitf(exitConfig, usage)
+	}
+	gateway := args[0]
+	fs := flag.NewFlagSet("serve "+gateway, flag.ContinueOnError)
+	defaultAddr := "127.0.0.1:8080"
+	if gateway == "sftp" {
+		defaultAddr = "127.0.0.1:2022"
+	}
+	addr := fs.String("addr", defaultAddr, "address to serve on")
+	user := fs.String("user", "", "user name clients authenticate with, the password in DRIVE_SERVE_PASSWORD")
+	hostKey := fs.String("host-key", defaultHostKeyFile, "private SSH host key of sftp, created if missing")
+	authorizedKeys := fs.String("authorized-keys", "", "authorized_keys file of the public keys sftp clients may log in with")
+	if err := fs.Parse(args[1:]); err != nil {
+		exit(exitConfig)
+	}
+	if fs.NArg() > 1 {
+		exitf(exitConfig, usage)
+	}
+	password := os.Getenv("DRIVE_SERVE_PASSWORD")
+	if *user != "" && password == "" && (gateway == "webdav" || *authorizedKeys == "") {
+		exitf(exitConfig, "-user needs the password in DRIVE_SERVE_PASSWORD")
+	}
+	if gateway == "sftp" && *user == "" && *authorizedKeys == "" {
+		exitf(exitConfig, "sftp needs -user with DRIVE_SERVE_PASSWORD, or -authorized-keys")
+	}
+	root, err := resolveRemote(srv, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	remoteFS, err := newRemoteFS(srv, root)
+	if err != nil {
+		return err
+	}
+	if gateway == "webdav" {
+		return serveWebDAV(remoteFS, *addr, *user, password)
+	}
+	auth := &sftpAuth{user: *user}
+	if *user != "" {
+		auth.password = password
+	}
+	if *authorizedKeys != "" {
+		if auth.keys, err = loadAuthorizedKeys(expandHome(*authorizedKeys)); err != nil {
+			exitf(exitConfig, "-authorized-keys: %v", err)
+		}
+	}
+	signer, err := loadHostKey(expandHome(*hostKey))
+	if err != nil {
+		exitf(exitConfig, "-host-key: %v", err)
+	}
+	return serveSFTP(remoteFS, *addr, signer, auth)
+}
+
+// apiOptions are the flags of serve api.
+type apiOptions struct {
+	addr     string
+	grpcAddr string // "" for no gRPC
+	token    string // clients must send
+}
+
+// apiFlags parses the flags of serve api, returning the arguments left,
+// the base path.
+func apiFlags(args []string) (apiOptions, []string) {
+	var opts apiOptions
+	fs := flag.NewFlagSet("serve api", flag.ContinueOnError)
+	fs.StringVar(&opts.addr, "addr", "127.0.0.1:8090", "address to serve the REST API on")
+	fs.StringVar(&opts.grpcAddr, "grpc-addr", "", "address to serve the gRPC interface on too, e.g. 127.0.0.1:8091")
+	if err := fs.Parse(args); err != nil {
+		exit(exitConfig)
+	}
+	if opts.token = os.Getenv("DRIVE_API_TOKEN"); opts.token == "" {
+		exitf(exitConfig, "serve api needs the token clients send in DRIVE_API_TOKEN")
+	}
+	return opts, fs.Args()
+}
@@ -0,0 +1,106 @@
+package syncer
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// envPrefix starts the environment variables setting flags: DRIVE_CHECKERS
+// sets -checkers, DRIVE_MIN_SIZE -min-size.
+const envPrefix = "DRIVE_"
+
+// defaultConfigFile is read if -config isn't given and it exists.
+func defaultConfigFile() string {
+	file := defaultConfigPath()
+	if _, err := os.Stat(file); err != nil {
+		return ""
+	}
+	return file
+}
+
+// defaultConfigPath is where the default config file is, or is created.
+func defaultConfigPath() string {
+	usr, err := user.Current()
+	if err != nil {
+		return "config.yaml"
+	}
+	return filepath.Join(usr.HomeDir, ".config", "drive", "config.yaml")
+}
+
+// envName returns the environment variable of a flag.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// envArgs returns the arguments DRIVE_COMMAND and DRIVE_BASE_PATH set, so
+// that a container can be set up by the environment alone.
+func envArgs() []string {
+	var args []string
+	for _, name := range []string{"command", "base_path"} {
+		if value := os.Getenv(envName(name)); value != "" {
+			args = append(args, value)
+		}
+	}
+	return args
+}
+
+// Sources of the flag values, as config show tells them.
+const (
+	sourceDefault     = "default"
+	sourceCommandLine = "command line"
+	sourceConfigFile  = "config file"
+)
+
+// applySettings sets the flags not given on the command line from the
+// environment or else from the settings of the config file, which have no
+// unknown ones. The command line wins over the environment, which wins
+// over the config file. It returns where the value of each flag set came
+// from.
+func applySettings(fs *flag.FlagSet, settings map[string]json.RawMessage) (map[string]string, error) {
+	sources := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) { sources[f.Name] = sourceCommandLine })
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || sources[f.Name] != "" {
+			return
+		}
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			if serr := fs.Set(f.Name, value); serr != nil {
+				err = fmt.Errorf("%s: %v", envName(f.Name), serr)
+			}
+			sources[f.Name] = envName(f.Name)
+			return
+		}
+		if raw, ok := settings[f.Name]; ok {
+			if serr := setFromJSON(fs, f.Name, raw); serr != nil {
+				err = fmt.Errorf("setting %s: %v", f.Name, serr)
+			}
+			sources[f.Name] = sourceConfigFile
+		}
+	})
+	return sources, err
+}
+
+// setFromJSON sets a flag to a value of the config file: a string, number
+// or boolean, or a list of them for repeatable flags.
+func setFromJSON(fs *flag.FlagSet, name string, raw json.RawMessage) error {
+	var list []json.RawMessage
+	if json.Unmarshal(raw, &list) == nil {
+		for _, item := range list {
+			if err := setFromJSON(fs, name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var s string
+	if json.Unmarshal(raw, &s) != nil {
+		s = string(raw)
+	}
+	return fs.Set(name, s)
+}
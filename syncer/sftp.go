@@ -0,0 +1,395 @@
+package syncer
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hiroshi/googledriveclient/drivefs"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// defaultHostKeyFile is the host key of serve sftp, created if missing.
+const defaultHostKeyFile = "sftp_host_key"
+
+// sftpAuth is who may log in to serve sftp: user with password, if set,
+// or with one of keys.
+type sftpAuth struct {
+	user     string
+	password string
+	keys     []ssh.PublicKey
+}
+
+// loadHostKey reads the private host key in file, creating an ed25519 key
+// there if it doesn't exist.
+func loadHostKey(file string) (ssh.Signer, error) {
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		block, err := ssh.MarshalPrivateKey(key, "drive serve sftp")
+		if err != nil {
+			return nil, err
+		}
+		b = pem.EncodeToMemory(block)
+		if err := ioutil.WriteFile(file, b, 0600); err != nil {
+			return nil, err
+		}
+		infof("Created host key %s\n", file)
+	} else if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(b)
+}
+
+// loadAuthorizedKeys reads the public keys of an authorized_keys file.
+func loadAuthorizedKeys(file string) ([]ssh.PublicKey, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var keys []ssh.PublicKey
+	for len(bytes.TrimSpace(b)) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		keys = append(keys, key)
+		b = rest
+	}
+	return keys, nil
+}
+
+// serveSFTP serves fs over SFTP on addr until the process ends.
+func serveSFTP(fs *drivefs.FS, addr string, hostKey ssh.Signer, auth *sftpAuth) error {
+	config := &ssh.ServerConfig{}
+	if auth.password != "" {
+		config.PasswordCallback = func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if subtle.ConstantTimeCompare([]byte(c.User()), []byte(auth.user)) == 1 &&
+				subtle.ConstantTimeCompare(password, []byte(auth.password)) == 1 {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("wrong user or password")
+		}
+	}
+	if len(auth.keys) > 0 {
+		config.PublicKeyCallback = func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if auth.user != "" && c.User() != auth.user {
+				return nil, fmt.Errorf("wrong user")
+			}
+			for _, k := range auth.keys {
+				if bytes.Equal(k.Marshal(), key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, fmt.Errorf("unknown key")
+		}
+	}
+	config.AddHostKey(hostKey)
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	infof("Serving SFTP on %s, host key %s\n", addr, ssh.FingerprintSHA256(hostKey.PublicKey()))
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go serveSFTPConn(fs, conn, config)
+	}
+}
+
+// serveSFTPConn serves the sftp subsystem on the sessions of an SSH
+// connection.
+func serveSFTPConn(fs *drivefs.FS, conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	sconn, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		log.Printf("SFTP %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	defer sconn.Close()
+	infof("SFTP login of %s from %s\n", sconn.User(), sconn.RemoteAddr())
+	go ssh.DiscardRequests(requests)
+	h := &sftpHandler{fs: fs, uploads: make(map[string]*sftpUpload)}
+	handlers := sftp.Handlers{FileGet: h, FilePut: h, FileCmd: h, FileList: h}
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			log.Printf("SFTP %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+		go func() {
+			for req := range requests {
+				// Only the sftp subsystem is served, no shell or
+				// commands.
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					server := sftp.NewRequestServer(sftpChannel{channel}, handlers)
+					if err := server.Serve(); err != nil && err != io.EOF {
+						log.Printf("SFTP %s: %v", conn.RemoteAddr(), err)
+					}
+					server.Close()
+					// scp fails without an exit status.
+					channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+					channel.Close()
+				}
+			}
+		}()
+	}
+}
+
+// sftpChannel keeps the session open when the SFTP server closes it, only
+// ending what it writes, so that the exit status can follow.
+type sftpChannel struct {
+	ssh.Channel
+}
+
+func (c sftpChannel) Close() error {
+	return c.CloseWrite()
+}
+
+// sftpHandler answers the SFTP requests of a connection from a remote tree.
+type sftpHandler struct {
+	fs      *drivefs.FS
+	mu      sync.Mutex
+	uploads map[string]*sftpUpload // open for writing, by path
+}
+
+// Fileread downloads the file into a temporary file, which the reads wait
+// for: clients read many chunks at once, in any order.
+func (h *sftpHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	info, err := h.fs.Stat(r.Context(), r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a folder", r.Filepath)
+	}
+	tmp, err := ioutil.TempFile("", "drive-sftp-")
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &sftpDownload{tmp: tmp, size: info.Size(), cancel: cancel}
+	d.cond = sync.NewCond(&d.mu)
+	go d.fetch(ctx, h.fs.NewReader(ctx, info))
+	return d, nil
+}
+
+// Filewrite takes the content in a temporary file, uploaded once the
+// client closes it.
+func (h *sftpHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	if _, _, err := h.fs.Parent(r.Context(), r.Filepath); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile("", "drive-sftp-")
+	if err != nil {
+		return nil, err
+	}
+	u := &sftpUpload{h: h, name: r.Filepath, tmp: tmp}
+	h.mu.Lock()
+	h.uploads[r.Filepath] = u
+	h.mu.Unlock()
+	return u, nil
+}
+
+func (h *sftpHandler) Filecmd(r *sftp.Request) error {
+	err := h.command(r)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("SFTP %s %s: %v", r.Method, r.Filepath, err)
+	}
+	return err
+}
+
+func (h *sftpHandler) command(r *sftp.Request) error {
+	ctx := r.Context()
+	switch r.Method {
+	case "Setstat":
+		// Only the modification time is kept, e.g. of scp -p.
+		if !r.AttrFlags().Acmodtime {
+			return nil
+		}
+		mtime := time.Unix(int64(r.Attributes().Mtime), 0)
+		h.mu.Lock()
+		u := h.uploads[r.Filepath]
+		if u != nil {
+			u.modTime = mtime
+		}
+		h.mu.Unlock()
+		if u != nil {
+			return nil
+		}
+		return h.fs.Update(ctx, r.Filepath, &drive.File{ModifiedTime: mtime.UTC().Format(time.RFC3339)})
+	case "Rename":
+		return h.fs.Rename(ctx, r.Filepath, r.Target)
+	case "Remove":
+		return h.fs.Remove(ctx, r.Filepath, false)
+	case "Rmdir":
+		return h.fs.Remove(ctx, r.Filepath, true)
+	case "Mkdir":
+		return h.fs.Mkdir(ctx, r.Filepath)
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (h *sftpHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	info, err := h.fs.Stat(r.Context(), r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	switch r.Method {
+	case "List":
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s isn't a folder", r.Filepath)
+		}
+		infos, err := h.fs.ReadDir(r.Context(), info)
+		if err != nil {
+			return nil, err
+		}
+		list := make(sftpList, len(infos))
+		for i := range infos {
+			list[i] = infos[i]
+		}
+		return list, nil
+	case "Stat":
+		return sftpList{info}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+type sftpList []os.FileInfo
+
+func (l sftpList) ListAt(infos []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(infos, l[offset:])
+	if n < len(infos) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// sftpUpload is a file written by a client.
+type sftpUpload struct {
+	h       *sftpHandler
+	name    string
+	tmp     *os.File
+	modTime time.Time // set while open, zero for the upload time
+}
+
+func (u *sftpUpload) WriteAt(b []byte, off int64) (int, error) {
+	return u.tmp.WriteAt(b, off)
+}
+
+func (u *sftpUpload) Close() error {
+	defer os.Remove(u.tmp.Name())
+	defer u.tmp.Close()
+	u.h.mu.Lock()
+	if u.h.uploads[u.name] == u {
+		delete(u.h.uploads, u.name)
+	}
+	modTime := u.modTime
+	u.h.mu.Unlock()
+	if err := u.h.fs.Upload(context.Background(), u.name, u.tmp, modTime); err != nil {
+		log.Printf("SFTP upload of %s failed: %v", u.name, err)
+		return err
+	}
+	infof("SFTP uploaded %s\n", u.name)
+	return nil
+}
+
+// sftpDownload is a file read by a client, downloaded into tmp.
+type sftpDownload struct {
+	tmp    *os.File
+	size   int64
+	cancel func()
+	mu     sync.Mutex
+	cond   *sync.Cond
+	done   int64 // bytes in tmp
+	err    error // which stopped the download
+}
+
+// fetch copies r into tmp, downloading again from where it stopped when the
+// connection breaks.
+func (d *sftpDownload) fetch(ctx context.Context, r *drivefs.Reader) {
+	defer r.Close()
+	buf := make([]byte, 256<<10)
+	failures := 0
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, werr := d.tmp.WriteAt(buf[:n], r.Offset()-int64(n)); werr != nil {
+				err = werr
+			}
+		}
+		if err != nil && err != io.EOF && retryable(err) && failures < maxRetries && ctx.Err() == nil {
+			failures++
+			r.Drop()
+			err = nil
+		}
+		d.mu.Lock()
+		d.done = r.Offset()
+		if err != nil {
+			d.err = err
+		}
+		d.cond.Broadcast()
+		d.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (d *sftpDownload) ReadAt(b []byte, off int64) (int, error) {
+	if off >= d.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(b))
+	if end > d.size {
+		end = d.size
+	}
+	d.mu.Lock()
+	for d.done < end && d.err == nil {
+		d.cond.Wait()
+	}
+	err := d.err
+	done := d.done
+	d.mu.Unlock()
+	if done < end {
+		return 0, err
+	}
+	n, err := d.tmp.ReadAt(b[:end-off], off)
+	if err == nil && n < len(b) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (d *sftpDownload) Close() error {
+	d.cancel()
+	d.tmp.Close()
+	return os.Remove(d.tmp.Name())
+}
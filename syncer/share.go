@@ -0,0 +1,298 @@
+package syncer
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/hiroshi/googledriveclient/drivefs"
+	"google.golang.org/api/drive/v3"
+)
+
+// grantee is whom share gives access: a user or group by email address, a
+// domain or anyone with the link.
+type grantee struct {
+	kind  string // user, group, domain or anyone
+	value string // the email address or domain
+}
+
+// parseGrantee parses the grantee s of share: an email address,
+// group:address, domain:name or anyone.
+func parseGrantee(s string) (grantee, error) {
+	switch {
+	case s == "anyone":
+		return grantee{kind: "anyone"}, nil
+	case strings.HasPrefix(s, "group:"):
+		return grantee{kind: "group", value: strings.TrimPrefix(s, "group:")}, nil
+	case strings.HasPrefix(s, "domain:"):
+		return grantee{kind: "domain", value: strings.TrimPrefix(s, "domain:")}, nil
+	case strings.Contains(s, "@"):
+		return grantee{kind: "user", value: s}, nil
+	}
+	return grantee{}, fmt.Errorf("%q is no email address, group:address, domain:name or anyone", s)
+}
+
+// matches reports whether the permission p is the grantee's.
+func (g grantee) matches(p *drive.Permission) bool {
+	if p.Type != g.kind {
+		return false
+	}
+	switch g.kind {
+	case "user", "group":
+		return strings.EqualFold(p.EmailAddress, g.value)
+	case "domain":
+		return strings.EqualFold(p.Domain, g.value)
+	}
+	return true
+}
+
+func (g grantee) String() string {
+	if g.value == "" {
+		return g.kind
+	}
+	return g.value
+}
+
+// permission returns the permission giving the grantee role.
+func (g grantee) permission(role string) *drive.Permission {
+	p := &drive.Permission{Type: g.kind, Role: role}
+	switch g.kind {
+	case "user", "group":
+		p.EmailAddress = g.value
+	case "domain":
+		p.Domain = g.value
+	}
+	return p
+}
+
+// shareCommand runs share: it gives a grantee a role on remote files and
+// folders, or takes their access away, with -recursive on every item below
+// the folders too.
+func shareCommand(srv *drive.Service, args []string) error {
+	const usage = "usage: share [-role reader|commenter|writer] [-remove] [-recursive] [-dry-run] [-notify] email|group:email|domain:name|anyone remotePath..."
+	fs := flag.NewFlagSet("share", flag.ContinueOnError)
+	role := fs.String("role", "reader", "the `role` given: reader, commenter or writer")
+	remove := fs.Bool("remove", false, "take the access of the grantee away instead")
+	recursive := fs.Bool("recursive", false, "also change every file and folder below the folders")
+	dryRun := fs.Bool("dry-run", false, "print the changes without making them")
+	notify := fs.Bool("notify", false, "email users and groups given access")
+	if err := fs.Parse(args); err != nil {
+		exit(exitConfig)
+	}
+	if fs.NArg() < 2 {
+		exitf(exitConfig, usage)
+	}
+	g, err := parseGrantee(fs.Arg(0))
+	if err != nil {
+		exitf(exitConfig, "%v", err)
+	}
+	switch *role {
+	case "reader", "commenter", "writer":
+	default:
+		exitf(exitConfig, "-role %s: must be reader, commenter or writer", *role)
+	}
+	handleSignals()
+	items, err := remoteItems(srv, fs.Args()[1:], *recursive)
+	if err != nil {
+		return err
+	}
+	s := &sharing{srv: srv, grantee: g, role: *role, remove: *remove, dryRun: *dryRun, notify: *notify}
+	s.failed = len(eachItem(items, s.item))
+	verb := "Shared"
+	if *dryRun {
+		verb = "Would share"
+	}
+	fmt.Printf("%s %d, changed %d, removed %d, %d up to date, %d failed\n", verb, s.added, s.changed, s.removed, s.upToDate, s.failed)
+	if stopping() {
+		return errors.New("interrupted")
+	}
+	if s.failed > 0 {
+		exit(exitPartial)
+	}
+	return nil
+}
+
+// shareItem is a remote file or folder share or chown changes.
+type shareItem struct {
+	path string
+	file drive.File
+}
+
+// remoteItems returns the remote files and folders at the paths names, and
+// with recursive those below the folders too.
+func remoteItems(srv *drive.Service, names []string, recursive bool) ([]shareItem, error) {
+	remoteFS, err := newRemoteFS(srv, "")
+	if err != nil {
+		return nil, err
+	}
+	remoteFS.Natives = true
+	var items []shareItem
+	for _, name := range names {
+		p, err := resolveRemote(srv, name)
+		if err != nil {
+			return nil, err
+		}
+		info, err := remoteFS.Stat(runCtx, p)
+		p = path.Clean("/" + p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", p, err)
+		}
+		items = append(items, shareItem{p, info.File})
+		if recursive && info.IsDir() {
+			if items, err = shareTree(remoteFS, info, p, items); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return items, nil
+}
+
+// shareTree appends the files and folders below dir, at p, to items.
+func shareTree(fs *drivefs.FS, dir drivefs.FileInfo, p string, items []shareItem) ([]shareItem, error) {
+	infos, err := fs.ReadDir(runCtx, dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", p, err)
+	}
+	for _, info := range infos {
+		childPath := path.Join(p, info.Name())
+		items = append(items, shareItem{childPath, info.File})
+		if info.IsDir() {
+			if items, err = shareTree(fs, info, childPath, items); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return items, nil
+}
+
+// eachItem runs do on the items, metadataConcurrency at a time, and prints
+// what it did to each with the progress made. It returns the errors of the
+// items do failed on, by path.
+func eachItem(items []shareItem, do func(shareItem) (string, error)) map[string]error {
+	failed := make(map[string]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, metadataConcurrency)
+	done := 0
+	for _, item := range items {
+		if stopping() {
+			break
+		}
+		item := item
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			result, err := do(item)
+			mu.Lock()
+			defer mu.Unlock()
+			done++
+			if err != nil {
+				failed[item.path] = err
+				fmt.Println(paint(colorRed, fmt.Sprintf("[%d/%d] %s failed: %v", done, len(items), item.path, err)))
+				return
+			}
+			fmt.Printf("[%d/%d] %s: %s\n", done, len(items), item.path, result)
+		}()
+	}
+	wg.Wait()
+	return failed
+}
+
+// sharing changes the access of a grantee on remote items.
+type sharing struct {
+	srv     *drive.Service
+	grantee grantee
+	role    string
+	remove  bool
+	dryRun  bool
+	notify  bool
+
+	mu       sync.Mutex
+	added    int
+	changed  int
+	removed  int
+	upToDate int
+	failed   int
+}
+
+// item changes the access of the grantee on one item and returns what it
+// did.
+func (s *sharing) item(item shareItem) (string, error) {
+	var perms []*drive.Permission
+	err := retry("Listing the permissions of "+item.path, func() error {
+		perms = nil
+		return s.srv.Permissions.List(item.file.Id).Fields("nextPageToken, permissions(id, type, role, emailAddress, domain)").
+			Context(runCtx).Pages(runCtx, func(r *drive.PermissionList) error {
+			perms = append(perms, r.Permissions...)
+			return nil
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	var existing *drive.Permission
+	for _, p := range perms {
+		if s.grantee.matches(p) {
+			existing = p
+			break
+		}
+	}
+	prefix := ""
+	if s.dryRun {
+		prefix = "would be "
+	}
+	switch {
+	case s.remove && existing == nil, !s.remove && existing != nil && existing.Role == s.role:
+		s.count(&s.upToDate)
+		return "up to date", nil
+	case s.remove:
+		if !s.dryRun {
+			err = retry("Unsharing "+item.path, func() error {
+				return s.srv.Permissions.Delete(item.file.Id, existing.Id).Context(runCtx).Do()
+			})
+		}
+		if err == nil {
+			s.count(&s.removed)
+		}
+		return fmt.Sprintf("%sunshared with %s", prefix, s.grantee), err
+	case existing != nil:
+		if existing.Role == "owner" {
+			return "", fmt.Errorf("%s owns it", s.grantee)
+		}
+		if !s.dryRun {
+			err = retry("Sharing "+item.path, func() error {
+				_, err := s.srv.Permissions.Update(item.file.Id, existing.Id, &drive.Permission{Role: s.role}).
+					Fields("id").Context(runCtx).Do()
+				return err
+			})
+		}
+		if err == nil {
+			s.count(&s.changed)
+		}
+		return fmt.Sprintf("%schanged from %s to %s for %s", prefix, existing.Role, s.role, s.grantee), err
+	}
+	if !s.dryRun {
+		err = retry("Sharing "+item.path, func() error {
+			call := s.srv.Permissions.Create(item.file.Id, s.grantee.permission(s.role)).Fields("id").Context(runCtx)
+			if s.grantee.kind == "user" || s.grantee.kind == "group" {
+				call.SendNotificationEmail(s.notify)
+			}
+			_, err := call.Do()
+			return err
+		})
+	}
+	if err == nil {
+		s.count(&s.added)
+	}
+	return fmt.Sprintf("%sshared with %s as %s", prefix, s.grantee, s.role), err
+}
+
+func (s *sharing) count(n *int) {
+	s.mu.Lock()
+	*n++
+	s.mu.Unlock()
+}
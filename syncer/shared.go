@@ -0,0 +1,92 @@
+package syncer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// remoteTop returns the outermost folder, or the file itself, reachable by
+// following the parents of the file.
+func remoteTop(idx *remoteIndex, file drive.File) drive.File {
+	if len(file.Parents) > 0 {
+		if top, ok := idx.tops[file.Parents[0]]; ok {
+			return idx.folders[top]
+		}
+	}
+	return file
+}
+
+// Sections of Drive a file can live in.
+const (
+	sectionMyDrive   = "My Drive"
+	sectionShared    = "Shared with me"
+	sectionComputers = "Computers"
+	sectionOrphans   = "Orphans"
+)
+
+// remoteSection tells where in Drive the file lives. Items shared with me
+// are marked by sharedWithMeTime; the folders of Google's desktop backup
+// client are my own folders outside the My Drive root, which have no parent
+// at all. Anything else whose parent chain doesn't end at the My Drive root
+// is an orphan. The id of the My Drive root may be unknown if it wasn't
+// recorded, in which case everything else counts as My Drive.
+func remoteSection(idx *remoteIndex, file drive.File) string {
+	top := remoteTop(idx, file)
+	if top.SharedWithMeTime != "" {
+		return sectionShared
+	}
+	if idx.rootId == "" {
+		return sectionMyDrive
+	}
+	if len(top.Parents) == 0 && top.MimeType == folderMimeType && top.OwnedByMe {
+		return sectionComputers
+	}
+	if len(top.Parents) == 0 || top.Parents[0] != idx.rootId {
+		return sectionOrphans
+	}
+	return sectionMyDrive
+}
+
+func remoteRootId(srv *drive.Service) (string, error) {
+	var root *drive.File
+	err := retry("Getting the root folder", func() (err error) {
+		root, err = srv.Files.Get("root").Fields("id").Do()
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to retrieve the root folder: %v", err)
+	}
+	return root.Id, nil
+}
+
+// remoteWritable reports whether I may modify the remote file. Files listed
+// before capabilities were requested count as writable.
+func remoteWritable(file drive.File) bool {
+	return file.Capabilities == nil || file.Capabilities.CanEdit
+}
+
+// protectReadOnly makes the local copy of a remote file I can't edit
+// read-only, so local changes to it are not expected to sync. The CSV files
+// of a spreadsheet exported with SheetsCSV are made read-only one by one, as
+// their directory is replaced on the next export.
+func protectReadOnly(localPath string, file drive.File) error {
+	if remoteWritable(file) {
+		return nil
+	}
+	if fi, err := os.Stat(localPath); err == nil && fi.IsDir() {
+		entries, err := os.ReadDir(localPath)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := protectReadOnly(filepath.Join(localPath, e.Name()), file); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return os.Chmod(localPath, 0444)
+}
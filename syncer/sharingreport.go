@@ -0,0 +1,230 @@
+package syncer
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// internalDomains are the domains of -internal-domain, which sharing-report
+// doesn't report sharing with.
+var internalDomains []string
+
+// sharingFields are the fields of the files sharing-report reads.
+const sharingFields = "nextPageToken, files(id, name, mimeType, parents, webViewLink, owners(emailAddress), " +
+	"sharingUser(emailAddress), sharedWithMeTime, permissions(type, role, emailAddress, domain))"
+
+// externalShare is a permission of a file given outside the internal
+// domains.
+type externalShare struct {
+	path     string
+	file     *drive.File
+	with     string // whom: an email address, a domain or anyone with the link
+	role     string
+	sharedBy string
+	sharedAt string // when the file was shared with the user, "" if unknown
+}
+
+// shareRecord is an external share as sharing-report prints it with
+// -format json, with the columns of the CSV.
+type shareRecord struct {
+	Path       string `json:"path"`
+	Id         string `json:"id"`
+	Type       string `json:"type"`
+	SharedWith string `json:"sharedWith"`
+	Role       string `json:"role"`
+	SharedBy   string `json:"sharedBy"`
+	SharedAt   string `json:"sharedAt,omitempty"`
+	Link       string `json:"link"`
+}
+
+// sharingReportCommand runs sharing-report: it lists the files and folders
+// shared outside the internal domains or with anyone with the link, by
+// their permissions, as text, CSV or, with -format json, a JSON object per
+// permission.
+func sharingReportCommand(srv *drive.Service, args []string) error {
+	fs := flag.NewFlagSet("sharing-report", flag.ContinueOnError)
+	csvFile := fs.String("csv", "", "write the report as CSV to this `file`, - for standard output")
+	if err := fs.Parse(args); err != nil {
+		exit(exitConfig)
+	}
+	if fs.NArg() > 1 {
+		exitf(exitConfig, "usage: sharing-report [-csv file] [remotePath]")
+	}
+	handleSignals()
+	internal := internalDomains
+	if len(internal) == 0 {
+		// Without -internal-domain, the domain of the account is.
+		email, err := accountEmail(srv)
+		if err != nil {
+			return err
+		}
+		if _, domain, ok := strings.Cut(email, "@"); ok {
+			internal = []string{domain}
+		}
+	}
+	shares, err := externalShares(srv, internal)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 1 {
+		prefix, err := resolveRemote(srv, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if prefix != "" {
+			var below []externalShare
+			for _, s := range shares {
+				if s.path == prefix || strings.HasPrefix(s.path, prefix+"/") {
+					below = append(below, s)
+				}
+			}
+			shares = below
+		}
+	}
+	if *csvFile != "" {
+		return writeSharingCSV(*csvFile, shares)
+	}
+	if outputFormat == formatJSON {
+		for _, s := range shares {
+			r := shareRecord{s.path, s.file.Id, s.file.MimeType, s.with, s.role, s.sharedBy, s.sharedAt, s.file.WebViewLink}
+			if err := writeJSON(&r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, s := range shares {
+		line := fmt.Sprintf("%s: %s (%s), shared by %s", s.path, s.with, s.role, s.sharedBy)
+		if s.sharedAt != "" {
+			line += " on " + s.sharedAt
+		}
+		fmt.Println(line)
+	}
+	fmt.Printf("%d external shares, outside %s\n", len(shares), strings.Join(internal, ", "))
+	return nil
+}
+
+// externalShares lists the files of the user and shared with them and
+// returns their permissions given outside the domains internal, by path.
+func externalShares(srv *drive.Service, internal []string) ([]externalShare, error) {
+	var root *drive.File
+	err := retry("Getting the root folder", func() (err error) {
+		root, err = srv.Files.Get("root").Fields("id").Context(runCtx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var files []*drive.File
+	err = retry("Listing the files", func() error {
+		files = nil
+		return srv.Files.List().Q("trashed = false").PageSize(1000).Fields(sharingFields).Context(runCtx).
+			Pages(runCtx, func(r *drive.FileList) error {
+				files = append(files, r.Files...)
+				return nil
+			})
+	})
+	if err != nil {
+		return nil, err
+	}
+	byId := make(map[string]*drive.File)
+	for _, f := range files {
+		byId[f.Id] = f
+	}
+	var shares []externalShare
+	for _, f := range files {
+		for _, p := range f.Permissions {
+			with, ok := externalGrantee(p, internal)
+			if !ok {
+				continue
+			}
+			s := externalShare{path: sharingPath(f, byId, root.Id), file: f, with: with, role: p.Role, sharedAt: f.SharedWithMeTime}
+			if f.SharingUser != nil {
+				s.sharedBy = f.SharingUser.EmailAddress
+			} else if len(f.Owners) > 0 {
+				s.sharedBy = f.Owners[0].EmailAddress
+			}
+			shares = append(shares, s)
+		}
+	}
+	sort.SliceStable(shares, func(i, j int) bool { return shares[i].path < shares[j].path })
+	return shares, nil
+}
+
+// externalGrantee returns whom the permission p gives access and whether
+// that is outside the domains internal.
+func externalGrantee(p *drive.Permission, internal []string) (string, bool) {
+	isInternal := func(domain string) bool {
+		for _, d := range internal {
+			if strings.EqualFold(d, domain) {
+				return true
+			}
+		}
+		return false
+	}
+	switch p.Type {
+	case "anyone":
+		return "anyone with the link", true
+	case "domain":
+		return "domain " + p.Domain, !isInternal(p.Domain)
+	case "user", "group":
+		_, domain, _ := strings.Cut(p.EmailAddress, "@")
+		return p.EmailAddress, !isInternal(domain)
+	}
+	return "", false
+}
+
+// sharingPath returns the path of f in My Drive, or below "Shared with me"
+// if its folders aren't there.
+func sharingPath(f *drive.File, byId map[string]*drive.File, rootId string) string {
+	names := []string{f.Name}
+	for seen := 0; len(f.Parents) > 0 && seen < len(byId); seen++ {
+		if f.Parents[0] == rootId {
+			return "/" + path.Join(reverse(names)...)
+		}
+		parent, ok := byId[f.Parents[0]]
+		if !ok {
+			break
+		}
+		names = append(names, parent.Name)
+		f = parent
+	}
+	return "/Shared with me/" + path.Join(reverse(names)...)
+}
+
+func reverse(names []string) []string {
+	reversed := make([]string, len(names))
+	for i, name := range names {
+		reversed[len(names)-1-i] = name
+	}
+	return reversed
+}
+
+// writeSharingCSV writes the shares to file as CSV, or to the standard
+// output if file is "-".
+func writeSharingCSV(file string, shares []externalShare) error {
+	var out io.Writer = os.Stdout
+	if file != "-" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	w := csv.NewWriter(out)
+	w.Write([]string{"path", "id", "type", "shared with", "role", "shared by", "shared at", "link"})
+	for _, s := range shares {
+		w.Write([]string{s.path, s.file.Id, s.file.MimeType, s.with, s.role, s.sharedBy, s.sharedAt, s.file.WebViewLink})
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,112 @@
+package syncer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/hiroshi/googledriveclient/drivefs"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/sheets/v4"
+)
+
+const spreadsheetMimeType = googleAppsPrefix + "spreadsheet"
+
+// sheetsCSV is the export format of spreadsheets in pairs with SheetsCSV
+// set: a directory named like the spreadsheet holding a CSV file per tab,
+// read with the Sheets API. Drive's own CSV export only has the first tab.
+var sheetsCSV = exportFormat{MimeType: "text/csv"}
+
+// sheetsService is the Sheets API of the account of -token-file, set up by
+// the first driveService.
+var sheetsService *sheets.Service
+
+// sheetRange returns the A1 range of the whole tab title.
+func sheetRange(title string) string {
+	return "'" + strings.Replace(title, "'", "''", -1) + "'"
+}
+
+// exportSheets writes the tabs of the spreadsheet file to the directory
+// dir, named by their titles. The tabs are written to a temporary directory
+// first which then replaces dir, so tabs removed since go away too.
+func exportSheets(file drive.File, dir string, job *transferJob) error {
+	var tabs []string
+	var values []*sheets.ValueRange
+	err := retry("Export of "+file.Name, func() error {
+		job.restart()
+		s, err := sheetsService.Spreadsheets.Get(contentId(file)).Fields("sheets(properties(title))").Context(job.ctx).Do()
+		if err != nil {
+			return err
+		}
+		tabs, values = nil, nil
+		for _, sheet := range s.Sheets {
+			tabs = append(tabs, sheet.Properties.Title)
+		}
+		if len(tabs) == 0 {
+			return nil
+		}
+		ranges := make([]string, len(tabs))
+		for i, title := range tabs {
+			ranges[i] = sheetRange(title)
+		}
+		resp, err := sheetsService.Spreadsheets.Values.BatchGet(contentId(file)).Ranges(ranges...).Context(job.ctx).Do()
+		if err != nil {
+			return err
+		}
+		if len(resp.ValueRanges) != len(tabs) {
+			return fmt.Errorf("%d ranges read for %d tabs", len(resp.ValueRanges), len(tabs))
+		}
+		values = resp.ValueRanges
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("export failed: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(filepath.Dir(dir), ".drive-tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return err
+	}
+	for i, title := range tabs {
+		name := drivefs.SanitizeName(title, runtime.GOOS == "windows") + ".csv"
+		if err := writeCSV(filepath.Join(tmp, name), values[i].Values); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dir)
+}
+
+// writeCSV writes the rows of cells to the file localPath.
+func writeCSV(localPath string, rows [][]interface{}) error {
+	out, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(out)
+	for _, row := range rows {
+		record := make([]string, len(row))
+		for i, cell := range row {
+			record[i] = fmt.Sprint(cell)
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,127 @@
+package syncer
+
+import (
+	"log"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// contentId returns the id of the Drive file with the content of file: the
+// target's for the files standing in for shortcuts followed, whose ids are
+// made up, else the file's own.
+func contentId(file drive.File) string {
+	if file.MimeType != shortcutMimeType && file.ShortcutDetails != nil && file.ShortcutDetails.TargetId != "" {
+		return file.ShortcutDetails.TargetId
+	}
+	return file.Id
+}
+
+// shortcutFollower replaces shortcuts with copies of their targets.
+type shortcutFollower struct {
+	byId     map[string]drive.File
+	children map[string][]drive.File // key: parent id
+	warned   map[string]bool         // shortcuts found in folders they point to
+}
+
+// followShortcuts returns files with their shortcuts replaced by copies of
+// the targets: a file at the shortcut's place for a shortcut to a file, the
+// tree of the folder for a shortcut to a folder. The copies have ids made
+// up from the shortcut's, and the id of the file with their content in
+// ShortcutDetails. Shortcuts to folders they are in, which would never end,
+// and to files not listed are left alone.
+func followShortcuts(files []drive.File) []drive.File {
+	sf := &shortcutFollower{byId: make(map[string]drive.File), children: make(map[string][]drive.File), warned: make(map[string]bool)}
+	for _, f := range files {
+		sf.byId[f.Id] = f
+		for _, parent := range f.Parents {
+			sf.children[parent] = append(sf.children[parent], f)
+		}
+	}
+	followed := make([]drive.File, 0, len(files))
+	for _, f := range files {
+		if f.MimeType != shortcutMimeType {
+			followed = append(followed, f)
+			continue
+		}
+		if copies, ok := sf.follow(f, f.Id, f.Parents, sf.ancestors(f)); ok {
+			followed = append(followed, copies...)
+		} else {
+			followed = append(followed, f)
+		}
+	}
+	return followed
+}
+
+// ancestors returns the ids of the folders f is in, following first
+// parents.
+func (sf *shortcutFollower) ancestors(f drive.File) []string {
+	var ids []string
+	for len(f.Parents) > 0 && len(ids) < len(sf.byId) {
+		ids = append(ids, f.Parents[0])
+		parent, ok := sf.byId[f.Parents[0]]
+		if !ok {
+			break
+		}
+		f = parent
+	}
+	return ids
+}
+
+// follow returns the copy of the target of the shortcut s with the id id
+// in the folders parents, and of its tree. within are the ids of the
+// folders the copy is in, which it may not be a copy of.
+func (sf *shortcutFollower) follow(s drive.File, id string, parents []string, within []string) ([]drive.File, bool) {
+	if s.ShortcutDetails == nil {
+		return nil, false
+	}
+	target, ok := sf.byId[s.ShortcutDetails.TargetId]
+	if !ok || target.MimeType == shortcutMimeType {
+		infof("Not following the shortcut %s, whose target isn't listed\n", s.Name)
+		return nil, false
+	}
+	for _, folder := range within {
+		if folder == target.Id {
+			if !sf.warned[s.Id] {
+				log.Printf("Not following the shortcut %s, which is in the folder it points to", s.Name)
+				sf.warned[s.Id] = true
+			}
+			return nil, false
+		}
+	}
+	c := sf.copy(target, id, parents)
+	c.Name = s.Name
+	copies := []drive.File{c}
+	if target.MimeType == folderMimeType {
+		copies = append(copies, sf.copyTree(target.Id, id, append(within, target.Id))...)
+	}
+	return copies, true
+}
+
+// copyTree returns copies of the files in the folder folderId, and of
+// their trees, in the copy of the folder with the id id.
+func (sf *shortcutFollower) copyTree(folderId, id string, within []string) []drive.File {
+	var copies []drive.File
+	for _, f := range sf.children[folderId] {
+		childId := id + "/" + f.Id
+		if f.MimeType == shortcutMimeType {
+			if followed, ok := sf.follow(f, childId, []string{id}, within); ok {
+				copies = append(copies, followed...)
+				continue
+			}
+		}
+		copies = append(copies, sf.copy(f, childId, []string{id}))
+		if f.MimeType == folderMimeType {
+			copies = append(copies, sf.copyTree(f.Id, childId, append(within, f.Id))...)
+		}
+	}
+	return copies
+}
+
+// copy returns a copy of f with the id id in the folders parents.
+func (sf *shortcutFollower) copy(f drive.File, id string, parents []string) drive.File {
+	if f.MimeType != shortcutMimeType {
+		f.ShortcutDetails = &drive.FileShortcutDetails{TargetId: contentId(f)}
+	}
+	f.Id, f.Parents = id, parents
+	return f
+}
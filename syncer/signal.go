@@ -0,0 +1,39 @@
+package syncer
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/net/context"
+)
+
+// processCtx is cancelled when the process is asked to stop by SIGINT or
+// SIGTERM.
+var processCtx, stopProcess = context.WithCancel(context.Background())
+
+// runCtx is cancelled when the run is asked to stop, with the process or
+// alone. Listing, scanning and transfers then stop early, transfers in
+// progress are aborted without leaving partial files, and the state and
+// journal are left so that the next run continues where this one stopped.
+var runCtx, stopRun = context.WithCancel(processCtx)
+
+// handleSignals stops the run on the first SIGINT or SIGTERM and exits at
+// once on the second.
+func handleSignals() {
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ch
+		fmt.Println("Stopping, interrupt again to quit at once")
+		stopProcess()
+		<-ch
+		os.Exit(exitInterrupted)
+	}()
+}
+
+// stopping reports whether the run was asked to stop.
+func stopping() bool {
+	return runCtx.Err() != nil
+}
@@ -0,0 +1,228 @@
+package syncer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Snapshots of a pair with Snapshots set are directories of the local root
+// named by the time their run started, e.g. 2026-10-15T132500, so they sort
+// by age. A run downloads into one with snapshotPartial appended, renamed
+// when the run is over.
+const (
+	snapshotLayout  = "2006-01-02T150405"
+	snapshotPartial = ".partial"
+)
+
+// snapshot is the directory a run downloads into and the latest complete
+// snapshot before it, whose unchanged files are hardlinked into it rather
+// than downloaded again.
+type snapshot struct {
+	root string
+	dir  string
+	prev string // "" for the first snapshot
+	// stale holds the partial snapshots of interrupted runs which
+	// aren't resumed.
+	stale []string
+}
+
+// listSnapshots returns the names of the complete and of the partial
+// snapshots in root, oldest first.
+func listSnapshots(root string) (complete, partial []string, err error) {
+	entries, err := os.ReadDir(root)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(snapshotLayout, name); err == nil {
+			complete = append(complete, name)
+		} else if _, err := time.Parse(snapshotLayout, strings.TrimSuffix(name, snapshotPartial)); err == nil && strings.HasSuffix(name, snapshotPartial) {
+			partial = append(partial, name)
+		}
+	}
+	return complete, partial, nil
+}
+
+// newSnapshot plans the snapshot of a run in root. The partial snapshot
+// of an interrupted run is continued if resume is set.
+func newSnapshot(root string, resume bool) (*snapshot, error) {
+	complete, partial, err := listSnapshots(root)
+	if err != nil {
+		return nil, err
+	}
+	s := &snapshot{root: root}
+	if len(complete) > 0 {
+		s.prev = filepath.Join(root, complete[len(complete)-1])
+	}
+	if resume && len(partial) > 0 {
+		s.dir = filepath.Join(root, partial[len(partial)-1])
+		partial = partial[:len(partial)-1]
+	} else {
+		s.dir = filepath.Join(root, time.Now().Format(snapshotLayout)+snapshotPartial)
+	}
+	for _, name := range partial {
+		s.stale = append(s.stale, filepath.Join(root, name))
+	}
+	return s, nil
+}
+
+// pair returns the pair downloading into the snapshot.
+func (s *snapshot) pair(p *syncPair) *syncPair {
+	snap := *p
+	snap.Local = s.dir
+	return &snap
+}
+
+// prevPair returns the pair scanning the previous snapshot, nil if there is
+// none.
+func (s *snapshot) prevPair(p *syncPair) *syncPair {
+	if s.prev == "" {
+		return nil
+	}
+	prev := *p
+	prev.Local = s.prev
+	return &prev
+}
+
+// start removes the partial snapshots left behind and creates the
+// directory of the snapshot.
+func (s *snapshot) start() error {
+	for _, dir := range s.stale {
+		infof("Remove the partial snapshot %s\n", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	return os.MkdirAll(s.dir, 0755)
+}
+
+// finish gives the snapshot its final name.
+func (s *snapshot) finish() error {
+	dir := strings.TrimSuffix(s.dir, snapshotPartial)
+	if err := os.Rename(s.dir, dir); err != nil {
+		return err
+	}
+	fmt.Printf("Snapshot %s\n", dir)
+	return nil
+}
+
+// linkFile hardlinks source to localPath, replacing what is there. Where
+// the file system has no hardlinks the file is copied. The files of a
+// directory, such as a spreadsheet exported with SheetsCSV, are linked one
+// by one.
+func linkFile(source, localPath string) error {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+	fi, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		entries, err := os.ReadDir(source)
+		if err != nil {
+			return err
+		}
+		os.RemoveAll(localPath)
+		if err := os.MkdirAll(localPath, 0755); err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := linkFile(filepath.Join(source, e.Name()), filepath.Join(localPath, e.Name())); err != nil {
+				return err
+			}
+		}
+		return os.Chtimes(localPath, fi.ModTime(), fi.ModTime())
+	}
+	os.Remove(localPath)
+	if err := os.Link(source, localPath); err == nil {
+		return nil
+	}
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := saveFile(localPath, in); err != nil {
+		return err
+	}
+	return os.Chtimes(localPath, fi.ModTime(), fi.ModTime())
+}
+
+// retained reports whether the pair has a retention policy for its
+// snapshots.
+func (p *syncPair) retained() bool {
+	return p.KeepDaily > 0 || p.KeepWeekly > 0 || p.KeepMonthly > 0
+}
+
+// expiredSnapshots returns the snapshots of names, oldest first, which the
+// retention policy of the pair doesn't keep. Each rule keeps the newest
+// snapshot of that many of the latest days, weeks or months which have one.
+// The latest snapshot is always kept, the next run links to it.
+func (p *syncPair) expiredSnapshots(names []string) []string {
+	if len(names) == 0 {
+		return nil
+	}
+	rules := []struct {
+		keep   int
+		period func(time.Time) string
+	}{
+		{p.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{p.KeepWeekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{p.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") }},
+	}
+	kept := map[string]bool{names[len(names)-1]: true}
+	for _, r := range rules {
+		periods := make(map[string]bool)
+		for i := len(names) - 1; i >= 0 && len(periods) < r.keep; i-- {
+			t, _ := time.Parse(snapshotLayout, names[i])
+			if period := r.period(t); !periods[period] {
+				periods[period] = true
+				kept[names[i]] = true
+			}
+		}
+	}
+	var expired []string
+	for _, name := range names {
+		if !kept[name] {
+			expired = append(expired, name)
+		}
+	}
+	return expired
+}
+
+// pruneSnapshots removes the snapshots of the pair its retention policy
+// doesn't keep. Files hardlinked to kept snapshots stay there; partial
+// snapshots are left to the next run.
+func pruneSnapshots(pair *syncPair) {
+	if !pair.Snapshots {
+		return
+	}
+	if !pair.retained() {
+		fmt.Printf("%s: no retention policy, keeping all snapshots\n", pair)
+		return
+	}
+	complete, _, err := listSnapshots(pair.Local)
+	if err != nil {
+		fatalf("Unable to list the snapshots of %s: %v", pair, err)
+	}
+	expired := pair.expiredSnapshots(complete)
+	for _, name := range expired {
+		dir := filepath.Join(pair.Local, name)
+		infof("Remove the snapshot %s\n", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			fatalf("os.RemoveAll(%s) failed: %v", dir, err)
+		}
+	}
+	fmt.Printf("%s: %d snapshots kept, %d removed\n", pair, len(complete)-len(expired), len(expired))
+}
@@ -0,0 +1,42 @@
+package syncer
+
+import (
+	"flag"
+	"fmt"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// starCommand runs star and unstar: it stars the remote files given, or
+// takes their star away when starred is false.
+func starCommand(srv *drive.Service, args []string, starred bool) error {
+	name := "star"
+	if !starred {
+		name = "unstar"
+	}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		exit(exitConfig)
+	}
+	if fs.NArg() == 0 {
+		exitf(exitConfig, "usage: %s remotePath...", name)
+	}
+	handleSignals()
+	for _, p := range fs.Args() {
+		file, err := remoteFileAt(srv, p)
+		if err != nil {
+			return err
+		}
+		// Starred is sent when false too, to unstar.
+		change := &drive.File{Starred: starred, ForceSendFields: []string{"Starred"}}
+		err = retry("Starring "+p, func() error {
+			_, err := srv.Files.Update(file.Id, change).Fields("id").Context(runCtx).Do()
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+		fmt.Printf("%s: %sred\n", p, name)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package syncer
+
+import (
+	"fmt"
+
+	"github.com/hiroshi/googledriveclient/state"
+)
+
+// The state of a pair, kept by the state package.
+type (
+	Files          = state.Files
+	localFile      = state.LocalFile
+	failedTransfer = state.FailedTransfer
+	stateStore     = state.Store
+)
+
+// migrateState upgrades loaded state to state.Version.
+func migrateState(files *Files) error {
+	if files.Version < state.Version && len(files.Remote)+len(files.Local) > 0 {
+		infof("Migrate state from version %d to %d\n", files.Version, state.Version)
+	}
+	return state.Migrate(files)
+}
+
+// openStates returns a function giving the state store of a pair for the
+// backend. The SQLite database is opened once and shared by all pairs.
+func openStates(backend string) (func(pair *syncPair) stateStore, func(), error) {
+	switch backend {
+	case state.JSON, state.JSONGz:
+		return func(pair *syncPair) stateStore {
+			return &state.JSONStore{File: pair.stateFile(), Compress: backend == state.JSONGz, Logf: infof}
+		}, func() {}, nil
+	case state.SQLite:
+		db, err := state.OpenDB(state.DBFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		return func(pair *syncPair) stateStore {
+			return &state.SQLiteStore{DB: db, Pair: pair.Name, LegacyFile: pair.stateFile(), Logf: infof}
+		}, func() { db.Close() }, nil
+	}
+	return nil, nil, fmt.Errorf("unknown state backend %q", backend)
+}
@@ -0,0 +1,129 @@
+package syncer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// runStats counts what a run did, over all pairs. The counters are updated
+// atomically.
+type runStats struct {
+	Checked    int64 `json:"checked"`    // files compared with the other side
+	Skipped    int64 `json:"skipped"`    // of those, files up to date or not modifiable
+	Downloaded int64 `json:"downloaded"` // files downloaded or exported
+	Uploaded   int64 `json:"uploaded"`
+	Updated    int64 `json:"updated"` // remote files whose metadata was updated
+	Deleted    int64 `json:"deleted"` // files moved to the local or Drive trash
+	Errors     int64 `json:"errors"`
+	Bytes      int64 `json:"bytes"` // bytes transferred
+
+	// Phases holds the time spent per phase in seconds.
+	Phases  map[string]float64 `json:"phaseSeconds"`
+	Elapsed float64            `json:"elapsedSeconds"`
+
+	mu    sync.Mutex
+	start time.Time
+}
+
+// Phases of a run.
+const (
+	phaseListing  = "listing"
+	phaseScanning = "scanning" // walking and hashing the local tree
+	phaseTransfer = "transfer"
+)
+
+// stats are the stats of the current run.
+var stats = newRunStats()
+
+func newRunStats() *runStats {
+	return &runStats{Phases: make(map[string]float64), start: time.Now()}
+}
+
+func (s *runStats) count(counter *int64, n int) {
+	atomic.AddInt64(counter, int64(n))
+}
+
+// snapshot returns a copy of the stats, which stays as it is while the
+// run goes on or the next one replaces them.
+func (s *runStats) snapshot() *runStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := &runStats{
+		Checked:    atomic.LoadInt64(&s.Checked),
+		Skipped:    atomic.LoadInt64(&s.Skipped),
+		Downloaded: atomic.LoadInt64(&s.Downloaded),
+		Uploaded:   atomic.LoadInt64(&s.Uploaded),
+		Updated:    atomic.LoadInt64(&s.Updated),
+		Deleted:    atomic.LoadInt64(&s.Deleted),
+		Errors:     atomic.LoadInt64(&s.Errors),
+		Bytes:      atomic.LoadInt64(&s.Bytes),
+		Phases:     make(map[string]float64, len(s.Phases)),
+		Elapsed:    s.Elapsed,
+		start:      s.start,
+	}
+	for name, seconds := range s.Phases {
+		c.Phases[name] = seconds
+	}
+	return c
+}
+
+// phase starts timing a phase, traced as a span; the returned function
+// stops it.
+func (s *runStats) phase(name string) func() {
+	start := time.Now()
+	end := enterSpan(name)
+	return func() {
+		end()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.Phases[name] += time.Since(start).Seconds()
+	}
+}
+
+// print prints the summary and, if jsonFile isn't empty, writes it there
+// as JSON, "-" meaning standard output.
+func (s *runStats) print(jsonFile string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Elapsed = time.Since(s.start).Seconds()
+	fmt.Printf("Checked %d files, %d up to date or skipped\n", s.Checked, s.Skipped)
+	nErrors := fmt.Sprintf("%d errors", s.Errors)
+	if s.Errors > 0 {
+		nErrors = paint(colorRed, nErrors)
+	}
+	fmt.Printf("Downloaded %d, uploaded %d, updated %d, deleted %d, %s transferred, %s\n",
+		s.Downloaded, s.Uploaded, s.Updated, s.Deleted, formatBytes(s.Bytes), nErrors)
+	fmt.Printf("Took %s: ", seconds(s.Elapsed))
+	for i, name := range []string{phaseListing, phaseScanning, phaseTransfer} {
+		if i > 0 {
+			fmt.Print(", ")
+		}
+		fmt.Printf("%s %s", name, seconds(s.Phases[name]))
+	}
+	fmt.Println()
+	emit(event{Event: eventSummary, Summary: s})
+	if jsonFile == "" {
+		return
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		fatalf("json.Marshal(stats) failed: %v", err)
+	}
+	b = append(b, '\n')
+	if jsonFile == "-" {
+		os.Stdout.Write(b)
+		return
+	}
+	if err := ioutil.WriteFile(jsonFile, b, 0644); err != nil {
+		fatalf("ioutil.WriteFile(%s) failed: %v", jsonFile, err)
+	}
+}
+
+func seconds(s float64) string {
+	return (time.Duration(s * float64(time.Second))).Round(time.Millisecond).String()
+}
@@ -0,0 +1,88 @@
+package syncer
+
+import (
+	"log"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+)
+
+// Spans are no-ops until setupTracing installs an exporter.
+var tracer = otel.Tracer("github.com/hiroshi/googledriveclient")
+
+// Names of the spans below a pair's span, besides the phases.
+const (
+	spanPlanning = "planning" // comparing both trees and queueing transfers
+	spanTransfer = "transfer file"
+	spanDriveAPI = "drive request"
+)
+
+var (
+	traceMu sync.Mutex
+	// traceCtx holds the innermost span entered by the main goroutine: the
+	// run, a pair or one of its phases. Spans of transfers and Drive
+	// requests, which happen on other goroutines, become its children.
+	traceCtx = context.Background()
+)
+
+// setupTracing exports spans over OTLP/HTTP to endpoint, a URL like
+// http://localhost:4318. The returned function flushes the spans not sent
+// yet.
+func setupTracing(endpoint string) func() {
+	ctx := context.Background()
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		fatalf("Unable to export traces to %s: %v", endpoint, err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "googledriveclient"))),
+	)
+	otel.SetTracerProvider(provider)
+	return func() {
+		if err := provider.Shutdown(ctx); err != nil {
+			log.Printf("Unable to export traces: %v", err)
+		}
+	}
+}
+
+// enterSpan starts a span and makes it the parent of the spans started
+// until the returned function ends it.
+func enterSpan(name string, attrs ...attribute.KeyValue) func() {
+	traceMu.Lock()
+	defer traceMu.Unlock()
+	parent := traceCtx
+	ctx, span := tracer.Start(parent, name, trace.WithAttributes(attrs...))
+	traceCtx = ctx
+	return func() {
+		span.End()
+		traceMu.Lock()
+		defer traceMu.Unlock()
+		traceCtx = parent
+	}
+}
+
+// startSpan starts a span below the current one, to be ended with endSpan.
+func startSpan(name string, attrs ...attribute.KeyValue) trace.Span {
+	traceMu.Lock()
+	parent := traceCtx
+	traceMu.Unlock()
+	_, span := tracer.Start(parent, name, trace.WithAttributes(attrs...))
+	return span
+}
+
+// endSpan ends span, marking it failed if err isn't nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
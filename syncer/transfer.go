@@ -0,0 +1,346 @@
+package syncer
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+)
+
+// transfers runs downloads and uploads on a fixed number of goroutines. A
+// failing transfer is reported and counted but doesn't stop the others.
+type transfers struct {
+	n     int
+	order transferOrder
+	queue []*transferJob
+	bytes int64         // accessed atomically
+	limit *rate.Limiter // nil for no bandwidth limit
+	// tty enables the live progress display.
+	tty bool
+	// retries records failed transfers across runs, it may be nil.
+	retries *retryQueue
+	// journal, if not nil, logs the transfers and tells those left to
+	// do after an interruption.
+	journal *journal
+
+	mu         sync.Mutex
+	start      time.Time
+	total      int
+	totalBytes int64
+	done       int
+	failed     []string
+	active     []*transferJob
+	dispatched int // transfers of queue handed to the workers
+	drawn      int // lines of progress display on the screen
+}
+
+type transferJob struct {
+	name    string
+	size    int64
+	modTime time.Time
+	run     func(job *transferJob) error
+	t       *transfers
+	done    int64 // bytes transferred, accessed atomically
+	started time.Time
+	// ctx is that of the job's requests, cancelled by skip or when the
+	// run stops.
+	ctx     context.Context
+	cancel  func()
+	skipped int32 // accessed atomically
+}
+
+// newTransfers prepares n workers whose transfers share the bandwidth of
+// limit, which may be nil, and start in the given order. Their failures are
+// recorded in retries.
+func newTransfers(n int, limit *rate.Limiter, order transferOrder, retries *retryQueue) *transfers {
+	return &transfers{n: n, limit: limit, order: order, tty: stdoutIsTerminal() && verbosity >= normal, retries: retries}
+}
+
+// add queues a transfer of size bytes last modified at modTime, unless it
+// failed too often already. run does the transfer, reading the content
+// through job.reader.
+func (t *transfers) add(name string, size int64, modTime time.Time, run func(job *transferJob) error) {
+	if t.retries.givenUp(name, size, modTime) {
+		stats.count(&stats.Skipped, 1)
+		return
+	}
+	if t.journal.skip(name) {
+		return
+	}
+	ctx, cancel := context.WithCancel(runCtx)
+	t.queue = append(t.queue, &transferJob{name: name, size: size, modTime: modTime, run: run, t: t, ctx: ctx, cancel: cancel})
+}
+
+// skip aborts the transfer for this run, the next one does it again.
+func (job *transferJob) skip() {
+	atomic.StoreInt32(&job.skipped, 1)
+	job.cancel()
+}
+
+// currentTransfers are those running, for the TUI; nil between runs.
+// currentPair is the pair being synced, for the dashboard.
+var (
+	currentMu        sync.Mutex
+	currentTransfers *transfers
+	currentPair      string
+)
+
+// transfersQueued counts the transfers waiting to start, accessed
+// atomically.
+var transfersQueued int64
+
+func (t *transfers) begin(job *transferJob) {
+	atomic.AddInt64(&transfersQueued, -1)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	job.started = time.Now()
+	t.active = append(t.active, job)
+}
+
+func (t *transfers) finish(job *transferJob, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.remove(job)
+	t.clearProgress()
+	t.journal.done(job)
+	t.done++
+	if err != nil {
+		t.failed = append(t.failed, job.name)
+		emit(event{Event: eventFailed, Path: job.name, Size: job.size, Error: err.Error()})
+		fmt.Printf("[%d/%d] %s\n", t.done, t.total, paint(colorRed, fmt.Sprintf("%s failed: %v", job.name, err)))
+		return
+	}
+	emit(event{Event: eventTransferred, Path: job.name, Size: job.size})
+	infof("[%d/%d, %s] %s\n", t.done, t.total, formatBytes(atomic.LoadInt64(&t.bytes)), paint(colorGreen, job.name))
+}
+
+// interrupt takes a transfer aborted by stopping the run or skipping it
+// off the display. It is neither done nor failed, so the next run does it
+// again.
+func (t *transfers) interrupt(job *transferJob) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.remove(job)
+	t.clearProgress()
+	emit(event{Event: eventInterrupted, Path: job.name})
+	if atomic.LoadInt32(&job.skipped) == 1 {
+		t.journal.done(job)
+		infof("%s skipped\n", job.name)
+		return
+	}
+	infof("%s interrupted\n", job.name)
+}
+
+func (t *transfers) remove(job *transferJob) {
+	for i, a := range t.active {
+		if a == job {
+			t.active = append(t.active[:i], t.active[i+1:]...)
+			break
+		}
+	}
+}
+
+// reader counts what is read from r for the job and the transferred total,
+// and keeps it within the bandwidth limit.
+func (job *transferJob) reader(r io.Reader) io.Reader {
+	if job.t.limit != nil {
+		r = &limitedReader{r: r, l: job.t.limit, ctx: job.ctx}
+	}
+	r = &pausingReader{r: r, ctx: job.ctx}
+	return &countingReader{r: r, n: []*int64{&job.done, &job.t.bytes}}
+}
+
+// run runs the queued transfers, prints a summary and returns the number of
+// failed ones.
+func (t *transfers) run() int {
+	if len(t.queue) == 0 {
+		return 0
+	}
+	t.order.sort(t.queue)
+	t.journal.plan(t.queue)
+	t.start = time.Now()
+	t.total = len(t.queue)
+	for _, job := range t.queue {
+		t.totalBytes += job.size
+	}
+	atomic.AddInt64(&transfersQueued, int64(len(t.queue)))
+	defer stats.phase(phaseTransfer)()
+	currentMu.Lock()
+	currentTransfers = t
+	currentMu.Unlock()
+	defer func() {
+		currentMu.Lock()
+		currentTransfers = nil
+		currentMu.Unlock()
+	}()
+	stop := make(chan struct{})
+	if t.tty {
+		go t.showProgress(stop)
+	}
+	jobs := make(chan *transferJob)
+	var wg sync.WaitGroup
+	for i := 0; i < t.n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				// Transfers not started yet are left for the
+				// next run.
+				if transferPause.wait(runCtx); stopping() {
+					atomic.AddInt64(&transfersQueued, -1)
+					continue
+				}
+				t.begin(job)
+				span := startSpan(spanTransfer, attribute.String("file", job.name), attribute.Int64("size", job.size))
+				err := job.run(job)
+				span.SetAttributes(attribute.Int64("bytes", atomic.LoadInt64(&job.done)))
+				endSpan(span, err)
+				job.cancel()
+				if err != nil && (stopping() || atomic.LoadInt32(&job.skipped) == 1) {
+					t.interrupt(job)
+					continue
+				}
+				t.retries.done(job, err)
+				t.finish(job, err)
+			}
+		}()
+	}
+	for _, job := range t.queue {
+		jobs <- job
+		t.mu.Lock()
+		t.dispatched++
+		t.mu.Unlock()
+	}
+	close(jobs)
+	wg.Wait()
+	close(stop)
+	t.mu.Lock()
+	t.clearProgress()
+	t.mu.Unlock()
+	t.mu.Lock()
+	t.queue = nil
+	t.mu.Unlock()
+	atomic.AddInt64(&stats.Bytes, t.bytes)
+	fmt.Printf("%d transferred, %s in %s", t.done-len(t.failed), formatBytes(t.bytes), time.Since(t.start).Round(time.Second))
+	if left := t.total - t.done; left > 0 {
+		fmt.Printf(", %d left for the next run", left)
+	}
+	if len(t.failed) > 0 {
+		fmt.Print(paint(colorRed, fmt.Sprintf(", %d failed:", len(t.failed))))
+		for _, name := range t.failed {
+			fmt.Printf("\n  %s", paint(colorRed, name))
+		}
+	}
+	fmt.Println()
+	return len(t.failed)
+}
+
+// succeeded returns the number of transfers done without error.
+func (t *transfers) succeeded() int {
+	return t.done - len(t.failed)
+}
+
+// transferOrder is the order in which queued transfers start.
+type transferOrder struct {
+	key        string // orderNone, orderSize, orderName or orderModTime
+	descending bool
+}
+
+const (
+	orderNone    = ""
+	orderSize    = "size"
+	orderName    = "name"
+	orderModTime = "mtime"
+)
+
+// parseOrder parses "KEY[,ascending|descending]", KEY being size, name or
+// mtime. "" keeps the order in which transfers are found.
+func parseOrder(s string) (transferOrder, error) {
+	var o transferOrder
+	if s == "" {
+		return o, nil
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) > 2 {
+		return o, fmt.Errorf("invalid order %q", s)
+	}
+	switch parts[0] {
+	case orderSize, orderName, orderModTime:
+		o.key = parts[0]
+	default:
+		return o, fmt.Errorf("invalid order %q: sort by size, name or mtime", s)
+	}
+	if len(parts) == 2 {
+		switch parts[1] {
+		case "ascending", "asc":
+		case "descending", "desc":
+			o.descending = true
+		default:
+			return o, fmt.Errorf("invalid order %q: ascending or descending", s)
+		}
+	}
+	return o, nil
+}
+
+func (o transferOrder) sort(jobs []*transferJob) {
+	if o.key == orderNone {
+		return
+	}
+	less := func(a, b *transferJob) bool {
+		switch o.key {
+		case orderSize:
+			return a.size < b.size
+		case orderModTime:
+			return a.modTime.Before(b.modTime)
+		}
+		return a.name < b.name
+	}
+	sort.SliceStable(jobs, func(i, j int) bool {
+		if o.descending {
+			return less(jobs[j], jobs[i])
+		}
+		return less(jobs[i], jobs[j])
+	})
+}
+
+// restart discounts what the job transferred so far, before it is tried
+// again.
+func (job *transferJob) restart() {
+	n := atomic.SwapInt64(&job.done, 0)
+	atomic.AddInt64(&job.t.bytes, -n)
+}
+
+// countingReader adds what is read from r to each of n.
+type countingReader struct {
+	r io.Reader
+	n []*int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	for _, counter := range c.n {
+		atomic.AddInt64(counter, int64(n))
+	}
+	return n, err
+}
+
+// formatBytes formats n with a binary unit, e.g. "1.5 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,181 @@
+package syncer
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// trashDirName is the folder in the local root which deleted local files are
+// moved to, until the purge command removes them.
+const trashDirName = ".drive-trash"
+
+// internalPath reports whether the slash separated relative path is one of
+// the tool's own files: the trash folder, the lock file and temporary files.
+func internalPath(rel string) bool {
+	if rel == trashDirName || strings.HasPrefix(rel, trashDirName+"/") || rel == localLockFile {
+		return true
+	}
+	base := path.Base(rel)
+	return strings.HasPrefix(base, ".drive-tmp-") || strings.HasPrefix(base, ".drive-case-probe-")
+}
+
+// trashLocal moves the local file at rel into the trash folder, below a
+// folder named after stamp so that files deleted by different runs don't
+// collide.
+func (p *syncPair) trashLocal(rel string, stamp string) error {
+	src := filepath.Join(p.Local, rel)
+	dst := filepath.Join(p.Local, trashDirName, stamp, rel)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.Rename(src, dst)
+}
+
+// trashRemote returns the update moving a remote file to the Drive trash
+// rather than deleting it for good.
+func trashRemote(file drive.File, name string) metadataUpdate {
+	return metadataUpdate{name: name, fileId: file.Id, change: &drive.File{Trashed: true}}
+}
+
+func trashStamp() string {
+	return time.Now().Format("2006-01-02T150405")
+}
+
+// extraneousLocal returns the paths of the local files which have no
+// remote counterpart at their path. The content of a file moved on the
+// remote side is downloaded to its new path, and the old one goes.
+func (p *syncPair) extraneousLocal(files *Files, remoteByPath map[string]drive.File) []string {
+	var extra []string
+	for _, l := range files.Local {
+		if _, ok := remoteByPath[pathKey(l.Path)]; !ok && !exportPart(l.Path, remoteByPath) {
+			extra = append(extra, l.Path)
+		}
+	}
+	return extra
+}
+
+// exportPart reports whether the local file at rel belongs to the export of
+// a native document of remoteByPath: a tab of a spreadsheet exported with
+// SheetsCSV or an image of a Markdown export.
+func exportPart(rel string, remoteByPath map[string]drive.File) bool {
+	dir := path.Dir(pathKey(rel))
+	if dir == "." {
+		return false
+	}
+	if r, ok := remoteByPath[dir]; ok && r.MimeType == spreadsheetMimeType {
+		return true
+	}
+	if strings.HasSuffix(dir, ".assets") {
+		r, ok := remoteByPath[strings.TrimSuffix(dir, ".assets")+docsMarkdown.Extension]
+		return ok && r.MimeType == documentMimeType
+	}
+	return false
+}
+
+// trashExtraneousLocal moves the local files which have no remote
+// counterpart to the trash. It returns the number of files trashed, and
+// the number of those which couldn't be, which stay in files.
+func (p *syncPair) trashExtraneousLocal(files *Files, remoteByPath map[string]drive.File) (int, int) {
+	extra := make(map[string]bool)
+	for _, rel := range p.extraneousLocal(files, remoteByPath) {
+		extra[rel] = true
+	}
+	stamp := trashStamp()
+	var kept []localFile
+	failed := 0
+	for _, l := range files.Local {
+		if !extra[l.Path] {
+			kept = append(kept, l)
+			continue
+		}
+		if err := p.trashLocal(l.Path, stamp); err != nil {
+			log.Printf("%s: not deleted: %v", l.Path, err)
+			kept = append(kept, l)
+			failed++
+			continue
+		}
+		emit(event{Event: eventDeleted, Path: l.Path, To: filepath.Join(trashDirName, stamp)})
+		infof("%s => %s\n", l.Path, filepath.Join(trashDirName, stamp))
+	}
+	trashed := len(files.Local) - len(kept)
+	files.Local = kept
+	return trashed, failed
+}
+
+// extraneousRemote returns the keys of remoteByPath of the remote files
+// which have no local counterpart, sorted, one key per file.
+func (p *syncPair) extraneousRemote(files *Files, remoteByPath map[string]drive.File) []string {
+	localByPath := make(map[string]bool)
+	for _, l := range files.Local {
+		localByPath[pathKey(l.Path)] = true
+	}
+	keys := make([]string, 0, len(remoteByPath))
+	for key := range remoteByPath {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var extra []string
+	seen := make(map[string]bool) // key: File.Id
+	for _, key := range keys {
+		r := remoteByPath[key]
+		if isNative(r) || localByPath[key] || seen[r.Id] {
+			continue
+		}
+		extra = append(extra, key)
+		seen[r.Id] = true
+	}
+	return extra
+}
+
+// trashExtraneousRemote moves the remote files which have no local
+// counterpart to the Drive trash. Files I can't modify are left alone. It
+// returns the number of files which could not be trashed.
+func (p *syncPair) trashExtraneousRemote(srv *drive.Service, files *Files, remoteByPath map[string]drive.File) int {
+	var updates []metadataUpdate
+	for _, key := range p.extraneousRemote(files, remoteByPath) {
+		r := remoteByPath[key]
+		if !remoteWritable(r) {
+			fmt.Printf("%s: not deleted, you can't modify it\n", key)
+			continue
+		}
+		emit(event{Event: eventDeleted, Path: key, To: "Drive trash"})
+		infof("%s => Drive trash\n", key)
+		updates = append(updates, trashRemote(r, key))
+		for _, id := range chunkIds(r) {
+			updates = append(updates, trashRemote(drive.File{Id: id}, key+" (chunk)"))
+		}
+	}
+	trashed, failed := updateMetadata(srv, updates)
+	deleted := 0
+	for _, u := range updates {
+		if _, ok := trashed[u.fileId]; ok && !strings.HasSuffix(u.name, " (chunk)") {
+			deleted++
+		}
+	}
+	stats.count(&stats.Deleted, deleted)
+	var kept []drive.File
+	for _, r := range files.Remote {
+		if _, ok := trashed[r.Id]; !ok {
+			kept = append(kept, r)
+		}
+	}
+	files.Remote = kept
+	return failed
+}
+
+// purgeTrash removes the local trash folder for good.
+func purgeTrash(pair *syncPair) {
+	dir := filepath.Join(pair.Local, trashDirName)
+	infof("Purge %s\n", dir)
+	if err := os.RemoveAll(dir); err != nil {
+		fatalf("os.RemoveAll(%s) failed: %v", dir, err)
+	}
+}
@@ -0,0 +1,403 @@
+package syncer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/term"
+)
+
+// pauseGate holds transfers while paused.
+type pauseGate struct {
+	mu     sync.Mutex
+	paused bool
+	resume chan struct{} // closed when the pause ends
+}
+
+// transferPause pauses the transfers of the run, from the TUI or the
+// control socket.
+var transferPause pauseGate
+
+func (g *pauseGate) toggle() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.setLocked(!g.paused)
+	return g.paused
+}
+
+// set pauses or resumes the transfers.
+func (g *pauseGate) set(paused bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.setLocked(paused)
+}
+
+func (g *pauseGate) setLocked(paused bool) {
+	if paused == g.paused {
+		return
+	}
+	if g.paused {
+		close(g.resume)
+	} else {
+		g.resume = make(chan struct{})
+	}
+	g.paused = paused
+}
+
+func (g *pauseGate) isPaused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
+
+// wait returns once the transfers aren't paused or ctx is done.
+func (g *pauseGate) wait(ctx context.Context) {
+	g.mu.Lock()
+	paused, resume := g.paused, g.resume
+	g.mu.Unlock()
+	if !paused {
+		return
+	}
+	select {
+	case <-resume:
+	case <-ctx.Done():
+	}
+}
+
+// pausingReader stops reading from r while the transfers are paused.
+type pausingReader struct {
+	r   io.Reader
+	ctx context.Context
+}
+
+func (p *pausingReader) Read(b []byte) (int, error) {
+	transferPause.wait(p.ctx)
+	return p.r.Read(b)
+}
+
+const (
+	tuiInterval = 500 * time.Millisecond
+	tuiErrors   = 5    // recent errors shown
+	tuiKept     = 1000 // lines of output kept, printed once the TUI ends
+)
+
+// tui is the full-screen display of -tui: the transfers in progress, those
+// queued, the bandwidth, recent errors and the last lines of output, which
+// would otherwise scroll by. Keys pause the transfers, skip one or stop the
+// run.
+type tui struct {
+	tty      *os.File // the terminal, the standard output going to a pipe
+	oldState *term.State
+	pipe     *os.File
+	read     chan struct{} // closed once the output is read
+	stop     chan struct{}
+	drawn    chan struct{} // closed once the drawing stopped
+	answers  chan bool     // answers to ask, while asking
+
+	mu        sync.Mutex
+	output    []string
+	errors    []string
+	selected  int // of the active transfers
+	question  string
+	lastBytes int64
+	lastTime  time.Time
+	speed     float64
+}
+
+// ui is the TUI, nil without -tui.
+var ui *tui
+
+// startTUI takes over the terminal until stopTUI.
+func startTUI() error {
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !stdoutIsTerminal() {
+		return fmt.Errorf("-tui needs a terminal")
+	}
+	t := &tui{
+		tty:   os.Stdout,
+		read:  make(chan struct{}),
+		stop:  make(chan struct{}),
+		drawn: make(chan struct{}),
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	t.oldState, err = term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return err
+	}
+	// The alternate screen keeps the terminal's contents for later.
+	fmt.Fprint(t.tty, "\033[?1049h\033[?25l")
+	t.pipe = w
+	os.Stdout = w
+	log.SetOutput(w)
+	go t.capture(r)
+	go t.keys()
+	go t.draw()
+	ui = t
+	return nil
+}
+
+// stopTUI gives the terminal back and prints the output kept.
+func stopTUI() {
+	t := ui
+	if t == nil {
+		return
+	}
+	ui = nil
+	close(t.stop)
+	<-t.drawn
+	os.Stdout = t.tty
+	log.SetOutput(os.Stderr)
+	t.pipe.Close()
+	<-t.read
+	fmt.Fprint(t.tty, "\033[?25h\033[?1049l")
+	term.Restore(int(os.Stdin.Fd()), t.oldState)
+	for _, line := range t.output {
+		fmt.Println(line)
+	}
+}
+
+// capture keeps the lines printed.
+func (t *tui) capture(r io.Reader) {
+	defer close(t.read)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		t.mu.Lock()
+		t.output = append(t.output, scanner.Text())
+		if len(t.output) > tuiKept {
+			t.output = t.output[len(t.output)-tuiKept:]
+		}
+		t.mu.Unlock()
+	}
+}
+
+// add records the failed transfers of an event.
+func (t *tui) add(e event) {
+	if e.Event != eventFailed {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.errors = append(t.errors, e.Path+": "+e.Error)
+	if len(t.errors) > tuiErrors {
+		t.errors = t.errors[1:]
+	}
+}
+
+// ask asks a yes or no question and waits for the answer.
+func (t *tui) ask(question string) bool {
+	answers := make(chan bool)
+	t.mu.Lock()
+	t.question, t.answers = question, answers
+	t.mu.Unlock()
+	answer := <-answers
+	t.mu.Lock()
+	t.question, t.answers = "", nil
+	t.mu.Unlock()
+	return answer
+}
+
+// keys handles the keys: p pauses or resumes the transfers, the arrows
+// select a transfer which s skips, q and Ctrl-C stop the run like SIGINT.
+func (t *tui) keys() {
+	buf := make([]byte, 8)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		key := string(buf[:n])
+		t.mu.Lock()
+		answers := t.answers
+		t.mu.Unlock()
+		if answers != nil {
+			answers <- key == "y" || key == "Y"
+			continue
+		}
+		switch key {
+		case "p":
+			transferPause.toggle()
+		case "\033[A", "k":
+			t.mu.Lock()
+			if t.selected > 0 {
+				t.selected--
+			}
+			t.mu.Unlock()
+		case "\033[B", "j":
+			t.mu.Lock()
+			t.selected++
+			t.mu.Unlock()
+		case "s":
+			if job := t.selectedJob(); job != nil {
+				job.skip()
+			}
+		case "q", "\x03":
+			if stopping() {
+				if key == "\x03" {
+					exit(exitInterrupted)
+				}
+				continue
+			}
+			fmt.Println("Stopping, press Ctrl-C again to quit at once")
+			stopRun()
+		}
+	}
+}
+
+// selectedJob returns the selected transfer in progress, if any.
+func (t *tui) selectedJob() *transferJob {
+	currentMu.Lock()
+	xfers := currentTransfers
+	currentMu.Unlock()
+	if xfers == nil {
+		return nil
+	}
+	xfers.mu.Lock()
+	defer xfers.mu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.selected < len(xfers.active) {
+		return xfers.active[t.selected]
+	}
+	return nil
+}
+
+func (t *tui) draw() {
+	defer close(t.drawn)
+	ticker := time.NewTicker(tuiInterval)
+	defer ticker.Stop()
+	for {
+		t.redraw()
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// redraw draws the screen from the top.
+func (t *tui) redraw() {
+	width, height, err := term.GetSize(int(t.tty.Fd()))
+	if err != nil {
+		width, height = 80, 24
+	}
+	var lines []string
+	add := func(format string, a ...interface{}) {
+		line := []rune(fmt.Sprintf(format, a...))
+		if len(line) > width {
+			line = line[:width]
+		}
+		lines = append(lines, string(line))
+	}
+
+	currentMu.Lock()
+	xfers := currentTransfers
+	currentMu.Unlock()
+	var active []string
+	var queued []*transferJob
+	status := "Listing and scanning"
+	if xfers != nil {
+		xfers.mu.Lock()
+		bytes := atomic.LoadInt64(&xfers.bytes)
+		now := time.Now()
+		if !t.lastTime.IsZero() && bytes >= t.lastBytes {
+			// The speed is smoothed over a few seconds.
+			current := float64(bytes-t.lastBytes) / now.Sub(t.lastTime).Seconds()
+			t.speed = 0.7*t.speed + 0.3*current
+		}
+		t.lastBytes, t.lastTime = bytes, now
+		status = fmt.Sprintf("%d/%d files, %s / %s, %s/s", xfers.done, xfers.total,
+			formatBytes(bytes), formatBytes(xfers.totalBytes), formatBytes(int64(t.speed)))
+		t.mu.Lock()
+		if t.selected >= len(xfers.active) && len(xfers.active) > 0 {
+			t.selected = len(xfers.active) - 1
+		}
+		for i, job := range xfers.active {
+			done := atomic.LoadInt64(&job.done)
+			percent := "  ?%"
+			if job.size > 0 {
+				percent = fmt.Sprintf("%3d%%", done*100/job.size)
+			}
+			marker := " "
+			if i == t.selected {
+				marker = ">"
+			}
+			active = append(active, fmt.Sprintf("%s %-*s %s %12s", marker, progressNameLen, shortName(job.name), percent,
+				formatSpeed(done, now.Sub(job.started))))
+		}
+		t.mu.Unlock()
+		if xfers.dispatched < len(xfers.queue) {
+			queued = xfers.queue[xfers.dispatched:]
+		}
+		xfers.mu.Unlock()
+	} else {
+		t.lastTime = time.Time{}
+	}
+	if transferPause.isPaused() {
+		status += "  [paused]"
+	}
+	if stopping() {
+		status += "  [stopping]"
+	}
+	add("%s", status)
+	add("")
+	add("Transferring")
+	for _, line := range active {
+		add("%s", line)
+	}
+	add("")
+	add("Queued (%d)", len(queued))
+	for i, job := range queued {
+		if i == 5 {
+			add("  ...")
+			break
+		}
+		add("  %-*s %10s", progressNameLen, shortName(job.name), formatBytes(job.size))
+	}
+
+	t.mu.Lock()
+	if len(t.errors) > 0 {
+		add("")
+		add("Recent errors")
+		for _, e := range t.errors {
+			add("  %s", e)
+			lines[len(lines)-1] = paint(colorRed, lines[len(lines)-1])
+		}
+	}
+	help := "p pause/resume  up/down select  s skip  q stop"
+	if t.question != "" {
+		help = t.question + " [y/N]"
+	}
+	add("")
+	add("Output")
+	// The last lines of output fill the rest of the screen.
+	room := height - len(lines) - 2
+	if room > len(t.output) {
+		room = len(t.output)
+	}
+	if room > 0 {
+		for _, line := range t.output[len(t.output)-room:] {
+			add("  %s", line)
+		}
+	}
+	t.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("\033[H")
+	for _, line := range lines {
+		b.WriteString(line + "\033[K\r\n")
+	}
+	b.WriteString("\033[J\033[" + fmt.Sprint(height) + ";1H\033[7m" + help + "\033[0m\033[K")
+	fmt.Fprint(t.tty, b.String())
+}
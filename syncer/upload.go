@@ -0,0 +1,412 @@
+package syncer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// uploader makes the remote tree of a pair like the local one.
+type uploader struct {
+	srv   *drive.Service
+	pair  *syncPair
+	files *Files
+	// folderIds maps remote folder paths to their ids; "" is the My
+	// Drive root. folderMu is held while folders are looked up or
+	// created so concurrent uploads don't create the same one twice.
+	folderIds map[string]string
+	folderMu  sync.Mutex
+	// folderErrs holds why the folders which couldn't be created failed,
+	// failing the uploads into them without trying again.
+	folderErrs map[string]error
+	filesMu    sync.Mutex // guards files.Remote and remoteAt
+	// remoteAt holds the index of each file in files.Remote by its id.
+	remoteAt map[string]int
+	// sidecars holds the pathKeys of the local files with a sidecar
+	// file.
+	sidecars map[string]bool
+}
+
+func newUploader(srv *drive.Service, pair *syncPair, idx *remoteIndex, files *Files) *uploader {
+	u := &uploader{srv: srv, pair: pair, files: files, folderIds: make(map[string]string), folderErrs: make(map[string]error), sidecars: make(map[string]bool)}
+	for _, l := range files.Local {
+		if isSidecar(l.Path) {
+			u.sidecars[pathKey(strings.TrimSuffix(l.Path, sidecarSuffix))] = true
+		}
+	}
+	u.indexRemote()
+	u.folderIds[""] = idx.rootId
+	if idx.rootId == "" {
+		u.folderIds[""] = "root"
+	}
+	for _, folder := range idx.folders {
+		if remoteSection(idx, folder) == sectionMyDrive {
+			u.folderIds[remotePath(idx, folder)] = folder.Id
+		}
+	}
+	return u
+}
+
+// uploadPair uploads the local files which are missing or differ on the
+// remote side through xfers, and with Delete trashes the remote files
+// missing locally. It returns the number of failed uploads and updates.
+func uploadPair(srv *drive.Service, pair *syncPair, idx *remoteIndex, files *Files, remoteByPath map[string]drive.File, xfers *transfers, opts *runOptions) int {
+	planned := enterSpan(spanPlanning)
+	u := newUploader(srv, pair, idx, files)
+	// touched updates the modification time and the metadata of remote
+	// files whose content is the same.
+	var touched []metadataUpdate
+	// unread counts the files whose sidecar file couldn't be read, which
+	// fail.
+	unread := 0
+	touch := func(l *localFile, r drive.File, modTime bool) {
+		change := &drive.File{}
+		if modTime {
+			change.ModifiedTime = l.ModTime.UTC().Format(time.RFC3339)
+		}
+		m, err := u.metadata(l.Path)
+		if err != nil {
+			log.Printf("%s: %v", l.Path, err)
+			unread++
+			return
+		}
+		if m.differs(r) {
+			m.apply(change)
+		} else if !modTime {
+			return
+		}
+		if remoteWritable(r) {
+			touched = append(touched, metadataUpdate{name: l.Path, fileId: r.Id, change: change})
+		}
+	}
+	var overwrites []string
+	// Files uploaded before OCR was set, or whose OCR failed, are read
+	// now.
+	ocrs := ocrDocs(files.Remote)
+	ocrMissing := func(l *localFile, r drive.File) {
+		if pair.OCR == "" || !ocrable(l.Path) || len(ocrs[r.Id]) > 0 {
+			return
+		}
+		decide(l.Path, actionOCR, "no OCR Doc")
+		emit(event{Event: eventPlanned, Path: l.Path, Action: actionOCR, Size: l.Size})
+		infof("%s => %s\n", paint(colorYellow, l.Path), r.Name+ocrSuffix)
+		xfers.add(l.Path+ocrSuffix, l.Size, l.ModTime, func(job *transferJob) error {
+			return u.ocr(l, r, job)
+		})
+	}
+	for i := range files.Local {
+		l := &files.Local[i]
+		if isSidecar(l.Path) {
+			continue
+		}
+		r, exists := remoteByPath[pathKey(l.Path)]
+		if exists && isNative(r) {
+			continue
+		}
+		stats.count(&stats.Checked, 1)
+		// Colab notebooks have no checksum to compare.
+		if exists && r.MimeType == colabMimeType && sameModTime(l.ModTime, r) {
+			decide(l.Path, actionUpToDate, "not modified since "+r.ModifiedTime)
+			stats.count(&stats.Skipped, 1)
+			continue
+		}
+		// A file moved locally is uploaded to its new path, its checksum
+		// being elsewhere on Drive or not.
+		if exists && upToDate(pair.Compare, l, r) {
+			decide(l.Path, actionUpToDate, "")
+			// The same content may have another modification time.
+			touch(l, r, pair.Compare == compareMd5 && !sameModTime(l.ModTime, r))
+			ocrMissing(l, r)
+			stats.count(&stats.Skipped, 1)
+			continue
+		}
+		if exists && !remoteWritable(r) {
+			fmt.Printf("%s: not uploaded, you can't modify the remote file\n", l.Path)
+			stats.count(&stats.Skipped, 1)
+			continue
+		}
+		action := actionUpdate
+		switch {
+		case !exists:
+			action = actionUpload
+			decide(l.Path, action, "missing remotely")
+		default:
+			decide(l.Path, action, pair.mismatch(pair.Compare, l, r))
+		}
+		emit(event{Event: eventPlanned, Path: l.Path, Action: action, Size: l.Size, Md5: l.Md5Checksum})
+		infof("%s => %s/%s\n", paint(colorYellow, l.Path), pair.Remote, filepath.ToSlash(l.Path))
+		var existing *drive.File
+		if exists {
+			existing = &r
+			overwrites = append(overwrites, l.Path)
+		}
+		xfers.add(l.Path, l.Size, l.ModTime, func(job *transferJob) error {
+			return u.upload(l, existing, job)
+		})
+	}
+	planned()
+	var deletes []string
+	if pair.Delete {
+		deletes = pair.extraneousRemote(files, remoteByPath)
+	}
+	// A plan exceeding the quota or refused counts as a failure, for the
+	// exit code.
+	if !checkQuota(srv, pair, xfers.planned(), opts) {
+		return 1
+	}
+	if opts.planOnly {
+		planDeletes(deletes)
+		return 0
+	}
+	if !confirmPlan(pair, deletes, overwrites, opts) {
+		return 1
+	}
+	failed := unread + xfers.run()
+	stats.count(&stats.Uploaded, xfers.succeeded())
+	if stopping() {
+		return failed
+	}
+	updated, n := updateMetadata(srv, touched)
+	failed += n
+	stats.count(&stats.Updated, len(updated))
+	for _, r := range updated {
+		u.record(r)
+	}
+	if pair.Delete {
+		failed += pair.trashExtraneousRemote(srv, files, remoteByPath)
+	}
+	return failed
+}
+
+// upload uploads a local file, replacing the content of existing if it is
+// not nil, and records the result in the state.
+func (u *uploader) upload(l *localFile, existing *drive.File, job *transferJob) error {
+	localPath := filepath.Join(u.pair.Local, l.Path)
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	meta := &drive.File{ModifiedTime: l.ModTime.UTC().Format(time.RFC3339)}
+	rel := filepath.ToSlash(l.Path)
+	compression := u.pair.compression(rel)
+	chunked := u.pair.chunkSize > 0 && l.Size > u.pair.chunkSize
+	if u.pair.Encrypt || compression != "" || chunked || (existing != nil && (existing.AppProperties[propPlainSize] != "" || existing.AppProperties[propSealedSize] != "")) {
+		// Empty values replace those of an earlier upload.
+		meta.AppProperties = map[string]string{
+			propPlainMd5:     "",
+			propPlainSha256:  "",
+			propPlainSize:    "",
+			propSealedMd5:    "",
+			propSealedSha256: "",
+			propSealedSize:   "",
+			propCompression:  compression,
+			propChunkSet:     "",
+			propChunks:       "",
+		}
+		if u.pair.Encrypt {
+			if err := u.pair.sealPlain(meta.AppProperties, l.Md5Checksum, l.Size); err != nil {
+				return err
+			}
+		} else {
+			meta.AppProperties[plainChecksumProp(u.pair.hash)] = l.Md5Checksum
+			meta.AppProperties[propPlainSize] = strconv.FormatInt(l.Size, 10)
+		}
+	}
+	// Drive would take compressed content for an archive.
+	if compression != "" && !u.pair.Encrypt {
+		meta.MimeType = localMimeType(l.Path)
+	}
+	switch {
+	case existing == nil && u.pair.Obfuscate:
+		// The remote root holds all files, named by their encrypted
+		// paths.
+		meta.Name = encryptName(u.pair.key, rel)
+		parent, err := u.folder(u.pair.Remote)
+		if err != nil {
+			return err
+		}
+		meta.Parents = []string{parent}
+	case existing == nil:
+		rp := u.pair.Remote + "/" + rel
+		meta.Name = path.Base(rp)
+		parent, err := u.folder(path.Dir(rp))
+		if err != nil {
+			return err
+		}
+		meta.Parents = []string{parent}
+	}
+	m, err := u.metadata(l.Path)
+	if err != nil {
+		return err
+	}
+	m.apply(meta)
+	var chunks []drive.File
+	if chunked {
+		// Chunks go next to the file, and obfuscated ones are named by
+		// their encrypted paths too.
+		name, parent := path.Base(rel), ""
+		switch {
+		case u.pair.Obfuscate:
+			name = rel
+			if parent, err = u.folder(u.pair.Remote); err != nil {
+				return err
+			}
+		case existing == nil:
+			parent = meta.Parents[0]
+		default:
+			parent = existing.Parents[0]
+		}
+		set, uploaded, err := u.uploadChunks(f, l, name, parent, compression, job)
+		chunks = uploaded
+		if err != nil {
+			trashChunks(u.srv, l.Path, fileIds(chunks))
+			return fmt.Errorf("upload failed: %v", err)
+		}
+		meta.AppProperties[propChunkSet] = set
+		meta.AppProperties[propChunks] = strconv.Itoa(len(chunks))
+	}
+	var r *drive.File
+	err = retry("Upload of "+l.Path, func() (err error) {
+		var content io.Reader = strings.NewReader("")
+		if !chunked {
+			job.restart()
+			// A reader of its own for each attempt, as the compression of
+			// a failed one may still be reading.
+			var closeContent func()
+			if content, closeContent, err = u.content(job.reader(io.NewSectionReader(f, 0, l.Size)), compression); err != nil {
+				return err
+			}
+			defer closeContent()
+		}
+		if existing != nil {
+			r, err = u.srv.Files.Update(existing.Id, meta).Media(content).Fields(fileFields).Context(job.ctx).Do()
+		} else {
+			r, err = u.srv.Files.Create(meta).Media(content).Fields(fileFields).Context(job.ctx).Do()
+		}
+		return err
+	})
+	if err != nil {
+		trashChunks(u.srv, l.Path, fileIds(chunks))
+		return fmt.Errorf("upload failed: %v", err)
+	}
+	// The chunks of the earlier upload are replaced.
+	if existing != nil {
+		trashChunks(u.srv, l.Path, chunkIds(*existing))
+	}
+	u.record(*r, chunks...)
+	if u.pair.OCR != "" && ocrable(l.Path) {
+		return u.ocr(l, *r, job)
+	}
+	return nil
+}
+
+// content returns what is uploaded of r: compressed with compression if it
+// isn't empty and encrypted if the pair encrypts, and a function stopping
+// the compression.
+func (u *uploader) content(r io.Reader, compression string) (io.Reader, func(), error) {
+	closeContent := func() {}
+	if compression != "" {
+		compressed := compressReader(r, compression)
+		r, closeContent = compressed, func() { compressed.Close() }
+	}
+	if u.pair.Encrypt {
+		// Each call encrypts with a new nonce.
+		encrypted, err := newEncryptReader(r, u.pair.key)
+		if err != nil {
+			closeContent()
+			return nil, nil, err
+		}
+		r = encrypted
+	}
+	return r, closeContent, nil
+}
+
+// fileIds returns the ids of files.
+func fileIds(files []drive.File) []string {
+	ids := make([]string, len(files))
+	for i, f := range files {
+		ids[i] = f.Id
+	}
+	return ids
+}
+
+// folder returns the id of the remote folder at the path, creating it and
+// its parents as needed.
+func (u *uploader) folder(dir string) (string, error) {
+	u.folderMu.Lock()
+	defer u.folderMu.Unlock()
+	return u.folderLocked(dir)
+}
+
+func (u *uploader) folderLocked(dir string) (string, error) {
+	if dir == "/" || dir == "." {
+		dir = ""
+	}
+	if id, ok := u.folderIds[dir]; ok {
+		return id, nil
+	}
+	if err, ok := u.folderErrs[dir]; ok {
+		return "", err
+	}
+	parent, err := u.folderLocked(path.Dir(dir))
+	if err != nil {
+		return "", err
+	}
+	meta := &drive.File{
+		Name:     path.Base(dir),
+		MimeType: folderMimeType,
+		Parents:  []string{parent},
+	}
+	if dir == u.pair.Remote && u.pair.newSalt != nil {
+		meta.AppProperties = map[string]string{propSalt: base64.RawURLEncoding.EncodeToString(u.pair.newSalt)}
+	}
+	var f *drive.File
+	err = retry("Creating folder "+dir, func() (err error) {
+		f, err = u.srv.Files.Create(meta).Fields(fileFields).Do()
+		return err
+	})
+	if err != nil {
+		err = fmt.Errorf("unable to create folder %s: %v", dir, err)
+		u.folderErrs[dir] = err
+		return "", err
+	}
+	u.folderIds[dir] = f.Id
+	u.record(*f)
+	return f.Id, nil
+}
+
+// record adds or replaces a remote file in the state's listing, together
+// with the made up folders of an obfuscated one and the chunk ids of a
+// chunked one.
+func (u *uploader) record(r drive.File, chunks ...drive.File) {
+	u.filesMu.Lock()
+	defer u.filesMu.Unlock()
+	for _, f := range u.pair.plainTree(append(chunks, r)) {
+		if i, ok := u.remoteAt[f.Id]; ok {
+			u.files.Remote[i] = f
+			continue
+		}
+		u.remoteAt[f.Id] = len(u.files.Remote)
+		u.files.Remote = append(u.files.Remote, f)
+	}
+}
+
+// indexRemote indexes files.Remote in remoteAt. u.filesMu is held, or the
+// uploader not shared yet.
+func (u *uploader) indexRemote() {
+	u.remoteAt = make(map[string]int, len(u.files.Remote))
+	for i, f := range u.files.Remote {
+		u.remoteAt[f.Id] = i
+	}
+}
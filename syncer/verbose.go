@@ -0,0 +1,91 @@
+package syncer
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"time"
+)
+
+// Levels of verbosity.
+const (
+	quiet   = -1 // only errors and the summary, -q
+	normal  = 0
+	verbose = 1 // why each file is transferred or not, -v
+	wire    = 2 // besides the Drive API requests and responses, -vv
+)
+
+var verbosity = normal
+
+// infof prints what is done, which -q leaves out.
+func infof(format string, a ...interface{}) {
+	if verbosity >= normal {
+		fmt.Printf(format, a...)
+	}
+}
+
+// decide prints why a file is transferred or not with -v: the action and
+// the reason for it, which may be empty.
+func decide(path, action, reason string) {
+	if verbosity < verbose {
+		return
+	}
+	emit(event{Event: eventDecision, Path: path, Action: action, Reason: reason})
+	c := colorYellow
+	if action == actionUpToDate {
+		c = colorGreen
+	}
+	s := action
+	if reason != "" {
+		s += ", " + reason
+	}
+	fmt.Printf("%s: %s\n", path, paint(c, s))
+}
+
+// sensitiveHeaders are left out of the requests and responses logged.
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization"}
+
+// wireLogTransport logs the requests and responses, without the bodies
+// and with the credentials redacted.
+type wireLogTransport struct {
+	base http.RoundTripper
+}
+
+func (t *wireLogTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	logged := req.Clone(req.Context())
+	for _, h := range sensitiveHeaders {
+		if logged.Header.Get(h) != "" {
+			logged.Header.Set(h, "REDACTED")
+		}
+	}
+	if dump, err := httputil.DumpRequestOut(logged, false); err == nil {
+		log.Printf("> %s", indentDump(dump))
+	}
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		log.Printf("< %s %s: %v", req.Method, req.URL.Path, err)
+		return nil, err
+	}
+	header := resp.Header
+	resp.Header = header.Clone()
+	for _, h := range sensitiveHeaders {
+		if resp.Header.Get(h) != "" {
+			resp.Header.Set(h, "REDACTED")
+		}
+	}
+	dump, derr := httputil.DumpResponse(resp, false)
+	resp.Header = header
+	if derr == nil {
+		log.Printf("< %s %s in %s\n  %s", req.Method, req.URL.Path, time.Since(start).Round(time.Millisecond), indentDump(dump))
+	}
+	return resp, nil
+}
+
+// indentDump indents the lines after the first of a dumped request or
+// response.
+func indentDump(dump []byte) string {
+	return strings.Replace(strings.TrimSpace(string(dump)), "\r\n", "\n  ", -1)
+}
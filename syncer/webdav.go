@@ -0,0 +1,145 @@
+package syncer
+
+import (
+	"crypto/subtle"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/hiroshi/googledriveclient/drivefs"
+	"golang.org/x/net/context"
+	"golang.org/x/net/webdav"
+)
+
+// webdavFS serves a remote tree over WebDAV, read-only.
+type webdavFS struct {
+	fs *drivefs.FS
+}
+
+func (w *webdavFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return os.ErrPermission
+}
+
+func (w *webdavFS) RemoveAll(ctx context.Context, name string) error {
+	return os.ErrPermission
+}
+
+func (w *webdavFS) Rename(ctx context.Context, oldName, newName string) error {
+	return os.ErrPermission
+}
+
+func (w *webdavFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	info, err := w.fs.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return webdavFileInfo{info}, nil
+}
+
+func (w *webdavFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+	info, err := w.fs.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &webdavFile{Reader: w.fs.NewReader(ctx, info), fs: w.fs, ctx: ctx}, nil
+}
+
+// webdavFileInfo adds the MIME type and md5 checksum Drive has to a
+// remote file, sparing the handler reading the file to find them.
+type webdavFileInfo struct {
+	drivefs.FileInfo
+}
+
+func (i webdavFileInfo) ContentType(ctx context.Context) (string, error) {
+	if i.IsDir() || i.File.MimeType == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return i.File.MimeType, nil
+}
+
+func (i webdavFileInfo) ETag(ctx context.Context) (string, error) {
+	if i.File.Md5Checksum == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return `"` + i.File.Md5Checksum + `"`, nil
+}
+
+// webdavFile is an open remote file or folder.
+type webdavFile struct {
+	*drivefs.Reader
+	fs      *drivefs.FS
+	ctx     context.Context
+	listed  []drivefs.FileInfo
+	listPos int
+}
+
+func (f *webdavFile) Write(b []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *webdavFile) Stat() (os.FileInfo, error) {
+	return webdavFileInfo{f.Info()}, nil
+}
+
+// Readdir returns the next count files of the folder, all of them if count
+// is 0 or less.
+func (f *webdavFile) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.Info().IsDir() {
+		return nil, os.ErrInvalid
+	}
+	if f.listed == nil {
+		listed, err := f.fs.ReadDir(f.ctx, f.Info())
+		if err != nil {
+			return nil, err
+		}
+		f.listed = listed
+	}
+	rest := f.listed[f.listPos:]
+	if count > 0 {
+		if len(rest) == 0 {
+			return nil, io.EOF
+		}
+		if len(rest) > count {
+			rest = rest[:count]
+		}
+	}
+	f.listPos += len(rest)
+	infos := make([]os.FileInfo, len(rest))
+	for i := range rest {
+		infos[i] = webdavFileInfo{rest[i]}
+	}
+	return infos, nil
+}
+
+// serveWebDAV serves fs over WebDAV on addr until the process ends. With a
+// user, requests need basic authentication with user and password.
+func serveWebDAV(fs *drivefs.FS, addr, user, password string) error {
+	handler := &webdav.Handler{
+		FileSystem: &webdavFS{fs: fs},
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil && !os.IsNotExist(err) {
+				log.Printf("WebDAV %s %s: %v", r.Method, r.URL.Path, err)
+			}
+		},
+	}
+	var h http.Handler = handler
+	if user != "" {
+		h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, p, ok := r.BasicAuth()
+			if !ok || subtle.ConstantTimeCompare([]byte(u), []byte(user)) != 1 ||
+				subtle.ConstantTimeCompare([]byte(p), []byte(password)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="drive"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			handler.ServeHTTP(w, r)
+		})
+	}
+	infof("Serving WebDAV on %s, read-only\n", addr)
+	return http.ListenAndServe(addr, h)
+}
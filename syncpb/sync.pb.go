@@ -0,0 +1,596 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: syncpb/sync.proto
+
+// The gRPC interface of serve api, to embed the sync engine in other
+// systems. Make the Go code with make proto.
+
+package syncpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ListPairsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListPairsRequest) Reset() {
+	*x = ListPairsRequest{}
+	mi := &file_syncpb_sync_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListPairsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPairsRequest) ProtoMessage() {}
+
+func (x *ListPairsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_syncpb_sync_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPairsRequest.ProtoReflect.Descriptor instead.
+func (*ListPairsRequest) Descriptor() ([]byte, []int) {
+	return file_syncpb_sync_proto_rawDescGZIP(), []int{0}
+}
+
+type ListPairsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pairs         []*Pair                `protobuf:"bytes,1,rep,name=pairs,proto3" json:"pairs,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListPairsResponse) Reset() {
+	*x = ListPairsResponse{}
+	mi := &file_syncpb_sync_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListPairsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPairsResponse) ProtoMessage() {}
+
+func (x *ListPairsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_syncpb_sync_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPairsResponse.ProtoReflect.Descriptor instead.
+func (*ListPairsResponse) Descriptor() ([]byte, []int) {
+	return file_syncpb_sync_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ListPairsResponse) GetPairs() []*Pair {
+	if x != nil {
+		return x.Pairs
+	}
+	return nil
+}
+
+type Pair struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Local         string                 `protobuf:"bytes,2,opt,name=local,proto3" json:"local,omitempty"`
+	Remote        string                 `protobuf:"bytes,3,opt,name=remote,proto3" json:"remote,omitempty"`       // "" for the whole My Drive
+	Direction     string                 `protobuf:"bytes,4,opt,name=direction,proto3" json:"direction,omitempty"` // download or upload
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Pair) Reset() {
+	*x = Pair{}
+	mi := &file_syncpb_sync_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Pair) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Pair) ProtoMessage() {}
+
+func (x *Pair) ProtoReflect() protoreflect.Message {
+	mi := &file_syncpb_sync_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Pair.ProtoReflect.Descriptor instead.
+func (*Pair) Descriptor() ([]byte, []int) {
+	return file_syncpb_sync_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Pair) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Pair) GetLocal() string {
+	if x != nil {
+		return x.Local
+	}
+	return ""
+}
+
+func (x *Pair) GetRemote() string {
+	if x != nil {
+		return x.Remote
+	}
+	return ""
+}
+
+func (x *Pair) GetDirection() string {
+	if x != nil {
+		return x.Direction
+	}
+	return ""
+}
+
+type RunRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The names of the pairs to run, all of them if empty.
+	Pairs         []string `protobuf:"bytes,1,rep,name=pairs,proto3" json:"pairs,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RunRequest) Reset() {
+	*x = RunRequest{}
+	mi := &file_syncpb_sync_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RunRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RunRequest) ProtoMessage() {}
+
+func (x *RunRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_syncpb_sync_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RunRequest.ProtoReflect.Descriptor instead.
+func (*RunRequest) Descriptor() ([]byte, []int) {
+	return file_syncpb_sync_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *RunRequest) GetPairs() []string {
+	if x != nil {
+		return x.Pairs
+	}
+	return nil
+}
+
+// Event is an event of the run, as in the -format json output: decision,
+// planned, transferred, failed, interrupted, deleted, and summary last.
+type Event struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=time,proto3" json:"time,omitempty"`
+	Event         string                 `protobuf:"bytes,2,opt,name=event,proto3" json:"event,omitempty"`
+	Path          string                 `protobuf:"bytes,3,opt,name=path,proto3" json:"path,omitempty"` // relative to the local root
+	Action        string                 `protobuf:"bytes,4,opt,name=action,proto3" json:"action,omitempty"`
+	Reason        string                 `protobuf:"bytes,5,opt,name=reason,proto3" json:"reason,omitempty"`
+	Size          int64                  `protobuf:"varint,6,opt,name=size,proto3" json:"size,omitempty"`
+	Md5           string                 `protobuf:"bytes,7,opt,name=md5,proto3" json:"md5,omitempty"`
+	To            string                 `protobuf:"bytes,8,opt,name=to,proto3" json:"to,omitempty"` // where a deleted file went
+	Error         string                 `protobuf:"bytes,9,opt,name=error,proto3" json:"error,omitempty"`
+	Summary       *Summary               `protobuf:"bytes,10,opt,name=summary,proto3" json:"summary,omitempty"` // of the summary event
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_syncpb_sync_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_syncpb_sync_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_syncpb_sync_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Event) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *Event) GetEvent() string {
+	if x != nil {
+		return x.Event
+	}
+	return ""
+}
+
+func (x *Event) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *Event) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *Event) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *Event) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *Event) GetMd5() string {
+	if x != nil {
+		return x.Md5
+	}
+	return ""
+}
+
+func (x *Event) GetTo() string {
+	if x != nil {
+		return x.To
+	}
+	return ""
+}
+
+func (x *Event) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *Event) GetSummary() *Summary {
+	if x != nil {
+		return x.Summary
+	}
+	return nil
+}
+
+type Summary struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Checked        int64                  `protobuf:"varint,1,opt,name=checked,proto3" json:"checked,omitempty"`
+	Skipped        int64                  `protobuf:"varint,2,opt,name=skipped,proto3" json:"skipped,omitempty"`
+	Downloaded     int64                  `protobuf:"varint,3,opt,name=downloaded,proto3" json:"downloaded,omitempty"`
+	Uploaded       int64                  `protobuf:"varint,4,opt,name=uploaded,proto3" json:"uploaded,omitempty"`
+	Updated        int64                  `protobuf:"varint,5,opt,name=updated,proto3" json:"updated,omitempty"`
+	Deleted        int64                  `protobuf:"varint,6,opt,name=deleted,proto3" json:"deleted,omitempty"`
+	Errors         int64                  `protobuf:"varint,7,opt,name=errors,proto3" json:"errors,omitempty"`
+	Bytes          int64                  `protobuf:"varint,8,opt,name=bytes,proto3" json:"bytes,omitempty"`
+	PhaseSeconds   map[string]float64     `protobuf:"bytes,9,rep,name=phase_seconds,json=phaseSeconds,proto3" json:"phase_seconds,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"fixed64,2,opt,name=value"`
+	ElapsedSeconds float64                `protobuf:"fixed64,10,opt,name=elapsed_seconds,json=elapsedSeconds,proto3" json:"elapsed_seconds,omitempty"`
+	Failed         int32                  `protobuf:"varint,11,opt,name=failed,proto3" json:"failed,omitempty"`                     // failed transfers
+	ExitCode       int32                  `protobuf:"varint,12,opt,name=exit_code,json=exitCode,proto3" json:"exit_code,omitempty"` // the exit code a sync run alone would have
+	Cancelled      bool                   `protobuf:"varint,13,opt,name=cancelled,proto3" json:"cancelled,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Summary) Reset() {
+	*x = Summary{}
+	mi := &file_syncpb_sync_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Summary) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Summary) ProtoMessage() {}
+
+func (x *Summary) ProtoReflect() protoreflect.Message {
+	mi := &file_syncpb_sync_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Summary.ProtoReflect.Descriptor instead.
+func (*Summary) Descriptor() ([]byte, []int) {
+	return file_syncpb_sync_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Summary) GetChecked() int64 {
+	if x != nil {
+		return x.Checked
+	}
+	return 0
+}
+
+func (x *Summary) GetSkipped() int64 {
+	if x != nil {
+		return x.Skipped
+	}
+	return 0
+}
+
+func (x *Summary) GetDownloaded() int64 {
+	if x != nil {
+		return x.Downloaded
+	}
+	return 0
+}
+
+func (x *Summary) GetUploaded() int64 {
+	if x != nil {
+		return x.Uploaded
+	}
+	return 0
+}
+
+func (x *Summary) GetUpdated() int64 {
+	if x != nil {
+		return x.Updated
+	}
+	return 0
+}
+
+func (x *Summary) GetDeleted() int64 {
+	if x != nil {
+		return x.Deleted
+	}
+	return 0
+}
+
+func (x *Summary) GetErrors() int64 {
+	if x != nil {
+		return x.Errors
+	}
+	return 0
+}
+
+func (x *Summary) GetBytes() int64 {
+	if x != nil {
+		return x.Bytes
+	}
+	return 0
+}
+
+func (x *Summary) GetPhaseSeconds() map[string]float64 {
+	if x != nil {
+		return x.PhaseSeconds
+	}
+	return nil
+}
+
+func (x *Summary) GetElapsedSeconds() float64 {
+	if x != nil {
+		return x.ElapsedSeconds
+	}
+	return 0
+}
+
+func (x *Summary) GetFailed() int32 {
+	if x != nil {
+		return x.Failed
+	}
+	return 0
+}
+
+func (x *Summary) GetExitCode() int32 {
+	if x != nil {
+		return x.ExitCode
+	}
+	return 0
+}
+
+func (x *Summary) GetCancelled() bool {
+	if x != nil {
+		return x.Cancelled
+	}
+	return false
+}
+
+var File_syncpb_sync_proto protoreflect.FileDescriptor
+
+const file_syncpb_sync_proto_rawDesc = "" +
+	"\n" +
+	"\x11syncpb/sync.proto\x12\fdrivesync.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\x12\n" +
+	"\x10ListPairsRequest\"=\n" +
+	"\x11ListPairsResponse\x12(\n" +
+	"\x05pairs\x18\x01 \x03(\v2\x12.drivesync.v1.PairR\x05pairs\"f\n" +
+	"\x04Pair\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x14\n" +
+	"\x05local\x18\x02 \x01(\tR\x05local\x12\x16\n" +
+	"\x06remote\x18\x03 \x01(\tR\x06remote\x12\x1c\n" +
+	"\tdirection\x18\x04 \x01(\tR\tdirection\"\"\n" +
+	"\n" +
+	"RunRequest\x12\x14\n" +
+	"\x05pairs\x18\x01 \x03(\tR\x05pairs\"\x8e\x02\n" +
+	"\x05Event\x12.\n" +
+	"\x04time\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x14\n" +
+	"\x05event\x18\x02 \x01(\tR\x05event\x12\x12\n" +
+	"\x04path\x18\x03 \x01(\tR\x04path\x12\x16\n" +
+	"\x06action\x18\x04 \x01(\tR\x06action\x12\x16\n" +
+	"\x06reason\x18\x05 \x01(\tR\x06reason\x12\x12\n" +
+	"\x04size\x18\x06 \x01(\x03R\x04size\x12\x10\n" +
+	"\x03md5\x18\a \x01(\tR\x03md5\x12\x0e\n" +
+	"\x02to\x18\b \x01(\tR\x02to\x12\x14\n" +
+	"\x05error\x18\t \x01(\tR\x05error\x12/\n" +
+	"\asummary\x18\n" +
+	" \x01(\v2\x15.drivesync.v1.SummaryR\asummary\"\xe6\x03\n" +
+	"\aSummary\x12\x18\n" +
+	"\achecked\x18\x01 \x01(\x03R\achecked\x12\x18\n" +
+	"\askipped\x18\x02 \x01(\x03R\askipped\x12\x1e\n" +
+	"\n" +
+	"downloaded\x18\x03 \x01(\x03R\n" +
+	"downloaded\x12\x1a\n" +
+	"\buploaded\x18\x04 \x01(\x03R\buploaded\x12\x18\n" +
+	"\aupdated\x18\x05 \x01(\x03R\aupdated\x12\x18\n" +
+	"\adeleted\x18\x06 \x01(\x03R\adeleted\x12\x16\n" +
+	"\x06errors\x18\a \x01(\x03R\x06errors\x12\x14\n" +
+	"\x05bytes\x18\b \x01(\x03R\x05bytes\x12L\n" +
+	"\rphase_seconds\x18\t \x03(\v2'.drivesync.v1.Summary.PhaseSecondsEntryR\fphaseSeconds\x12'\n" +
+	"\x0felapsed_seconds\x18\n" +
+	" \x01(\x01R\x0eelapsedSeconds\x12\x16\n" +
+	"\x06failed\x18\v \x01(\x05R\x06failed\x12\x1b\n" +
+	"\texit_code\x18\f \x01(\x05R\bexitCode\x12\x1c\n" +
+	"\tcancelled\x18\r \x01(\bR\tcancelled\x1a?\n" +
+	"\x11PhaseSecondsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x01R\x05value:\x028\x012\xcd\x01\n" +
+	"\n" +
+	"SyncEngine\x12L\n" +
+	"\tListPairs\x12\x1e.drivesync.v1.ListPairsRequest\x1a\x1f.drivesync.v1.ListPairsResponse\x127\n" +
+	"\x04Plan\x12\x18.drivesync.v1.RunRequest\x1a\x13.drivesync.v1.Event0\x01\x128\n" +
+	"\x05Apply\x12\x18.drivesync.v1.RunRequest\x1a\x13.drivesync.v1.Event0\x01B-Z+github.com/hiroshi/googledriveclient/syncpbb\x06proto3"
+
+var (
+	file_syncpb_sync_proto_rawDescOnce sync.Once
+	file_syncpb_sync_proto_rawDescData []byte
+)
+
+func file_syncpb_sync_proto_rawDescGZIP() []byte {
+	file_syncpb_sync_proto_rawDescOnce.Do(func() {
+		file_syncpb_sync_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_syncpb_sync_proto_rawDesc), len(file_syncpb_sync_proto_rawDesc)))
+	})
+	return file_syncpb_sync_proto_rawDescData
+}
+
+var file_syncpb_sync_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_syncpb_sync_proto_goTypes = []any{
+	(*ListPairsRequest)(nil),      // 0: drivesync.v1.ListPairsRequest
+	(*ListPairsResponse)(nil),     // 1: drivesync.v1.ListPairsResponse
+	(*Pair)(nil),                  // 2: drivesync.v1.Pair
+	(*RunRequest)(nil),            // 3: drivesync.v1.RunRequest
+	(*Event)(nil),                 // 4: drivesync.v1.Event
+	(*Summary)(nil),               // 5: drivesync.v1.Summary
+	nil,                           // 6: drivesync.v1.Summary.PhaseSecondsEntry
+	(*timestamppb.Timestamp)(nil), // 7: google.protobuf.Timestamp
+}
+var file_syncpb_sync_proto_depIdxs = []int32{
+	2, // 0: drivesync.v1.ListPairsResponse.pairs:type_name -> drivesync.v1.Pair
+	7, // 1: drivesync.v1.Event.time:type_name -> google.protobuf.Timestamp
+	5, // 2: drivesync.v1.Event.summary:type_name -> drivesync.v1.Summary
+	6, // 3: drivesync.v1.Summary.phase_seconds:type_name -> drivesync.v1.Summary.PhaseSecondsEntry
+	0, // 4: drivesync.v1.SyncEngine.ListPairs:input_type -> drivesync.v1.ListPairsRequest
+	3, // 5: drivesync.v1.SyncEngine.Plan:input_type -> drivesync.v1.RunRequest
+	3, // 6: drivesync.v1.SyncEngine.Apply:input_type -> drivesync.v1.RunRequest
+	1, // 7: drivesync.v1.SyncEngine.ListPairs:output_type -> drivesync.v1.ListPairsResponse
+	4, // 8: drivesync.v1.SyncEngine.Plan:output_type -> drivesync.v1.Event
+	4, // 9: drivesync.v1.SyncEngine.Apply:output_type -> drivesync.v1.Event
+	7, // [7:10] is the sub-list for method output_type
+	4, // [4:7] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_syncpb_sync_proto_init() }
+func file_syncpb_sync_proto_init() {
+	if File_syncpb_sync_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_syncpb_sync_proto_rawDesc), len(file_syncpb_sync_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_syncpb_sync_proto_goTypes,
+		DependencyIndexes: file_syncpb_sync_proto_depIdxs,
+		MessageInfos:      file_syncpb_sync_proto_msgTypes,
+	}.Build()
+	File_syncpb_sync_proto = out.File
+	file_syncpb_sync_proto_goTypes = nil
+	file_syncpb_sync_proto_depIdxs = nil
+}
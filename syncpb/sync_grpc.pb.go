@@ -0,0 +1,225 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: syncpb/sync.proto
+
+// The gRPC interface of serve api, to embed the sync engine in other
+// systems. Make the Go code with make proto.
+
+package syncpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	SyncEngine_ListPairs_FullMethodName = "/drivesync.v1.SyncEngine/ListPairs"
+	SyncEngine_Plan_FullMethodName      = "/drivesync.v1.SyncEngine/Plan"
+	SyncEngine_Apply_FullMethodName     = "/drivesync.v1.SyncEngine/Apply"
+)
+
+// SyncEngineClient is the client API for SyncEngine service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// SyncEngine syncs the pairs of the serve api process, one run at a time.
+// Calls send the token of DRIVE_API_TOKEN as "authorization: Bearer TOKEN"
+// metadata.
+type SyncEngineClient interface {
+	ListPairs(ctx context.Context, in *ListPairsRequest, opts ...grpc.CallOption) (*ListPairsResponse, error)
+	// Plan lists, scans and plans without changing anything. It streams the
+	// planned transfers and deletions, then the summary.
+	Plan(ctx context.Context, in *RunRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error)
+	// Apply syncs, streaming the events of the run, then the summary.
+	// Cancelling the call stops the sync; the next continues where it
+	// stopped.
+	Apply(ctx context.Context, in *RunRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error)
+}
+
+type syncEngineClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSyncEngineClient(cc grpc.ClientConnInterface) SyncEngineClient {
+	return &syncEngineClient{cc}
+}
+
+func (c *syncEngineClient) ListPairs(ctx context.Context, in *ListPairsRequest, opts ...grpc.CallOption) (*ListPairsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListPairsResponse)
+	err := c.cc.Invoke(ctx, SyncEngine_ListPairs_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *syncEngineClient) Plan(ctx context.Context, in *RunRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &SyncEngine_ServiceDesc.Streams[0], SyncEngine_Plan_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[RunRequest, Event]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SyncEngine_PlanClient = grpc.ServerStreamingClient[Event]
+
+func (c *syncEngineClient) Apply(ctx context.Context, in *RunRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &SyncEngine_ServiceDesc.Streams[1], SyncEngine_Apply_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[RunRequest, Event]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SyncEngine_ApplyClient = grpc.ServerStreamingClient[Event]
+
+// SyncEngineServer is the server API for SyncEngine service.
+// All implementations must embed UnimplementedSyncEngineServer
+// for forward compatibility.
+//
+// SyncEngine syncs the pairs of the serve api process, one run at a time.
+// Calls send the token of DRIVE_API_TOKEN as "authorization: Bearer TOKEN"
+// metadata.
+type SyncEngineServer interface {
+	ListPairs(context.Context, *ListPairsRequest) (*ListPairsResponse, error)
+	// Plan lists, scans and plans without changing anything. It streams the
+	// planned transfers and deletions, then the summary.
+	Plan(*RunRequest, grpc.ServerStreamingServer[Event]) error
+	// Apply syncs, streaming the events of the run, then the summary.
+	// Cancelling the call stops the sync; the next continues where it
+	// stopped.
+	Apply(*RunRequest, grpc.ServerStreamingServer[Event]) error
+	mustEmbedUnimplementedSyncEngineServer()
+}
+
+// UnimplementedSyncEngineServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedSyncEngineServer struct{}
+
+func (UnimplementedSyncEngineServer) ListPairs(context.Context, *ListPairsRequest) (*ListPairsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListPairs not implemented")
+}
+func (UnimplementedSyncEngineServer) Plan(*RunRequest, grpc.ServerStreamingServer[Event]) error {
+	return status.Error(codes.Unimplemented, "method Plan not implemented")
+}
+func (UnimplementedSyncEngineServer) Apply(*RunRequest, grpc.ServerStreamingServer[Event]) error {
+	return status.Error(codes.Unimplemented, "method Apply not implemented")
+}
+func (UnimplementedSyncEngineServer) mustEmbedUnimplementedSyncEngineServer() {}
+func (UnimplementedSyncEngineServer) testEmbeddedByValue()                    {}
+
+// UnsafeSyncEngineServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SyncEngineServer will
+// result in compilation errors.
+type UnsafeSyncEngineServer interface {
+	mustEmbedUnimplementedSyncEngineServer()
+}
+
+func RegisterSyncEngineServer(s grpc.ServiceRegistrar, srv SyncEngineServer) {
+	// If the following call panics, it indicates UnimplementedSyncEngineServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&SyncEngine_ServiceDesc, srv)
+}
+
+func _SyncEngine_ListPairs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPairsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SyncEngineServer).ListPairs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SyncEngine_ListPairs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SyncEngineServer).ListPairs(ctx, req.(*ListPairsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SyncEngine_Plan_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(RunRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SyncEngineServer).Plan(m, &grpc.GenericServerStream[RunRequest, Event]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SyncEngine_PlanServer = grpc.ServerStreamingServer[Event]
+
+func _SyncEngine_Apply_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(RunRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SyncEngineServer).Apply(m, &grpc.GenericServerStream[RunRequest, Event]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SyncEngine_ApplyServer = grpc.ServerStreamingServer[Event]
+
+// SyncEngine_ServiceDesc is the grpc.ServiceDesc for SyncEngine service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SyncEngine_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "drivesync.v1.SyncEngine",
+	HandlerType: (*SyncEngineServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListPairs",
+			Handler:    _SyncEngine_ListPairs_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Plan",
+			Handler:       _SyncEngine_Plan_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Apply",
+			Handler:       _SyncEngine_Apply_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "syncpb/sync.proto",
+}